@@ -0,0 +1,83 @@
+package api
+
+import (
+	"fmt"
+	"strings"
+)
+
+const (
+	defaultAnomalySensitivity = 3.5
+	defaultDayWindow          = 14
+	defaultHourWindow         = 7
+)
+
+// Anomaly is a rollup bucket whose value sits far above its baseline.
+type Anomaly struct {
+	Granularity string  `json:"granularity"`
+	Bucket      string  `json:"bucket"`
+	Date        string  `json:"date"`
+	Hour        *int    `json:"hour,omitempty"`
+	Agent       string  `json:"agent"`
+	Model       string  `json:"model"`
+	Metric      string  `json:"metric"`
+	Value       float64 `json:"value"`
+	Baseline    float64 `json:"baseline"`
+	Spread      float64 `json:"spread"`
+	Score       float64 `json:"score"`
+}
+
+// Key identifies the bucket an anomaly was raised for, independent of score.
+func (a Anomaly) Key() string {
+	return strings.Join([]string{a.Granularity, a.Bucket, a.Agent, a.Model, a.Metric}, "|")
+}
+
+// AnomalyOptions controls a detection run.
+// Start/End only limit which buckets are reported; older buckets still feed
+// the baseline.
+type AnomalyOptions struct {
+	Granularity string  // "day" (default) or "hour"
+	Metric      string  // "cost" (default) or "tokens"
+	Sensitivity float64 // robust z-score threshold; higher flags fewer buckets
+	Window      int     // number of baseline buckets
+	Start       string
+	End         string
+}
+
+// WithDefaults validates o and fills in the defaults for unset fields.
+func (o AnomalyOptions) WithDefaults() (AnomalyOptions, error) {
+	switch o.Granularity {
+	case "":
+		o.Granularity = "day"
+	case "day", "hour":
+	default:
+		return o, fmt.Errorf("unknown granularity %q", o.Granularity)
+	}
+	switch o.Metric {
+	case "":
+		o.Metric = "cost"
+	case "cost", "tokens":
+	default:
+		return o, fmt.Errorf("unknown metric %q", o.Metric)
+	}
+	if o.Sensitivity <= 0 {
+		o.Sensitivity = defaultAnomalySensitivity
+	}
+	if o.Window <= 0 {
+		if o.Granularity == "hour" {
+			o.Window = defaultHourWindow
+		} else {
+			o.Window = defaultDayWindow
+		}
+	}
+	return o, nil
+}
+
+// AnomalyResponse is the payload of /api/anomalies.
+type AnomalyResponse struct {
+	GeneratedAt string    `json:"generated_at"`
+	Granularity string    `json:"granularity"`
+	Metric      string    `json:"metric"`
+	Sensitivity float64   `json:"sensitivity"`
+	Window      int       `json:"window"`
+	Anomalies   []Anomaly `json:"anomalies"`
+}
@@ -0,0 +1,63 @@
+// Package api holds the wire types of the dashboard's JSON API (/api/v1):
+// the request filters and the payloads the server encodes. It depends on
+// the standard library only, so a client can import it without pulling in
+// the database drivers and renderers behind the server. The store, server,
+// push and chart packages declare these types as aliases.
+package api
+
+import "net/url"
+
+// Prefix is the versioned root of the JSON API.
+const Prefix = "/api/v1"
+
+// UsageFilter narrows queries over usage_records. Zero fields match everything.
+type UsageFilter struct {
+	Start string // inclusive "YYYY-MM-DD"
+	End   string // inclusive "YYYY-MM-DD"
+	Agent string
+	Model string
+
+	Provider   string
+	Role       string
+	StopReason string
+	Tool       string // records whose message called this tool
+	Project    string
+	Tag        string // records carrying this tag
+	Host       string // "local" selects records synced on this machine
+	User       string // user a pushed record was received from; "unknown" for local ones
+}
+
+// ParseUsageFilter reads the common filter parameters shared by the API.
+func ParseUsageFilter(q url.Values) UsageFilter {
+	return UsageFilter{
+		Start: q.Get("start"),
+		End:   q.Get("end"),
+		Agent: q.Get("agent"),
+		Model: q.Get("model"),
+
+		Provider:   q.Get("provider"),
+		Role:       q.Get("role"),
+		StopReason: q.Get("stop_reason"),
+		Tool:       q.Get("tool"),
+		Project:    q.Get("project"),
+		Tag:        q.Get("tag"),
+		Host:       q.Get("host"),
+		User:       q.Get("user"),
+	}
+}
+
+// EncodeUsageFilter is the inverse of ParseUsageFilter. Empty fields are
+// left out.
+func EncodeUsageFilter(f UsageFilter) url.Values {
+	q := url.Values{}
+	for _, p := range []struct{ name, value string }{
+		{"start", f.Start}, {"end", f.End}, {"agent", f.Agent}, {"model", f.Model},
+		{"provider", f.Provider}, {"role", f.Role}, {"stop_reason", f.StopReason}, {"tool", f.Tool},
+		{"project", f.Project}, {"tag", f.Tag}, {"host", f.Host}, {"user", f.User},
+	} {
+		if p.value != "" {
+			q.Set(p.name, p.value)
+		}
+	}
+	return q
+}
@@ -0,0 +1,70 @@
+package api
+
+// PortableRecord is a usage record detached from its database, as shipped
+// from a push agent to a collector. ID is only meaningful in the source
+// database; the host, source file and offset identify the record anywhere.
+type PortableRecord struct {
+	ID           int64          `json:"id"`
+	Host         string         `json:"host,omitempty"`
+	User         string         `json:"user,omitempty"`
+	Agent        string         `json:"agent"`
+	Model        string         `json:"model"`
+	Date         string         `json:"date"`
+	Hour         *int           `json:"hour,omitempty"`
+	DOW          *int           `json:"dow,omitempty"`
+	Timestamp    int64          `json:"ts,omitempty"`
+	Tokens       int            `json:"tokens"`
+	Cost         float64        `json:"cost"`
+	Provider     string         `json:"provider"`
+	Role         string         `json:"role"`
+	StopReason   string         `json:"stop_reason"`
+	ToolCalls    int            `json:"tool_calls,omitempty"`
+	Tools        map[string]int `json:"tools,omitempty"` // tool name -> calls
+	LatencyMs    *int           `json:"latency_ms,omitempty"`
+	DedupeKey    string         `json:"dedupe_key,omitempty"`
+	Project      string         `json:"project"`
+	SourceFile   string         `json:"source_file"`
+	SourceOffset int64          `json:"source_offset"`
+}
+
+// IngestResult counts the outcome of one Ingest or Import call. A record
+// whose host, source file and offset are already stored is a duplicate when
+// it carries the same usage and a conflict when it does not; either way the
+// stored record is kept.
+type IngestResult struct {
+	Accepted        int        `json:"accepted"`
+	Duplicates      int        `json:"duplicates"`
+	Conflicts       int        `json:"conflicts"`
+	ConflictSamples []Conflict `json:"conflict_samples,omitempty"` // the first maxConflictSamples
+}
+
+// Conflict describes one incoming record that disagrees with the stored
+// record at the same source position.
+type Conflict struct {
+	Host         string `json:"host"`
+	SourceFile   string `json:"source_file"`
+	SourceOffset int64  `json:"source_offset"`
+	Stored       string `json:"stored"`   // "model tokens cost"
+	Incoming     string `json:"incoming"` // same, for the rejected record
+}
+
+const maxConflictSamples = 20
+
+// Add accumulates another batch's result.
+func (r *IngestResult) Add(o IngestResult) {
+	r.Accepted += o.Accepted
+	r.Duplicates += o.Duplicates
+	r.Conflicts += o.Conflicts
+	for _, c := range o.ConflictSamples {
+		if len(r.ConflictSamples) < maxConflictSamples {
+			r.ConflictSamples = append(r.ConflictSamples, c)
+		}
+	}
+}
+
+// Batch is the body of a push request. The collector attributes the
+// records to Host and to the user the bearer token belongs to.
+type Batch struct {
+	Host    string           `json:"host"`
+	Records []PortableRecord `json:"records"`
+}
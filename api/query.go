@@ -0,0 +1,72 @@
+package api
+
+import (
+	"encoding/csv"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+	"text/tabwriter"
+)
+
+// QueryResult holds the rows of a user query. Values are strings, numbers
+// or nil.
+type QueryResult struct {
+	Columns   []string        `json:"columns"`
+	Rows      [][]interface{} `json:"rows"`
+	Truncated bool            `json:"truncated"`
+}
+
+// formatValue renders a value for CSV and table output.
+func formatValue(v interface{}) string {
+	switch x := v.(type) {
+	case nil:
+		return ""
+	case string:
+		return x
+	case int64:
+		return strconv.FormatInt(x, 10)
+	case float64:
+		return strconv.FormatFloat(x, 'f', -1, 64)
+	default:
+		return fmt.Sprint(x)
+	}
+}
+
+// WriteCSV writes the result with a header row.
+func (r QueryResult) WriteCSV(w io.Writer) error {
+	cw := csv.NewWriter(w)
+	if err := cw.Write(r.Columns); err != nil {
+		return err
+	}
+	rec := make([]string, len(r.Columns))
+	for _, row := range r.Rows {
+		for i, v := range row {
+			rec[i] = formatValue(v)
+		}
+		if err := cw.Write(rec); err != nil {
+			return err
+		}
+	}
+	cw.Flush()
+	return cw.Error()
+}
+
+// WriteTable writes the result as aligned columns for a terminal.
+func (r QueryResult) WriteTable(w io.Writer) error {
+	tw := tabwriter.NewWriter(w, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(tw, strings.Join(r.Columns, "\t"))
+	rule := make([]string, len(r.Columns))
+	for i, c := range r.Columns {
+		rule[i] = strings.Repeat("-", len(c))
+	}
+	fmt.Fprintln(tw, strings.Join(rule, "\t"))
+	cells := make([]string, len(r.Columns))
+	for _, row := range r.Rows {
+		for i, v := range row {
+			cells[i] = strings.NewReplacer("\t", " ", "\n", " ").Replace(formatValue(v))
+		}
+		fmt.Fprintln(tw, strings.Join(cells, "\t"))
+	}
+	return tw.Flush()
+}
@@ -0,0 +1,204 @@
+package api
+
+import "fmt"
+
+// SyncResult holds statistics from a sync run.
+type SyncResult struct {
+	NewRecords   int `json:"new_records"`
+	Duplicates   int `json:"duplicates"`
+	SyncedFiles  int `json:"synced_files"`
+	SkippedFiles int `json:"skipped_files"`
+}
+
+// AgentTotal, ModelTotal, ProjectTotal and ProviderTotal sum the records
+// of one value of their dimension.
+type AgentTotal struct {
+	Agent   string  `json:"agent"`
+	Tokens  int     `json:"tokens"`
+	Cost    float64 `json:"cost"`
+	Records int     `json:"records"`
+}
+
+type ModelTotal struct {
+	Model   string  `json:"model"`
+	Tokens  int     `json:"tokens"`
+	Cost    float64 `json:"cost"`
+	Records int     `json:"records"`
+}
+
+type ProjectTotal struct {
+	Project string  `json:"project"`
+	Tokens  int     `json:"tokens"`
+	Cost    float64 `json:"cost"`
+	Records int     `json:"records"`
+}
+
+type ProviderTotal struct {
+	Provider string  `json:"provider"`
+	Tokens   int     `json:"tokens"`
+	Cost     float64 `json:"cost"`
+	Records  int     `json:"records"`
+}
+
+// ToolTotal sums the turns that called a tool. A turn calling several
+// tools counts toward each of them.
+type ToolTotal struct {
+	Tool   string  `json:"tool"`
+	Calls  int     `json:"calls"`
+	Turns  int     `json:"turns"`
+	Tokens int     `json:"tokens"`
+	Cost   float64 `json:"cost"`
+}
+
+// TagTotal sums the records carrying a tag. A record with several tags
+// counts toward each of them.
+type TagTotal struct {
+	Tag     string  `json:"tag"`
+	Tokens  int     `json:"tokens"`
+	Cost    float64 `json:"cost"`
+	Records int     `json:"records"`
+}
+
+// DailyTokens is one point of the daily trend; Date may be "unknown".
+type DailyTokens struct {
+	Date    string  `json:"date"`
+	Tokens  int     `json:"tokens"`
+	Cost    float64 `json:"cost"`
+	Records int     `json:"records"`
+}
+
+// HeatmapCell sums usage by day of week (0=Mon) and hour of day.
+type HeatmapCell struct {
+	DOW    int     `json:"dow"`
+	Hour   int     `json:"hour"`
+	Tokens int     `json:"tokens"`
+	Cost   float64 `json:"cost"`
+}
+
+// Summary holds the headline numbers for the filtered range.
+type Summary struct {
+	TotalTokens  int     `json:"total_tokens"`
+	TotalCost    float64 `json:"total_cost"`
+	UsageRecords int     `json:"usage_records"`
+	SessionFiles int     `json:"session_files"`
+	Duplicates   int     `json:"duplicates_dropped"`
+	AgentCount   int     `json:"agent_count"`
+	ModelCount   int     `json:"model_count"`
+	DayCount     int     `json:"day_count"`
+}
+
+// StatsResponse is the dashboard payload of /api/stats. Source and Sync
+// describe the sync run that preceded the query; Store.Stats leaves them for
+// the caller to fill in.
+type StatsResponse struct {
+	GeneratedAt    string          `json:"generated_at"`
+	Source         string          `json:"source"`
+	Cached         bool            `json:"cached"`
+	Sync           SyncResult      `json:"sync"`
+	Summary        Summary         `json:"summary"`
+	AgentTotals    []AgentTotal    `json:"agent_totals"`
+	ModelTotals    []ModelTotal    `json:"model_totals"`
+	ProjectTotals  []ProjectTotal  `json:"project_totals"`
+	ProviderTotals []ProviderTotal `json:"provider_totals"`
+	ToolTotals     []ToolTotal     `json:"tool_totals"`
+	TagTotals      []TagTotal      `json:"tag_totals"`
+	DailyTokens    []DailyTokens   `json:"daily_tokens"`
+	Heatmap        []HeatmapCell   `json:"heatmap"`
+}
+
+// RecordRow is one usage_records row as returned by /api/records.
+type RecordRow struct {
+	ID           int64    `json:"id"`
+	Agent        string   `json:"agent"`
+	Model        string   `json:"model"`
+	Date         string   `json:"date"`
+	Hour         *int     `json:"hour"`
+	DOW          *int     `json:"dow"`
+	Timestamp    string   `json:"timestamp,omitempty"`
+	Tokens       int      `json:"tokens"`
+	Cost         float64  `json:"cost"`
+	Provider     string   `json:"provider"`
+	Role         string   `json:"role"`
+	StopReason   string   `json:"stop_reason"`
+	ToolCalls    int      `json:"tool_calls"`
+	Tools        []string `json:"tools"`
+	LatencyMs    *int     `json:"latency_ms"`
+	Project      string   `json:"project"`
+	Tags         []string `json:"tags"`
+	Host         string   `json:"host,omitempty"` // empty for records a SQLite cache synced itself
+	User         string   `json:"user,omitempty"`
+	SourceFile   string   `json:"source_file"`
+	SourceOffset int64    `json:"source_offset"`
+	Raw          string   `json:"raw,omitempty"`
+	RawError     string   `json:"raw_error,omitempty"`
+}
+
+// RecordsQuery selects one page of records.
+type RecordsQuery struct {
+	Filter UsageFilter
+	Sort   string // "time" (default), "tokens", "cost" or "id"
+	Order  string // "desc" (default) or "asc"
+	Limit  int
+	Cursor string // next_cursor from the previous page
+	Raw    bool   // also read the original JSONL line for each record
+}
+
+// RecordsPage is the payload of /api/records.
+type RecordsPage struct {
+	Sort       string      `json:"sort"`
+	Order      string      `json:"order"`
+	Records    []RecordRow `json:"records"`
+	NextCursor string      `json:"next_cursor,omitempty"`
+}
+
+// AggregateQuery describes one /api/aggregate request.
+type AggregateQuery struct {
+	Filter  UsageFilter
+	GroupBy []string
+	Metrics []string // default tokens, cost, records; the first one ranks rows
+	Top     int      // keep the top N values of the first dimension, fold the rest into "other"
+	Limit   int      // maximum number of rows returned
+}
+
+// AggregateResponse is the payload of /api/aggregate. Each row maps the
+// dimension and metric names to their values.
+type AggregateResponse struct {
+	GroupBy   []string                 `json:"group_by"`
+	Metrics   []string                 `json:"metrics"`
+	Top       int                      `json:"top,omitempty"`
+	Rows      []map[string]interface{} `json:"rows"`
+	Truncated bool                     `json:"truncated"`
+}
+
+// ChartOptions tune a chart. Zero values pick the defaults.
+type ChartOptions struct {
+	Width, Height int
+	Metric        string // "tokens" (default) or "cost"
+	Theme         string // "dark" (default, as the dashboard) or "light"
+}
+
+// Chart size limits keep a request from allocating a huge image.
+const (
+	MinChartSize = 120
+	MaxChartSize = 2400
+)
+
+// Validate checks the metric, theme and size.
+func (o ChartOptions) Validate() error {
+	switch o.Metric {
+	case "", "tokens", "cost":
+	default:
+		return fmt.Errorf("unknown metric %q (tokens or cost)", o.Metric)
+	}
+	switch o.Theme {
+	case "", "dark", "light":
+	default:
+		return fmt.Errorf("unknown theme %q (dark or light)", o.Theme)
+	}
+	for _, v := range []int{o.Width, o.Height} {
+		if v != 0 && (v < MinChartSize || v > MaxChartSize) {
+			return fmt.Errorf("size must be between %d and %d", MinChartSize, MaxChartSize)
+		}
+	}
+	return nil
+}
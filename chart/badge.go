@@ -0,0 +1,66 @@
+package chart
+
+import (
+	"fmt"
+	"io"
+	"math"
+)
+
+// Badge colours, as shields.io names them.
+const (
+	BadgeGreen  = "#4c1"
+	BadgeYellow = "#dfb317"
+	BadgeOrange = "#fe7d37"
+	BadgeRed    = "#e05d44"
+	BadgeBlue   = "#007ec6"
+	BadgeGrey   = "#9f9f9f"
+)
+
+// Badge writes a flat shields-style badge: label on grey, message on color.
+func Badge(w io.Writer, label, message, color string) error {
+	lw, mw := badgeTextWidth(label)+10, badgeTextWidth(message)+10
+	total := lw + mw
+	text := func(x float64, s string, width int) string {
+		return fmt.Sprintf(`<text x="%s" y="15" fill="#010101" fill-opacity=".3" textLength="%d">%s</text><text x="%s" y="14" textLength="%d">%s</text>`,
+			num(x), width-10, xmlEscape(s), num(x), width-10, xmlEscape(s))
+	}
+	_, err := fmt.Fprintf(w, `<svg xmlns="http://www.w3.org/2000/svg" width="%d" height="20" role="img" aria-label="%s: %s">`+
+		`<title>%s: %s</title>`+
+		`<linearGradient id="s" x2="0" y2="100%%"><stop offset="0" stop-color="#bbb" stop-opacity=".1"/><stop offset="1" stop-opacity=".1"/></linearGradient>`+
+		`<clipPath id="r"><rect width="%d" height="20" rx="3" fill="#fff"/></clipPath>`+
+		`<g clip-path="url(#r)"><rect width="%d" height="20" fill="#555"/><rect x="%d" width="%d" height="20" fill="%s"/><rect width="%d" height="20" fill="url(#s)"/></g>`+
+		`<g fill="#fff" text-anchor="middle" font-family="Verdana,Geneva,DejaVu Sans,sans-serif" font-size="11">%s%s</g></svg>`+"\n",
+		total, xmlEscape(label), xmlEscape(message),
+		xmlEscape(label), xmlEscape(message),
+		total,
+		lw, lw, mw, xmlEscape(color), total,
+		text(float64(lw)/2, label, lw), text(float64(lw)+float64(mw)/2, message, mw))
+	return err
+}
+
+// badgeTextWidth estimates the width of s in 11px Verdana. textLength makes
+// the renderer stretch the text to it, so small misses only change spacing.
+func badgeTextWidth(s string) int {
+	var w float64
+	for _, r := range s {
+		switch {
+		case r == ' ' || r == '.' || r == ',' || r == ':' || r == ';' || r == '\'' || r == '!' || r == '|':
+			w += 3.9
+		case r == 'i' || r == 'l' || r == 'j':
+			w += 3.1
+		case r == 'f' || r == 't' || r == 'r' || r == 'I' || r == '(' || r == ')' || r == '/' || r == '-':
+			w += 4.8
+		case r == 'm' || r == 'w' || r == 'M' || r == 'W' || r == '%':
+			w += 10.5
+		case r >= 'A' && r <= 'Z':
+			w += 7.5
+		case r >= '0' && r <= '9' || r == '$' || r == '_':
+			w += 7
+		case r < 0x80:
+			w += 6.6
+		default:
+			w += 10 // wide scripts and symbols
+		}
+	}
+	return int(math.Ceil(w))
+}
@@ -0,0 +1,198 @@
+package chart
+
+import (
+	"fmt"
+	"image"
+	"image/color"
+	"image/draw"
+	"image/png"
+	"io"
+	"math"
+	"strings"
+
+	"golang.org/x/image/font"
+	"golang.org/x/image/font/basicfont"
+	"golang.org/x/image/math/fixed"
+)
+
+// canvas is the drawing surface the charts are laid out on; svgCanvas and
+// pngCanvas render the same calls. Coordinates are pixels from the top left.
+type canvas interface {
+	Rect(x, y, w, h float64, fill color.NRGBA)
+	Line(x1, y1, x2, y2 float64, stroke color.NRGBA, width float64)
+	Polyline(pts []point, stroke color.NRGBA, width float64)
+	// Area fills between the polyline pts (x ascending) and the line y = base.
+	Area(pts []point, base float64, fill color.NRGBA)
+	Dot(x, y, r float64, fill color.NRGBA)
+	// Text draws s with its baseline at y, anchored "start", "middle" or "end" at x.
+	Text(x, y float64, s string, anchor string, fill color.NRGBA)
+	Encode(w io.Writer) error
+}
+
+type point struct{ X, Y float64 }
+
+// ── SVG ─────────────────────────────────────────────────────────────────────
+
+type svgCanvas struct {
+	b strings.Builder
+}
+
+func newSVG(w, h int, title string) *svgCanvas {
+	c := &svgCanvas{}
+	fmt.Fprintf(&c.b, `<svg xmlns="http://www.w3.org/2000/svg" width="%d" height="%d" viewBox="0 0 %d %d" font-family="Verdana,DejaVu Sans,sans-serif" font-size="11">`, w, h, w, h)
+	fmt.Fprintf(&c.b, "<title>%s</title>", xmlEscape(title))
+	return c
+}
+
+func (c *svgCanvas) Rect(x, y, w, h float64, fill color.NRGBA) {
+	fmt.Fprintf(&c.b, `<rect x="%s" y="%s" width="%s" height="%s"%s/>`, num(x), num(y), num(w), num(h), svgFill(fill))
+}
+
+func (c *svgCanvas) Line(x1, y1, x2, y2 float64, stroke color.NRGBA, width float64) {
+	fmt.Fprintf(&c.b, `<line x1="%s" y1="%s" x2="%s" y2="%s"%s stroke-width="%s"/>`, num(x1), num(y1), num(x2), num(y2), svgStroke(stroke), num(width))
+}
+
+func (c *svgCanvas) Polyline(pts []point, stroke color.NRGBA, width float64) {
+	fmt.Fprintf(&c.b, `<polyline points="%s" fill="none"%s stroke-width="%s" stroke-linejoin="round"/>`, svgPoints(pts), svgStroke(stroke), num(width))
+}
+
+func (c *svgCanvas) Area(pts []point, base float64, fill color.NRGBA) {
+	if len(pts) == 0 {
+		return
+	}
+	all := append([]point{{pts[0].X, base}}, pts...)
+	all = append(all, point{pts[len(pts)-1].X, base})
+	fmt.Fprintf(&c.b, `<polygon points="%s"%s/>`, svgPoints(all), svgFill(fill))
+}
+
+func (c *svgCanvas) Dot(x, y, r float64, fill color.NRGBA) {
+	fmt.Fprintf(&c.b, `<circle cx="%s" cy="%s" r="%s"%s/>`, num(x), num(y), num(r), svgFill(fill))
+}
+
+func (c *svgCanvas) Text(x, y float64, s, anchor string, fill color.NRGBA) {
+	fmt.Fprintf(&c.b, `<text x="%s" y="%s" text-anchor="%s"%s>%s</text>`, num(x), num(y), anchor, svgFill(fill), xmlEscape(s))
+}
+
+func (c *svgCanvas) Encode(w io.Writer) error {
+	_, err := io.WriteString(w, c.b.String()+"</svg>\n")
+	return err
+}
+
+func num(v float64) string {
+	return strings.TrimSuffix(strings.TrimRight(fmt.Sprintf("%.1f", v), "0"), ".")
+}
+
+func hex(c color.NRGBA) string { return fmt.Sprintf("#%02x%02x%02x", c.R, c.G, c.B) }
+
+func svgFill(c color.NRGBA) string {
+	if c.A == 255 {
+		return ` fill="` + hex(c) + `"`
+	}
+	return fmt.Sprintf(` fill="%s" fill-opacity="%.2f"`, hex(c), float64(c.A)/255)
+}
+
+func svgStroke(c color.NRGBA) string {
+	if c.A == 255 {
+		return ` stroke="` + hex(c) + `"`
+	}
+	return fmt.Sprintf(` stroke="%s" stroke-opacity="%.2f"`, hex(c), float64(c.A)/255)
+}
+
+func svgPoints(pts []point) string {
+	parts := make([]string, len(pts))
+	for i, p := range pts {
+		parts[i] = num(p.X) + "," + num(p.Y)
+	}
+	return strings.Join(parts, " ")
+}
+
+func xmlEscape(s string) string {
+	return strings.NewReplacer("&", "&amp;", "<", "&lt;", ">", "&gt;", `"`, "&quot;").Replace(s)
+}
+
+// ── PNG ─────────────────────────────────────────────────────────────────────
+
+// pngCanvas rasterises without anti-aliasing. Text uses a 7×13 bitmap
+// font that covers Latin-1; other characters are drawn as boxes.
+type pngCanvas struct {
+	img *image.RGBA
+}
+
+func newPNG(w, h int) *pngCanvas {
+	return &pngCanvas{img: image.NewRGBA(image.Rect(0, 0, w, h))}
+}
+
+func (c *pngCanvas) fill(r image.Rectangle, col color.NRGBA) {
+	draw.Draw(c.img, r, image.NewUniform(col), image.Point{}, draw.Over)
+}
+
+func (c *pngCanvas) Rect(x, y, w, h float64, fill color.NRGBA) {
+	c.fill(image.Rect(round(x), round(y), round(x+w), round(y+h)), fill)
+}
+
+func (c *pngCanvas) Line(x1, y1, x2, y2 float64, stroke color.NRGBA, width float64) {
+	c.Polyline([]point{{x1, y1}, {x2, y2}}, stroke, width)
+}
+
+// Polyline stamps the segments onto a mask and composites it once, so a
+// translucent stroke does not darken where the stamps overlap.
+func (c *pngCanvas) Polyline(pts []point, stroke color.NRGBA, width float64) {
+	mask := image.NewAlpha(c.img.Bounds())
+	half := width / 2
+	for i := 1; i < len(pts); i++ {
+		a, b := pts[i-1], pts[i]
+		steps := int(math.Max(math.Abs(b.X-a.X), math.Abs(b.Y-a.Y)))
+		if steps == 0 {
+			steps = 1
+		}
+		for j := 0; j <= steps; j++ {
+			t := float64(j) / float64(steps)
+			x, y := a.X+(b.X-a.X)*t, a.Y+(b.Y-a.Y)*t
+			draw.Draw(mask, image.Rect(round(x-half), round(y-half), round(x+half), round(y+half)), image.Opaque, image.Point{}, draw.Src)
+		}
+	}
+	draw.DrawMask(c.img, c.img.Bounds(), image.NewUniform(stroke), image.Point{}, mask, image.Point{}, draw.Over)
+}
+
+func (c *pngCanvas) Area(pts []point, base float64, fill color.NRGBA) {
+	if len(pts) < 2 {
+		return
+	}
+	seg := 0
+	for x := round(pts[0].X); x < round(pts[len(pts)-1].X); x++ {
+		fx := float64(x) + 0.5
+		for seg < len(pts)-2 && fx > pts[seg+1].X {
+			seg++
+		}
+		a, b := pts[seg], pts[seg+1]
+		y := a.Y
+		if b.X > a.X {
+			y += (b.Y - a.Y) * (fx - a.X) / (b.X - a.X)
+		}
+		c.fill(image.Rect(x, round(y), x+1, round(base)), fill)
+	}
+}
+
+func (c *pngCanvas) Dot(x, y, r float64, fill color.NRGBA) {
+	for dy := -r; dy <= r; dy++ {
+		dx := math.Sqrt(r*r - dy*dy)
+		c.fill(image.Rect(round(x-dx), round(y+dy), round(x+dx)+1, round(y+dy)+1), fill)
+	}
+}
+
+func (c *pngCanvas) Text(x, y float64, s, anchor string, fill color.NRGBA) {
+	d := &font.Drawer{Dst: c.img, Src: image.NewUniform(fill), Face: basicfont.Face7x13}
+	w := float64(d.MeasureString(s).Round())
+	switch anchor {
+	case "middle":
+		x -= w / 2
+	case "end":
+		x -= w
+	}
+	d.Dot = fixed.P(round(x), round(y))
+	d.DrawString(s)
+}
+
+func (c *pngCanvas) Encode(w io.Writer) error { return png.Encode(w, c.img) }
+
+func round(v float64) int { return int(math.Round(v)) }
@@ -0,0 +1,357 @@
+// Package chart draws the dashboard's charts as SVG or PNG images in pure
+// Go, for embedding where Chart.js cannot run: wikis, READMEs, chat
+// unfurls.
+package chart
+
+import (
+	"fmt"
+	"image/color"
+	"io"
+	"math"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/yeremiel/claw-usage-chart/api"
+	"github.com/yeremiel/claw-usage-chart/store"
+)
+
+// Kinds are the charts Render draws, with their default size.
+var Kinds = map[string][2]int{
+	"daily":   {800, 300},
+	"agents":  {600, 0}, // height follows the number of agents
+	"heatmap": {800, 260},
+}
+
+// Options tune a chart. Zero values pick the defaults.
+type Options = api.ChartOptions
+
+// Size limits keep a request from allocating a huge image.
+const (
+	MinSize = api.MinChartSize
+	MaxSize = api.MaxChartSize
+)
+
+// Render draws the chart kind ("daily", "agents", "heatmap") from stats
+// and writes it in format ("svg" or "png").
+func Render(w io.Writer, kind, format string, stats store.StatsResponse, opts Options) error {
+	size, ok := Kinds[kind]
+	if !ok {
+		return fmt.Errorf("unknown chart %q", kind)
+	}
+	if format != "svg" && format != "png" {
+		return fmt.Errorf("unknown format %q (svg or png)", format)
+	}
+	if err := opts.Validate(); err != nil {
+		return err
+	}
+	if opts.Metric == "" {
+		opts.Metric = "tokens"
+	}
+	if opts.Width == 0 {
+		opts.Width = size[0]
+	}
+	if opts.Height == 0 {
+		opts.Height = size[1]
+	}
+	if kind == "agents" && opts.Height == 0 {
+		rows := len(stats.AgentTotals)
+		if rows > maxBars {
+			rows = maxBars
+		}
+		if rows == 0 {
+			rows = 1
+		}
+		opts.Height = 48 + rows*barPitch
+	}
+
+	th := darkTheme
+	if opts.Theme == "light" {
+		th = lightTheme
+	}
+	title := chartTitle(kind, opts.Metric)
+	var c canvas
+	if format == "svg" {
+		c = newSVG(opts.Width, opts.Height, title)
+	} else {
+		c = newPNG(opts.Width, opts.Height)
+	}
+	d := drawing{c: c, th: th, w: float64(opts.Width), h: float64(opts.Height), metric: opts.Metric}
+	c.Rect(0, 0, d.w, d.h, th.bg)
+	c.Text(12, 20, title, "start", th.text)
+
+	switch kind {
+	case "daily":
+		d.daily(stats.DailyTokens)
+	case "agents":
+		d.agents(stats.AgentTotals)
+	case "heatmap":
+		d.heatmap(stats.Heatmap)
+	}
+	return c.Encode(w)
+}
+
+func chartTitle(kind, metric string) string {
+	m := "tokens"
+	if metric == "cost" {
+		m = "cost"
+	}
+	switch kind {
+	case "daily":
+		return "Daily " + m
+	case "agents":
+		return strings.ToUpper(m[:1]) + m[1:] + " by agent"
+	default:
+		return strings.ToUpper(m[:1]) + m[1:] + " by hour and weekday"
+	}
+}
+
+type theme struct {
+	bg, text, muted, grid, accent, empty color.NRGBA
+	palette                              []color.NRGBA
+}
+
+func rgb(v uint32) color.NRGBA {
+	return color.NRGBA{R: uint8(v >> 16), G: uint8(v >> 8), B: uint8(v), A: 255}
+}
+
+func alpha(c color.NRGBA, a float64) color.NRGBA {
+	c.A = uint8(math.Round(a * 255))
+	return c
+}
+
+// The colours follow index.html.
+var (
+	palette = []color.NRGBA{
+		rgb(0x42d3ff), rgb(0x4de88e), rgb(0xffb84a), rgb(0x78f0d4), rgb(0x5f9dff),
+		rgb(0xf78357), rgb(0x8bcf7a), rgb(0xd7aa5f), rgb(0x5f6979),
+	}
+	darkTheme = theme{
+		bg: rgb(0x0b1118), text: rgb(0xe8f1ff), muted: rgb(0x98adc6), grid: rgb(0x1e3142),
+		accent: rgb(0x4de88e), empty: alpha(rgb(0xffffff), 0.04), palette: palette,
+	}
+	lightTheme = theme{
+		bg: rgb(0xffffff), text: rgb(0x1b2733), muted: rgb(0x5b6b7c), grid: rgb(0xe3e8ee),
+		accent: rgb(0x1f9d55), empty: alpha(rgb(0x000000), 0.04), palette: palette,
+	}
+)
+
+type drawing struct {
+	c      canvas
+	th     theme
+	w, h   float64
+	metric string
+}
+
+func (d drawing) value(tokens int, cost float64) float64 {
+	if d.metric == "cost" {
+		return cost
+	}
+	return float64(tokens)
+}
+
+func (d drawing) format(v float64) string {
+	if d.metric == "cost" {
+		return FormatCost(v)
+	}
+	return FormatTokens(v)
+}
+
+func (d drawing) noData() {
+	d.c.Text(d.w/2, d.h/2, "No data", "middle", d.th.muted)
+}
+
+// daily draws the trend as a filled line with a value axis.
+func (d drawing) daily(days []store.DailyTokens) {
+	var pts []store.DailyTokens
+	for _, p := range days {
+		if p.Date != "unknown" {
+			pts = append(pts, p)
+		}
+	}
+	if len(pts) == 0 {
+		d.noData()
+		return
+	}
+	left, right, top, bottom := 60.0, d.w-16, 34.0, d.h-26
+	var peak float64
+	for _, p := range pts {
+		peak = math.Max(peak, d.value(p.Tokens, p.Cost))
+	}
+	max := niceCeil(peak)
+	for i := 0; i <= 4; i++ {
+		y := bottom - (bottom-top)*float64(i)/4
+		d.c.Line(left, y, right, y, d.th.grid, 1)
+		d.c.Text(left-8, y+4, d.format(max*float64(i)/4), "end", d.th.muted)
+	}
+
+	line := make([]point, len(pts))
+	for i, p := range pts {
+		x := (left + right) / 2
+		if len(pts) > 1 {
+			x = left + (right-left)*float64(i)/float64(len(pts)-1)
+		}
+		line[i] = point{x, bottom - (bottom-top)*d.value(p.Tokens, p.Cost)/max}
+	}
+	d.c.Area(line, bottom, alpha(d.th.accent, 0.15))
+	d.c.Polyline(line, d.th.accent, 2)
+	if len(line) <= 60 {
+		for _, p := range line {
+			d.c.Dot(p.X, p.Y, 2, d.th.accent)
+		}
+	}
+
+	// At most about one date label per 90px, always including the last.
+	step := int(math.Ceil(float64(len(pts)) * 90 / (right - left)))
+	if step < 1 {
+		step = 1
+	}
+	last := len(pts) - 1
+	for i := 0; i < last; i += step {
+		if last-i >= step { // leave room for the last label
+			d.c.Text(line[i].X, d.h-8, shortDate(pts[i].Date), "middle", d.th.muted)
+		}
+	}
+	d.c.Text(line[last].X, d.h-8, shortDate(pts[last].Date), "middle", d.th.muted)
+}
+
+const (
+	maxBars  = 12
+	barPitch = 24
+)
+
+// agents draws horizontal bars, largest first; agents past maxBars are
+// summed into "other".
+func (d drawing) agents(totals []store.AgentTotal) {
+	if len(totals) == 0 {
+		d.noData()
+		return
+	}
+	type bar struct {
+		name string
+		v    float64
+	}
+	bars := make([]bar, len(totals))
+	for i, a := range totals {
+		bars[i] = bar{a.Agent, d.value(a.Tokens, a.Cost)}
+	}
+	sort.SliceStable(bars, func(i, j int) bool { return bars[i].v > bars[j].v })
+	rows := int((d.h - 48) / barPitch)
+	if rows < 1 {
+		rows = 1
+	}
+	if rows > maxBars {
+		rows = maxBars
+	}
+	if len(bars) > rows {
+		other := bar{name: fmt.Sprintf("other (%d)", len(bars)-rows+1)}
+		for _, b := range bars[rows-1:] {
+			other.v += b.v
+		}
+		bars = append(bars[:rows-1], other)
+	}
+
+	left, right := 140.0, d.w-80
+	peak := bars[0].v
+	for _, b := range bars {
+		peak = math.Max(peak, b.v)
+	}
+	for i, b := range bars {
+		y := 36 + float64(i)*barPitch
+		d.c.Text(left-8, y+13, clip(b.name, 18), "end", d.th.text)
+		w := 0.0
+		if peak > 0 {
+			w = (right - left) * b.v / peak
+		}
+		d.c.Rect(left, y+2, math.Max(w, 1), barPitch-6, d.th.palette[i%len(d.th.palette)])
+		d.c.Text(left+w+6, y+13, d.format(b.v), "start", d.th.muted)
+	}
+}
+
+// heatmap draws weekday rows (Mon first) by hour columns, shaded like the
+// dashboard heatmap.
+func (d drawing) heatmap(cells []store.HeatmapCell) {
+	var grid [7][24]float64
+	var peak float64
+	for _, c := range cells {
+		if c.DOW < 0 || c.DOW > 6 || c.Hour < 0 || c.Hour > 23 {
+			continue
+		}
+		grid[c.DOW][c.Hour] += d.value(c.Tokens, c.Cost)
+		peak = math.Max(peak, grid[c.DOW][c.Hour])
+	}
+	left, top, bottom := 44.0, 34.0, d.h-22
+	cw := (d.w - left - 12) / 24
+	ch := (bottom - top) / 7
+	days := []string{"Mon", "Tue", "Wed", "Thu", "Fri", "Sat", "Sun"}
+	for dow := 0; dow < 7; dow++ {
+		y := top + float64(dow)*ch
+		d.c.Text(left-8, y+ch/2+4, days[dow], "end", d.th.muted)
+		for h := 0; h < 24; h++ {
+			fill := d.th.empty
+			if v := grid[dow][h]; v > 0 && peak > 0 {
+				fill = alpha(d.th.accent, math.Max(0.08, v/peak))
+			}
+			d.c.Rect(left+float64(h)*cw+1, y+1, cw-2, ch-2, fill)
+		}
+	}
+	for h := 0; h < 24; h += 3 {
+		d.c.Text(left+float64(h)*cw+cw/2, d.h-6, fmt.Sprintf("%02d", h), "middle", d.th.muted)
+	}
+	if peak == 0 {
+		d.noData()
+	}
+}
+
+// niceCeil rounds v up to 1, 2, 2.5 or 5 times a power of ten, so axis
+// labels at quarters stay short.
+func niceCeil(v float64) float64 {
+	if v <= 0 {
+		return 1
+	}
+	p := math.Pow(10, math.Floor(math.Log10(v)))
+	for _, m := range []float64{1, 2, 2.5, 5, 10} {
+		if m*p >= v {
+			return m * p
+		}
+	}
+	return 10 * p
+}
+
+// FormatTokens shortens a count like the dashboard: 1.2K, 3.4M, 5.6B.
+func FormatTokens(v float64) string {
+	switch {
+	case v >= 1e9:
+		return strconv.FormatFloat(v/1e9, 'f', 1, 64) + "B"
+	case v >= 1e6:
+		return strconv.FormatFloat(v/1e6, 'f', 1, 64) + "M"
+	case v >= 1e3:
+		return strconv.FormatFloat(v/1e3, 'f', 1, 64) + "K"
+	default:
+		return strconv.FormatFloat(v, 'f', -1, 64)
+	}
+}
+
+// FormatCost writes dollars with cents, or more decimals below a cent.
+func FormatCost(v float64) string {
+	if v > 0 && v < 0.01 {
+		return "$" + strconv.FormatFloat(v, 'f', 4, 64)
+	}
+	return "$" + strconv.FormatFloat(v, 'f', 2, 64)
+}
+
+// shortDate turns "2026-02-17" into "02-17".
+func shortDate(date string) string {
+	if len(date) == 10 {
+		return date[5:]
+	}
+	return date
+}
+
+func clip(s string, n int) string {
+	r := []rune(s)
+	if len(r) <= n {
+		return s
+	}
+	return string(r[:n-1]) + "…"
+}
@@ -0,0 +1,86 @@
+package chart
+
+import (
+	"bytes"
+	"image/png"
+	"strings"
+	"testing"
+
+	"github.com/yeremiel/claw-usage-chart/store"
+)
+
+func sampleStats() store.StatsResponse {
+	return store.StatsResponse{
+		DailyTokens: []store.DailyTokens{
+			{Date: "2026-09-01", Tokens: 1200, Cost: 0.5},
+			{Date: "2026-09-02", Tokens: 3400, Cost: 1.25},
+			{Date: "unknown", Tokens: 99},
+		},
+		AgentTotals: []store.AgentTotal{
+			{Agent: "main", Tokens: 4000, Cost: 1.5},
+			{Agent: "a<b>", Tokens: 600, Cost: 0.25},
+		},
+		Heatmap: []store.HeatmapCell{{DOW: 0, Hour: 9, Tokens: 4600, Cost: 1.75}},
+	}
+}
+
+func TestRenderSVG(t *testing.T) {
+	for kind := range Kinds {
+		var buf bytes.Buffer
+		if err := Render(&buf, kind, "svg", sampleStats(), Options{Metric: "cost", Theme: "light"}); err != nil {
+			t.Fatalf("%s: %v", kind, err)
+		}
+		svg := buf.String()
+		if !strings.HasPrefix(svg, "<svg ") || !strings.HasSuffix(svg, "</svg>\n") || !strings.Contains(svg, "<title>"+chartTitle(kind, "cost")+"</title>") {
+			t.Errorf("%s: unexpected SVG %q", kind, svg)
+		}
+		if strings.Contains(svg, "No data") {
+			t.Errorf("%s: drew the empty state for sample stats", kind)
+		}
+	}
+
+	var buf bytes.Buffer
+	if err := Render(&buf, "agents", "svg", sampleStats(), Options{}); err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(buf.String(), "a&lt;b&gt;") || !strings.Contains(buf.String(), "4.0K") {
+		t.Errorf("agent names must be escaped and values shortened: %s", buf.String())
+	}
+}
+
+func TestRenderPNGSize(t *testing.T) {
+	for kind := range Kinds {
+		for _, stats := range []store.StatsResponse{sampleStats(), {}} {
+			var buf bytes.Buffer
+			if err := Render(&buf, kind, "png", stats, Options{Width: 400, Height: 200}); err != nil {
+				t.Fatalf("%s: %v", kind, err)
+			}
+			img, err := png.Decode(&buf)
+			if err != nil {
+				t.Fatalf("%s: decode: %v", kind, err)
+			}
+			if b := img.Bounds(); b.Dx() != 400 || b.Dy() != 200 {
+				t.Errorf("%s: size %v, want 400x200", kind, b.Size())
+			}
+		}
+	}
+}
+
+func TestOptionsValidate(t *testing.T) {
+	for _, o := range []Options{{Metric: "calls"}, {Theme: "blue"}, {Width: 10}, {Height: MaxSize + 1}} {
+		if o.Validate() == nil {
+			t.Errorf("%+v: expected an error", o)
+		}
+	}
+	if err := Render(&bytes.Buffer{}, "pie", "svg", store.StatsResponse{}, Options{}); err == nil {
+		t.Error("unknown kind: expected an error")
+	}
+}
+
+func TestNiceCeil(t *testing.T) {
+	for v, want := range map[float64]float64{0: 1, 0.3: 0.5, 7: 10, 120: 200, 2100: 2500, 48000: 50000} {
+		if got := niceCeil(v); got != want {
+			t.Errorf("niceCeil(%v) = %v, want %v", v, got, want)
+		}
+	}
+}
@@ -0,0 +1,210 @@
+// Package client is a typed Go client for the dashboard's JSON API
+// (/api/v1). The request and response types come from package api, the
+// ones the server encodes, so a client built from the same version never
+// drifts from it. Neither package depends on the server's database drivers
+// or renderers.
+//
+//	c := client.New("http://dash.internal:8585")
+//	stats, err := c.Stats(ctx, api.UsageFilter{Start: "2026-02-01"})
+package client
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/yeremiel/claw-usage-chart/api"
+)
+
+// maxErrorBody caps how much of an error response is read.
+const maxErrorBody = 1 << 20
+
+// Client calls one dashboard server. The zero HTTPClient uses a client
+// with a 30 second timeout.
+type Client struct {
+	BaseURL   string // e.g. "http://dash.internal:8585", without /api/v1
+	Token     string // collector token; only Push needs it
+	UserAgent string
+
+	HTTPClient *http.Client
+}
+
+// New returns a client for the server at baseURL.
+func New(baseURL string) *Client {
+	return &Client{BaseURL: baseURL}
+}
+
+// Error is a non-2xx answer from the server.
+type Error struct {
+	StatusCode int
+	Message    string // the "error" field of the body, when there is one
+}
+
+func (e *Error) Error() string {
+	if e.Message == "" {
+		return fmt.Sprintf("claw-usage-chart: %d %s", e.StatusCode, http.StatusText(e.StatusCode))
+	}
+	return fmt.Sprintf("claw-usage-chart: %d %s", e.StatusCode, e.Message)
+}
+
+// Stats returns the dashboard totals for f.
+func (c *Client) Stats(ctx context.Context, f api.UsageFilter) (api.StatsResponse, error) {
+	var out api.StatsResponse
+	err := c.getJSON(ctx, "/stats", api.EncodeUsageFilter(f), &out)
+	return out, err
+}
+
+// Records returns one page of records. Pass the page's NextCursor in
+// q.Cursor to fetch the next one.
+func (c *Client) Records(ctx context.Context, q api.RecordsQuery) (api.RecordsPage, error) {
+	v := api.EncodeUsageFilter(q.Filter)
+	setString(v, "sort", q.Sort)
+	setString(v, "order", q.Order)
+	setString(v, "cursor", q.Cursor)
+	setInt(v, "limit", q.Limit)
+	if q.Raw {
+		v.Set("raw", "1")
+	}
+	var out api.RecordsPage
+	err := c.getJSON(ctx, "/records", v, &out)
+	return out, err
+}
+
+// Aggregate returns totals grouped by q.GroupBy.
+func (c *Client) Aggregate(ctx context.Context, q api.AggregateQuery) (api.AggregateResponse, error) {
+	v := api.EncodeUsageFilter(q.Filter)
+	setString(v, "group_by", strings.Join(q.GroupBy, ","))
+	setString(v, "metrics", strings.Join(q.Metrics, ","))
+	setInt(v, "top", q.Top)
+	setInt(v, "limit", q.Limit)
+	var out api.AggregateResponse
+	err := c.getJSON(ctx, "/aggregate", v, &out)
+	return out, err
+}
+
+// Anomalies runs anomaly detection; zero fields of o use the server's
+// defaults.
+func (c *Client) Anomalies(ctx context.Context, o api.AnomalyOptions) (api.AnomalyResponse, error) {
+	v := url.Values{}
+	setString(v, "granularity", o.Granularity)
+	setString(v, "metric", o.Metric)
+	setString(v, "start", o.Start)
+	setString(v, "end", o.End)
+	setInt(v, "window", o.Window)
+	if o.Sensitivity > 0 {
+		v.Set("sensitivity", strconv.FormatFloat(o.Sensitivity, 'g', -1, 64))
+	}
+	var out api.AnomalyResponse
+	err := c.getJSON(ctx, "/anomalies", v, &out)
+	return out, err
+}
+
+// Query runs a read-only SELECT. The server must run with --query-api.
+// limit <= 0 uses the server's row cap.
+func (c *Client) Query(ctx context.Context, sql string, limit int) (api.QueryResult, error) {
+	v := url.Values{"q": {sql}}
+	setInt(v, "limit", limit)
+	var out api.QueryResult
+	err := c.do(ctx, http.MethodPost, "/query", nil, "application/x-www-form-urlencoded",
+		strings.NewReader(v.Encode()), &out)
+	return out, err
+}
+
+// Chart renders a chart (a key of chart.Kinds) as "svg" or "png".
+func (c *Client) Chart(ctx context.Context, kind, format string, f api.UsageFilter, o api.ChartOptions) ([]byte, error) {
+	v := api.EncodeUsageFilter(f)
+	setInt(v, "width", o.Width)
+	setInt(v, "height", o.Height)
+	setString(v, "metric", o.Metric)
+	setString(v, "theme", o.Theme)
+	var out []byte
+	err := c.do(ctx, http.MethodGet, "/chart/"+url.PathEscape(kind)+"."+url.PathEscape(format), v, "", nil, &out)
+	return out, err
+}
+
+// Push sends a batch to a collector with c.Token. push.Pusher does this
+// incrementally from a local cache; Push is for services that produce
+// records themselves.
+func (c *Client) Push(ctx context.Context, b api.Batch) (api.IngestResult, error) {
+	body, err := json.Marshal(b)
+	if err != nil {
+		return api.IngestResult{}, err
+	}
+	var out api.IngestResult
+	err = c.do(ctx, http.MethodPost, "/push", nil, "application/json", bytes.NewReader(body), &out)
+	return out, err
+}
+
+func (c *Client) getJSON(ctx context.Context, path string, q url.Values, out any) error {
+	return c.do(ctx, http.MethodGet, path, q, "", nil, out)
+}
+
+// do sends one request under api.Prefix. out is decoded as JSON,
+// except a *[]byte which receives the raw body.
+func (c *Client) do(ctx context.Context, method, path string, q url.Values, contentType string, body io.Reader, out any) error {
+	u := strings.TrimRight(c.BaseURL, "/") + api.Prefix + path
+	if len(q) > 0 {
+		u += "?" + q.Encode()
+	}
+	req, err := http.NewRequestWithContext(ctx, method, u, body)
+	if err != nil {
+		return err
+	}
+	if contentType != "" {
+		req.Header.Set("Content-Type", contentType)
+	}
+	if c.Token != "" {
+		req.Header.Set("Authorization", "Bearer "+c.Token)
+	}
+	if c.UserAgent != "" {
+		req.Header.Set("User-Agent", c.UserAgent)
+	}
+
+	hc := c.HTTPClient
+	if hc == nil {
+		hc = &http.Client{Timeout: 30 * time.Second}
+	}
+	resp, err := hc.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		apiErr := &Error{StatusCode: resp.StatusCode}
+		var e struct {
+			Error string `json:"error"`
+		}
+		payload, _ := io.ReadAll(io.LimitReader(resp.Body, maxErrorBody))
+		if json.Unmarshal(payload, &e) == nil {
+			apiErr.Message = e.Error
+		}
+		return apiErr
+	}
+	if raw, ok := out.(*[]byte); ok {
+		*raw, err = io.ReadAll(resp.Body)
+		return err
+	}
+	if err := json.NewDecoder(resp.Body).Decode(out); err != nil {
+		return fmt.Errorf("%s %s: %w", method, path, err)
+	}
+	return nil
+}
+
+func setString(v url.Values, name, value string) {
+	if value != "" {
+		v.Set(name, value)
+	}
+}
+
+func setInt(v url.Values, name string, n int) {
+	if n > 0 {
+		v.Set(name, strconv.Itoa(n))
+	}
+}
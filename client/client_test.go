@@ -0,0 +1,123 @@
+package client
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"go/parser"
+	"go/token"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"testing"
+
+	"github.com/yeremiel/claw-usage-chart/api"
+	"github.com/yeremiel/claw-usage-chart/server"
+	"github.com/yeremiel/claw-usage-chart/store"
+)
+
+func TestClientAgainstServer(t *testing.T) {
+	tmp := t.TempDir()
+	agentsDir := filepath.Join(tmp, "agents")
+	sessionDir := filepath.Join(agentsDir, "alpha", "sessions")
+	if err := os.MkdirAll(sessionDir, 0o755); err != nil {
+		t.Fatalf("mkdir: %v", err)
+	}
+	line := `{"timestamp":"2026-02-17T10:00:00Z","model":"m1","usage":{"input_tokens":42}}` + "\n"
+	if err := os.WriteFile(filepath.Join(sessionDir, "s.jsonl"), []byte(line), 0o644); err != nil {
+		t.Fatalf("write: %v", err)
+	}
+	st, err := store.Open(filepath.Join(tmp, "usage_cache.db"), store.Options{})
+	if err != nil {
+		t.Fatalf("open: %v", err)
+	}
+	defer st.Close()
+	opts := server.Options{
+		QueryAPI:   true,
+		RawRecords: true,
+		PushTokens: []server.PushToken{{User: "ci", Token: "secret", Hosts: []string{"ci-runner"}}},
+	}
+	srv := httptest.NewServer(server.New(st, agentsDir, opts).Handler())
+	defer srv.Close()
+
+	ctx := context.Background()
+	c := New(srv.URL + "/")
+
+	stats, err := c.Stats(ctx, api.UsageFilter{Agent: "alpha"})
+	if err != nil || stats.Summary.TotalTokens != 42 {
+		t.Fatalf("Stats: %+v, %v", stats.Summary, err)
+	}
+	stats, err = c.Stats(ctx, api.UsageFilter{Agent: "beta"})
+	if err != nil || stats.Summary.TotalTokens != 0 {
+		t.Fatalf("Stats filtered: %+v, %v", stats.Summary, err)
+	}
+
+	page, err := c.Records(ctx, api.RecordsQuery{Limit: 10, Raw: true})
+	if err != nil || len(page.Records) != 1 || page.Records[0].Model != "m1" || page.Records[0].Raw == "" {
+		t.Fatalf("Records: %+v, %v", page, err)
+	}
+
+	agg, err := c.Aggregate(ctx, api.AggregateQuery{GroupBy: []string{"model"}, Metrics: []string{"tokens"}})
+	if err != nil || len(agg.Rows) != 1 || agg.Rows[0]["model"] != "m1" {
+		t.Fatalf("Aggregate: %+v, %v", agg, err)
+	}
+
+	an, err := c.Anomalies(ctx, api.AnomalyOptions{Metric: "tokens", Sensitivity: 2.5})
+	if err != nil || an.Metric != "tokens" || an.Sensitivity != 2.5 || an.Anomalies == nil {
+		t.Fatalf("Anomalies: %+v, %v", an, err)
+	}
+
+	res, err := c.Query(ctx, "SELECT SUM(tokens) FROM v_usage", 5)
+	if err != nil || len(res.Rows) != 1 || res.Rows[0][0] != float64(42) {
+		t.Fatalf("Query: %+v, %v", res, err)
+	}
+
+	svg, err := c.Chart(ctx, "daily", "svg", api.UsageFilter{}, api.ChartOptions{Theme: "light"})
+	if err != nil || !bytes.Contains(svg, []byte("<svg")) {
+		t.Fatalf("Chart: %d bytes, %v", len(svg), err)
+	}
+
+	_, err = c.Push(ctx, api.Batch{Host: "ci-runner"})
+	var apiErr *Error
+	if !errors.As(err, &apiErr) || apiErr.StatusCode != http.StatusUnauthorized || apiErr.Message != "invalid token" {
+		t.Fatalf("Push without token: %v", err)
+	}
+	c.Token = "secret"
+	if _, err := c.Push(ctx, api.Batch{Host: "ci-runner"}); err != nil {
+		t.Fatalf("Push: %v", err)
+	}
+
+	_, err = c.Aggregate(ctx, api.AggregateQuery{GroupBy: []string{"nope"}})
+	if !errors.As(err, &apiErr) || apiErr.StatusCode != http.StatusBadRequest || apiErr.Message == "" {
+		t.Fatalf("Aggregate with bad dimension: %v", err)
+	}
+}
+
+// TestImportsStayLight keeps the client and package api on the standard
+// library, so embedding the client never links the database drivers or
+// renderers the server needs.
+func TestImportsStayLight(t *testing.T) {
+	const apiPath = "github.com/yeremiel/claw-usage-chart/api"
+	for _, dir := range []string{".", "../api"} {
+		pkgs, err := parser.ParseDir(token.NewFileSet(), dir, func(fi os.FileInfo) bool {
+			return !strings.HasSuffix(fi.Name(), "_test.go")
+		}, parser.ImportsOnly)
+		if err != nil {
+			t.Fatalf("parse %s: %v", dir, err)
+		}
+		for _, pkg := range pkgs {
+			for name, f := range pkg.Files {
+				for _, imp := range f.Imports {
+					path, _ := strconv.Unquote(imp.Path.Value)
+					first, _, _ := strings.Cut(path, "/")
+					if strings.Contains(first, ".") && path != apiPath {
+						t.Errorf("%s imports %s", name, path)
+					}
+				}
+			}
+		}
+	}
+}
@@ -0,0 +1,114 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"log"
+	"log/slog"
+	"path/filepath"
+	"strconv"
+	"time"
+
+	"github.com/yeremiel/claw-usage-chart/store"
+)
+
+// backupSettings는 백업 디렉터리와 보관 개수다. 플래그가 비어 있으면
+// OCL_BACKUP_DIR, OCL_BACKUP_KEEP, 그다음 기본값을 쓴다.
+func backupSettings(dir string, keep int, p paths) (string, int) {
+	if dir == "" {
+		dir = getEnv("OCL_BACKUP_DIR", filepath.Join(p.BinDir, "backups"))
+	}
+	if keep < 0 {
+		v, err := strconv.Atoi(getEnv("OCL_BACKUP_KEEP", "7"))
+		if err != nil || v < 0 {
+			log.Fatalf("잘못된 백업 보관 개수: %s", getEnv("OCL_BACKUP_KEEP", ""))
+		}
+		keep = v
+	}
+	return dir, keep
+}
+
+// runBackup은 서버 실행 중에도 SQLite 캐시의 일관된 사본을 만든다.
+//
+//	claw-usage-chart backup [--dir 경로] [--keep N]
+func runBackup(args []string) {
+	fs := flag.NewFlagSet("backup", flag.ExitOnError)
+	dir := fs.String("dir", "", "백업 디렉터리 (기본: <바이너리 디렉터리>/backups, 환경변수: OCL_BACKUP_DIR)")
+	keep := fs.Int("keep", -1, "보관할 최근 백업 수, 0이면 모두 보관 (기본: 7, 환경변수: OCL_BACKUP_KEEP)")
+	fs.Parse(args)
+
+	p := resolvePaths("")
+	backupDir, n := backupSettings(*dir, *keep, p)
+	st, _ := openStore("", p.DBPath, store.Options{})
+	defer st.Close()
+
+	path, err := st.BackupRotate(backupDir, n, time.Now())
+	if err != nil {
+		log.Fatalf("백업 실패: %v", err)
+	}
+	fmt.Printf("백업 완료: %s\n", path)
+}
+
+// runRestore는 무결성 검사를 통과한 백업으로 SQLite 캐시를 교체한다.
+// 캐시를 연 서버가 없어야 한다.
+//
+//	claw-usage-chart restore [백업 파일]   (생략 시 가장 최근 백업)
+func runRestore(args []string) {
+	fs := flag.NewFlagSet("restore", flag.ExitOnError)
+	dir := fs.String("dir", "", "백업 디렉터리 (기본: <바이너리 디렉터리>/backups, 환경변수: OCL_BACKUP_DIR)")
+	fs.Parse(args)
+
+	p := resolvePaths("")
+	backupDir, _ := backupSettings(*dir, 0, p)
+
+	var backup string
+	switch fs.NArg() {
+	case 0:
+		backups, err := store.ListBackups(backupDir)
+		if err != nil {
+			log.Fatalf("백업 목록 읽기 실패: %v", err)
+		}
+		if len(backups) == 0 {
+			log.Fatalf("%s 에 백업이 없음", backupDir)
+		}
+		backup = backups[len(backups)-1]
+	case 1:
+		backup = fs.Arg(0)
+	default:
+		log.Fatal("사용법: claw-usage-chart restore [백업 파일]")
+	}
+
+	for _, name := range append(listInstances(), "8585") {
+		inst, err := newInstance(name)
+		if err != nil {
+			continue
+		}
+		if pid, _ := instancePID(inst); pid > 0 && isProcessRunning(pid) && isOwnProcess(pid) {
+			log.Fatalf("데몬 실행 중 (인스턴스 %s, PID %d), --stop 후 다시 시도", name, pid)
+		}
+	}
+	if err := store.RestoreSQLite(backup, p.DBPath); err != nil {
+		log.Fatalf("복원 실패: %v", err)
+	}
+	fmt.Printf("복원 완료: %s → %s (이전 캐시: %s.pre-restore)\n", backup, p.DBPath, p.DBPath)
+}
+
+// runBackupSchedule은 every 주기로 백업하고 오래된 백업을 정리한다.
+func runBackupSchedule(ctx context.Context, st *store.SQLStore, dir string, keep int, every time.Duration) {
+	ticker := time.NewTicker(every)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case now := <-ticker.C:
+			path, err := st.BackupRotate(dir, keep, now)
+			if err != nil {
+				slog.Error("backup failed", "component", "backup", "dir", dir, "err", err)
+				continue
+			}
+			slog.Info("backup written", "component", "backup", "path", path)
+		}
+	}
+}
@@ -0,0 +1,135 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"strings"
+
+	"github.com/yeremiel/claw-usage-chart/notify"
+	"github.com/yeremiel/claw-usage-chart/server"
+	"github.com/yeremiel/claw-usage-chart/store"
+)
+
+// FileConfig is the optional JSON config file (--config / OCL_CONFIG).
+// Everything that is a list of rules or targets lives here; simple scalar
+// settings stay on CLI flags and environment variables.
+type FileConfig struct {
+	Budgets  []store.Budget      `json:"budgets"`
+	Notify   notify.NotifyConfig `json:"notify"`
+	Projects []store.ProjectRule `json:"projects"`
+	Tags     []store.TagRule     `json:"tags"`
+
+	Collector server.CollectorConfig `json:"collector"`
+	Query     QueryConfig            `json:"query"`
+}
+
+// QueryConfig lists the tables and views the query command and /api/query
+// may read. Empty means store.DefaultQueryTables.
+type QueryConfig struct {
+	Allow []string `json:"allow"`
+}
+
+// loadFileConfig reads and validates the config file.
+// A missing file is not an error and yields an empty config.
+func loadFileConfig(path string) (FileConfig, error) {
+	var fc FileConfig
+	data, err := os.ReadFile(path)
+	if os.IsNotExist(err) {
+		return fc, nil
+	}
+	if err != nil {
+		return fc, err
+	}
+	if err := json.Unmarshal(data, &fc); err != nil {
+		return fc, fmt.Errorf("%s: %w", path, err)
+	}
+
+	for i := range fc.Budgets {
+		b := &fc.Budgets[i]
+		if b.Name == "" {
+			return fc, fmt.Errorf("budgets[%d]: name is required", i)
+		}
+		if b.Period == "" {
+			b.Period = "month"
+		}
+		if b.Period != "day" && b.Period != "month" {
+			return fc, fmt.Errorf("budget %q: period must be day or month", b.Name)
+		}
+		if b.LimitUSD <= 0 {
+			return fc, fmt.Errorf("budget %q: limit_usd must be positive", b.Name)
+		}
+		if len(b.Thresholds) == 0 {
+			b.Thresholds = []float64{0.5, 0.8, 1.0}
+		}
+	}
+
+	for i, r := range fc.Projects {
+		if err := r.Validate(); err != nil {
+			return fc, fmt.Errorf("projects[%d]: %w", i, err)
+		}
+	}
+	for i, r := range fc.Tags {
+		if err := r.Validate(); err != nil {
+			return fc, fmt.Errorf("tags[%d]: %w", i, err)
+		}
+	}
+
+	tokens := map[string]bool{}
+	hostUsers := map[string]string{}
+	for i, t := range fc.Collector.Tokens {
+		if t.User == "" || t.Token == "" {
+			return fc, fmt.Errorf("collector.tokens[%d]: user and token are required", i)
+		}
+		if len(t.Token) < 16 {
+			return fc, fmt.Errorf("collector.tokens[%d]: token must be at least 16 characters", i)
+		}
+		if tokens[t.Token] {
+			return fc, fmt.Errorf("collector.tokens[%d]: token is reused", i)
+		}
+		tokens[t.Token] = true
+		if len(t.Hosts) == 0 {
+			return fc, fmt.Errorf("collector.tokens[%d]: hosts is required (the --host-label values this token may push)", i)
+		}
+		for _, h := range t.Hosts {
+			if !store.ValidHost(h) {
+				return fc, fmt.Errorf("collector.tokens[%d]: invalid host %q", i, h)
+			}
+			if u, ok := hostUsers[h]; ok && u != t.User {
+				return fc, fmt.Errorf("collector.tokens[%d]: host %q already belongs to user %q", i, h, u)
+			}
+			hostUsers[h] = t.User
+		}
+	}
+
+	for i, t := range fc.Query.Allow {
+		if t == "" || strings.ContainsAny(t, " \t\"';") {
+			return fc, fmt.Errorf("query.allow[%d]: invalid table name %q", i, t)
+		}
+	}
+
+	names := map[string]bool{}
+	for i := range fc.Notify.Targets {
+		t := &fc.Notify.Targets[i]
+		if t.URL == "" {
+			return fc, fmt.Errorf("notify.targets[%d]: url is required", i)
+		}
+		if t.Name == "" {
+			t.Name = notify.DefaultTargetName(t.URL)
+		} else if t.Name == t.URL {
+			return fc, fmt.Errorf("notify.targets[%d]: name must not be the url, which is a secret", i)
+		}
+		if names[t.Name] {
+			return fc, fmt.Errorf("notify target %q defined twice", t.Name)
+		}
+		names[t.Name] = true
+		switch t.Format {
+		case "":
+			t.Format = "json"
+		case "json", "slack", "discord":
+		default:
+			return fc, fmt.Errorf("notify target %q: unknown format %q", t.Name, t.Format)
+		}
+	}
+	return fc, nil
+}
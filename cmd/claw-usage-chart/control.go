@@ -0,0 +1,298 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"log/slog"
+	"net"
+	"net/http"
+	"os"
+	"runtime/pprof"
+	"sync"
+	"time"
+
+	"github.com/yeremiel/claw-usage-chart/store"
+)
+
+// ── 제어 소켓 ──────────────────────────────────────────────────────────────
+//
+// 실행 중인 서버는 인스턴스의 Unix 소켓(<런타임 디렉터리>/<이름>.sock)에서
+// 로컬 HTTP로 관리 요청을 받는다. 접근은 소켓과 디렉터리의 파일 권한(0600,
+// 0700)으로 소유자에게만 열려 있으므로 별도 인증은 없다.
+//
+//	GET  /status       상태, 마지막 동기화, 레코드 수
+//	POST /sync         지금 동기화
+//	POST /reload       설정 파일 재적재 (SIGHUP과 같음)
+//	POST /rotate-logs  데몬 로그 교체
+//	GET  /goroutines   고루틴 스택 덤프 (text/plain)
+
+// syncStatus는 마지막 Sync 실행 결과다.
+type syncStatus struct {
+	At     time.Time        `json:"at"`
+	Result store.SyncResult `json:"result"`
+	Error  string           `json:"error,omitempty"`
+}
+
+// controlStatus는 GET /status 응답이다.
+type controlStatus struct {
+	PID       int           `json:"pid"`
+	Version   string        `json:"version"`
+	Instance  string        `json:"instance"`
+	Addr      string        `json:"addr"`
+	StartedAt time.Time     `json:"started_at"`
+	LogFile   string        `json:"log_file,omitempty"`
+	LastSync  *syncStatus   `json:"last_sync,omitempty"`
+	Counts    *store.Counts `json:"counts,omitempty"`
+}
+
+// syncTracker는 Store의 Sync 호출(API 요청, 백그라운드 점검)을 가로채
+// 마지막 결과를 기억하고 "sync" 이벤트로 기록한다. 새 레코드가 없는 Sync는
+// 요청마다 일어나므로 debug 레벨이다.
+type syncTracker struct {
+	store.Store
+
+	mu   sync.Mutex
+	last *syncStatus
+}
+
+func (t *syncTracker) Sync(agentsDir string) (store.SyncResult, error) {
+	start := time.Now()
+	res, err := t.Store.Sync(agentsDir)
+	st := &syncStatus{At: time.Now(), Result: res}
+	attrs := []slog.Attr{
+		slog.String("component", "sync"),
+		slog.String("agents_dir", agentsDir),
+		slog.Int("files_scanned", res.SyncedFiles+res.SkippedFiles),
+		slog.Int("files_changed", res.SyncedFiles),
+		slog.Int("records_added", res.NewRecords),
+		slog.Int("duplicates", res.Duplicates),
+		slog.Float64("duration_ms", float64(st.At.Sub(start).Microseconds())/1000),
+	}
+	level := slog.LevelDebug
+	switch {
+	case err != nil:
+		st.Error = err.Error()
+		level = slog.LevelError
+		attrs = append(attrs, slog.String("err", st.Error))
+	case res.NewRecords > 0:
+		level = slog.LevelInfo
+	}
+	slog.LogAttrs(context.Background(), level, "sync", attrs...)
+	t.mu.Lock()
+	t.last = st
+	t.mu.Unlock()
+	return res, err
+}
+
+func (t *syncTracker) lastSync() *syncStatus {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	return t.last
+}
+
+// control은 제어 소켓 요청에 답한다.
+type control struct {
+	inst      instance
+	addr      string
+	started   time.Time
+	agentsDir string
+	syncs     *syncTracker
+	reload    func() error
+	logs      *rotatingFile // 데몬 모드에서만
+}
+
+func (c *control) handler() http.Handler {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/status", func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodGet {
+			writeControlJSON(w, http.StatusMethodNotAllowed, map[string]string{"error": "GET만 가능"})
+			return
+		}
+		st := controlStatus{
+			PID:       os.Getpid(),
+			Version:   version,
+			Instance:  c.inst.Name,
+			Addr:      c.addr,
+			StartedAt: c.started,
+			LastSync:  c.syncs.lastSync(),
+		}
+		if c.logs != nil {
+			st.LogFile = c.inst.LogFile
+		}
+		if counts, err := c.syncs.Counts(); err != nil {
+			slog.Error("record counts failed", "component", "control", "err", err)
+		} else {
+			st.Counts = &counts
+		}
+		writeControlJSON(w, http.StatusOK, st)
+	})
+	mux.HandleFunc("/sync", c.post(func() (any, error) {
+		if _, err := c.syncs.Sync(c.agentsDir); err != nil {
+			return nil, err
+		}
+		return c.syncs.lastSync(), nil
+	}))
+	mux.HandleFunc("/reload", c.post(func() (any, error) {
+		if err := c.reload(); err != nil {
+			return nil, err
+		}
+		slog.Info("config reloaded", "trigger", "control")
+		return map[string]string{"status": "reloaded"}, nil
+	}))
+	mux.HandleFunc("/rotate-logs", c.post(func() (any, error) {
+		if c.logs == nil {
+			return nil, errors.New("로그 파일이 없음 (데몬 모드가 아님)")
+		}
+		if err := c.logs.Rotate(); err != nil {
+			return nil, err
+		}
+		return map[string]string{"log_file": c.inst.LogFile}, nil
+	}))
+	mux.HandleFunc("/goroutines", func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodGet {
+			writeControlJSON(w, http.StatusMethodNotAllowed, map[string]string{"error": "GET만 가능"})
+			return
+		}
+		w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+		pprof.Lookup("goroutine").WriteTo(w, 2)
+	})
+	return mux
+}
+
+// post는 POST 전용 동작을 감싼다. 실패는 500과 {"error": ...}로 답한다.
+func (c *control) post(action func() (any, error)) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost {
+			writeControlJSON(w, http.StatusMethodNotAllowed, map[string]string{"error": "POST만 가능"})
+			return
+		}
+		v, err := action()
+		if err != nil {
+			writeControlJSON(w, http.StatusInternalServerError, map[string]string{"error": err.Error()})
+			return
+		}
+		writeControlJSON(w, http.StatusOK, v)
+	}
+}
+
+func writeControlJSON(w http.ResponseWriter, status int, v any) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(v)
+}
+
+// listenControl은 제어 소켓을 연다. 같은 이름의 인스턴스가 소켓에 응답하면
+// errInstanceRunning을 반환하고, 응답 없는 소켓 파일은 지난 실행의 흔적으로
+// 보고 지운다.
+func listenControl(path string) (*net.UnixListener, error) {
+	if conn, err := net.DialTimeout("unix", path, time.Second); err == nil {
+		conn.Close()
+		return nil, errInstanceRunning
+	}
+	os.Remove(path)
+	ln, err := net.ListenUnix("unix", &net.UnixAddr{Name: path, Net: "unix"})
+	if err != nil {
+		return nil, err
+	}
+	if err := os.Chmod(path, 0o600); err != nil {
+		ln.Close()
+		return nil, err
+	}
+	return ln, nil
+}
+
+// serveControl은 ctx가 끝날 때까지 제어 요청을 받는다. 리스너를 닫으면
+// 소켓 파일도 지워진다 (무중단 교체 때는 SetUnlinkOnClose(false)로 남긴다).
+func serveControl(ctx context.Context, ln *net.UnixListener, h http.Handler) {
+	srv := &http.Server{Handler: h}
+	go func() {
+		<-ctx.Done()
+		srv.Close()
+	}()
+	go func() {
+		if err := srv.Serve(ln); err != http.ErrServerClosed {
+			slog.Error("control socket failed", "component", "control", "err", err)
+		}
+	}()
+}
+
+// controlCall은 제어 소켓에 요청을 보내고 JSON 응답을 out에 읽는다. out이
+// io.Writer면 본문을 그대로 쓴다.
+func controlCall(path, method, endpoint string, out any) error {
+	// 조회는 멈춘 서버를 빨리 알아채야 하고, 첫 동기화 같은 동작은 오래 걸릴
+	// 수 있다.
+	timeout := 5 * time.Second
+	if method == http.MethodPost {
+		timeout = 5 * time.Minute
+	}
+	client := &http.Client{
+		Timeout: timeout,
+		Transport: &http.Transport{
+			DialContext: func(ctx context.Context, _, _ string) (net.Conn, error) {
+				var d net.Dialer
+				return d.DialContext(ctx, "unix", path)
+			},
+		},
+	}
+	req, err := http.NewRequest(method, "http://unix"+endpoint, nil)
+	if err != nil {
+		return err
+	}
+	resp, err := client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		var e struct {
+			Error string `json:"error"`
+		}
+		json.NewDecoder(resp.Body).Decode(&e)
+		return fmt.Errorf("%s %s: %s %s", method, endpoint, resp.Status, e.Error)
+	}
+	switch out := out.(type) {
+	case nil:
+		return nil
+	case io.Writer:
+		_, err = io.Copy(out, resp.Body)
+		return err
+	}
+	return json.NewDecoder(resp.Body).Decode(out)
+}
+
+// runControlCommand는 --sync, --reload, --rotate-logs, --dump-goroutines를
+// 실행 중인 인스턴스의 제어 소켓으로 보낸다.
+func runControlCommand(inst instance, cfg Config) {
+	var err error
+	switch {
+	case cfg.Sync:
+		var st syncStatus
+		if err = controlCall(inst.Socket, "POST", "/sync", &st); err == nil {
+			fmt.Printf("동기화 완료: 새 레코드 %d, 중복 %d, 파일 %d (변경 없음 %d)\n",
+				st.Result.NewRecords, st.Result.Duplicates, st.Result.SyncedFiles, st.Result.SkippedFiles)
+		}
+	case cfg.Reload:
+		if err = controlCall(inst.Socket, "POST", "/reload", nil); err == nil {
+			fmt.Printf("인스턴스 %s 설정 재적재 완료\n", inst.Name)
+		}
+	case cfg.RotateLogs:
+		var res struct {
+			LogFile string `json:"log_file"`
+		}
+		if err = controlCall(inst.Socket, "POST", "/rotate-logs", &res); err == nil {
+			fmt.Printf("로그 교체 완료: %s (이전 로그: %s.1)\n", res.LogFile, res.LogFile)
+		}
+	case cfg.DumpGoroutines:
+		err = controlCall(inst.Socket, "GET", "/goroutines", os.Stdout)
+	}
+	var op *net.OpError
+	if errors.As(err, &op) && op.Op == "dial" {
+		log.Fatalf("인스턴스 %s 가 실행 중이 아니거나 제어 소켓이 없음 (%s)", inst.Name, inst.Socket)
+	} else if err != nil {
+		log.Fatalf("인스턴스 %s: %v", inst.Name, err)
+	}
+}
@@ -0,0 +1,334 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"syscall"
+	"time"
+)
+
+const daemonEnvKey = "__CLAW_DAEMON_CHILD"
+
+// legacyPIDFile는 인스턴스별 경로 이전의 PID 파일이다. 업그레이드 전에
+// 띄운 데몬도 --status, --stop으로 찾을 수 있게 읽기만 한다.
+const legacyPIDFile = "/tmp/claw-usage-chart.pid"
+
+// ── 인스턴스 경로 ──────────────────────────────────────────────────────────
+
+// instance는 한 서버 프로세스의 PID 파일, 제어 소켓, 로그 파일 위치다.
+// 이름(기본: 포트)별로 나뉘어, 한 사용자가 여러 인스턴스를 띄우거나 여러
+// 사용자가 한 머신을 나눠 써도 겹치지 않는다.
+type instance struct {
+	Name    string
+	PIDFile string // <런타임 디렉터리>/<이름>.pid
+	Socket  string // <런타임 디렉터리>/<이름>.sock
+	LogFile string // <상태 디렉터리>/<이름>.log (데몬 모드)
+}
+
+var instanceNameRe = regexp.MustCompile(`^[A-Za-z0-9._-]+$`)
+
+func newInstance(name string) (instance, error) {
+	if !instanceNameRe.MatchString(name) {
+		return instance{}, fmt.Errorf("잘못된 인스턴스 이름: %q (영문, 숫자, ., _, -만)", name)
+	}
+	run, err := runtimeDir()
+	if err != nil {
+		return instance{}, err
+	}
+	state, err := stateDir()
+	if err != nil {
+		return instance{}, err
+	}
+	return instance{
+		Name:    name,
+		PIDFile: filepath.Join(run, name+".pid"),
+		Socket:  filepath.Join(run, name+".sock"),
+		LogFile: filepath.Join(state, name+".log"),
+	}, nil
+}
+
+// runtimeDir는 $XDG_RUNTIME_DIR/claw-usage-chart다. 없으면 임시 디렉터리
+// 아래 사용자별 디렉터리를 쓰되, 다른 사용자가 미리 만들어 둔 것은 거부한다.
+func runtimeDir() (string, error) {
+	if dir := os.Getenv("XDG_RUNTIME_DIR"); dir != "" {
+		dir = filepath.Join(dir, "claw-usage-chart")
+		return dir, os.MkdirAll(dir, 0o700)
+	}
+	dir := filepath.Join(os.TempDir(), fmt.Sprintf("claw-usage-chart-%d", os.Getuid()))
+	if err := os.Mkdir(dir, 0o700); err != nil && !os.IsExist(err) {
+		return "", err
+	}
+	fi, err := os.Lstat(dir)
+	if err != nil {
+		return "", err
+	}
+	st, ok := fi.Sys().(*syscall.Stat_t)
+	if !fi.IsDir() || !ok || int(st.Uid) != os.Getuid() || fi.Mode().Perm()&0o077 != 0 {
+		return "", fmt.Errorf("%s: 다른 사용자 소유이거나 권한이 열려 있음", dir)
+	}
+	return dir, nil
+}
+
+// stateDir는 $XDG_STATE_HOME/claw-usage-chart (기본: ~/.local/state/...)다.
+func stateDir() (string, error) {
+	base := os.Getenv("XDG_STATE_HOME")
+	if base == "" {
+		home, err := os.UserHomeDir()
+		if err != nil {
+			return "", err
+		}
+		base = filepath.Join(home, ".local", "state")
+	}
+	dir := filepath.Join(base, "claw-usage-chart")
+	return dir, os.MkdirAll(dir, 0o700)
+}
+
+// listInstances는 런타임 디렉터리에 PID 파일이나 제어 소켓이 있는 인스턴스
+// 이름을 반환한다.
+func listInstances() []string {
+	run, err := runtimeDir()
+	if err != nil {
+		return nil
+	}
+	seen := map[string]bool{}
+	for _, pattern := range []string{"*.pid", "*.sock"} {
+		matches, _ := filepath.Glob(filepath.Join(run, pattern))
+		for _, m := range matches {
+			seen[strings.TrimSuffix(filepath.Base(m), filepath.Ext(m))] = true
+		}
+	}
+	names := make([]string, 0, len(seen))
+	for n := range seen {
+		names = append(names, n)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// ── PID 파일 관리 ──────────────────────────────────────────────────────────
+
+func writePIDFile(path string) error {
+	return os.WriteFile(path, []byte(strconv.Itoa(os.Getpid())), 0o644)
+}
+
+// removePIDFile은 PID 파일이 이 프로세스의 것일 때만 지운다. 무중단 교체
+// 뒤에는 새 프로세스가 이미 자신의 PID를 써 두었다.
+func removePIDFile(path string) {
+	if readPID(path) == os.Getpid() {
+		os.Remove(path)
+	}
+}
+
+func readPID(path string) int {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return 0
+	}
+	pid, err := strconv.Atoi(strings.TrimSpace(string(data)))
+	if err != nil {
+		return 0
+	}
+	return pid
+}
+
+// instancePID는 인스턴스의 PID를 읽는다. 기본 인스턴스는 예전 PID 파일도
+// 본다. 파일 경로도 함께 반환한다.
+func instancePID(inst instance) (int, string) {
+	if pid := readPID(inst.PIDFile); pid > 0 {
+		return pid, inst.PIDFile
+	}
+	if inst.Name == "8585" {
+		if pid := readPID(legacyPIDFile); pid > 0 {
+			return pid, legacyPIDFile
+		}
+	}
+	return 0, inst.PIDFile
+}
+
+func isProcessRunning(pid int) bool {
+	if pid <= 0 {
+		return false
+	}
+	proc, err := os.FindProcess(pid)
+	if err != nil {
+		return false
+	}
+	// Unix에서 FindProcess는 항상 성공. signal 0으로 실제 생존 확인.
+	return proc.Signal(syscall.Signal(0)) == nil
+}
+
+// ── 데몬 관리 ──────────────────────────────────────────────────────────────
+
+// printStatus는 제어 소켓으로 상태를 묻고, 응답이 없으면 PID 파일로
+// 실행 여부만 확인한다.
+func printStatus(inst instance) {
+	var st controlStatus
+	if err := controlCall(inst.Socket, "GET", "/status", &st); err == nil {
+		fmt.Printf("claw-usage-chart 실행 중: 인스턴스 %s (PID %d, %s)\n", st.Instance, st.PID, st.Version)
+		fmt.Printf("  주소          : %s\n", st.Addr)
+		fmt.Printf("  가동 시간     : %s (%s부터)\n", time.Since(st.StartedAt).Round(time.Second), st.StartedAt.Local().Format("2006-01-02 15:04:05"))
+		switch {
+		case st.LastSync == nil:
+			fmt.Println("  마지막 동기화 : 아직 없음")
+		case st.LastSync.Error != "":
+			fmt.Printf("  마지막 동기화 : %s 실패: %s\n", st.LastSync.At.Local().Format("2006-01-02 15:04:05"), st.LastSync.Error)
+		default:
+			fmt.Printf("  마지막 동기화 : %s (%s 전, 새 레코드 %d)\n", st.LastSync.At.Local().Format("2006-01-02 15:04:05"),
+				time.Since(st.LastSync.At).Round(time.Second), st.LastSync.Result.NewRecords)
+		}
+		if st.Counts != nil {
+			fmt.Printf("  레코드        : %d (세션 파일 %d, 에이전트 %d, 제거된 중복 %d)\n",
+				st.Counts.Records, st.Counts.SessionFiles, st.Counts.Agents, st.Counts.Duplicates)
+		}
+		if st.LogFile != "" {
+			fmt.Printf("  로그          : %s\n", st.LogFile)
+		}
+		return
+	}
+
+	pid, pidFile := instancePID(inst)
+	if pid > 0 && isProcessRunning(pid) && isOwnProcess(pid) {
+		fmt.Printf("claw-usage-chart 실행 중: 인스턴스 %s (PID %d, 제어 소켓 응답 없음)\n", inst.Name, pid)
+		return
+	}
+	fmt.Printf("claw-usage-chart 인스턴스 %s 는 실행 중이 아닙니다\n", inst.Name)
+	if pid > 0 {
+		removePIDFile(pidFile)
+	}
+}
+
+// checkDaemonStatus는 인스턴스 하나의 상태를 출력한다. all이면 런타임
+// 디렉터리에 흔적이 있는 모든 인스턴스를 출력한다.
+func checkDaemonStatus(inst instance, all bool) {
+	if !all {
+		printStatus(inst)
+		return
+	}
+	names := listInstances()
+	if len(names) == 0 {
+		printStatus(inst)
+		return
+	}
+	for i, name := range names {
+		if i > 0 {
+			fmt.Println()
+		}
+		other, err := newInstance(name)
+		if err != nil {
+			continue
+		}
+		printStatus(other)
+	}
+}
+
+// stopDaemon은 인스턴스에 SIGTERM을 보낸다. wait이면 프로세스가 끝날
+// 때까지 기다리고, timeout이 지나면 SIGKILL로 끝낸다.
+func stopDaemon(inst instance, wait bool, timeout time.Duration) {
+	pid := instanceProcess(inst)
+	if pid == 0 {
+		fmt.Printf("실행 중인 데몬이 없습니다 (인스턴스 %s)\n", inst.Name)
+		if _, pidFile := instancePID(inst); pidFile != "" {
+			os.Remove(pidFile)
+		}
+		return
+	}
+	if err := syscall.Kill(pid, syscall.SIGTERM); err != nil {
+		log.Fatalf("SIGTERM 전송 실패 (PID %d): %v", pid, err)
+	}
+	fmt.Printf("claw-usage-chart 데몬에 종료 신호 전송 (인스턴스 %s, PID %d)\n", inst.Name, pid)
+	if !wait {
+		return
+	}
+
+	if waitExit(pid, timeout) {
+		fmt.Println("종료됨")
+		return
+	}
+	fmt.Printf("%s 안에 끝나지 않아 SIGKILL 전송\n", timeout)
+	if err := syscall.Kill(pid, syscall.SIGKILL); err != nil && err != syscall.ESRCH {
+		log.Fatalf("SIGKILL 전송 실패 (PID %d): %v", pid, err)
+	}
+	if !waitExit(pid, 5*time.Second) {
+		log.Fatalf("PID %d 가 SIGKILL 뒤에도 남아 있음", pid)
+	}
+	// 강제 종료된 프로세스는 자기 파일을 치우지 못한다.
+	os.Remove(inst.PIDFile)
+	os.Remove(inst.Socket)
+	fmt.Println("강제 종료됨")
+}
+
+// waitExit는 pid가 끝날 때까지 최대 timeout 동안 기다린다.
+func waitExit(pid int, timeout time.Duration) bool {
+	deadline := time.Now().Add(timeout)
+	for isProcessRunning(pid) {
+		if time.Now().After(deadline) {
+			return false
+		}
+		time.Sleep(100 * time.Millisecond)
+	}
+	return true
+}
+
+// isOwnProcess는 해당 PID의 프로세스가 claw-usage-chart 바이너리인지 확인한다.
+func isOwnProcess(pid int) bool {
+	exe, err := os.Readlink(fmt.Sprintf("/proc/%d/exe", pid))
+	if err == nil {
+		return strings.Contains(exe, "claw-usage-chart")
+	}
+	// macOS: /proc 없으므로 ps로 확인
+	out, err := exec.Command("ps", "-p", strconv.Itoa(pid), "-o", "comm=").Output()
+	if err != nil {
+		return false
+	}
+	return strings.Contains(strings.TrimSpace(string(out)), "claw-usage-chart")
+}
+
+func isDaemonChild() bool {
+	return os.Getenv(daemonEnvKey) == "1"
+}
+
+// forkDaemon은 현재 바이너리를 백그라운드 자식 프로세스로 재실행한다.
+// 자식은 __CLAW_DAEMON_CHILD=1 환경변수로 데몬 모드를 감지하고, 출력을
+// 인스턴스 로그 파일로 보낸다 (openDaemonLog).
+func forkDaemon(inst instance) {
+	if pid, _ := instancePID(inst); pid > 0 && isProcessRunning(pid) && isOwnProcess(pid) {
+		log.Fatalf("인스턴스 %s 가 이미 실행 중 (PID %d)", inst.Name, pid)
+	}
+	exe, err := os.Executable()
+	if err != nil {
+		log.Fatalf("실행 파일 경로 확인 실패: %v", err)
+	}
+
+	// 자식이 openDaemonLog로 표준 출력을 옮기기 전에 죽어도 그 메시지가
+	// 남도록 처음부터 로그 파일에 연결해 둔다.
+	logFile, err := os.OpenFile(inst.LogFile, os.O_WRONLY|os.O_CREATE|os.O_APPEND, 0o600)
+	if err != nil {
+		log.Fatalf("로그 파일 열기 실패: %v", err)
+	}
+	defer logFile.Close()
+
+	cmd := exec.Command(exe, os.Args[1:]...)
+	cmd.Env = append(os.Environ(), daemonEnvKey+"=1")
+	cmd.Stdout = logFile
+	cmd.Stderr = logFile
+	cmd.Stdin = nil
+	cmd.SysProcAttr = &syscall.SysProcAttr{Setsid: true}
+
+	if err := cmd.Start(); err != nil {
+		log.Fatalf("데몬 프로세스 시작 실패: %v", err)
+	}
+
+	fmt.Printf("claw-usage-chart 데몬 시작 (인스턴스 %s, PID %d)\n", inst.Name, cmd.Process.Pid)
+	fmt.Printf("  로그: %s\n", inst.LogFile)
+}
+
+// errInstanceRunning은 같은 이름의 인스턴스가 이미 제어 소켓을 쓰고 있음을 뜻한다.
+var errInstanceRunning = errors.New("같은 이름의 인스턴스가 이미 실행 중")
@@ -0,0 +1,88 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"sync"
+
+	"golang.org/x/sys/unix"
+)
+
+// 데몬 로그는 logMaxSize를 넘으면 .1, .2, … 로 밀려나고 logKeep개까지 남는다.
+const (
+	logMaxSize = 10 << 20
+	logKeep    = 5
+)
+
+// rotatingFile은 크기가 차면 스스로 교체되는 로그 파일이다. 데몬 자식의
+// 표준 출력·오류도 같은 파일을 가리키게 해, 패닉과 fmt 출력도 남는다.
+type rotatingFile struct {
+	path string
+
+	mu   sync.Mutex
+	f    *os.File
+	size int64
+}
+
+// openDaemonLog는 path를 이어 쓰기로 열고 표준 출력·오류를 그리로 돌린다.
+func openDaemonLog(path string) (*rotatingFile, error) {
+	r := &rotatingFile{path: path}
+	if err := r.open(); err != nil {
+		return nil, err
+	}
+	return r, nil
+}
+
+func (r *rotatingFile) open() error {
+	f, err := os.OpenFile(r.path, os.O_WRONLY|os.O_CREATE|os.O_APPEND, 0o600)
+	if err != nil {
+		return err
+	}
+	fi, err := f.Stat()
+	if err != nil {
+		f.Close()
+		return err
+	}
+	for _, fd := range []int{1, 2} {
+		if err := unix.Dup2(int(f.Fd()), fd); err != nil {
+			f.Close()
+			return fmt.Errorf("표준 출력 연결: %w", err)
+		}
+	}
+	if r.f != nil {
+		r.f.Close()
+	}
+	r.f, r.size = f, fi.Size()
+	return nil
+}
+
+func (r *rotatingFile) Write(p []byte) (int, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if r.size+int64(len(p)) > logMaxSize && r.size > 0 {
+		if err := r.rotate(); err != nil {
+			fmt.Fprintf(r.f, "로그 교체 실패: %v\n", err)
+		}
+	}
+	n, err := r.f.Write(p)
+	r.size += int64(n)
+	return n, err
+}
+
+// Rotate는 크기와 관계없이 지금 로그를 교체한다.
+func (r *rotatingFile) Rotate() error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return r.rotate()
+}
+
+func (r *rotatingFile) rotate() error {
+	os.Remove(fmt.Sprintf("%s.%d", r.path, logKeep))
+	for i := logKeep - 1; i >= 1; i-- {
+		os.Rename(fmt.Sprintf("%s.%d", r.path, i), fmt.Sprintf("%s.%d", r.path, i+1))
+	}
+	if err := os.Rename(r.path, r.path+".1"); err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	return r.open()
+}
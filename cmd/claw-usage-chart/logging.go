@@ -0,0 +1,61 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"log/slog"
+	"os"
+	"strings"
+)
+
+// ── 구조화 로그 ────────────────────────────────────────────────────────────
+//
+// 서버는 log/slog로 기록한다. 메시지는 영어의 고정된 이벤트 이름이고
+// 세부 사항은 키-값 속성이라, 로그 수집기에서 component·request_id 등으로
+// 걸러 볼 수 있다. 표준 log 패키지로 남기는 메시지도 같은 핸들러를 거친다.
+
+// parseLogLevel은 debug, info, warn, error(대소문자 무관)를 받는다.
+func parseLogLevel(s string) (slog.Level, error) {
+	var level slog.Level
+	if err := level.UnmarshalText([]byte(s)); err != nil {
+		return 0, fmt.Errorf("잘못된 로그 레벨 %q (debug, info, warn, error)", s)
+	}
+	return level, nil
+}
+
+// setupLogging은 w로 쓰는 기본 slog 핸들러를 설치한다. format은 text 또는
+// json이다. journald 아래에서는 journald가 시각을 붙이므로 text 출력에서
+// 시각을 뺀다.
+func setupLogging(w io.Writer, format, level string) error {
+	lv, err := parseLogLevel(level)
+	if err != nil {
+		return err
+	}
+	opts := &slog.HandlerOptions{Level: lv}
+	var h slog.Handler
+	switch strings.ToLower(format) {
+	case "json":
+		h = slog.NewJSONHandler(w, opts)
+	case "text":
+		if underJournald() {
+			opts.ReplaceAttr = func(groups []string, a slog.Attr) slog.Attr {
+				if len(groups) == 0 && a.Key == slog.TimeKey {
+					return slog.Attr{}
+				}
+				return a
+			}
+		}
+		h = slog.NewTextHandler(w, opts)
+	default:
+		return fmt.Errorf("잘못된 로그 형식 %q (text, json)", format)
+	}
+	slog.SetDefault(slog.New(h))
+	return nil
+}
+
+// fatal은 오류 이벤트를 남기고 종료한다. 로그가 설정된 뒤 서버 경로에서
+// log.Fatalf 대신 쓴다.
+func fatal(msg string, args ...any) {
+	slog.Error(msg, args...)
+	os.Exit(1)
+}
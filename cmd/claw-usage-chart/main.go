@@ -0,0 +1,361 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"log"
+	"log/slog"
+	"net"
+	"net/http"
+	"net/url"
+	"os"
+	"os/signal"
+	"path/filepath"
+	"sync"
+	"sync/atomic"
+	"syscall"
+	"time"
+
+	"github.com/yeremiel/claw-usage-chart/notify"
+	"github.com/yeremiel/claw-usage-chart/server"
+	"github.com/yeremiel/claw-usage-chart/store"
+)
+
+func main() {
+	if len(os.Args) > 1 {
+		if run, ok := subcommands[os.Args[1]]; ok {
+			run(os.Args[2:])
+			return
+		}
+	}
+
+	cfg := ParseFlags()
+	started := time.Now()
+	inst, err := newInstance(cfg.Instance)
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	// 데몬 자식은 표준 출력·오류와 로그를 인스턴스 로그 파일로 보낸다.
+	var logs *rotatingFile
+	var logOut io.Writer = os.Stderr
+	if isDaemonChild() {
+		if logs, err = openDaemonLog(inst.LogFile); err != nil {
+			log.Fatalf("로그 파일 열기 실패: %v", err)
+		}
+		logOut = logs
+	}
+	if err := setupLogging(logOut, cfg.LogFormat, cfg.LogLevel); err != nil {
+		log.Fatal(err)
+	}
+
+	// ── 경로 설정 ────────────────────────────────────────────────────────────
+	p := resolvePaths(cfg.ConfigPath)
+	agentsDir, dbPath, configPath := p.AgentsDir, p.DBPath, p.ConfigPath
+	fileCfg, err := loadFileConfig(configPath)
+	if err != nil {
+		fatal("read config failed", "path", configPath, "err", err)
+	}
+
+	// ── 시작 전 액션 ─────────────────────────────────────────────────────────
+	if cfg.Reset {
+		if cfg.DBURL != "" {
+			slog.Warn("--reset only applies to the SQLite cache, PostgreSQL data left as is")
+		} else {
+			resetDBCache(dbPath)
+		}
+	}
+
+	// ── 데몬 fork (부모 경로) ────────────────────────────────────────────────
+	if cfg.Daemon && !isDaemonChild() {
+		forkDaemon(inst)
+		if cfg.Open {
+			openBrowser(fmt.Sprintf("http://%s:%s", browserHost(cfg.Host), cfg.Port))
+		}
+		os.Exit(0)
+	}
+
+	// ── 저장소 열기 (SQLite 또는 PostgreSQL) ────────────────────────────────
+	st, dbLabel := openStore(cfg.DBURL, dbPath, store.Options{Dedupe: cfg.Dedupe, Projects: fileCfg.Projects, Tags: fileCfg.Tags, QueryDSN: cfg.QueryDBURL})
+	defer st.Close()
+	tracked := &syncTracker{Store: st}
+	if cfg.QueryAPI && cfg.DBURL != "" && cfg.QueryDBURL == "" {
+		slog.Warn("/api/query on PostgreSQL needs --query-db-url; it answers 503 until one is set")
+	}
+
+	// ── Graceful shutdown ────────────────────────────────────────────────────
+	addr := fmt.Sprintf("%s:%s", cfg.Host, cfg.Port)
+	app := server.New(tracked, agentsDir, serverOptions(cfg, fileCfg))
+	srv := &http.Server{Addr: addr, Handler: app.Handler()}
+
+	sigCtx, stop := signal.NotifyContext(context.Background(), syscall.SIGINT, syscall.SIGTERM)
+	defer stop()
+	// 무중단 교체가 끝나면 시그널 없이도 종료한다.
+	ctx, shutdown := context.WithCancel(sigCtx)
+	defer shutdown()
+	var handedOff atomic.Bool
+
+	daemon := isDaemonChild()
+
+	// ── 웹훅 알림 + 백그라운드 점검 ──────────────────────────────────────────
+	var notifier *notify.Notifier
+	sinks := []server.AnomalySink{server.LogAnomalySink{}}
+	if len(fileCfg.Notify.Targets) > 0 {
+		notifier = notify.NewNotifier(st.Outbox(), fileCfg.Notify.Targets)
+		notifier.UserAgent = "claw-usage-chart/" + version
+		sinks = append(sinks, notifier)
+		go notifier.Run(ctx)
+	}
+	var monitor *server.Monitor
+	if cfg.WatchInterval > 0 {
+		monitor = &server.Monitor{
+			Store:       tracked,
+			AgentsDir:   agentsDir,
+			Interval:    cfg.WatchInterval,
+			Sensitivity: cfg.AnomalySensitivity,
+			Budgets:     fileCfg.Budgets,
+			Notifier:    notifier,
+			Sinks:       sinks,
+		}
+		go monitor.Run(ctx)
+	}
+
+	// ── 자동 백업 ────────────────────────────────────────────────────────────
+	if cfg.BackupInterval > 0 {
+		if cfg.DBURL != "" {
+			slog.Warn("automatic backups only apply to the SQLite cache, use pg_dump for PostgreSQL")
+		} else {
+			dir, keep := backupSettings(cfg.BackupDir, cfg.BackupKeep, p)
+			go runBackupSchedule(ctx, st, dir, keep, cfg.BackupInterval)
+		}
+	}
+
+	stopped := make(chan struct{})
+	go func() {
+		defer close(stopped)
+		<-ctx.Done()
+		if handedOff.Load() {
+			slog.Info("handed off to new process, draining requests")
+		} else {
+			slog.Info("shutting down")
+			sdNotify("STOPPING=1")
+		}
+		shutdownCtx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+		defer cancel()
+		if err := srv.Shutdown(shutdownCtx); err != nil {
+			slog.Error("shutdown failed", "err", err)
+		}
+		if daemon {
+			removePIDFile(inst.PIDFile)
+		}
+	}()
+
+	// ── 서버 시작 ────────────────────────────────────────────────────────────
+	// 리스너는 무중단 교체로 넘겨받은 것, systemd가 넘긴 것, 직접 연 것 순.
+	inherited, err := inheritedListeners()
+	if err != nil {
+		fatal("inherit listeners failed", "err", err)
+	}
+	var ln net.Listener
+	var ctlLn *net.UnixListener
+	if inherited != nil {
+		ln, ctlLn = inherited.http, inherited.ctl
+		slog.Info("listener inherited from previous process", "addr", ln.Addr().String())
+	} else if ln, err = activationListener(); err != nil {
+		fatal("socket activation failed", "err", err)
+	} else if ln != nil {
+		slog.Info("listener from systemd socket activation", "addr", ln.Addr().String())
+	} else if ln, err = net.Listen("tcp", addr); err != nil {
+		fatal("listen failed", "addr", addr, "err", err)
+	}
+
+	// 리스너가 성공한 후에만 PID 파일 작성 (포트 충돌 시 stale PID 방지)
+	if daemon {
+		if err := writePIDFile(inst.PIDFile); err != nil {
+			fatal("write PID file failed", "path", inst.PIDFile, "err", err)
+		}
+	}
+
+	// 소켓 활성화에서는 systemd가 연 포트가 --port와 다를 수 있다.
+	_, port, _ := net.SplitHostPort(ln.Addr().String())
+
+	// ── 설정 재적재 (SIGHUP, 제어 소켓) ─────────────────────────────────────
+	var reloadMu sync.Mutex
+	reload := func() error {
+		reloadMu.Lock()
+		defer reloadMu.Unlock()
+		fc, err := loadFileConfig(configPath)
+		if err != nil {
+			return err
+		}
+		st.SetOptions(store.Options{Dedupe: cfg.Dedupe, Projects: fc.Projects, Tags: fc.Tags, QueryDSN: cfg.QueryDBURL})
+		app.SetOptions(serverOptions(cfg, fc))
+		if monitor != nil {
+			monitor.SetBudgets(fc.Budgets)
+		}
+		if notifier != nil {
+			notifier.SetTargets(fc.Notify.Targets)
+		} else if len(fc.Notify.Targets) > 0 {
+			slog.Warn("notify targets added to a server started without any, restart to enable them")
+		}
+		return nil
+	}
+
+	// ── 제어 소켓 ────────────────────────────────────────────────────────────
+	ctl := &control{
+		inst:      inst,
+		addr:      fmt.Sprintf("http://%s:%s", browserHost(cfg.Host), port),
+		started:   started,
+		agentsDir: agentsDir,
+		syncs:     tracked,
+		reload:    reload,
+		logs:      logs,
+	}
+	if ctlLn == nil {
+		ctlLn, err = listenControl(inst.Socket)
+		if err == errInstanceRunning {
+			fatal("instance already running, pick another --instance", "instance", inst.Name, "socket", inst.Socket)
+		} else if err != nil {
+			slog.Warn("control socket unavailable, --status falls back to the PID file", "socket", inst.Socket, "err", err)
+		}
+	}
+	if ctlLn != nil {
+		serveControl(ctx, ctlLn, ctl.handler())
+	}
+
+	// ── 시그널: 설정 재적재(SIGHUP), 무중단 교체(SIGUSR2) ───────────────────
+	sigs := make(chan os.Signal, 1)
+	signal.Notify(sigs, syscall.SIGHUP, syscall.SIGUSR2)
+	go func() {
+		for sig := range sigs {
+			switch sig {
+			case syscall.SIGHUP:
+				if err := reload(); err != nil {
+					slog.Error("config reload failed, keeping previous settings", "path", configPath, "err", err)
+				} else {
+					slog.Info("config reloaded", "path", configPath, "trigger", "SIGHUP")
+				}
+			case syscall.SIGUSR2:
+				slog.Info("starting zero-downtime restart")
+				pid, err := spawnSuccessor(ln, ctlLn)
+				if err != nil {
+					slog.Error("restart failed, still serving", "err", err)
+					continue
+				}
+				sdNotify(fmt.Sprintf("MAINPID=%d", pid))
+				if ctlLn != nil {
+					ctlLn.SetUnlinkOnClose(false) // 소켓 파일은 새 프로세스의 것
+				}
+				handedOff.Store(true)
+				shutdown()
+				return
+			}
+		}
+	}()
+
+	fmt.Printf("Claw Usage Chart → http://localhost:%s\n", port)
+	fmt.Printf("  Agents dir : %s\n", agentsDir)
+	fmt.Printf("  DB cache   : %s (%s)\n", dbLabel, st.Dialect())
+	fmt.Printf("  Config     : %s\n", configPath)
+	fmt.Printf("  Instance   : %s (%s)\n", inst.Name, inst.Socket)
+	slog.Info("server started", "addr", ln.Addr().String(), "instance", inst.Name, "version", version,
+		"pid", os.Getpid(), "store", st.Dialect(), "agents_dir", agentsDir)
+
+	if cfg.Open && !daemon && inherited == nil {
+		openBrowser(fmt.Sprintf("http://%s:%s", browserHost(cfg.Host), port))
+	}
+
+	if inherited != nil {
+		inherited.signalReady()
+	} else {
+		sdNotify("READY=1")
+	}
+	if interval := watchdogInterval(); interval > 0 {
+		go runWatchdog(ctx.Done(), ln.Addr(), interval)
+	}
+
+	if err := srv.Serve(ln); err != http.ErrServerClosed {
+		fatal("serve failed", "err", err)
+	}
+	<-stopped
+	slog.Info("server stopped")
+}
+
+// serverOptions는 플래그와 설정 파일에서 서버 옵션을 만든다. SIGHUP 재적재
+// 때도 같은 함수로 다시 만든다.
+func serverOptions(cfg Config, fc FileConfig) server.Options {
+	return server.Options{
+		AnomalySensitivity: cfg.AnomalySensitivity,
+		PushTokens:         fc.Collector.Tokens,
+		QueryAPI:           cfg.QueryAPI,
+		QueryAllow:         fc.Query.Allow,
+		RawRecords:         cfg.RawRecords,
+		Budgets:            fc.Budgets,
+	}
+}
+
+// paths는 환경변수와 기본값으로 정한 파일 위치다.
+type paths struct {
+	AgentsDir  string
+	DBPath     string
+	ConfigPath string
+	BinDir     string // 바이너리가 있는 디렉터리, 기본 파일 위치의 기준
+}
+
+// resolvePaths는 에이전트 디렉터리, SQLite 캐시, 설정 파일 경로를 정한다.
+// configFlag가 비어 있으면 OCL_CONFIG, 그다음 바이너리 옆 config.json을 쓴다.
+func resolvePaths(configFlag string) paths {
+	home, err := os.UserHomeDir()
+	if err != nil {
+		log.Fatalf("홈 디렉터리 확인 불가: %v", err)
+	}
+	var p paths
+	p.AgentsDir = getEnv("OCL_AGENTS_DIR", filepath.Join(home, ".openclaw", "agents"))
+
+	p.BinDir = "."
+	if exe, err := os.Executable(); err == nil {
+		p.BinDir = filepath.Dir(exe)
+	}
+	p.DBPath = getEnv("OCL_DB_PATH", filepath.Join(p.BinDir, "usage_cache.db"))
+
+	p.ConfigPath = configFlag
+	if p.ConfigPath == "" {
+		p.ConfigPath = getEnv("OCL_CONFIG", filepath.Join(p.BinDir, "config.json"))
+	}
+	return p
+}
+
+// openStore는 dbURL이 있으면 PostgreSQL을, 없으면 dbPath의 SQLite를 연다.
+// 실패하면 종료한다. 로그용 이름(비밀번호 제외)도 함께 반환한다.
+func openStore(dbURL, dbPath string, opts store.Options) (*store.SQLStore, string) {
+	label := dbPath
+	var st *store.SQLStore
+	var err error
+	if dbURL != "" {
+		label = redactDBURL(dbURL)
+		st, err = store.OpenPostgres(dbURL, opts)
+	} else {
+		st, err = store.Open(dbPath, opts)
+	}
+	if err != nil {
+		log.Fatalf("DB 열기 실패 %s: %v", label, err)
+	}
+	return st, label
+}
+
+// redactDBURL은 로그에 남기지 않도록 접속 URL의 비밀번호를 가린다.
+func redactDBURL(raw string) string {
+	u, err := url.Parse(raw)
+	if err != nil {
+		return "postgres"
+	}
+	return u.Redacted()
+}
+
+func getEnv(key, fallback string) string {
+	if v := os.Getenv(key); v != "" {
+		return v
+	}
+	return fallback
+}
@@ -0,0 +1,98 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"log"
+	"os"
+	"os/signal"
+	"path/filepath"
+	"syscall"
+	"time"
+
+	"github.com/yeremiel/claw-usage-chart/push"
+	"github.com/yeremiel/claw-usage-chart/store"
+)
+
+// runPush는 로컬 세션 파일을 증분 동기화해 중앙 수집 서버로 전송한다.
+//
+//	claw-usage-chart push --url http://dash.internal:8585 --token <토큰>
+func runPush(args []string) {
+	fs := flag.NewFlagSet("push", flag.ExitOnError)
+	url := fs.String("url", "", "수집 서버 주소 (환경변수: OCL_PUSH_URL)")
+	token := fs.String("token", "", "수집 서버 인증 토큰 (환경변수: OCL_PUSH_TOKEN)")
+	hostLabel := fs.String("host-label", "", "이 머신의 레코드에 붙일 호스트 이름 (기본: hostname, 환경변수: OCL_PUSH_HOST)")
+	interval := fs.String("interval", "", "전송 주기 (기본: 1m, 환경변수: OCL_PUSH_INTERVAL)")
+	once := fs.Bool("once", false, "한 번만 전송하고 종료")
+	dbPath := fs.String("db", "", "전송용 SQLite 캐시 경로 (기본: <바이너리 디렉터리>/push_cache.db, 환경변수: OCL_PUSH_DB)")
+	configPath := fs.String("config", "", "JSON 설정 파일 경로, 프로젝트 규칙에 사용 (환경변수: OCL_CONFIG)")
+	fs.Parse(args)
+
+	p := resolvePaths(*configPath)
+	fileCfg, err := loadFileConfig(p.ConfigPath)
+	if err != nil {
+		log.Fatalf("설정 파일 읽기 실패: %v", err)
+	}
+
+	if *url == "" {
+		*url = getEnv("OCL_PUSH_URL", "")
+	}
+	if *token == "" {
+		*token = getEnv("OCL_PUSH_TOKEN", "")
+	}
+	if *url == "" || *token == "" {
+		log.Fatal("--url과 --token(또는 OCL_PUSH_URL, OCL_PUSH_TOKEN)이 필요함")
+	}
+	if *hostLabel == "" {
+		h, _ := os.Hostname()
+		*hostLabel = getEnv("OCL_PUSH_HOST", h)
+	}
+	if !store.ValidHost(*hostLabel) {
+		log.Fatalf("사용할 수 없는 호스트 이름: %q", *hostLabel)
+	}
+	if *interval == "" {
+		*interval = getEnv("OCL_PUSH_INTERVAL", "1m")
+	}
+	every, err := time.ParseDuration(*interval)
+	if err != nil || every <= 0 {
+		log.Fatalf("잘못된 전송 주기: %s", *interval)
+	}
+	if *dbPath == "" {
+		*dbPath = getEnv("OCL_PUSH_DB", filepath.Join(p.BinDir, "push_cache.db"))
+	}
+
+	// 대시보드 서버와 캐시를 공유하지 않도록 전송 전용 캐시를 쓴다.
+	st, err := store.Open(*dbPath, store.Options{
+		Dedupe:   getEnv("OCL_DEDUPE", store.DedupeContent),
+		Projects: fileCfg.Projects,
+	})
+	if err != nil {
+		log.Fatalf("DB 열기 실패 %s: %v", *dbPath, err)
+	}
+	defer st.Close()
+
+	pusher := &push.Pusher{
+		Store:     st,
+		AgentsDir: p.AgentsDir,
+		URL:       *url,
+		Token:     *token,
+		Host:      *hostLabel,
+		UserAgent: "claw-usage-chart/" + version,
+	}
+
+	ctx, stop := signal.NotifyContext(context.Background(), syscall.SIGINT, syscall.SIGTERM)
+	defer stop()
+
+	if *once {
+		res, err := pusher.Push(ctx)
+		if err != nil {
+			log.Fatalf("전송 실패: %v", err)
+		}
+		fmt.Printf("전송 완료: %d건 (신규 %d, 중복 %d)\n", res.Sent, res.Accepted, res.Duplicates)
+		return
+	}
+
+	fmt.Printf("claw-usage-chart push → %s (host %s, %s 주기)\n", *url, *hostLabel, every)
+	pusher.Run(ctx, every)
+}
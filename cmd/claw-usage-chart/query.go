@@ -0,0 +1,95 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"strings"
+
+	"github.com/yeremiel/claw-usage-chart/store"
+)
+
+// runQuery는 세션 파일을 동기화한 뒤 캐시에 읽기 전용 SELECT를 실행해 표, CSV 또는 JSON으로 출력한다.
+// SQL을 인자로 주지 않으면 표준 입력에서 읽는다.
+//
+//	claw-usage-chart query "SELECT model, SUM(cost) FROM v_usage GROUP BY model"
+//	claw-usage-chart query --format csv < monthly.sql
+func runQuery(args []string) {
+	fs := flag.NewFlagSet("query", flag.ExitOnError)
+	format := fs.String("format", "table", "출력 형식: table, csv, json")
+	limit := fs.Int("limit", store.DefaultQueryRows, "최대 출력 행 수")
+	timeout := fs.Duration("timeout", store.DefaultQueryTimeout, "쿼리 제한 시간")
+	dbURL := fs.String("db-url", "", "PostgreSQL 접속 URL (환경변수: OCL_DB_URL)")
+	queryDBURL := fs.String("query-db-url", "", "PostgreSQL에서 쿼리를 실행할 읽기 전용 역할의 접속 URL (환경변수: OCL_QUERY_DB_URL)")
+	configPath := fs.String("config", "", "JSON 설정 파일 경로, 조회 허용 테이블에 사용 (환경변수: OCL_CONFIG)")
+	fs.Parse(args)
+
+	switch *format {
+	case "table", "csv", "json":
+	default:
+		log.Fatalf("알 수 없는 출력 형식: %s (table, csv, json 중 하나)", *format)
+	}
+	q := strings.Join(fs.Args(), " ")
+	if q == "" {
+		data, err := io.ReadAll(os.Stdin)
+		if err != nil {
+			log.Fatalf("표준 입력 읽기 실패: %v", err)
+		}
+		q = string(data)
+	}
+	if strings.TrimSpace(q) == "" {
+		log.Fatal("사용법: claw-usage-chart query [--format table|csv|json] <SELECT ...>")
+	}
+
+	p := resolvePaths(*configPath)
+	fileCfg, err := loadFileConfig(p.ConfigPath)
+	if err != nil {
+		log.Fatalf("설정 파일 읽기 실패: %v", err)
+	}
+	if *dbURL == "" {
+		*dbURL = getEnv("OCL_DB_URL", "")
+	}
+	if *queryDBURL == "" {
+		*queryDBURL = getEnv("OCL_QUERY_DB_URL", "")
+	}
+	st, _ := openStore(*dbURL, p.DBPath, store.Options{
+		Dedupe:   getEnv("OCL_DEDUPE", store.DedupeContent),
+		Projects: fileCfg.Projects,
+		Tags:     fileCfg.Tags,
+		QueryDSN: *queryDBURL,
+	})
+	defer st.Close()
+	if _, err := st.Sync(p.AgentsDir); err != nil {
+		log.Fatalf("동기화 실패: %v", err)
+	}
+
+	res, err := st.Query(context.Background(), q, store.QueryOptions{
+		Allow:   fileCfg.Query.Allow,
+		MaxRows: *limit,
+		Timeout: *timeout,
+	})
+	if err != nil {
+		log.Fatalf("쿼리 실패: %v", err)
+	}
+
+	switch *format {
+	case "csv":
+		err = res.WriteCSV(os.Stdout)
+	case "json":
+		enc := json.NewEncoder(os.Stdout)
+		enc.SetIndent("", "  ")
+		err = enc.Encode(res)
+	default:
+		err = res.WriteTable(os.Stdout)
+	}
+	if err != nil {
+		log.Fatalf("출력 실패: %v", err)
+	}
+	if res.Truncated && *format != "json" {
+		fmt.Fprintf(os.Stderr, "(%d행에서 잘림, --limit으로 조정)\n", len(res.Rows))
+	}
+}
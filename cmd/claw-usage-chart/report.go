@@ -0,0 +1,177 @@
+package main
+
+import (
+	"bytes"
+	"flag"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/yeremiel/claw-usage-chart/report"
+	"github.com/yeremiel/claw-usage-chart/server"
+	"github.com/yeremiel/claw-usage-chart/store"
+)
+
+// runReport는 기간별 사용량을 공유·보관용 파일로 만든다. HTML은 대시보드
+// 화면에 데이터를 넣어 두어 서버 없이 열린다.
+//
+//	claw-usage-chart report --month 2026-09 --out 2026-09.html
+//	claw-usage-chart report --format md --start 2026-09-01 --end 2026-09-07
+func runReport(args []string) {
+	fs := flag.NewFlagSet("report", flag.ExitOnError)
+	format := fs.String("format", "html", "출력 형식: html, md, text")
+	out := fs.String("out", "-", "출력 파일 경로, -는 표준 출력")
+	month := fs.String("month", "", "보고 월 YYYY-MM (--start, --end 대신)")
+	start := fs.String("start", "", "시작일 YYYY-MM-DD (포함)")
+	end := fs.String("end", "", "종료일 YYYY-MM-DD (포함)")
+	agent := fs.String("agent", "", "이 에이전트만 포함")
+	title := fs.String("title", "", "보고서 제목 (기본: Claw Usage Report)")
+	chartJS := fs.String("chartjs", "", "HTML에 넣을 Chart.js 파일 경로, none이면 CDN 링크만 둠 (기본: 바이너리 옆 사본, 없으면 CDN에서 받아 저장)")
+	dbURL := fs.String("db-url", "", "PostgreSQL 접속 URL (환경변수: OCL_DB_URL)")
+	configPath := fs.String("config", "", "JSON 설정 파일 경로 (환경변수: OCL_CONFIG)")
+	fs.Parse(args)
+
+	switch *format {
+	case "html", "md", "text":
+	default:
+		log.Fatalf("알 수 없는 출력 형식: %s (html, md, text 중 하나)", *format)
+	}
+	if *month != "" {
+		m, err := time.Parse("2006-01", *month)
+		if err != nil || *start != "" || *end != "" {
+			log.Fatal("--month는 YYYY-MM 형식이며 --start, --end와 함께 쓸 수 없음")
+		}
+		*start = m.Format("2006-01-02")
+		*end = m.AddDate(0, 1, -1).Format("2006-01-02")
+	}
+	for _, d := range []string{*start, *end} {
+		if _, err := time.Parse("2006-01-02", d); d != "" && err != nil {
+			log.Fatalf("잘못된 날짜: %s (YYYY-MM-DD)", d)
+		}
+	}
+	if *title == "" {
+		*title = "Claw Usage Report"
+		if *month != "" {
+			*title += " — " + *month
+		}
+	}
+
+	p := resolvePaths(*configPath)
+	fileCfg, err := loadFileConfig(p.ConfigPath)
+	if err != nil {
+		log.Fatalf("설정 파일 읽기 실패: %v", err)
+	}
+	if *dbURL == "" {
+		*dbURL = getEnv("OCL_DB_URL", "")
+	}
+	st, _ := openStore(*dbURL, p.DBPath, store.Options{
+		Dedupe:   getEnv("OCL_DEDUPE", store.DedupeContent),
+		Projects: fileCfg.Projects,
+		Tags:     fileCfg.Tags,
+	})
+	defer st.Close()
+
+	stats, err := server.New(st, p.AgentsDir, server.Options{}).CollectStats(store.UsageFilter{
+		Start: *start,
+		End:   *end,
+		Agent: *agent,
+	})
+	if err != nil {
+		log.Fatalf("통계 수집 실패: %v", err)
+	}
+	r := report.Report{Title: *title, Start: *start, End: *end, Stats: stats}
+	var js []byte
+	if *format == "html" {
+		js = loadChartJS(*chartJS, p.BinDir) // 출력 파일을 만들기 전에 실패하도록
+	}
+
+	var w io.Writer = os.Stdout
+	if *out != "-" {
+		f, err := os.Create(*out)
+		if err != nil {
+			log.Fatalf("출력 파일 생성 실패: %v", err)
+		}
+		defer f.Close()
+		w = f
+	}
+	switch *format {
+	case "md":
+		err = report.Markdown(w, r)
+	case "text":
+		err = report.Text(w, r)
+	default:
+		err = report.HTML(w, r, js)
+	}
+	if err != nil {
+		log.Fatalf("보고서 쓰기 실패: %v", err)
+	}
+	if *out != "-" {
+		fmt.Fprintf(os.Stderr, "보고서 저장: %s (%s)\n", *out, r.Subtitle())
+	}
+}
+
+// loadChartJS는 HTML 보고서에 넣을 Chart.js를 읽는다. 기본값이면 바이너리
+// 옆에 버전별로 받아 둔 사본을 쓰고, 없을 때만 CDN에서 받아 저장한다. 받지
+// 못하면 차트 없는 보고서를 만들지 않고 종료한다.
+func loadChartJS(src, binDir string) []byte {
+	switch src {
+	case "none":
+		return nil
+	case "":
+		cached := filepath.Join(binDir, "chart-"+server.ChartJSVersion+".umd.min.js")
+		if data, err := os.ReadFile(cached); err == nil && checkChartJS(data) == nil {
+			return data
+		}
+		data, err := downloadChartJS()
+		if err != nil {
+			log.Fatalf("Chart.js %s 받기 실패: %v (오프라인이면 --chartjs <파일> 로 사본을 지정하거나 --chartjs none 으로 CDN 링크만 둠)", server.ChartJSVersion, err)
+		}
+		if err := os.WriteFile(cached, data, 0o644); err != nil {
+			log.Printf("[report] Chart.js 사본 저장 실패, 다음에도 다시 받음: %v", err)
+		}
+		return data
+	default:
+		data, err := os.ReadFile(src)
+		if err != nil {
+			log.Fatalf("Chart.js 파일 읽기 실패: %v", err)
+		}
+		if err := checkChartJS(data); err != nil {
+			log.Printf("[report] %s: %v", src, err)
+		}
+		return data
+	}
+}
+
+func downloadChartJS() ([]byte, error) {
+	client := &http.Client{Timeout: 15 * time.Second}
+	resp, err := client.Get(server.ChartJSURL)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("HTTP %d", resp.StatusCode)
+	}
+	data, err := io.ReadAll(io.LimitReader(resp.Body, 8<<20))
+	if err != nil {
+		return nil, err
+	}
+	return data, checkChartJS(data)
+}
+
+// checkChartJS는 data가 대시보드와 같은 버전의 Chart.js 빌드인지 머리 주석으로
+// 확인한다. 프록시 오류 페이지 등을 스크립트로 넣지 않기 위함이다.
+func checkChartJS(data []byte) error {
+	head := data
+	if len(head) > 512 {
+		head = head[:512]
+	}
+	if !bytes.Contains(head, []byte("Chart.js v"+server.ChartJSVersion)) {
+		return fmt.Errorf("Chart.js v%s 빌드가 아님", server.ChartJSVersion)
+	}
+	return nil
+}
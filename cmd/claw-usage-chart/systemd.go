@@ -0,0 +1,295 @@
+package main
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"log"
+	"log/slog"
+	"net"
+	"net/http"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// ── systemd 사용자 유닛 설치 ────────────────────────────────────────────────
+
+// runInstallService는 systemd 사용자 유닛을 쓰고 켠다. -- 뒤의 인자는 서버
+// 플래그로 ExecStart에 붙는다.
+//
+//	claw-usage-chart install-service --port 8585 -- --config ~/claw.json
+//	claw-usage-chart install-service --socket
+func runInstallService(args []string) {
+	fs := flag.NewFlagSet("install-service", flag.ExitOnError)
+	name := fs.String("name", "claw-usage-chart", "유닛 이름")
+	port := fs.String("port", "", "서버 포트 (기본: 8585, 환경변수: OCL_PORT)")
+	host := fs.String("host", "", "바인드 주소 (기본: 모든 주소, 환경변수: OCL_HOST)")
+	socket := fs.Bool("socket", false, "소켓 유닛도 설치해 systemd가 포트를 열고 넘겨줌 (소켓 활성화)")
+	watchdog := fs.Duration("watchdog", time.Minute, "응답이 없으면 재시작할 시간, 0이면 워치독 끔")
+	start := fs.Bool("start", true, "설치 후 systemctl --user enable --now 실행")
+	fs.Parse(args)
+
+	for _, a := range fs.Args() {
+		if a == "--daemon" || a == "-d" || a == "-daemon" {
+			log.Fatal("--daemon은 systemd 유닛에 쓸 수 없음 (systemd가 프로세스를 관리함)")
+		}
+	}
+	if *port == "" {
+		*port = getEnv("OCL_PORT", "8585")
+	}
+	if *host == "" {
+		*host = getEnv("OCL_HOST", "")
+	}
+	exe, err := os.Executable()
+	if err != nil {
+		log.Fatalf("실행 파일 경로 확인 실패: %v", err)
+	}
+	if exe, err = filepath.EvalSymlinks(exe); err != nil {
+		log.Fatalf("실행 파일 경로 확인 실패: %v", err)
+	}
+	dir, err := systemdUserDir()
+	if err != nil {
+		log.Fatalf("유닛 디렉터리 확인 실패: %v", err)
+	}
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		log.Fatalf("유닛 디렉터리 생성 실패: %v", err)
+	}
+
+	execArgs := []string{exe, "--port", *port}
+	if *host != "" {
+		execArgs = append(execArgs, "--host", *host)
+	}
+	execArgs = append(execArgs, fs.Args()...)
+
+	units := map[string]string{
+		*name + ".service": serviceUnit(*name, execArgs, *watchdog, *socket),
+	}
+	if *socket {
+		listen := *port
+		if *host != "" {
+			listen = net.JoinHostPort(*host, *port)
+		}
+		units[*name+".socket"] = socketUnit(listen)
+	}
+	for file, content := range units {
+		path := filepath.Join(dir, file)
+		if err := os.WriteFile(path, []byte(content), 0o644); err != nil {
+			log.Fatalf("유닛 파일 쓰기 실패: %v", err)
+		}
+		fmt.Printf("유닛 작성: %s\n", path)
+	}
+
+	enable := []string{"enable", "--now", *name + ".service"}
+	if *socket {
+		enable = []string{"enable", "--now", *name + ".socket", *name + ".service"}
+	}
+	if !*start {
+		fmt.Printf("시작하려면: systemctl --user daemon-reload && systemctl --user %s\n", strings.Join(enable, " "))
+		return
+	}
+	if err := systemctlUser("daemon-reload"); err != nil {
+		log.Fatalf("systemctl --user daemon-reload 실패: %v", err)
+	}
+	if err := systemctlUser(enable...); err != nil {
+		log.Fatalf("systemctl --user %s 실패: %v", strings.Join(enable, " "), err)
+	}
+	fmt.Printf("서비스 시작: systemctl --user status %s, 로그: journalctl --user -u %s\n", *name, *name)
+	fmt.Println("로그아웃 후에도 계속 실행하려면: loginctl enable-linger")
+}
+
+// runUninstallService는 install-service가 만든 유닛을 멈추고 지운다.
+func runUninstallService(args []string) {
+	fs := flag.NewFlagSet("uninstall-service", flag.ExitOnError)
+	name := fs.String("name", "claw-usage-chart", "유닛 이름")
+	fs.Parse(args)
+
+	dir, err := systemdUserDir()
+	if err != nil {
+		log.Fatalf("유닛 디렉터리 확인 실패: %v", err)
+	}
+	var files []string
+	for _, file := range []string{*name + ".socket", *name + ".service"} {
+		if _, err := os.Stat(filepath.Join(dir, file)); err == nil {
+			files = append(files, file)
+		}
+	}
+	if len(files) == 0 {
+		fmt.Printf("%s 에 %s 유닛이 없습니다\n", dir, *name)
+		return
+	}
+	if err := systemctlUser(append([]string{"disable", "--now"}, files...)...); err != nil {
+		log.Printf("systemctl --user disable 실패, 유닛 파일만 지움: %v", err)
+	}
+	for _, file := range files {
+		path := filepath.Join(dir, file)
+		if err := os.Remove(path); err != nil {
+			log.Fatalf("유닛 파일 삭제 실패: %v", err)
+		}
+		fmt.Printf("유닛 삭제: %s\n", path)
+	}
+	systemctlUser("daemon-reload")
+}
+
+// systemdUserDir는 사용자 유닛 디렉터리다 ($XDG_CONFIG_HOME/systemd/user).
+func systemdUserDir() (string, error) {
+	dir, err := os.UserConfigDir()
+	if err != nil {
+		return "", err
+	}
+	return filepath.Join(dir, "systemd", "user"), nil
+}
+
+func systemctlUser(args ...string) error {
+	cmd := exec.Command("systemctl", append([]string{"--user"}, args...)...)
+	cmd.Stdout = os.Stdout
+	cmd.Stderr = os.Stderr
+	return cmd.Run()
+}
+
+// serviceUnit은 Type=notify 서비스 유닛이다. 준비되면 READY=1을,
+// WatchdogSec의 절반마다 WATCHDOG=1을 보낸다 (sdNotify, runWatchdog).
+func serviceUnit(name string, execArgs []string, watchdog time.Duration, socket bool) string {
+	var b strings.Builder
+	b.WriteString("[Unit]\nDescription=Claw Usage Chart dashboard\n")
+	if socket {
+		fmt.Fprintf(&b, "Requires=%s.socket\nAfter=%s.socket\n", name, name)
+	}
+	b.WriteString("After=network-online.target\n\n[Service]\nType=notify\nNotifyAccess=main\n")
+	quoted := make([]string, len(execArgs))
+	for i, a := range execArgs {
+		quoted[i] = unitQuote(a)
+	}
+	fmt.Fprintf(&b, "ExecStart=%s\n", strings.Join(quoted, " "))
+	b.WriteString("ExecReload=/bin/kill -HUP $MAINPID\n")
+	b.WriteString("Restart=on-failure\nRestartSec=5\n")
+	if watchdog > 0 {
+		fmt.Fprintf(&b, "WatchdogSec=%d\n", int(watchdog.Seconds()))
+	}
+	fmt.Fprintf(&b, "SyslogIdentifier=%s\n\n[Install]\nWantedBy=default.target\n", name)
+	return b.String()
+}
+
+// socketUnit은 listen 주소를 systemd가 열어 서비스에 넘기게 한다.
+func socketUnit(listen string) string {
+	return "[Unit]\nDescription=Claw Usage Chart dashboard socket\n\n" +
+		"[Socket]\nListenStream=" + listen + "\n\n" +
+		"[Install]\nWantedBy=sockets.target\n"
+}
+
+// unitQuote는 ExecStart 인자 하나를 systemd 문법으로 감싼다. %와 $는
+// 지정자·변수 확장을 막으려고 두 번 쓴다.
+func unitQuote(s string) string {
+	s = strings.NewReplacer("%", "%%", "$", "$$").Replace(s)
+	if s != "" && !strings.ContainsAny(s, " \t\"'\\;") {
+		return s
+	}
+	return `"` + strings.NewReplacer(`\`, `\\`, `"`, `\"`).Replace(s) + `"`
+}
+
+// ── systemd 런타임 연동 ─────────────────────────────────────────────────────
+
+// underJournald는 표준 출력·오류가 journald로 가는지 알려준다. 이때는
+// journald가 시각을 붙이므로 로그에서 시각을 뺀다.
+func underJournald() bool {
+	return os.Getenv("JOURNAL_STREAM") != ""
+}
+
+// activationListener는 소켓 활성화로 systemd가 넘긴 첫 리스너를 반환한다.
+// 넘겨받은 소켓이 없으면 nil이다.
+func activationListener() (net.Listener, error) {
+	defer os.Unsetenv("LISTEN_PID")
+	defer os.Unsetenv("LISTEN_FDS")
+	defer os.Unsetenv("LISTEN_FDNAMES")
+	if os.Getenv("LISTEN_PID") != strconv.Itoa(os.Getpid()) {
+		return nil, nil
+	}
+	n, err := strconv.Atoi(os.Getenv("LISTEN_FDS"))
+	if err != nil || n < 1 {
+		return nil, nil
+	}
+	if n > 1 {
+		slog.Warn("systemd passed several sockets, using the first", "count", n)
+	}
+	const listenFDsStart = 3
+	f := os.NewFile(listenFDsStart, "LISTEN_FD_3")
+	defer f.Close()
+	ln, err := net.FileListener(f)
+	if err != nil {
+		return nil, fmt.Errorf("systemd 소켓: %w", err)
+	}
+	return ln, nil
+}
+
+// sdNotify는 NOTIFY_SOCKET에 상태를 보낸다. systemd 밖이면 아무것도 하지
+// 않는다.
+func sdNotify(state string) error {
+	addr := os.Getenv("NOTIFY_SOCKET")
+	if addr == "" {
+		return nil
+	}
+	if addr[0] == '@' {
+		addr = "\x00" + addr[1:] // 추상 소켓
+	}
+	conn, err := net.DialUnix("unixgram", nil, &net.UnixAddr{Name: addr, Net: "unixgram"})
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+	_, err = conn.Write([]byte(state))
+	return err
+}
+
+// watchdogInterval은 WatchdogSec가 켜져 있으면 그 절반을 반환한다.
+func watchdogInterval() time.Duration {
+	if pid := os.Getenv("WATCHDOG_PID"); pid != "" && pid != strconv.Itoa(os.Getpid()) {
+		return 0
+	}
+	usec, err := strconv.ParseInt(os.Getenv("WATCHDOG_USEC"), 10, 64)
+	if err != nil || usec <= 0 {
+		return 0
+	}
+	return time.Duration(usec) * time.Microsecond / 2
+}
+
+// runWatchdog는 서버가 /health에 응답할 때만 WATCHDOG=1을 보낸다. 멈춘
+// 서버는 systemd가 WatchdogSec 뒤에 재시작한다.
+func runWatchdog(done <-chan struct{}, addr net.Addr, interval time.Duration) {
+	host, port, err := net.SplitHostPort(addr.String())
+	if err != nil {
+		slog.Error("watchdog disabled, bad listen address", "component", "watchdog", "addr", addr.String(), "err", err)
+		return
+	}
+	if ip := net.ParseIP(host); ip == nil || ip.IsUnspecified() {
+		host = "127.0.0.1"
+		if ip != nil && ip.To4() == nil {
+			host = "::1"
+		}
+	}
+	url := "http://" + net.JoinHostPort(host, port) + "/health"
+	client := &http.Client{Timeout: interval}
+	t := time.NewTicker(interval)
+	defer t.Stop()
+	for {
+		select {
+		case <-done:
+			return
+		case <-t.C:
+		}
+		resp, err := client.Get(url)
+		if err == nil {
+			resp.Body.Close()
+			if resp.StatusCode != http.StatusOK {
+				err = errors.New(resp.Status)
+			}
+		}
+		if err != nil {
+			slog.Warn("health check failed, skipping watchdog ping", "component", "watchdog", "url", url, "err", err)
+			continue
+		}
+		sdNotify("WATCHDOG=1")
+	}
+}
@@ -0,0 +1,373 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"log"
+	"os"
+	"os/signal"
+	"path/filepath"
+	"strings"
+	"syscall"
+	"time"
+	"unicode/utf8"
+
+	"golang.org/x/term"
+
+	"github.com/yeremiel/claw-usage-chart/store"
+)
+
+// runTop은 최근 사용량을 전체 화면으로 갱신해 보여준다. ANSI 이스케이프만
+// 쓰므로 SSH로 접속한 헤드리스 서버에서도 동작한다.
+//
+//	claw-usage-chart top [--interval 2s] [--agent 이름]
+func runTop(args []string) {
+	fs := flag.NewFlagSet("top", flag.ExitOnError)
+	interval := fs.Duration("interval", 2*time.Second, "화면 갱신 주기")
+	sparkWindow := fs.Duration("spark", 6*time.Hour, "스파크라인 기간")
+	agent := fs.String("agent", "", "이 에이전트만 표시")
+	host := fs.String("host", "", "이 호스트만 표시 (이 머신은 local)")
+	once := fs.Bool("once", false, "화면을 한 번 출력하고 종료 (터미널이 아니어도 됨)")
+	dbURL := fs.String("db-url", "", "PostgreSQL 접속 URL (환경변수: OCL_DB_URL)")
+	configPath := fs.String("config", "", "JSON 설정 파일 경로 (환경변수: OCL_CONFIG)")
+	fs.Parse(args)
+	if *interval <= 0 || *sparkWindow <= 0 {
+		log.Fatal("--interval과 --spark는 0보다 커야 함")
+	}
+
+	p := resolvePaths(*configPath)
+	fileCfg, err := loadFileConfig(p.ConfigPath)
+	if err != nil {
+		log.Fatalf("설정 파일 읽기 실패: %v", err)
+	}
+	if *dbURL == "" {
+		*dbURL = getEnv("OCL_DB_URL", "")
+	}
+	st, label := openStore(*dbURL, p.DBPath, store.Options{
+		Dedupe:   getEnv("OCL_DEDUPE", store.DedupeContent),
+		Projects: fileCfg.Projects,
+		Tags:     fileCfg.Tags,
+	})
+	defer st.Close()
+
+	t := &topScreen{
+		store:     st,
+		agentsDir: p.AgentsDir,
+		source:    label,
+		spark:     *sparkWindow,
+		filter:    store.UsageFilter{Agent: *agent, Host: *host},
+	}
+
+	if *once || !term.IsTerminal(int(os.Stdout.Fd())) {
+		t.plain = true
+		t.resize()
+		t.refresh()
+		for _, line := range t.render() {
+			fmt.Println(line)
+		}
+		return
+	}
+	t.run(*interval)
+}
+
+// topScreen은 top 화면의 상태다.
+type topScreen struct {
+	store     store.Store
+	agentsDir string
+	source    string
+	spark     time.Duration
+	filter    store.UsageFilter
+
+	plain         bool // 한 번만 출력: 색, 화면 채우기 없음
+	width, height int
+	live          store.LiveStats
+	synced        int // 마지막 동기화에서 추가된 레코드 수
+	err           error
+}
+
+// run은 대체 화면에서 interval마다 다시 그린다. q, Ctrl-C로 끝내고
+// r로 즉시 갱신한다.
+func (t *topScreen) run(interval time.Duration) {
+	in := int(os.Stdin.Fd())
+	if term.IsTerminal(in) {
+		if old, err := term.MakeRaw(in); err == nil {
+			defer term.Restore(in, old)
+		}
+	}
+	out := os.Stdout
+	out.WriteString("\x1b[?1049h\x1b[?25l") // 대체 화면, 커서 숨김
+	defer out.WriteString("\x1b[?25h\x1b[?1049l")
+
+	ctx, stop := signal.NotifyContext(context.Background(), syscall.SIGINT, syscall.SIGTERM, syscall.SIGHUP)
+	defer stop()
+	winch := make(chan os.Signal, 1)
+	signal.Notify(winch, syscall.SIGWINCH)
+	defer signal.Stop(winch)
+
+	keys := make(chan byte)
+	go func() {
+		buf := make([]byte, 1)
+		for {
+			if n, err := os.Stdin.Read(buf); err != nil || n == 0 {
+				return
+			}
+			keys <- buf[0]
+		}
+	}()
+
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	t.resize()
+	t.refresh()
+	for {
+		t.draw(out)
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-winch:
+			t.resize()
+			t.refresh()
+		case <-ticker.C:
+			t.refresh()
+		case k := <-keys:
+			switch k {
+			case 'q', 'Q', 3: // 3 = Ctrl-C (raw 모드에서는 시그널이 오지 않음)
+				return
+			case 'r', 'R':
+				t.refresh()
+			}
+		}
+	}
+}
+
+// resize는 터미널 크기를 읽는다. 터미널이 아니면 100×40으로 둔다.
+func (t *topScreen) resize() {
+	t.width, t.height = 100, 40
+	if w, h, err := term.GetSize(int(os.Stdout.Fd())); err == nil {
+		t.width, t.height = w, h
+	}
+}
+
+// refresh는 세션 파일을 동기화하고 최근 사용량을 다시 읽는다.
+func (t *topScreen) refresh() {
+	res, err := t.store.Sync(t.agentsDir)
+	if err != nil {
+		t.err = fmt.Errorf("sync: %w", err)
+		return
+	}
+	t.synced = res.NewRecords
+	// 스파크라인 한 칸은 화면 너비에 맞춰 분 단위로 반올림한 길이다.
+	cols := t.width
+	if cols > 240 {
+		cols = 240
+	}
+	if cols < 10 {
+		cols = 10
+	}
+	step := (t.spark/time.Duration(cols) + time.Minute - 1).Truncate(time.Minute)
+	if step < time.Minute {
+		step = time.Minute
+	}
+	buckets := int(t.spark / step)
+	if buckets < 1 {
+		buckets = 1
+	}
+	live, err := t.store.Live(time.Now(), store.LiveOptions{
+		Filter:       t.filter,
+		SparkWindow:  step * time.Duration(buckets),
+		SparkBuckets: buckets,
+		MaxSessions:  50,
+	})
+	if err != nil {
+		t.err = err
+		return
+	}
+	t.live, t.err = live, nil
+}
+
+// draw는 화면을 지우지 않고 덮어써서 깜빡임을 줄인다.
+func (t *topScreen) draw(out *os.File) {
+	var b strings.Builder
+	b.WriteString("\x1b[H")
+	for i, line := range t.render() {
+		if i > 0 {
+			b.WriteString("\r\n")
+		}
+		b.WriteString(line)
+		b.WriteString("\x1b[K")
+	}
+	b.WriteString("\x1b[J")
+	out.WriteString(b.String())
+}
+
+// render는 화면 한 장을 height줄 이하, 줄마다 width칸 이하로 만든다.
+func (t *topScreen) render() []string {
+	bold, dim, reset := "\x1b[1m", "\x1b[2m", "\x1b[0m"
+	if t.plain {
+		bold, dim, reset = "", "", ""
+	}
+	var lines []string
+	add := func(style, s string) {
+		s = clip(s, t.width)
+		if style != "" {
+			s = style + s + reset
+		}
+		lines = append(lines, s)
+	}
+	l := t.live
+
+	add(bold, fmt.Sprintf("claw-usage-chart top — %s", time.Now().Format("15:04:05")))
+	status := fmt.Sprintf("%s · sync +%d", t.source, t.synced)
+	if t.err != nil {
+		status += " · error: " + t.err.Error()
+	}
+	add(dim, status)
+	add("", "")
+	add("", fmt.Sprintf("tokens/min %s   $/hour %s   (last %s)",
+		humanCount(l.TokensPerMin), formatUSD(l.CostPerHour), window(l.Windows.Rate)))
+	add("", "")
+
+	// 스파크라인: 토큰 기준, 버킷마다 한 칸
+	var peak int64
+	for _, s := range l.Spark {
+		if s.Tokens > peak {
+			peak = s.Tokens
+		}
+	}
+	var spark strings.Builder
+	for _, s := range l.Spark {
+		spark.WriteRune(sparkRune(s.Tokens, peak))
+	}
+	add(bold, fmt.Sprintf("tokens, last %s (peak %s per %s)", window(l.Windows.Spark), humanCount(float64(peak)), bucketWidth(l)))
+	add("", spark.String())
+	add("", "")
+
+	table := func(title string, rows []store.LiveRow, room int) {
+		add(bold, fmt.Sprintf("%-28s %8s %10s %10s", title, "records", "tokens", "cost"))
+		for i, r := range rows {
+			if i == room {
+				add(dim, fmt.Sprintf("… %d more", len(rows)-room))
+				break
+			}
+			add("", fmt.Sprintf("%-28s %8d %10s %10s", clip(r.Name, 28), r.Records, humanCount(float64(r.Tokens)), formatUSD(r.Cost)))
+		}
+		if len(rows) == 0 {
+			add(dim, "no usage")
+		}
+		add("", "")
+	}
+	// 남은 줄을 세 표에 나눈다 (제목, 빈 줄 몫을 뺀 값).
+	room := (t.height - len(lines) - 10) / 3
+	if room < 1 {
+		room = 1
+	}
+	table("AGENT (last "+window(l.Windows.Table)+")", l.Agents, room)
+	table("MODEL (last "+window(l.Windows.Table)+")", l.Models, room)
+
+	add(bold, fmt.Sprintf("%-28s %-22s %8s %10s %10s %6s", "ACTIVE SESSION (last "+window(l.Windows.Active)+")", "model", "records", "tokens", "cost", "idle"))
+	left := t.height - len(lines) - 1
+	if t.plain {
+		left = len(l.Sessions)
+	}
+	for i, s := range l.Sessions {
+		if i >= left {
+			add(dim, fmt.Sprintf("… %d more", len(l.Sessions)-i))
+			break
+		}
+		name := s.Agent + "/" + strings.TrimSuffix(filepath.Base(s.SourceFile), ".jsonl")
+		if s.Host != store.LocalHost {
+			name = s.Host + ":" + name
+		}
+		idle := time.Duration(l.At-s.LastTS) * time.Second
+		add("", fmt.Sprintf("%-28s %-22s %8d %10s %10s %6s", clip(name, 28), clip(s.Model, 22), s.Records,
+			humanCount(float64(s.Tokens)), formatUSD(s.Cost), idle.String()))
+	}
+	if len(l.Sessions) == 0 {
+		add(dim, "no active sessions")
+	}
+
+	if t.plain {
+		return lines
+	}
+	if len(lines) > t.height && t.height > 0 {
+		lines = lines[:t.height]
+	}
+	if n := len(lines); n < t.height {
+		footer := dim + clip("q quit · r refresh", t.width) + reset
+		for len(lines) < t.height-1 {
+			lines = append(lines, "")
+		}
+		lines = append(lines, footer)
+	}
+	return lines
+}
+
+var sparkRunes = []rune("▁▂▃▄▅▆▇█")
+
+func sparkRune(v, peak int64) rune {
+	if v <= 0 || peak <= 0 {
+		return ' '
+	}
+	i := int((v*int64(len(sparkRunes)) - 1) / peak)
+	if i >= len(sparkRunes) {
+		i = len(sparkRunes) - 1
+	}
+	return sparkRunes[i]
+}
+
+// humanCount는 1234 → 1.2k처럼 줄여 쓴다.
+func humanCount(v float64) string {
+	switch {
+	case v >= 1e9:
+		return fmt.Sprintf("%.1fG", v/1e9)
+	case v >= 1e6:
+		return fmt.Sprintf("%.1fM", v/1e6)
+	case v >= 1e4:
+		return fmt.Sprintf("%.1fk", v/1e3)
+	case v == float64(int64(v)):
+		return fmt.Sprintf("%d", int64(v))
+	default:
+		return fmt.Sprintf("%.1f", v)
+	}
+}
+
+func formatUSD(v float64) string {
+	if v > 0 && v < 0.01 {
+		return fmt.Sprintf("$%.4f", v)
+	}
+	return fmt.Sprintf("$%.2f", v)
+}
+
+// window는 초 단위 기간을 5m, 1h 같은 짧은 형태로 쓴다.
+func window(sec int64) string {
+	d := time.Duration(sec) * time.Second
+	switch {
+	case d%time.Hour == 0:
+		return fmt.Sprintf("%dh", d/time.Hour)
+	case d%time.Minute == 0:
+		return fmt.Sprintf("%dm", d/time.Minute)
+	default:
+		return d.String()
+	}
+}
+
+func bucketWidth(l store.LiveStats) string {
+	if len(l.Spark) < 2 {
+		return window(l.Windows.Spark)
+	}
+	return window(l.Spark[1].Start - l.Spark[0].Start)
+}
+
+// clip은 s를 n칸 이하로 자른다 (문자 단위, 전각 문자는 고려하지 않음).
+func clip(s string, n int) string {
+	if n <= 0 || utf8.RuneCountInString(s) <= n {
+		return s
+	}
+	r := []rune(s)
+	if n == 1 {
+		return string(r[:1])
+	}
+	return string(r[:n-1]) + "…"
+}
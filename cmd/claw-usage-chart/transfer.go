@@ -0,0 +1,169 @@
+package main
+
+import (
+	"bytes"
+	"flag"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"time"
+
+	"github.com/yeremiel/claw-usage-chart/store"
+)
+
+// recordSource는 다른 캐시 파일이나 번들에서 레코드를 일정량씩 읽는다.
+type recordSource interface {
+	Next(n int) ([]store.PortableRecord, error)
+	Close() error
+}
+
+// runExport는 캐시 전체를 이식 가능한 번들 파일로 내보낸다.
+//
+//	claw-usage-chart export --out laptop.bundle.gz
+func runExport(args []string) {
+	fs := flag.NewFlagSet("export", flag.ExitOnError)
+	out := fs.String("out", "", "번들 파일 경로, -는 표준 출력 (기본: claw-usage-<호스트>-<날짜>.bundle.gz)")
+	hostLabel := fs.String("host-label", "", "이 머신의 레코드에 붙일 호스트 이름 (기본: hostname)")
+	dbURL := fs.String("db-url", "", "PostgreSQL 접속 URL (환경변수: OCL_DB_URL)")
+	fs.Parse(args)
+
+	if *hostLabel == "" {
+		*hostLabel, _ = os.Hostname()
+	}
+	if !store.ValidHost(*hostLabel) {
+		log.Fatalf("사용할 수 없는 호스트 이름: %q", *hostLabel)
+	}
+	if *dbURL == "" {
+		*dbURL = getEnv("OCL_DB_URL", "")
+	}
+	if *out == "" {
+		*out = fmt.Sprintf("claw-usage-%s-%s.bundle.gz", *hostLabel, time.Now().Format("20060102"))
+	}
+
+	p := resolvePaths("")
+	st, label := openStore(*dbURL, p.DBPath, store.Options{})
+	defer st.Close()
+
+	var w io.Writer = os.Stdout
+	if *out != "-" {
+		f, err := os.Create(*out)
+		if err != nil {
+			log.Fatalf("번들 파일 생성 실패: %v", err)
+		}
+		defer f.Close()
+		w = f
+	}
+	n, err := store.WriteBundle(w, st, *hostLabel)
+	if err != nil {
+		log.Fatalf("내보내기 실패: %v", err)
+	}
+	if *out != "-" {
+		fmt.Printf("%s → %s: 레코드 %d건 (번들 버전 %d)\n", label, *out, n, store.BundleVersion)
+	}
+}
+
+// runImport는 다른 인스턴스의 usage_cache.db 또는 번들을 현재 캐시에 합친다.
+//
+//	claw-usage-chart import --host laptop-1 /mnt/backup/usage_cache.db
+//	claw-usage-chart import laptop.bundle.gz
+func runImport(args []string) {
+	fs := flag.NewFlagSet("import", flag.ExitOnError)
+	host := fs.String("host", "", "가져온 레코드에 붙일 호스트 이름 (번들은 기본값: 내보낸 호스트)")
+	user := fs.String("user", "", "가져온 레코드에 붙일 사용자 이름")
+	dbURL := fs.String("db-url", "", "PostgreSQL 접속 URL (환경변수: OCL_DB_URL)")
+	configPath := fs.String("config", "", "JSON 설정 파일 경로, 태그 규칙에 사용 (환경변수: OCL_CONFIG)")
+	fs.Parse(args)
+	if fs.NArg() != 1 {
+		log.Fatal("사용법: claw-usage-chart import [--host 이름] <usage_cache.db | 번들 파일>")
+	}
+	path := fs.Arg(0)
+
+	src, defaultHost, err := openRecordSource(path)
+	if err != nil {
+		log.Fatalf("가져오기 원본 열기 실패: %v", err)
+	}
+	defer src.Close()
+	if *host == "" {
+		*host = defaultHost
+	}
+	if !store.ValidHost(*host) {
+		log.Fatalf("--host로 유효한 호스트 이름을 지정해야 함 (현재: %q)", *host)
+	}
+
+	p := resolvePaths(*configPath)
+	fileCfg, err := loadFileConfig(p.ConfigPath)
+	if err != nil {
+		log.Fatalf("설정 파일 읽기 실패: %v", err)
+	}
+	if *dbURL == "" {
+		*dbURL = getEnv("OCL_DB_URL", "")
+	}
+	st, label := openStore(*dbURL, p.DBPath, store.Options{
+		Dedupe: getEnv("OCL_DEDUPE", store.DedupeContent),
+		Tags:   fileCfg.Tags,
+	})
+	defer st.Close()
+
+	var total store.IngestResult
+	for {
+		recs, err := src.Next(5000)
+		if err != nil {
+			log.Fatalf("읽기 실패: %v", err)
+		}
+		if len(recs) == 0 {
+			break
+		}
+		res, err := st.Import(*host, *user, recs)
+		if err != nil {
+			log.Fatalf("가져오기 실패: %v", err)
+		}
+		total.Add(res)
+	}
+
+	fmt.Printf("%s → %s (host %s)\n", path, label, *host)
+	fmt.Printf("  추가 %d건, 중복 %d건, 충돌 %d건\n", total.Accepted, total.Duplicates, total.Conflicts)
+	for _, c := range total.ConflictSamples {
+		fmt.Printf("  충돌 %s %s@%d: 기존 [%s] / 가져온 값 [%s]\n", c.Host, c.SourceFile, c.SourceOffset, c.Stored, c.Incoming)
+	}
+	if total.Conflicts > len(total.ConflictSamples) {
+		fmt.Printf("  … 외 %d건 (기존 레코드 유지)\n", total.Conflicts-len(total.ConflictSamples))
+	}
+}
+
+// openRecordSource는 파일 머리로 SQLite 캐시와 번들을 구분해 연다.
+// 번들이면 내보낸 호스트 이름도 반환한다.
+func openRecordSource(path string) (recordSource, string, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, "", err
+	}
+	head := make([]byte, 16)
+	n, _ := io.ReadFull(f, head)
+	if bytes.Equal(head[:n], []byte("SQLite format 3\x00")) {
+		f.Close()
+		fc, err := store.OpenForeignCache(path)
+		return fc, "", err
+	}
+	if _, err := f.Seek(0, io.SeekStart); err != nil {
+		f.Close()
+		return nil, "", err
+	}
+	br, err := store.NewBundleReader(f)
+	if err != nil {
+		f.Close()
+		return nil, "", err
+	}
+	return bundleFile{br, f}, br.Header.Host, nil
+}
+
+// bundleFile은 번들 리더와 그 파일을 함께 닫는다.
+type bundleFile struct {
+	*store.BundleReader
+	f *os.File
+}
+
+func (b bundleFile) Close() error {
+	b.BundleReader.Close()
+	return b.f.Close()
+}
@@ -0,0 +1,227 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"net"
+	"os"
+	"os/exec"
+	"strings"
+	"syscall"
+	"time"
+)
+
+// ── 무중단 교체 ────────────────────────────────────────────────────────────
+//
+// SIGUSR2(또는 --restart)를 받은 서버는 디스크의 현재 바이너리를 같은
+// 인자로 새로 띄우고, 열어 둔 HTTP 리스너와 제어 소켓을 넘긴다. 새 프로세스가
+// 같은 소켓에서 요청을 받기 시작했다고 알리면, 이전 프로세스는 처리 중인
+// 요청만 마치고 종료한다. 그 사이에도 포트는 계속 열려 있다.
+
+// handoffEnvKey는 넘겨받은 파일의 이름 목록이다 ("http,ctl,ready"). i번째
+// 이름의 파일은 fd 3+i로 열려 있다.
+const handoffEnvKey = "__CLAW_HANDOFF"
+
+// successorTimeout은 새 프로세스가 준비될 때까지 기다리는 시간이다.
+const successorTimeout = 30 * time.Second
+
+// handoff는 이전 프로세스에게서 넘겨받은 리스너들이다.
+type handoff struct {
+	http  net.Listener
+	ctl   *net.UnixListener // 이전 프로세스에 제어 소켓이 없었으면 nil
+	ready *os.File          // 요청을 받기 시작하면 한 바이트를 쓰고 닫는다
+}
+
+// inheritedListeners는 무중단 교체로 시작된 경우 넘겨받은 리스너를
+// 반환한다. 아니면 nil이다.
+func inheritedListeners() (*handoff, error) {
+	names := os.Getenv(handoffEnvKey)
+	if names == "" {
+		return nil, nil
+	}
+	os.Unsetenv(handoffEnvKey)
+	h := &handoff{}
+	for i, name := range strings.Split(names, ",") {
+		f := os.NewFile(uintptr(3+i), name)
+		switch name {
+		case "ready":
+			h.ready = f
+			continue
+		case "http":
+			ln, err := net.FileListener(f)
+			f.Close()
+			if err != nil {
+				return nil, fmt.Errorf("넘겨받은 리스너: %w", err)
+			}
+			h.http = ln
+		case "ctl":
+			ln, err := net.FileListener(f)
+			f.Close()
+			if err != nil {
+				return nil, fmt.Errorf("넘겨받은 제어 소켓: %w", err)
+			}
+			h.ctl = ln.(*net.UnixListener)
+			// 이제 이 프로세스가 소켓 파일의 주인이다.
+			h.ctl.SetUnlinkOnClose(true)
+		}
+	}
+	if h.http == nil || h.ready == nil {
+		return nil, errors.New("넘겨받은 파일이 부족함: " + names)
+	}
+	return h, nil
+}
+
+// signalReady는 이전 프로세스에게 교체가 끝났음을 알린다.
+func (h *handoff) signalReady() {
+	h.ready.Write([]byte{1})
+	h.ready.Close()
+}
+
+// spawnSuccessor는 새 프로세스에 리스너를 넘기고 준비될 때까지 기다린다.
+// 새 프로세스가 준비 전에 끝나거나 시간이 지나면 종료시키고 오류를
+// 반환하며, 이 프로세스가 계속 요청을 받는다.
+func spawnSuccessor(httpLn net.Listener, ctlLn *net.UnixListener) (int, error) {
+	exe, err := os.Executable()
+	if err != nil {
+		return 0, err
+	}
+
+	type filer interface {
+		File() (*os.File, error)
+		SyscallConn() (syscall.RawConn, error)
+	}
+	var files []*os.File
+	var names []string
+	defer func() {
+		for _, f := range files {
+			f.Close()
+		}
+	}()
+	hf, ok := httpLn.(filer)
+	if !ok {
+		return 0, fmt.Errorf("리스너 %T는 넘길 수 없음", httpLn)
+	}
+	f, err := hf.File()
+	if err != nil {
+		return 0, err
+	}
+	files, names = append(files, f), append(names, "http")
+	if ctlLn != nil {
+		if f, err = ctlLn.File(); err != nil {
+			return 0, err
+		}
+		files, names = append(files, f), append(names, "ctl")
+	}
+	r, w, err := os.Pipe()
+	if err != nil {
+		return 0, err
+	}
+	defer r.Close()
+	files, names = append(files, w), append(names, "ready")
+
+	cmd := exec.Command(exe, os.Args[1:]...)
+	// WATCHDOG_PID는 이 프로세스를 가리키므로 넘기지 않는다. 새 프로세스는
+	// MAINPID 알림 뒤로 systemd 워치독을 이어받는다.
+	for _, kv := range os.Environ() {
+		if !strings.HasPrefix(kv, "WATCHDOG_PID=") {
+			cmd.Env = append(cmd.Env, kv)
+		}
+	}
+	cmd.Env = append(cmd.Env, handoffEnvKey+"="+strings.Join(names, ","))
+	cmd.ExtraFiles = files
+	cmd.Stdout = os.Stdout
+	cmd.Stderr = os.Stderr
+	if isDaemonChild() {
+		cmd.SysProcAttr = &syscall.SysProcAttr{Setsid: true}
+	}
+	err = cmd.Start()
+	// ExtraFiles의 Fd()가 공유 소켓을 블로킹 모드로 바꾼다. 그대로 두면 이
+	// 프로세스의 accept가 리스너를 닫은 뒤에도 막힌 채 남아 연결을 하나 더
+	// 받고 응답 없이 닫는다.
+	setNonblock(hf)
+	if ctlLn != nil {
+		setNonblock(ctlLn)
+	}
+	if err != nil {
+		return 0, err
+	}
+	// 파이프의 쓰기 쪽을 닫아야 새 프로세스가 죽었을 때 EOF를 받는다.
+	w.Close()
+	files = files[:len(files)-1]
+
+	ready := make(chan error, 1)
+	go func() {
+		_, err := r.Read(make([]byte, 1))
+		ready <- err
+	}()
+	select {
+	case err = <-ready:
+		if err != nil {
+			err = errors.New("새 프로세스가 준비 전에 종료됨")
+		}
+	case <-time.After(successorTimeout):
+		err = fmt.Errorf("새 프로세스가 %s 안에 준비되지 않음", successorTimeout)
+	}
+	if err != nil {
+		cmd.Process.Kill()
+		cmd.Wait()
+		return 0, err
+	}
+	// 새 프로세스는 이 프로세스가 끝난 뒤에도 계속 실행된다.
+	pid := cmd.Process.Pid
+	cmd.Process.Release()
+	return pid, nil
+}
+
+func setNonblock(c interface {
+	SyscallConn() (syscall.RawConn, error)
+}) {
+	rc, err := c.SyscallConn()
+	if err != nil {
+		return
+	}
+	rc.Control(func(fd uintptr) { syscall.SetNonblock(int(fd), true) })
+}
+
+// ── 재시작·종료 (CLI 쪽) ───────────────────────────────────────────────────
+
+// instanceProcess는 실행 중인 인스턴스의 PID다. 제어 소켓에 먼저 묻고,
+// 응답이 없으면 PID 파일을 본다. 실행 중이 아니면 0이다.
+func instanceProcess(inst instance) int {
+	var st controlStatus
+	if err := controlCall(inst.Socket, "GET", "/status", &st); err == nil {
+		return st.PID
+	}
+	if pid, _ := instancePID(inst); pid > 0 && isProcessRunning(pid) && isOwnProcess(pid) {
+		return pid
+	}
+	return 0
+}
+
+// restartDaemon은 실행 중인 인스턴스를 무중단으로 교체하고 끝날 때까지
+// 기다린다. 실행 중이 아니면 false를 반환한다.
+func restartDaemon(inst instance) bool {
+	pid := instanceProcess(inst)
+	if pid == 0 {
+		return false
+	}
+	if err := syscall.Kill(pid, syscall.SIGUSR2); err != nil {
+		log.Fatalf("재시작 신호 전송 실패 (PID %d): %v", pid, err)
+	}
+	fmt.Printf("인스턴스 %s 재시작 중 (PID %d)...\n", inst.Name, pid)
+	deadline := time.Now().Add(successorTimeout + 10*time.Second)
+	for time.Now().Before(deadline) {
+		time.Sleep(200 * time.Millisecond)
+		if isProcessRunning(pid) {
+			continue
+		}
+		if next := instanceProcess(inst); next > 0 && next != pid {
+			fmt.Printf("재시작 완료: PID %d → %d\n", pid, next)
+			return true
+		}
+		log.Fatalf("이전 프로세스가 종료됐지만 새 프로세스가 보이지 않음 (로그: %s)", inst.LogFile)
+	}
+	log.Fatalf("재시작 실패: 이전 프로세스(PID %d)가 계속 실행 중, 로그 확인 (%s)", pid, inst.LogFile)
+	return false
+}
@@ -2,7 +2,14 @@ module github.com/yeremiel/claw-usage-chart
 
 go 1.22
 
-require modernc.org/sqlite v1.29.9
+require (
+	github.com/andybalholm/brotli v1.1.1
+	github.com/lib/pq v1.10.9
+	golang.org/x/image v0.18.0
+	golang.org/x/sys v0.19.0
+	golang.org/x/term v0.19.0
+	modernc.org/sqlite v1.29.9
+)
 
 require (
 	github.com/dustin/go-humanize v1.0.1 // indirect
@@ -11,7 +18,6 @@ require (
 	github.com/mattn/go-isatty v0.0.20 // indirect
 	github.com/ncruces/go-strftime v0.1.9 // indirect
 	github.com/remyoudompheng/bigfft v0.0.0-20230129092748-24d4a6f8daec // indirect
-	golang.org/x/sys v0.19.0 // indirect
 	modernc.org/gc/v3 v3.0.0-20240107210532-573471604cb6 // indirect
 	modernc.org/libc v1.49.3 // indirect
 	modernc.org/mathutil v1.6.0 // indirect
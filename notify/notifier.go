@@ -0,0 +1,343 @@
+// Package notify delivers events such as budget crossings and anomalies to
+// webhook targets through a durable store.Outbox.
+package notify
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/http"
+	"net/url"
+	"os"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/yeremiel/claw-usage-chart/store"
+)
+
+// Event kinds pushed to webhook targets.
+const (
+	EventBudgetCrossed   = "budget.crossed"
+	EventAnomalyDetected = "anomaly.detected"
+	EventSyncFailed      = "sync.failed"
+)
+
+// Event is one notification. ID is stable for the thing being reported, so
+// emitting the same event twice (e.g. after a restart) delivers it once.
+type Event struct {
+	ID        string      `json:"id"`
+	Kind      string      `json:"event"`
+	CreatedAt string      `json:"created_at"`
+	Summary   string      `json:"summary"`
+	Data      interface{} `json:"data,omitempty"`
+}
+
+// NotifyConfig lists the webhook targets events are pushed to.
+type NotifyConfig struct {
+	Targets []WebhookTarget `json:"targets"`
+}
+
+// WebhookTarget is one outgoing webhook. Name is what the outbox, logs and
+// status output refer to it by; the URL of a chat webhook is a credential
+// and is never stored.
+type WebhookTarget struct {
+	Name   string   `json:"name"`
+	URL    string   `json:"url"`
+	Format string   `json:"format"`           // "json" (default), "slack" or "discord"
+	Secret string   `json:"secret,omitempty"` // HMAC-SHA256 key for X-Claw-Signature
+	Events []string `json:"events,omitempty"` // event kinds to send; empty means all
+}
+
+// DefaultTargetName names a target configured without a name: the URL's
+// host and a short hash of the URL, which tells targets apart without
+// revealing the URL.
+func DefaultTargetName(rawURL string) string {
+	host := "webhook"
+	if u, err := url.Parse(rawURL); err == nil && u.Hostname() != "" {
+		host = u.Hostname()
+	}
+	sum := sha256.Sum256([]byte(rawURL))
+	return host + "-" + hex.EncodeToString(sum[:4])
+}
+
+// Notifier delivers events to webhook targets through the outbox. Emit only
+// writes to the outbox; Run drains it with retries, so
+// pending deliveries survive restarts.
+type Notifier struct {
+	// UserAgent is sent with every delivery.
+	UserAgent string
+
+	outbox store.Outbox
+	client *http.Client
+	owner  string // claims outbox rows for this notifier
+
+	mu      sync.RWMutex
+	targets map[string]WebhookTarget
+	order   []string
+	wake    chan struct{}
+	now     func() time.Time
+
+	pollInterval time.Duration
+	baseBackoff  time.Duration
+	maxBackoff   time.Duration
+	maxAttempts  int
+	claimLease   time.Duration
+}
+
+// NewNotifier creates a notifier for the given targets.
+func NewNotifier(outbox store.Outbox, targets []WebhookTarget) *Notifier {
+	n := &Notifier{
+		UserAgent:    "claw-usage-chart",
+		outbox:       outbox,
+		client:       &http.Client{Timeout: 10 * time.Second},
+		wake:         make(chan struct{}, 1),
+		now:          time.Now,
+		pollInterval: 15 * time.Second,
+		baseBackoff:  10 * time.Second,
+		maxBackoff:   time.Hour,
+		maxAttempts:  8,
+		claimLease:   5 * time.Minute,
+	}
+	host, _ := os.Hostname()
+	n.owner = fmt.Sprintf("%s/%d/%d", host, os.Getpid(), time.Now().UnixNano())
+	n.SetTargets(targets)
+	return n
+}
+
+// SetTargets replaces the targets. Queued deliveries to a target that is not
+// configured stay in the outbox for the processes that have it. Rows that
+// older versions queued under a target's URL are moved to its name.
+func (n *Notifier) SetTargets(targets []WebhookTarget) {
+	m := make(map[string]WebhookTarget, len(targets))
+	order := make([]string, 0, len(targets))
+	for _, t := range targets {
+		if err := n.outbox.RenameTarget(t.URL, t.Name); err != nil {
+			slog.Error("rename outbox target failed", "component", "notify", "target", t.Name, "err", err)
+		}
+		m[t.Name] = t
+		order = append(order, t.Name)
+	}
+	n.mu.Lock()
+	n.targets, n.order = m, order
+	n.mu.Unlock()
+}
+
+func (n *Notifier) targetNames() []string {
+	n.mu.RLock()
+	defer n.mu.RUnlock()
+	return append([]string(nil), n.order...)
+}
+
+func (n *Notifier) target(name string) (WebhookTarget, bool) {
+	n.mu.RLock()
+	defer n.mu.RUnlock()
+	t, ok := n.targets[name]
+	return t, ok
+}
+
+func (t WebhookTarget) wants(kind string) bool {
+	if len(t.Events) == 0 {
+		return true
+	}
+	for _, e := range t.Events {
+		if e == kind {
+			return true
+		}
+	}
+	return false
+}
+
+// Emit queues ev for every target subscribed to its kind.
+func (n *Notifier) Emit(ev Event) error {
+	if ev.CreatedAt == "" {
+		ev.CreatedAt = n.now().UTC().Format(time.RFC3339)
+	}
+	payload, err := json.Marshal(ev)
+	if err != nil {
+		return err
+	}
+
+	n.mu.RLock()
+	order := n.order
+	n.mu.RUnlock()
+	queued := false
+	for _, name := range order {
+		if t, _ := n.target(name); !t.wants(ev.Kind) {
+			continue
+		}
+		added, err := n.outbox.Enqueue(name, ev.ID, ev.Kind, payload, n.now())
+		if err != nil {
+			return err
+		}
+		queued = queued || added
+	}
+	if queued {
+		select {
+		case n.wake <- struct{}{}:
+		default:
+		}
+	}
+	return nil
+}
+
+// NotifyAnomalies emits one anomaly.detected event per anomaly.
+func (n *Notifier) NotifyAnomalies(anomalies []store.Anomaly) {
+	for _, a := range anomalies {
+		ev := Event{
+			ID:   "anomaly:" + a.Key(),
+			Kind: EventAnomalyDetected,
+			Summary: fmt.Sprintf("Unusual %s for %s/%s at %s: %.4g (baseline %.4g, score %.1f)",
+				a.Metric, a.Agent, a.Model, a.Bucket, a.Value, a.Baseline, a.Score),
+			Data: a,
+		}
+		if err := n.Emit(ev); err != nil {
+			slog.Error("emit event failed", "component", "notify", "event", ev.ID, "err", err)
+		}
+	}
+}
+
+// Run delivers due outbox rows until ctx is cancelled.
+func (n *Notifier) Run(ctx context.Context) {
+	ticker := time.NewTicker(n.pollInterval)
+	defer ticker.Stop()
+	for {
+		if _, err := n.deliverDue(ctx); err != nil {
+			slog.Error("delivery failed", "component", "notify", "err", err)
+		}
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		case <-n.wake:
+		}
+	}
+}
+
+// deliverDue claims the pending rows of the configured targets whose next
+// attempt is due, attempts them and returns how many were delivered. Rows
+// another process has claimed, or for targets only it has, are left alone.
+func (n *Notifier) deliverDue(ctx context.Context) (int, error) {
+	claimed := n.now()
+	due, err := n.outbox.Claim(n.owner, n.targetNames(), claimed, n.claimLease)
+	if err != nil {
+		return 0, err
+	}
+
+	delivered := 0
+	for _, r := range due {
+		// Past the lease another process may have claimed the rest.
+		if ctx.Err() != nil || n.now().Sub(claimed) >= n.claimLease {
+			break
+		}
+		target, ok := n.target(r.Target)
+		if !ok {
+			continue // removed since the claim; the lease runs out
+		}
+
+		permanent, err := n.post(ctx, target, r)
+		if err == nil {
+			if err := n.outbox.MarkDelivered(r.ID, n.now()); err != nil {
+				return delivered, err
+			}
+			delivered++
+			continue
+		}
+
+		r.Attempts++
+		if permanent || r.Attempts >= n.maxAttempts {
+			n.markFailed(r, err.Error())
+			continue
+		}
+		backoff := n.baseBackoff << (r.Attempts - 1)
+		if backoff > n.maxBackoff || backoff <= 0 {
+			backoff = n.maxBackoff
+		}
+		if err2 := n.outbox.Retry(r.ID, r.Attempts, n.now().Add(backoff), err.Error()); err2 != nil {
+			return delivered, err2
+		}
+	}
+	return delivered, nil
+}
+
+func (n *Notifier) markFailed(r store.OutboxItem, reason string) {
+	slog.Warn("giving up on delivery", "component", "notify", "kind", r.Kind, "target", r.Target,
+		"attempts", r.Attempts, "reason", reason)
+	if err := n.outbox.MarkFailed(r.ID, r.Attempts, n.now(), reason); err != nil {
+		slog.Error("mark delivery failed", "component", "notify", "err", err)
+	}
+}
+
+// post sends one delivery. permanent reports errors that retrying won't fix.
+func (n *Notifier) post(ctx context.Context, t WebhookTarget, r store.OutboxItem) (permanent bool, err error) {
+	var ev Event
+	if err := json.Unmarshal(r.Payload, &ev); err != nil {
+		return true, fmt.Errorf("corrupt payload: %w", err)
+	}
+	body, err := renderWebhookBody(t.Format, ev)
+	if err != nil {
+		return true, err
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, t.URL, bytes.NewReader(body))
+	if err != nil {
+		return true, errors.New("invalid webhook url")
+	}
+	ts := strconv.FormatInt(n.now().Unix(), 10)
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("User-Agent", n.UserAgent)
+	req.Header.Set("X-Claw-Event", ev.Kind)
+	req.Header.Set("X-Claw-Delivery", strconv.FormatInt(r.ID, 10))
+	req.Header.Set("X-Claw-Timestamp", ts)
+	if t.Secret != "" {
+		req.Header.Set("X-Claw-Signature", "sha256="+signPayload(t.Secret, ts, body))
+	}
+
+	resp, err := n.client.Do(req)
+	if err != nil {
+		// Keep the URL out of the error, which is stored and logged.
+		var ue *url.Error
+		if errors.As(err, &ue) {
+			err = fmt.Errorf("%s: %w", ue.Op, ue.Err)
+		}
+		return false, err
+	}
+	io.Copy(io.Discard, io.LimitReader(resp.Body, 64*1024))
+	resp.Body.Close()
+
+	if resp.StatusCode >= 200 && resp.StatusCode < 300 {
+		return false, nil
+	}
+	err = fmt.Errorf("HTTP %d", resp.StatusCode)
+	// 4xx means the request itself is wrong, except timeouts and rate limits.
+	permanent = resp.StatusCode >= 400 && resp.StatusCode < 500 &&
+		resp.StatusCode != http.StatusRequestTimeout && resp.StatusCode != http.StatusTooManyRequests
+	return permanent, err
+}
+
+// signPayload returns hex(HMAC-SHA256(secret, timestamp + "." + body)).
+func signPayload(secret, timestamp string, body []byte) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write([]byte(timestamp))
+	mac.Write([]byte("."))
+	mac.Write(body)
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+func renderWebhookBody(format string, ev Event) ([]byte, error) {
+	switch format {
+	case "slack":
+		return json.Marshal(map[string]string{"text": "*[claw-usage-chart]* " + ev.Summary})
+	case "discord":
+		return json.Marshal(map[string]string{"content": "**[claw-usage-chart]** " + ev.Summary})
+	default:
+		return json.Marshal(ev)
+	}
+}
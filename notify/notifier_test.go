@@ -0,0 +1,234 @@
+package notify
+
+import (
+	"context"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/yeremiel/claw-usage-chart/store"
+)
+
+type receivedHook struct {
+	header http.Header
+	body   []byte
+}
+
+// hookReceiver is a local webhook endpoint that fails the first `failures`
+// requests with 500 and records every request it sees.
+func hookReceiver(t *testing.T, failures int) (*httptest.Server, func() []receivedHook) {
+	t.Helper()
+	var mu sync.Mutex
+	var got []receivedHook
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := io.ReadAll(r.Body)
+		mu.Lock()
+		got = append(got, receivedHook{header: r.Header.Clone(), body: body})
+		n := len(got)
+		mu.Unlock()
+		if n <= failures {
+			w.WriteHeader(http.StatusInternalServerError)
+			return
+		}
+		w.WriteHeader(http.StatusNoContent)
+	}))
+	t.Cleanup(srv.Close)
+	return srv, func() []receivedHook {
+		mu.Lock()
+		defer mu.Unlock()
+		return append([]receivedHook(nil), got...)
+	}
+}
+
+func TestNotifierRetriesWithBackoffAndSigns(t *testing.T) {
+	srv, received := hookReceiver(t, 1)
+
+	st, err := store.Open(filepath.Join(t.TempDir(), "usage_cache.db"), store.Options{})
+	if err != nil {
+		t.Fatalf("open: %v", err)
+	}
+	defer st.Close()
+
+	clock := time.Date(2026, 2, 17, 12, 0, 0, 0, time.UTC)
+	n := NewNotifier(st.Outbox(), []WebhookTarget{{Name: "hook", URL: srv.URL, Format: "json", Secret: "s3cret"}})
+	n.now = func() time.Time { return clock }
+
+	ev := Event{ID: "budget:team:2026-02:0.8", Kind: EventBudgetCrossed, Summary: "80%"}
+	if err := n.Emit(ev); err != nil {
+		t.Fatalf("emit: %v", err)
+	}
+	// Same ID again is a no-op.
+	if err := n.Emit(ev); err != nil {
+		t.Fatalf("emit duplicate: %v", err)
+	}
+
+	ctx := context.Background()
+	if delivered, err := n.deliverDue(ctx); err != nil || delivered != 0 {
+		t.Fatalf("first attempt: delivered=%d err=%v, want 0 delivered", delivered, err)
+	}
+	// Not due yet: backoff has not elapsed.
+	if _, err := n.deliverDue(ctx); err != nil {
+		t.Fatalf("deliverDue: %v", err)
+	}
+	if len(received()) != 1 {
+		t.Fatalf("expected 1 request before backoff elapsed, got %d", len(received()))
+	}
+
+	clock = clock.Add(n.baseBackoff)
+	if delivered, err := n.deliverDue(ctx); err != nil || delivered != 1 {
+		t.Fatalf("retry: delivered=%d err=%v, want 1", delivered, err)
+	}
+
+	got := received()
+	if len(got) != 2 {
+		t.Fatalf("expected 2 requests, got %d", len(got))
+	}
+	last := got[1]
+	wantSig := "sha256=" + signPayload("s3cret", last.header.Get("X-Claw-Timestamp"), last.body)
+	if last.header.Get("X-Claw-Signature") != wantSig {
+		t.Fatalf("signature mismatch: got %q want %q", last.header.Get("X-Claw-Signature"), wantSig)
+	}
+	if last.header.Get("X-Claw-Event") != EventBudgetCrossed {
+		t.Fatalf("unexpected event header %q", last.header.Get("X-Claw-Event"))
+	}
+	var payload Event
+	if err := json.Unmarshal(last.body, &payload); err != nil || payload.ID != ev.ID {
+		t.Fatalf("unexpected payload %s (%v)", last.body, err)
+	}
+
+	// Nothing left to send.
+	if delivered, _ := n.deliverDue(ctx); delivered != 0 {
+		t.Fatalf("expected empty outbox, delivered %d", delivered)
+	}
+}
+
+func TestNotifierOutboxSurvivesRestart(t *testing.T) {
+	srv, received := hookReceiver(t, 0)
+	dbPath := filepath.Join(t.TempDir(), "usage_cache.db")
+	targets := []WebhookTarget{{Name: "chat", URL: srv.URL, Format: "slack"}}
+
+	st, err := store.Open(dbPath, store.Options{})
+	if err != nil {
+		t.Fatalf("open: %v", err)
+	}
+	if err := NewNotifier(st.Outbox(), targets).Emit(Event{ID: "sync:x", Kind: EventSyncFailed, Summary: "sync broke"}); err != nil {
+		t.Fatalf("emit: %v", err)
+	}
+	st.Close()
+
+	st, err = store.Open(dbPath, store.Options{})
+	if err != nil {
+		t.Fatalf("reopen: %v", err)
+	}
+	defer st.Close()
+	if delivered, err := NewNotifier(st.Outbox(), targets).deliverDue(context.Background()); err != nil || delivered != 1 {
+		t.Fatalf("delivered=%d err=%v, want 1", delivered, err)
+	}
+
+	var body map[string]string
+	if err := json.Unmarshal(received()[0].body, &body); err != nil {
+		t.Fatalf("decode slack body: %v", err)
+	}
+	if body["text"] != "*[claw-usage-chart]* sync broke" {
+		t.Fatalf("unexpected slack body %v", body)
+	}
+}
+
+// TestNotifiersShareOutbox checks two processes draining one outbox: a row
+// is delivered once, by the process holding its lease, and rows for a
+// target only the other process has are left for it.
+func TestNotifiersShareOutbox(t *testing.T) {
+	srv, received := hookReceiver(t, 0)
+	st, err := store.Open(filepath.Join(t.TempDir(), "usage_cache.db"), store.Options{})
+	if err != nil {
+		t.Fatalf("open: %v", err)
+	}
+	defer st.Close()
+
+	clock := time.Date(2026, 2, 17, 12, 0, 0, 0, time.UTC)
+	hook := WebhookTarget{Name: "hook", URL: srv.URL}
+	a := NewNotifier(st.Outbox(), []WebhookTarget{hook})
+	b := NewNotifier(st.Outbox(), []WebhookTarget{hook})
+	other := NewNotifier(st.Outbox(), []WebhookTarget{{Name: "chat", URL: srv.URL}})
+	for _, n := range []*Notifier{a, b, other} {
+		n.now = func() time.Time { return clock }
+	}
+	if err := a.Emit(Event{ID: "sync:x", Kind: EventSyncFailed, Summary: "sync broke"}); err != nil {
+		t.Fatalf("emit: %v", err)
+	}
+	ctx := context.Background()
+
+	if delivered, err := other.deliverDue(ctx); err != nil || delivered != 0 {
+		t.Fatalf("other target: delivered=%d err=%v, want 0", delivered, err)
+	}
+
+	// a has claimed the row and is still delivering it.
+	claimed, err := st.Outbox().Claim(a.owner, []string{"hook"}, clock, a.claimLease)
+	if err != nil || len(claimed) != 1 {
+		t.Fatalf("claim = %v, %v", claimed, err)
+	}
+	if delivered, err := b.deliverDue(ctx); err != nil || delivered != 0 {
+		t.Fatalf("leased row: delivered=%d err=%v, want 0", delivered, err)
+	}
+
+	// a died without settling it; once the lease runs out b takes over.
+	clock = clock.Add(a.claimLease)
+	if delivered, err := b.deliverDue(ctx); err != nil || delivered != 1 {
+		t.Fatalf("expired lease: delivered=%d err=%v, want 1", delivered, err)
+	}
+	if delivered, _ := a.deliverDue(ctx); delivered != 0 {
+		t.Fatalf("delivered again by a")
+	}
+	if n := len(received()); n != 1 {
+		t.Fatalf("receiver got %d requests, want 1", n)
+	}
+}
+
+// TestNotifierKeepsWebhookURLOutOfOutbox checks that neither target names
+// nor stored errors carry the webhook URL, a credential for chat hooks, and
+// that rows queued under a URL by older versions move to the name.
+func TestNotifierKeepsWebhookURLOutOfOutbox(t *testing.T) {
+	dead := httptest.NewServer(http.NotFoundHandler())
+	hookURL := dead.URL + "/services/T000/B000/s3cretpath"
+	dead.Close()
+
+	name := DefaultTargetName(hookURL)
+	if strings.Contains(name, "s3cretpath") || !strings.HasPrefix(name, "127.0.0.1-") {
+		t.Fatalf("DefaultTargetName = %q", name)
+	}
+
+	st, err := store.Open(filepath.Join(t.TempDir(), "usage_cache.db"), store.Options{})
+	if err != nil {
+		t.Fatalf("open: %v", err)
+	}
+	defer st.Close()
+	clock := time.Date(2026, 2, 17, 12, 0, 0, 0, time.UTC)
+	if _, err := st.Outbox().Enqueue(hookURL, "sync:old", EventSyncFailed, []byte(`{"id":"sync:old"}`), clock); err != nil {
+		t.Fatalf("enqueue legacy row: %v", err)
+	}
+
+	n := NewNotifier(st.Outbox(), []WebhookTarget{{Name: name, URL: hookURL}})
+	n.now = func() time.Time { return clock }
+	if err := n.Emit(Event{ID: "sync:new", Kind: EventSyncFailed, Summary: "sync broke"}); err != nil {
+		t.Fatalf("emit: %v", err)
+	}
+	if _, err := n.deliverDue(context.Background()); err != nil {
+		t.Fatalf("deliverDue: %v", err)
+	}
+
+	var rows, leaks int
+	if err := st.DB().QueryRow(`
+		SELECT COUNT(*), COALESCE(SUM(CASE WHEN target LIKE '%s3cretpath%' OR last_error LIKE '%s3cretpath%' THEN 1 ELSE 0 END), 0)
+		FROM notify_outbox`).Scan(&rows, &leaks); err != nil {
+		t.Fatalf("count: %v", err)
+	}
+	if rows != 2 || leaks != 0 {
+		t.Fatalf("outbox has %d rows, %d with the url; want 2, 0", rows, leaks)
+	}
+}
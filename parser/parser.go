@@ -0,0 +1,592 @@
+// Package parser turns OpenClaw session JSONL lines into usage records.
+// It has no dependencies beyond the standard library and keeps no state,
+// so it can be used on its own to read session files.
+package parser
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// rawRecord is a flexible struct for deserialising JSONL lines.
+// Fields can appear at top level or inside "message".
+type rawRecord struct {
+	Type       interface{}     `json:"type"`
+	Timestamp  interface{}     `json:"timestamp"`
+	Message    json.RawMessage `json:"message"`
+	Usage      json.RawMessage `json:"usage"`
+	CostUsd    *float64        `json:"costUsd"`
+	Model      string          `json:"model"`
+	ModelID    string          `json:"modelId"`
+	ModelID2   string          `json:"model_id"`
+	RequestID  string          `json:"requestId"`
+	RequestID2 string          `json:"request_id"`
+
+	rawDetails
+}
+
+type rawMessage struct {
+	Usage      json.RawMessage `json:"usage"`
+	Model      string          `json:"model"`
+	ModelID    string          `json:"modelId"`
+	ModelID2   string          `json:"model_id"`
+	ID         string          `json:"id"`
+	ResponseID string          `json:"responseId"`
+
+	rawDetails
+}
+
+// rawDetails holds the descriptive fields OpenClaw (and compatible tools)
+// write next to usage, either at top level or inside "message".
+type rawDetails struct {
+	Provider    string          `json:"provider"`
+	API         string          `json:"api"`
+	Role        string          `json:"role"`
+	StopReason  string          `json:"stopReason"`
+	StopReason2 string          `json:"stop_reason"`
+	DurationMs  interface{}     `json:"durationMs"`
+	DurationMs2 interface{}     `json:"duration_ms"`
+	LatencyMs   interface{}     `json:"latencyMs"`
+	LatencyMs2  interface{}     `json:"latency_ms"`
+	Content     json.RawMessage `json:"content"`
+	ToolCalls   []rawToolCall   `json:"tool_calls"`
+}
+
+// rawContentBlock is one entry of a message "content" array.
+type rawContentBlock struct {
+	Type string `json:"type"`
+	Name string `json:"name"`
+}
+
+// rawToolCall is an OpenAI-style tool_calls entry.
+type rawToolCall struct {
+	Name     string `json:"name"`
+	Function struct {
+		Name string `json:"name"`
+	} `json:"function"`
+}
+
+type rawUsage struct {
+	// Explicit total fields
+	TotalTokens  interface{} `json:"totalTokens"`
+	TotalTokens2 interface{} `json:"total_tokens"`
+	Total        interface{} `json:"total"`
+	Tokens       interface{} `json:"tokens"`
+
+	// Individual token fields
+	Input                    interface{} `json:"input"`
+	Output                   interface{} `json:"output"`
+	CacheRead                interface{} `json:"cacheRead"`
+	CacheWrite               interface{} `json:"cacheWrite"`
+	InputTokens              interface{} `json:"input_tokens"`
+	OutputTokens             interface{} `json:"output_tokens"`
+	CacheReadInputTokens     interface{} `json:"cache_read_input_tokens"`
+	CacheCreationInputTokens interface{} `json:"cache_creation_input_tokens"`
+	ReasoningTokens          interface{} `json:"reasoning_tokens"`
+
+	// Cost sub-field
+	Cost interface{} `json:"cost"`
+}
+
+// UsageRecord is what we store after parsing one JSONL line.
+type UsageRecord struct {
+	AgentName string
+	Model     string
+	DateKey   string // "YYYY-MM-DD" or "unknown"
+	Tokens    int
+	Cost      float64
+	Hour      *int  // 0-23, nil if unknown
+	DOW       *int  // 0=Mon..6=Sun, nil if unknown
+	Timestamp int64 // unix seconds, 0 if unknown
+
+	Provider   string   // provider, else API name; "unknown" if absent
+	Role       string   // message role; "unknown" if absent
+	StopReason string   // "unknown" if absent
+	ToolCalls  int      // number of tool-use blocks in the message
+	ToolNames  []string // one entry per tool-use block, in order
+	LatencyMs  *int     // response latency, nil if not recorded
+
+	// DedupeKey identifies the underlying API response across files:
+	// "id:<message or request id>" when the line carries one, otherwise
+	// "sha256:<hash of the line>".
+	DedupeKey string
+}
+
+// SessionFile pairs an agent name with a JSONL file path.
+type SessionFile struct {
+	AgentName string
+	Path      string
+}
+
+// IterSessionFiles returns all *.jsonl files under agentsDir/<agent>/sessions/*.
+func IterSessionFiles(agentsDir string) ([]SessionFile, error) {
+	entries, err := os.ReadDir(agentsDir)
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	var results []SessionFile
+	for _, e := range entries {
+		if !e.IsDir() {
+			continue
+		}
+		sessDir := filepath.Join(agentsDir, e.Name(), "sessions")
+		files, err := filepath.Glob(filepath.Join(sessDir, "*.jsonl"))
+		if err != nil {
+			continue
+		}
+		sort.Strings(files)
+		for _, f := range files {
+			results = append(results, SessionFile{AgentName: e.Name(), Path: f})
+		}
+	}
+	return results, nil
+}
+
+// ParseLine parses a single JSONL line into a UsageRecord.
+// Returns nil if the line should be skipped.
+func ParseLine(agentName string, line []byte) *UsageRecord {
+	line = []byte(strings.TrimSpace(string(line)))
+	if len(line) == 0 {
+		return nil
+	}
+
+	var rec rawRecord
+	if err := json.Unmarshal(line, &rec); err != nil {
+		return nil
+	}
+
+	// Extract usage dict
+	usage := extractUsage(&rec)
+	if usage == nil {
+		return nil
+	}
+
+	tokens := extractTotalTokens(usage)
+	if tokens <= 0 {
+		return nil
+	}
+
+	ts := extractTimestamp(&rec)
+	dateKey := parseTimestampToDate(ts)
+	if dateKey == "" {
+		dateKey = "unknown"
+	}
+
+	cost := extractCost(&rec, usage)
+	model := extractModel(&rec)
+	hour, dow := parseTimestampToHourDOW(ts)
+	var unix int64
+	if t := parseTimestampToTime(ts); !t.IsZero() {
+		unix = t.Unix()
+	}
+
+	out := &UsageRecord{
+		AgentName: agentName,
+		Model:     model,
+		DateKey:   dateKey,
+		Tokens:    tokens,
+		Cost:      cost,
+		Hour:      hour,
+		DOW:       dow,
+		Timestamp: unix,
+	}
+	applyDetails(out, &rec)
+	out.DedupeKey = dedupeKey(agentName, &rec, line)
+	return out
+}
+
+// dedupeKey prefers a provider message ID, then a request ID, and falls back
+// to a hash of the agent name and the whole line. The agent is part of the
+// hash so that two agents logging byte-identical lines (same timestamp,
+// model and usage) keep both records.
+func dedupeKey(agentName string, rec *rawRecord, line []byte) string {
+	var ids []string
+	if len(rec.Message) > 0 {
+		var msg rawMessage
+		if err := json.Unmarshal(rec.Message, &msg); err == nil {
+			ids = append(ids, msg.ID, msg.ResponseID)
+		}
+	}
+	ids = append(ids, rec.RequestID, rec.RequestID2)
+	for _, id := range ids {
+		if id = strings.TrimSpace(id); id != "" {
+			return "id:" + id
+		}
+	}
+	h := sha256.New()
+	h.Write([]byte(agentName))
+	h.Write([]byte{0})
+	h.Write(line)
+	return "sha256:" + hex.EncodeToString(h.Sum(nil)[:16])
+}
+
+// SessionMeta is the working-directory context of a session, taken from
+// session header lines ({"type":"session","cwd":...}) or from any line that
+// carries cwd/gitBranch fields.
+type SessionMeta struct {
+	Cwd       string
+	GitBranch string
+	GitRepo   string
+}
+
+type rawSessionMeta struct {
+	Cwd        string `json:"cwd"`
+	Cwd2       string `json:"workingDirectory"`
+	GitBranch  string `json:"gitBranch"`
+	GitBranch2 string `json:"git_branch"`
+	Branch     string `json:"branch"`
+	GitRepo    string `json:"gitRepo"`
+	GitRepo2   string `json:"git_repo"`
+	Repository string `json:"repository"`
+	GitRemote  string `json:"gitRemote"`
+}
+
+// ParseSessionMeta returns the session metadata on line, or nil if the line
+// carries none.
+func ParseSessionMeta(line []byte) *SessionMeta {
+	// Cheap pre-check: most lines are messages without any of these keys.
+	if !bytes.Contains(line, []byte(`"cwd"`)) && !bytes.Contains(line, []byte(`"workingDirectory"`)) &&
+		!bytes.Contains(line, []byte(`"gitBranch"`)) && !bytes.Contains(line, []byte(`"git_branch"`)) {
+		return nil
+	}
+	var raw rawSessionMeta
+	if err := json.Unmarshal(line, &raw); err != nil {
+		return nil
+	}
+	meta := SessionMeta{
+		Cwd:       firstNonEmpty(raw.Cwd, raw.Cwd2),
+		GitBranch: firstNonEmpty(raw.GitBranch, raw.GitBranch2, raw.Branch),
+		GitRepo:   firstNonEmpty(raw.GitRepo, raw.GitRepo2, raw.Repository, raw.GitRemote),
+	}
+	if meta == (SessionMeta{}) {
+		return nil
+	}
+	return &meta
+}
+
+// Merge overlays the non-empty fields of other onto m.
+func (m SessionMeta) Merge(other SessionMeta) SessionMeta {
+	if other.Cwd != "" {
+		m.Cwd = other.Cwd
+	}
+	if other.GitBranch != "" {
+		m.GitBranch = other.GitBranch
+	}
+	if other.GitRepo != "" {
+		m.GitRepo = other.GitRepo
+	}
+	return m
+}
+
+func firstNonEmpty(values ...string) string {
+	for _, v := range values {
+		if v = strings.TrimSpace(v); v != "" {
+			return v
+		}
+	}
+	return ""
+}
+
+// ── internal helpers ─────────────────────────────────────────────────────────
+
+func extractUsage(rec *rawRecord) *rawUsage {
+	// Try message.usage first
+	if len(rec.Message) > 0 {
+		var msg rawMessage
+		if err := json.Unmarshal(rec.Message, &msg); err == nil && len(msg.Usage) > 0 {
+			var u rawUsage
+			if err := json.Unmarshal(msg.Usage, &u); err == nil {
+				return &u
+			}
+		}
+	}
+	// Then top-level usage
+	if len(rec.Usage) > 0 {
+		var u rawUsage
+		if err := json.Unmarshal(rec.Usage, &u); err == nil {
+			return &u
+		}
+	}
+	return nil
+}
+
+func toInt(v interface{}) int {
+	if v == nil {
+		return 0
+	}
+	switch val := v.(type) {
+	case float64:
+		return int(val)
+	case int:
+		return val
+	case int64:
+		return int(val)
+	case string:
+		s := strings.TrimSpace(val)
+		if s == "" {
+			return 0
+		}
+		f, err := strconv.ParseFloat(s, 64)
+		if err != nil {
+			return 0
+		}
+		return int(f)
+	case bool:
+		if val {
+			return 1
+		}
+		return 0
+	}
+	return 0
+}
+
+func extractTotalTokens(u *rawUsage) int {
+	// Check explicit total fields first
+	for _, v := range []interface{}{u.TotalTokens, u.TotalTokens2, u.Total, u.Tokens} {
+		if n := toInt(v); n > 0 {
+			return n
+		}
+	}
+	// Sum individual fields
+	sum := 0
+	for _, v := range []interface{}{
+		u.Input, u.Output, u.CacheRead, u.CacheWrite,
+		u.InputTokens, u.OutputTokens,
+		u.CacheReadInputTokens, u.CacheCreationInputTokens,
+		u.ReasoningTokens,
+	} {
+		if n := toInt(v); n > 0 {
+			sum += n
+		}
+	}
+	return sum
+}
+
+func extractModel(rec *rawRecord) string {
+	// Try message fields first
+	if len(rec.Message) > 0 {
+		var msg rawMessage
+		if err := json.Unmarshal(rec.Message, &msg); err == nil {
+			for _, s := range []string{msg.Model, msg.ModelID, msg.ModelID2} {
+				if s = strings.TrimSpace(s); s != "" {
+					return s
+				}
+			}
+		}
+	}
+	// Then top-level
+	for _, s := range []string{rec.Model, rec.ModelID, rec.ModelID2} {
+		if s = strings.TrimSpace(s); s != "" {
+			return s
+		}
+	}
+	return "unknown"
+}
+
+func extractCost(rec *rawRecord, u *rawUsage) float64 {
+	// Prefer top-level costUsd (OpenClaw native field)
+	if rec.CostUsd != nil {
+		return *rec.CostUsd
+	}
+	// Fall back to usage.cost
+	if u.Cost == nil {
+		return 0
+	}
+	switch v := u.Cost.(type) {
+	case float64:
+		return v
+	case map[string]interface{}:
+		if total, ok := v["total"]; ok {
+			if f, ok := total.(float64); ok {
+				return f
+			}
+		}
+	}
+	return 0
+}
+
+// applyDetails fills provider, role, stop reason, tool calls and latency,
+// preferring values inside "message" over top-level ones.
+func applyDetails(out *UsageRecord, rec *rawRecord) {
+	details := []*rawDetails{}
+	if len(rec.Message) > 0 {
+		var msg rawMessage
+		if err := json.Unmarshal(rec.Message, &msg); err == nil {
+			details = append(details, &msg.rawDetails)
+		}
+	}
+	details = append(details, &rec.rawDetails)
+
+	out.Provider, out.Role, out.StopReason = "unknown", "unknown", "unknown"
+	for i := len(details) - 1; i >= 0; i-- {
+		d := details[i]
+		for _, s := range []string{d.Provider, d.API} {
+			if s = strings.TrimSpace(s); s != "" {
+				out.Provider = s
+				break
+			}
+		}
+		if s := strings.TrimSpace(d.Role); s != "" {
+			out.Role = s
+		}
+		for _, s := range []string{d.StopReason, d.StopReason2} {
+			if s = strings.TrimSpace(s); s != "" {
+				out.StopReason = s
+				break
+			}
+		}
+		for _, v := range []interface{}{d.LatencyMs, d.LatencyMs2, d.DurationMs, d.DurationMs2} {
+			if n := toInt(v); n > 0 {
+				out.LatencyMs = &n
+				break
+			}
+		}
+		if names := extractToolNames(d); len(names) > 0 {
+			out.ToolNames = names
+		}
+	}
+	out.ToolCalls = len(out.ToolNames)
+}
+
+// extractToolNames lists the tools called by a message: content blocks of
+// type tool_use (Anthropic), toolCall (OpenClaw) or function_call (OpenAI
+// Responses), plus OpenAI chat tool_calls.
+func extractToolNames(d *rawDetails) []string {
+	var names []string
+	if len(d.Content) > 0 && d.Content[0] == '[' {
+		var blocks []rawContentBlock
+		if err := json.Unmarshal(d.Content, &blocks); err == nil {
+			for _, b := range blocks {
+				switch b.Type {
+				case "tool_use", "toolCall", "tool_call", "function_call":
+					names = append(names, toolName(b.Name))
+				}
+			}
+		}
+	}
+	for _, tc := range d.ToolCalls {
+		n := tc.Function.Name
+		if n == "" {
+			n = tc.Name
+		}
+		names = append(names, toolName(n))
+	}
+	return names
+}
+
+func toolName(s string) string {
+	if s = strings.TrimSpace(s); s != "" {
+		return s
+	}
+	return "unknown"
+}
+
+func extractTimestamp(rec *rawRecord) interface{} {
+	if rec.Timestamp != nil {
+		return rec.Timestamp
+	}
+	if len(rec.Message) > 0 {
+		var m map[string]interface{}
+		if err := json.Unmarshal(rec.Message, &m); err == nil {
+			if ts, ok := m["timestamp"]; ok {
+				return ts
+			}
+		}
+	}
+	return nil
+}
+
+func parseTimestampToDate(ts interface{}) string {
+	t := parseTimestampToTime(ts)
+	if t.IsZero() {
+		return ""
+	}
+	// Use local time for date
+	return t.Local().Format("2006-01-02")
+}
+
+func parseTimestampToHourDOW(ts interface{}) (*int, *int) {
+	t := parseTimestampToTime(ts)
+	if t.IsZero() {
+		return nil, nil
+	}
+	lt := t.Local()
+	h := lt.Hour()
+	// weekday: Go Sunday=0, Monday=1 … Saturday=6
+	// Python: 0=Mon..6=Sun → convert
+	wd := int(lt.Weekday())
+	dow := (wd + 6) % 7 // Sun(0)→6, Mon(1)→0, …, Sat(6)→5
+	return &h, &dow
+}
+
+// parseTimestampToTime converts a raw timestamp value to time.Time (UTC).
+func parseTimestampToTime(ts interface{}) time.Time {
+	if ts == nil {
+		return time.Time{}
+	}
+	switch v := ts.(type) {
+	case float64:
+		sec := v
+		if v > 10_000_000_000 {
+			sec = v / 1000.0
+		}
+		return time.Unix(int64(sec), 0).UTC()
+	case string:
+		s := strings.TrimSpace(v)
+		if s == "" {
+			return time.Time{}
+		}
+		// Numeric string
+		if isNumeric(s) {
+			f, err := strconv.ParseFloat(s, 64)
+			if err != nil {
+				return time.Time{}
+			}
+			if len(s) >= 13 {
+				f /= 1000.0
+			}
+			return time.Unix(int64(f), 0).UTC()
+		}
+		// ISO 8601 — replace Z with +00:00
+		iso := strings.Replace(s, "Z", "+00:00", 1)
+		t, err := time.Parse(time.RFC3339Nano, iso)
+		if err == nil {
+			return t.UTC()
+		}
+		// Try without nanoseconds
+		t, err = time.Parse(time.RFC3339, iso)
+		if err == nil {
+			return t.UTC()
+		}
+		// Bare date
+		if len(s) >= 10 && s[4] == '-' && s[7] == '-' {
+			t, err = time.Parse("2006-01-02", s[:10])
+			if err == nil {
+				return t.UTC()
+			}
+		}
+	}
+	return time.Time{}
+}
+
+func isNumeric(s string) bool {
+	s = strings.TrimPrefix(s, "-")
+	for _, c := range s {
+		if c < '0' || c > '9' {
+			return false
+		}
+	}
+	return len(s) > 0
+}
@@ -0,0 +1,69 @@
+package parser
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestParseLineCapturesMessageDetails(t *testing.T) {
+	for _, tc := range []struct {
+		name       string
+		line       string
+		provider   string
+		role       string
+		stopReason string
+		tools      []string
+		latency    int
+	}{
+		{
+			name: "openclaw",
+			line: `{"type":"message","timestamp":"2026-02-17T10:00:00Z","message":{"role":"assistant",` +
+				`"api":"anthropic-messages","provider":"anthropic","model":"claude-x","stopReason":"toolUse",` +
+				`"content":[{"type":"text","text":"hi"},{"type":"toolCall","name":"exec"},{"type":"toolCall","name":"read"}],` +
+				`"usage":{"input":10,"output":5,"totalTokens":15,"cost":{"total":0.01}}},"durationMs":1234}`,
+			provider:   "anthropic",
+			role:       "assistant",
+			stopReason: "toolUse",
+			tools:      []string{"exec", "read"},
+			latency:    1234,
+		},
+		{
+			name: "anthropic content blocks",
+			line: `{"type":"assistant","timestamp":"2026-02-17T10:00:00Z","message":{"role":"assistant","model":"m",` +
+				`"stop_reason":"tool_use","content":[{"type":"tool_use","name":"Bash"}],"usage":{"input_tokens":7}}}`,
+			provider:   "unknown",
+			role:       "assistant",
+			stopReason: "tool_use",
+			tools:      []string{"Bash"},
+		},
+		{
+			name: "openai tool_calls",
+			line: `{"timestamp":"2026-02-17T10:00:00Z","message":{"role":"assistant","api":"openai-completions",` +
+				`"tool_calls":[{"function":{"name":"search"}}],"usage":{"total_tokens":9}},"latency_ms":80}`,
+			provider:   "openai-completions",
+			role:       "assistant",
+			stopReason: "unknown",
+			tools:      []string{"search"},
+			latency:    80,
+		},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			rec := ParseLine("a", []byte(tc.line))
+			if rec == nil {
+				t.Fatalf("ParseLine returned nil")
+			}
+			if rec.Provider != tc.provider || rec.Role != tc.role || rec.StopReason != tc.stopReason {
+				t.Fatalf("got provider=%q role=%q stop=%q", rec.Provider, rec.Role, rec.StopReason)
+			}
+			if !reflect.DeepEqual(rec.ToolNames, tc.tools) || rec.ToolCalls != len(tc.tools) {
+				t.Fatalf("got tools %v (%d calls), want %v", rec.ToolNames, rec.ToolCalls, tc.tools)
+			}
+			if tc.latency == 0 && rec.LatencyMs != nil {
+				t.Fatalf("unexpected latency %d", *rec.LatencyMs)
+			}
+			if tc.latency != 0 && (rec.LatencyMs == nil || *rec.LatencyMs != tc.latency) {
+				t.Fatalf("got latency %v, want %d", rec.LatencyMs, tc.latency)
+			}
+		})
+	}
+}
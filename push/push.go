@@ -0,0 +1,174 @@
+// Package push ships usage records from a local cache to a central
+// collector over HTTP, so a team can see one dashboard without sharing
+// home directories.
+package push
+
+import (
+	"bytes"
+	"compress/gzip"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/yeremiel/claw-usage-chart/api"
+	"github.com/yeremiel/claw-usage-chart/store"
+)
+
+// Path is where a collector accepts batches.
+const Path = "/api/push"
+
+// Batch is the body of a push request. The collector attributes the
+// records to Host and to the user the bearer token belongs to.
+type Batch = api.Batch
+
+// Pusher syncs the local session files into its own cache and sends every
+// record the collector has not acknowledged yet. The id of the last
+// acknowledged record is kept in the cache, so a restart resumes where
+// the previous run stopped; resending is harmless because the collector
+// drops records it already has.
+type Pusher struct {
+	Store     store.Store
+	AgentsDir string
+	URL       string // collector base URL, e.g. "http://dash.internal:8585"
+	Token     string
+	Host      string // label for this machine's records on the collector
+	UserAgent string
+	BatchSize int // records per request; default 1000
+
+	Client *http.Client
+}
+
+// Result counts what one Push call sent.
+type Result struct {
+	Sync       store.SyncResult
+	Sent       int
+	Accepted   int
+	Duplicates int
+}
+
+func (p *Pusher) watermarkKey() string {
+	return "push_watermark:" + strings.TrimRight(p.URL, "/")
+}
+
+// Push syncs once and sends the new records in batches.
+func (p *Pusher) Push(ctx context.Context) (Result, error) {
+	var res Result
+	synced, err := p.Store.Sync(p.AgentsDir)
+	if err != nil {
+		return res, fmt.Errorf("sync: %w", err)
+	}
+	res.Sync = synced
+
+	key := p.watermarkKey()
+	v, err := p.Store.Meta(key)
+	if err != nil {
+		return res, err
+	}
+	var after int64
+	if v != "" {
+		if after, err = strconv.ParseInt(v, 10, 64); err != nil {
+			return res, fmt.Errorf("bad watermark %q", v)
+		}
+	}
+
+	size := p.BatchSize
+	if size <= 0 {
+		size = 1000
+	}
+	for {
+		recs, err := p.Store.Export(after, size)
+		if err != nil {
+			return res, err
+		}
+		if len(recs) == 0 {
+			return res, nil
+		}
+		ack, err := p.send(ctx, Batch{Host: p.Host, Records: recs})
+		if err != nil {
+			return res, err
+		}
+		res.Sent += len(recs)
+		res.Accepted += ack.Accepted
+		res.Duplicates += ack.Duplicates
+
+		after = recs[len(recs)-1].ID
+		if err := p.Store.SetMeta(key, strconv.FormatInt(after, 10)); err != nil {
+			return res, err
+		}
+		if len(recs) < size {
+			return res, nil
+		}
+	}
+}
+
+// Run pushes immediately and then every interval until ctx is done.
+func (p *Pusher) Run(ctx context.Context, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		res, err := p.Push(ctx)
+		if err != nil {
+			slog.Error("push failed", "component", "push", "err", err)
+		} else if res.Sent > 0 {
+			slog.Info("pushed records", "component", "push", "sent", res.Sent, "new", res.Accepted, "duplicates", res.Duplicates)
+		}
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+func (p *Pusher) send(ctx context.Context, b Batch) (store.IngestResult, error) {
+	var body bytes.Buffer
+	zw := gzip.NewWriter(&body)
+	if err := json.NewEncoder(zw).Encode(b); err != nil {
+		return store.IngestResult{}, err
+	}
+	if err := zw.Close(); err != nil {
+		return store.IngestResult{}, err
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, strings.TrimRight(p.URL, "/")+Path, &body)
+	if err != nil {
+		return store.IngestResult{}, err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("Content-Encoding", "gzip")
+	req.Header.Set("Authorization", "Bearer "+p.Token)
+	if p.UserAgent != "" {
+		req.Header.Set("User-Agent", p.UserAgent)
+	}
+
+	client := p.Client
+	if client == nil {
+		client = &http.Client{Timeout: 30 * time.Second}
+	}
+	resp, err := client.Do(req)
+	if err != nil {
+		return store.IngestResult{}, err
+	}
+	defer resp.Body.Close()
+	payload, _ := io.ReadAll(io.LimitReader(resp.Body, 1<<20))
+	if resp.StatusCode != http.StatusOK {
+		var e struct {
+			Error string `json:"error"`
+		}
+		if json.Unmarshal(payload, &e) == nil && e.Error != "" {
+			return store.IngestResult{}, fmt.Errorf("collector: %s: %s", resp.Status, e.Error)
+		}
+		return store.IngestResult{}, fmt.Errorf("collector: %s", resp.Status)
+	}
+	var ack store.IngestResult
+	if err := json.Unmarshal(payload, &ack); err != nil {
+		return store.IngestResult{}, fmt.Errorf("collector response: %w", err)
+	}
+	return ack, nil
+}
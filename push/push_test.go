@@ -0,0 +1,117 @@
+package push_test
+
+import (
+	"context"
+	"fmt"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/yeremiel/claw-usage-chart/push"
+	"github.com/yeremiel/claw-usage-chart/server"
+	"github.com/yeremiel/claw-usage-chart/store"
+)
+
+const token = "0123456789abcdef-alice"
+
+func writeSession(t *testing.T, agentsDir string, tokens ...int) {
+	t.Helper()
+	dir := filepath.Join(agentsDir, "alpha", "sessions")
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		t.Fatalf("mkdir: %v", err)
+	}
+	var b strings.Builder
+	for i, n := range tokens {
+		fmt.Fprintf(&b, `{"timestamp":"2026-02-17T10:%02d:00Z","model":"m1","requestId":"req-%d","usage":{"input_tokens":%d}}`+"\n", i, i, n)
+	}
+	if err := os.WriteFile(filepath.Join(dir, "s.jsonl"), []byte(b.String()), 0o644); err != nil {
+		t.Fatalf("write: %v", err)
+	}
+}
+
+func TestPushToCollector(t *testing.T) {
+	tmp := t.TempDir()
+
+	central, err := store.Open(filepath.Join(tmp, "central.db"), store.Options{
+		Tags: []store.TagRule{{Tags: []string{"team"}, Agent: "alpha"}},
+	})
+	if err != nil {
+		t.Fatalf("open central: %v", err)
+	}
+	defer central.Close()
+	srv := httptest.NewServer(server.New(central, filepath.Join(tmp, "none"), server.Options{
+		PushTokens: []server.PushToken{
+			{User: "alice", Token: token, Hosts: []string{"laptop-1"}},
+			{User: "bob", Token: "bob-token-bob-token", Hosts: []string{"desktop"}},
+		},
+	}).Handler())
+	defer srv.Close()
+
+	local, err := store.Open(filepath.Join(tmp, "push.db"), store.Options{})
+	if err != nil {
+		t.Fatalf("open local: %v", err)
+	}
+	defer local.Close()
+
+	agentsDir := filepath.Join(tmp, "agents")
+	writeSession(t, agentsDir, 10, 20)
+	p := &push.Pusher{Store: local, AgentsDir: agentsDir, URL: srv.URL, Token: token, Host: "laptop-1", BatchSize: 1}
+
+	res, err := p.Push(context.Background())
+	if err != nil {
+		t.Fatalf("push: %v", err)
+	}
+	if res.Sent != 2 || res.Accepted != 2 {
+		t.Fatalf("first push = %+v, want 2 sent and accepted", res)
+	}
+
+	writeSession(t, agentsDir, 10, 20, 30)
+	if res, err = p.Push(context.Background()); err != nil || res.Sent != 1 || res.Accepted != 1 {
+		t.Fatalf("second push = %+v, %v; want only the new record", res, err)
+	}
+
+	// A lost watermark resends everything; the collector drops it all.
+	if err := local.SetMeta("push_watermark:"+srv.URL, ""); err != nil {
+		t.Fatalf("reset watermark: %v", err)
+	}
+	if res, err = p.Push(context.Background()); err != nil || res.Sent != 3 || res.Duplicates != 3 {
+		t.Fatalf("resend = %+v, %v; want 3 duplicates", res, err)
+	}
+
+	stats, err := central.Stats(store.UsageFilter{Host: "laptop-1", User: "alice", Tag: "team"})
+	if err != nil {
+		t.Fatalf("stats: %v", err)
+	}
+	if stats.Summary.UsageRecords != 3 || stats.Summary.TotalTokens != 60 {
+		t.Fatalf("central summary = %+v, want 3 records / 60 tokens", stats.Summary)
+	}
+	if stats, _ := central.Stats(store.UsageFilter{Host: store.LocalHost}); stats.Summary.UsageRecords != 0 {
+		t.Fatalf("pushed records counted as local: %+v", stats.Summary)
+	}
+
+	writeSession(t, agentsDir, 10, 20, 30, 40)
+	bad := *p
+	bad.Token = "wrong-token-wrong-token"
+	if _, err := bad.Push(context.Background()); err == nil || !strings.Contains(err.Error(), "401") {
+		t.Fatalf("push with bad token: %v, want 401", err)
+	}
+	spoof := *p
+	spoof.Host = "desktop"
+	if _, err := spoof.Push(context.Background()); err == nil || !strings.Contains(err.Error(), "403") {
+		t.Fatalf("push as another token's host: %v, want 403", err)
+	}
+
+	// Dedupe keys only match records of the same host or user, so bob's
+	// identical lines are not taken for alice's.
+	bobStore, err := store.Open(filepath.Join(tmp, "bob.db"), store.Options{})
+	if err != nil {
+		t.Fatalf("open bob: %v", err)
+	}
+	defer bobStore.Close()
+	bob := &push.Pusher{Store: bobStore, AgentsDir: agentsDir, URL: srv.URL, Token: "bob-token-bob-token", Host: "desktop"}
+	if res, err = bob.Push(context.Background()); err != nil || res.Accepted != 4 {
+		t.Fatalf("bob push = %+v, %v; want 4 accepted", res, err)
+	}
+}
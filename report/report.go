@@ -0,0 +1,276 @@
+// Package report renders a StatsResponse as a file to share or archive:
+// the dashboard page with its data inlined, Markdown, or plain text.
+package report
+
+import (
+	"bytes"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/yeremiel/claw-usage-chart/server"
+	"github.com/yeremiel/claw-usage-chart/store"
+)
+
+// Report is one snapshot of the stats for a date range.
+type Report struct {
+	Title string
+	Start string // inclusive "YYYY-MM-DD"; empty means from the first record
+	End   string // inclusive "YYYY-MM-DD"; empty means up to the last record
+	Stats store.StatsResponse
+}
+
+// maxRows caps each breakdown table; the rest is summed into one row.
+const maxRows = 15
+
+// Subtitle describes the range and when the data was read.
+func (r Report) Subtitle() string {
+	rng := "All time"
+	switch {
+	case r.Start != "" && r.End != "" && r.Start == r.End:
+		rng = r.Start
+	case r.Start != "" && r.End != "":
+		rng = r.Start + " – " + r.End
+	case r.Start != "":
+		rng = "Since " + r.Start
+	case r.End != "":
+		rng = "Until " + r.End
+	}
+	if t, err := time.Parse(time.RFC3339, r.Stats.GeneratedAt); err == nil {
+		rng += " · generated " + t.UTC().Format("2006-01-02 15:04 UTC")
+	}
+	return rng
+}
+
+// HTML writes the dashboard page with the report's stats inlined, so it
+// renders without a server. chartJS is inlined when given; otherwise the
+// page loads Chart.js from the CDN and shows everything but the charts
+// when offline.
+func HTML(w io.Writer, r Report, chartJS []byte) error {
+	page, err := server.Asset("index.html")
+	if err != nil {
+		return err
+	}
+	icon, err := server.Asset("favicon.svg")
+	if err != nil {
+		return err
+	}
+	data, err := json.Marshal(struct {
+		Title    string              `json:"title"`
+		Subtitle string              `json:"subtitle"`
+		Stats    store.StatsResponse `json:"stats"`
+	}{r.Title, r.Subtitle(), r.Stats})
+	if err != nil {
+		return err
+	}
+
+	chartTag := []byte(`<script src="` + server.ChartJSURL + `"></script>`)
+	if !bytes.Contains(page, chartTag) {
+		return errors.New("report: Chart.js tag not found in index.html")
+	}
+	script := chartTag
+	if chartJS != nil {
+		// A literal "</script" inside the library would end the element.
+		js := bytes.ReplaceAll(chartJS, []byte("</script"), []byte(`<\/script`))
+		script = append(append([]byte("<script>"), js...), "</script>"...)
+	}
+	// json.Marshal escapes <, > and &, so the data cannot close the element.
+	script = append(script, "\n  <script>window.__CLAW_REPORT__ = "...)
+	script = append(script, data...)
+	script = append(script, ";</script>"...)
+	page = bytes.Replace(page, chartTag, script, 1)
+
+	page = bytes.Replace(page, []byte(`href="/favicon.svg"`),
+		[]byte(`href="data:image/svg+xml;base64,`+base64.StdEncoding.EncodeToString(icon)+`"`), 1)
+	page = bytes.Replace(page, []byte("<title>Claw Usage Chart</title>"),
+		[]byte("<title>"+htmlEscape(r.Title)+"</title>"), 1)
+
+	_, err = w.Write(page)
+	return err
+}
+
+// Markdown writes the report as GitHub-flavoured Markdown tables.
+func Markdown(w io.Writer, r Report) error {
+	var b strings.Builder
+	fmt.Fprintf(&b, "# %s\n\n_%s_\n", r.Title, r.Subtitle())
+	for _, t := range r.tables() {
+		fmt.Fprintf(&b, "\n## %s\n\n", t.title)
+		b.WriteString("| " + strings.Join(mdCells(t.head), " | ") + " |\n")
+		b.WriteString("|")
+		for i := range t.head {
+			if i == 0 {
+				b.WriteString(" --- |")
+			} else {
+				b.WriteString(" ---: |")
+			}
+		}
+		b.WriteString("\n")
+		for _, row := range t.rows {
+			b.WriteString("| " + strings.Join(mdCells(row), " | ") + " |\n")
+		}
+	}
+	_, err := io.WriteString(w, b.String())
+	return err
+}
+
+// Text writes the report as aligned plain text, for chat and terminals.
+func Text(w io.Writer, r Report) error {
+	var b strings.Builder
+	fmt.Fprintf(&b, "%s\n%s\n", r.Title, r.Subtitle())
+	for _, t := range r.tables() {
+		fmt.Fprintf(&b, "\n%s\n", strings.ToUpper(t.title))
+		widths := make([]int, len(t.head))
+		for _, row := range append([][]string{t.head}, t.rows...) {
+			for i, c := range row {
+				if n := len([]rune(c)); n > widths[i] {
+					widths[i] = n
+				}
+			}
+		}
+		for _, row := range append([][]string{t.head}, t.rows...) {
+			cells := make([]string, len(row))
+			for i, c := range row {
+				pad := strings.Repeat(" ", widths[i]-len([]rune(c)))
+				if i == 0 {
+					cells[i] = c + pad
+				} else {
+					cells[i] = pad + c
+				}
+			}
+			b.WriteString(strings.TrimRight(strings.Join(cells, "  "), " ") + "\n")
+		}
+	}
+	_, err := io.WriteString(w, b.String())
+	return err
+}
+
+// table is one section of the Markdown and text reports. The first column
+// is a label; the others are numbers and align right.
+type table struct {
+	title string
+	head  []string
+	rows  [][]string
+}
+
+func (r Report) tables() []table {
+	s := r.Stats
+	sum := s.Summary
+	tables := []table{{
+		title: "Summary",
+		head:  []string{"Metric", "Value"},
+		rows: [][]string{
+			{"Total tokens", count(sum.TotalTokens)},
+			{"Total cost", usd(sum.TotalCost)},
+			{"Usage records", count(sum.UsageRecords)},
+			{"Session files", count(sum.SessionFiles)},
+			{"Agents", count(sum.AgentCount)},
+			{"Models", count(sum.ModelCount)},
+			{"Days", count(sum.DayCount)},
+		},
+	}}
+
+	share := func(tokens int) string {
+		if sum.TotalTokens == 0 {
+			return "-"
+		}
+		return strconv.FormatFloat(float64(tokens)*100/float64(sum.TotalTokens), 'f', 1, 64) + "%"
+	}
+	totals := func(title, label string, n int, row func(i int) (string, int, float64, int)) {
+		t := table{title: title, head: []string{label, "Tokens", "Cost", "Records", "Share"}}
+		var restTokens, restRecords, rest int
+		var restCost float64
+		for i := 0; i < n; i++ {
+			name, tokens, cost, records := row(i)
+			if i >= maxRows {
+				rest++
+				restTokens += tokens
+				restCost += cost
+				restRecords += records
+				continue
+			}
+			t.rows = append(t.rows, []string{name, count(tokens), usd(cost), count(records), share(tokens)})
+		}
+		if rest > 0 {
+			t.rows = append(t.rows, []string{fmt.Sprintf("(%d more)", rest), count(restTokens), usd(restCost), count(restRecords), share(restTokens)})
+		}
+		if len(t.rows) > 0 {
+			tables = append(tables, t)
+		}
+	}
+	totals("By agent", "Agent", len(s.AgentTotals), func(i int) (string, int, float64, int) {
+		a := s.AgentTotals[i]
+		return a.Agent, a.Tokens, a.Cost, a.Records
+	})
+	totals("By model", "Model", len(s.ModelTotals), func(i int) (string, int, float64, int) {
+		m := s.ModelTotals[i]
+		return m.Model, m.Tokens, m.Cost, m.Records
+	})
+	totals("By project", "Project", len(s.ProjectTotals), func(i int) (string, int, float64, int) {
+		p := s.ProjectTotals[i]
+		return p.Project, p.Tokens, p.Cost, p.Records
+	})
+	totals("By provider", "Provider", len(s.ProviderTotals), func(i int) (string, int, float64, int) {
+		p := s.ProviderTotals[i]
+		return p.Provider, p.Tokens, p.Cost, p.Records
+	})
+	totals("By tag", "Tag", len(s.TagTotals), func(i int) (string, int, float64, int) {
+		t := s.TagTotals[i]
+		return t.Tag, t.Tokens, t.Cost, t.Records
+	})
+
+	if len(s.ToolTotals) > 0 {
+		t := table{title: "By tool", head: []string{"Tool", "Calls", "Turns", "Tokens", "Cost"}}
+		for i, tt := range s.ToolTotals {
+			if i == maxRows {
+				t.rows = append(t.rows, []string{fmt.Sprintf("(%d more)", len(s.ToolTotals)-maxRows), "", "", "", ""})
+				break
+			}
+			t.rows = append(t.rows, []string{tt.Tool, count(tt.Calls), count(tt.Turns), count(tt.Tokens), usd(tt.Cost)})
+		}
+		tables = append(tables, t)
+	}
+
+	if len(s.DailyTokens) > 0 {
+		t := table{title: "Daily", head: []string{"Date", "Tokens", "Cost", "Records"}}
+		for _, d := range s.DailyTokens {
+			t.rows = append(t.rows, []string{d.Date, count(d.Tokens), usd(d.Cost), count(d.Records)})
+		}
+		tables = append(tables, t)
+	}
+	return tables
+}
+
+// count formats n with thousands separators.
+func count(n int) string {
+	s := strconv.Itoa(n)
+	neg := strings.HasPrefix(s, "-")
+	s = strings.TrimPrefix(s, "-")
+	for i := len(s) - 3; i > 0; i -= 3 {
+		s = s[:i] + "," + s[i:]
+	}
+	if neg {
+		s = "-" + s
+	}
+	return s
+}
+
+func usd(v float64) string {
+	return "$" + strconv.FormatFloat(v, 'f', 2, 64)
+}
+
+func mdCells(cells []string) []string {
+	out := make([]string, len(cells))
+	for i, c := range cells {
+		out[i] = strings.ReplaceAll(c, "|", `\|`)
+	}
+	return out
+}
+
+func htmlEscape(s string) string {
+	return strings.NewReplacer("&", "&amp;", "<", "&lt;", ">", "&gt;", `"`, "&quot;").Replace(s)
+}
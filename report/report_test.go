@@ -0,0 +1,91 @@
+package report
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+
+	"github.com/yeremiel/claw-usage-chart/server"
+	"github.com/yeremiel/claw-usage-chart/store"
+)
+
+func sampleReport() Report {
+	return Report{
+		Title: "Usage — 2026-09",
+		Start: "2026-09-01",
+		End:   "2026-09-30",
+		Stats: store.StatsResponse{
+			GeneratedAt: "2026-10-01T09:00:00Z",
+			Summary:     store.Summary{TotalTokens: 1500000, TotalCost: 12.5, UsageRecords: 30, AgentCount: 2, ModelCount: 1, DayCount: 3},
+			AgentTotals: []store.AgentTotal{
+				{Agent: "main", Tokens: 1000000, Cost: 10, Records: 20},
+				{Agent: "a|b", Tokens: 500000, Cost: 2.5, Records: 10},
+			},
+			ModelTotals: []store.ModelTotal{{Model: "m1", Tokens: 1500000, Cost: 12.5, Records: 30}},
+			DailyTokens: []store.DailyTokens{{Date: "2026-09-01", Tokens: 1500000, Cost: 12.5, Records: 30}},
+		},
+	}
+}
+
+func TestHTMLInlinesStats(t *testing.T) {
+	var buf bytes.Buffer
+	if err := HTML(&buf, sampleReport(), []byte("window.Chart = {}; // </script>")); err != nil {
+		t.Fatalf("html: %v", err)
+	}
+	page := buf.String()
+	for _, want := range []string{
+		"window.__CLAW_REPORT__ = {",
+		`"total_tokens":1500000`,
+		"2026-09-01 – 2026-09-30 · generated 2026-10-01 09:00 UTC",
+		`<\/script>`,
+		"<title>Usage — 2026-09</title>",
+		`href="data:image/svg+xml;base64,`,
+	} {
+		if !strings.Contains(page, want) {
+			t.Errorf("page lacks %q", want)
+		}
+	}
+	if strings.Contains(page, server.ChartJSURL) {
+		t.Error("page still links Chart.js although it was inlined")
+	}
+
+	buf.Reset()
+	if err := HTML(&buf, sampleReport(), nil); err != nil {
+		t.Fatalf("html without Chart.js: %v", err)
+	}
+	if !strings.Contains(buf.String(), server.ChartJSURL) {
+		t.Error("page without an inlined Chart.js should link the CDN")
+	}
+}
+
+func TestMarkdownAndText(t *testing.T) {
+	var md bytes.Buffer
+	if err := Markdown(&md, sampleReport()); err != nil {
+		t.Fatalf("markdown: %v", err)
+	}
+	for _, want := range []string{
+		"# Usage — 2026-09\n",
+		"| Agent | Tokens | Cost | Records | Share |\n| --- | ---: | ---: | ---: | ---: |\n",
+		"| main | 1,000,000 | $10.00 | 20 | 66.7% |\n",
+		`| a\|b | 500,000 | $2.50 | 10 | 33.3% |`,
+	} {
+		if !strings.Contains(md.String(), want) {
+			t.Errorf("markdown lacks %q:\n%s", want, md.String())
+		}
+	}
+	if strings.Contains(md.String(), "By project") {
+		t.Error("empty breakdowns should be left out")
+	}
+
+	var txt bytes.Buffer
+	if err := Text(&txt, sampleReport()); err != nil {
+		t.Fatalf("text: %v", err)
+	}
+	want := "BY AGENT\n" +
+		"Agent     Tokens    Cost  Records  Share\n" +
+		"main   1,000,000  $10.00       20  66.7%\n" +
+		"a|b      500,000   $2.50       10  33.3%\n"
+	if !strings.Contains(txt.String(), want) {
+		t.Errorf("text lacks\n%s\ngot:\n%s", want, txt.String())
+	}
+}
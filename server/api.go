@@ -0,0 +1,45 @@
+package server
+
+import (
+	_ "embed"
+	"net/http"
+
+	"github.com/yeremiel/claw-usage-chart/api"
+)
+
+// APIPrefix is the versioned root of the JSON API. The unversioned /api
+// paths stay as aliases of v1 for existing dashboards and scripts; a
+// breaking change gets a new prefix instead.
+const APIPrefix = api.Prefix
+
+// openAPISpec describes every route in apiRoutes. server_test.go keeps the
+// two, and the schemas and the Go types, in step.
+//
+//go:embed openapi.json
+var openAPISpec []byte
+
+// OpenAPISpec returns the OpenAPI 3 document served at /api/openapi.json.
+func OpenAPISpec() []byte { return openAPISpec }
+
+// apiRoutes maps API paths, relative to APIPrefix, to their handlers. A
+// trailing slash registers a subtree as in http.ServeMux.
+func (s *Server) apiRoutes() map[string]http.HandlerFunc {
+	return map[string]http.HandlerFunc{
+		"/stats":        s.statsHandler,
+		"/records":      s.recordsHandler,
+		"/aggregate":    s.aggregateHandler,
+		"/anomalies":    s.anomaliesHandler,
+		"/chart/":       s.chartHandler,
+		"/query":        s.queryHandler,
+		"/push":         s.pushHandler,
+		"/openapi.json": openAPIHandler,
+	}
+}
+
+func openAPIHandler(w http.ResponseWriter, r *http.Request) {
+	if checkETag(w, r, contentETag(openAPISpec)) {
+		return
+	}
+	w.Header().Set("Content-Type", "application/json; charset=utf-8")
+	w.Write(openAPISpec)
+}
@@ -0,0 +1,319 @@
+package server
+
+import (
+	"bytes"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"reflect"
+	"sort"
+	"strings"
+	"testing"
+
+	"github.com/yeremiel/claw-usage-chart/chart"
+	"github.com/yeremiel/claw-usage-chart/push"
+	"github.com/yeremiel/claw-usage-chart/store"
+)
+
+// specSchemaTypes are the Go types behind the spec's component schemas.
+var specSchemaTypes = map[string]reflect.Type{
+	"AgentTotal":        reflect.TypeOf(store.AgentTotal{}),
+	"AggregateResponse": reflect.TypeOf(store.AggregateResponse{}),
+	"Anomaly":           reflect.TypeOf(store.Anomaly{}),
+	"AnomalyResponse":   reflect.TypeOf(AnomalyResponse{}),
+	"Batch":             reflect.TypeOf(push.Batch{}),
+	"Conflict":          reflect.TypeOf(store.Conflict{}),
+	"DailyTokens":       reflect.TypeOf(store.DailyTokens{}),
+	"HeatmapCell":       reflect.TypeOf(store.HeatmapCell{}),
+	"IngestResult":      reflect.TypeOf(store.IngestResult{}),
+	"ModelTotal":        reflect.TypeOf(store.ModelTotal{}),
+	"PortableRecord":    reflect.TypeOf(store.PortableRecord{}),
+	"ProjectTotal":      reflect.TypeOf(store.ProjectTotal{}),
+	"ProviderTotal":     reflect.TypeOf(store.ProviderTotal{}),
+	"QueryResult":       reflect.TypeOf(store.QueryResult{}),
+	"RecordRow":         reflect.TypeOf(store.RecordRow{}),
+	"RecordsPage":       reflect.TypeOf(store.RecordsPage{}),
+	"StatsResponse":     reflect.TypeOf(store.StatsResponse{}),
+	"Summary":           reflect.TypeOf(store.Summary{}),
+	"SyncResult":        reflect.TypeOf(store.SyncResult{}),
+	"TagTotal":          reflect.TypeOf(store.TagTotal{}),
+	"ToolTotal":         reflect.TypeOf(store.ToolTotal{}),
+}
+
+type openAPIDoc struct {
+	Servers []struct {
+		URL string `json:"url"`
+	} `json:"servers"`
+	Paths      map[string]map[string]json.RawMessage `json:"paths"`
+	Components struct {
+		Schemas    map[string]any `json:"schemas"`
+		Parameters map[string]struct {
+			Name string `json:"name"`
+			In   string `json:"in"`
+		} `json:"parameters"`
+	} `json:"components"`
+}
+
+func loadSpec(t *testing.T) openAPIDoc {
+	t.Helper()
+	var doc openAPIDoc
+	if err := json.Unmarshal(OpenAPISpec(), &doc); err != nil {
+		t.Fatalf("openapi.json: %v", err)
+	}
+	return doc
+}
+
+// TestOpenAPIPathsMatchRoutes fails when a route is added or removed
+// without updating openapi.json.
+func TestOpenAPIPathsMatchRoutes(t *testing.T) {
+	doc := loadSpec(t)
+	if len(doc.Servers) != 1 || doc.Servers[0].URL != APIPrefix {
+		t.Fatalf("servers = %+v, want %s", doc.Servers, APIPrefix)
+	}
+	var documented, routed []string
+	for p := range doc.Paths {
+		// Path templates map onto the ServeMux subtree they live in.
+		if i := strings.Index(p, "{"); i >= 0 {
+			p = p[:i]
+		}
+		documented = append(documented, p)
+	}
+	for p := range New(nil, "", Options{}).apiRoutes() {
+		routed = append(routed, p)
+	}
+	sort.Strings(documented)
+	sort.Strings(routed)
+	if !reflect.DeepEqual(documented, routed) {
+		t.Fatalf("documented paths %v, routes %v", documented, routed)
+	}
+
+	var kinds []string
+	for k := range chart.Kinds {
+		kinds = append(kinds, k)
+	}
+	sort.Strings(kinds)
+	var chartOp struct {
+		Parameters []struct {
+			Name   string `json:"name"`
+			Schema struct {
+				Enum []string `json:"enum"`
+			} `json:"schema"`
+		} `json:"parameters"`
+	}
+	json.Unmarshal(doc.Paths["/chart/{kind}.{format}"]["get"], &chartOp)
+	if len(chartOp.Parameters) == 0 || chartOp.Parameters[0].Name != "kind" ||
+		!reflect.DeepEqual(chartOp.Parameters[0].Schema.Enum, kinds) {
+		t.Fatalf("chart kinds in spec do not match chart.Kinds %v", kinds)
+	}
+}
+
+// TestOpenAPISchemasMatchTypes rebuilds every component schema from the
+// json tags of its Go type and compares it with the document.
+func TestOpenAPISchemasMatchTypes(t *testing.T) {
+	doc := loadSpec(t)
+	for name, schema := range doc.Components.Schemas {
+		if name == "Error" {
+			continue
+		}
+		typ, ok := specSchemaTypes[name]
+		if !ok {
+			t.Errorf("schema %s has no Go type", name)
+			continue
+		}
+		want := schemaOf(typ, true)
+		got, _ := json.Marshal(schema)
+		wantJSON, _ := json.Marshal(want)
+		if !bytes.Equal(got, wantJSON) {
+			t.Errorf("schema %s:\n got %s\nwant %s", name, got, wantJSON)
+		}
+	}
+	for name := range specSchemaTypes {
+		if _, ok := doc.Components.Schemas[name]; !ok {
+			t.Errorf("schema %s missing from openapi.json", name)
+		}
+	}
+
+	// Every $ref must resolve.
+	var refs []string
+	var walk func(v any)
+	walk = func(v any) {
+		switch v := v.(type) {
+		case map[string]any:
+			for k, x := range v {
+				if s, ok := x.(string); ok && k == "$ref" {
+					refs = append(refs, s)
+				}
+				walk(x)
+			}
+		case []any:
+			for _, x := range v {
+				walk(x)
+			}
+		}
+	}
+	var raw map[string]any
+	json.Unmarshal(OpenAPISpec(), &raw)
+	walk(raw)
+	for _, ref := range refs {
+		parts := strings.Split(strings.TrimPrefix(ref, "#/"), "/")
+		var node any = raw
+		for _, p := range parts {
+			m, _ := node.(map[string]any)
+			node = m[p]
+		}
+		if node == nil {
+			t.Errorf("unresolved $ref %s", ref)
+		}
+	}
+}
+
+// schemaOf mirrors encoding/json: fields without omitempty are always
+// present and so required, pointers may be null.
+func schemaOf(t reflect.Type, top bool) map[string]any {
+	if t.Kind() == reflect.Pointer {
+		s := schemaOf(t.Elem(), top)
+		s["nullable"] = true
+		return s
+	}
+	if !top && t.Kind() == reflect.Struct {
+		for name, typ := range specSchemaTypes {
+			if typ == t {
+				return map[string]any{"$ref": "#/components/schemas/" + name}
+			}
+		}
+	}
+	switch t.Kind() {
+	case reflect.String:
+		return map[string]any{"type": "string"}
+	case reflect.Bool:
+		return map[string]any{"type": "boolean"}
+	case reflect.Int64:
+		return map[string]any{"type": "integer", "format": "int64"}
+	case reflect.Int, reflect.Int32:
+		return map[string]any{"type": "integer"}
+	case reflect.Float64:
+		return map[string]any{"type": "number"}
+	case reflect.Interface:
+		return map[string]any{}
+	case reflect.Slice:
+		return map[string]any{"type": "array", "items": schemaOf(t.Elem(), false)}
+	case reflect.Map:
+		return map[string]any{"type": "object", "additionalProperties": schemaOf(t.Elem(), false)}
+	case reflect.Struct:
+		props := map[string]any{}
+		var required []any
+		for i := 0; i < t.NumField(); i++ {
+			f := t.Field(i)
+			tag := f.Tag.Get("json")
+			name, opts, _ := strings.Cut(tag, ",")
+			if !f.IsExported() || name == "-" {
+				continue
+			}
+			if name == "" {
+				name = f.Name
+			}
+			props[name] = schemaOf(f.Type, false)
+			if !strings.Contains(opts, "omitempty") {
+				required = append(required, name)
+			}
+		}
+		s := map[string]any{"type": "object", "properties": props}
+		if len(required) > 0 {
+			s["required"] = required
+		}
+		return s
+	}
+	panic("no schema for " + t.String())
+}
+
+// TestOpenAPIFilterParameters checks that the documented filter parameters
+// are exactly the ones ParseUsageFilter reads, and that EncodeUsageFilter
+// writes them back.
+func TestOpenAPIFilterParameters(t *testing.T) {
+	doc := loadSpec(t)
+	filterType := reflect.TypeOf(store.UsageFilter{})
+	covered := map[string]string{}
+	for _, p := range doc.Components.Parameters {
+		if p.In != "query" {
+			continue
+		}
+		f := ParseUsageFilter(map[string][]string{p.Name: {"x"}})
+		v := reflect.ValueOf(f)
+		var set []string
+		for i := 0; i < v.NumField(); i++ {
+			if !v.Field(i).IsZero() {
+				set = append(set, filterType.Field(i).Name)
+			}
+		}
+		if len(set) != 1 {
+			t.Errorf("parameter %s sets fields %v, want exactly one", p.Name, set)
+			continue
+		}
+		covered[set[0]] = p.Name
+		if got := EncodeUsageFilter(f).Encode(); got != p.Name+"=x" {
+			t.Errorf("EncodeUsageFilter for %s = %q", p.Name, got)
+		}
+	}
+	for i := 0; i < filterType.NumField(); i++ {
+		if name := filterType.Field(i).Name; covered[name] == "" {
+			t.Errorf("UsageFilter.%s has no documented parameter", name)
+		}
+	}
+}
+
+func TestVersionedAPIAndSpecEndpoint(t *testing.T) {
+	tmp := t.TempDir()
+	agentsDir := filepath.Join(tmp, "agents")
+	sessionDir := filepath.Join(agentsDir, "alpha", "sessions")
+	if err := os.MkdirAll(sessionDir, 0o755); err != nil {
+		t.Fatalf("mkdir: %v", err)
+	}
+	line := `{"timestamp":"2026-02-17T10:00:00Z","model":"m1","usage":{"input_tokens":42}}` + "\n"
+	if err := os.WriteFile(filepath.Join(sessionDir, "s.jsonl"), []byte(line), 0o644); err != nil {
+		t.Fatalf("write: %v", err)
+	}
+	st, err := store.Open(filepath.Join(tmp, "usage_cache.db"), store.Options{})
+	if err != nil {
+		t.Fatalf("open: %v", err)
+	}
+	defer st.Close()
+	srv := httptest.NewServer(New(st, agentsDir, Options{}).Handler())
+	defer srv.Close()
+
+	for _, path := range []string{"/api/openapi.json", APIPrefix + "/openapi.json"} {
+		resp, err := http.Get(srv.URL + path)
+		if err != nil {
+			t.Fatalf("get %s: %v", path, err)
+		}
+		body, _ := io.ReadAll(resp.Body)
+		resp.Body.Close()
+		if resp.StatusCode != http.StatusOK || !bytes.Equal(body, OpenAPISpec()) ||
+			!strings.HasPrefix(resp.Header.Get("Content-Type"), "application/json") {
+			t.Fatalf("%s: %d %s", path, resp.StatusCode, resp.Header.Get("Content-Type"))
+		}
+	}
+
+	for _, path := range []string{"/api/stats", APIPrefix + "/stats"} {
+		resp, err := http.Get(srv.URL + path)
+		if err != nil {
+			t.Fatalf("get %s: %v", path, err)
+		}
+		var stats store.StatsResponse
+		err = json.NewDecoder(resp.Body).Decode(&stats)
+		resp.Body.Close()
+		if err != nil || resp.StatusCode != http.StatusOK || stats.Summary.TotalTokens != 42 {
+			t.Fatalf("%s: %d %v %+v", path, resp.StatusCode, err, stats.Summary)
+		}
+	}
+	resp, err := http.Get(srv.URL + APIPrefix + "/chart/daily.svg")
+	if err != nil {
+		t.Fatalf("get chart: %v", err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		t.Fatalf("v1 chart: %d", resp.StatusCode)
+	}
+}
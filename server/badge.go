@@ -0,0 +1,227 @@
+package server
+
+import (
+	"bytes"
+	"net/http"
+	"net/url"
+	"path"
+	"strconv"
+	"time"
+
+	"github.com/yeremiel/claw-usage-chart/chart"
+	"github.com/yeremiel/claw-usage-chart/store"
+)
+
+// defaultBadgeMaxAge is how long badge caches (GitHub's image proxy, wiki
+// renderers) may keep a badge unless max_age says otherwise.
+const defaultBadgeMaxAge = 300
+
+// defaultBadgeThresholds colour limit= badges the way budgets default.
+var defaultBadgeThresholds = []float64{0.5, 0.8, 1.0}
+
+// badgeHandler serves /badge/cost, /badge/tokens and /badge/budget.
+// Problems are drawn as a grey badge, so an embedding page shows them.
+func (s *Server) badgeHandler(w http.ResponseWriter, r *http.Request) {
+	q := r.URL.Query()
+	maxAge := defaultBadgeMaxAge
+	if v := q.Get("max_age"); v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil || n < 0 || n > 86400 {
+			writeBadge(w, http.StatusBadRequest, 0, "badge", "invalid max_age", chart.BadgeGrey)
+			return
+		}
+		maxAge = n
+	}
+
+	var label, message, color string
+	status := http.StatusOK
+	switch kind := path.Base(r.URL.Path); kind {
+	case "cost", "tokens":
+		label, message, color, status = s.totalBadge(kind, q, time.Now())
+	case "budget":
+		label, message, color, status = s.budgetBadge(q, time.Now())
+	default:
+		writeBadge(w, http.StatusNotFound, maxAge, "badge", "not found", chart.BadgeGrey)
+		return
+	}
+	if l := q.Get("label"); l != "" {
+		label = l
+	}
+	writeBadge(w, status, maxAge, label, message, color)
+}
+
+// totalBadge sums cost or tokens over the filters and period. limit=, or the
+// limit of the budget named by budget= (cost only), colours it by how much
+// of the limit is used. A budget's limit only means something over its own
+// window, so budget= defaults the period, agent and model to the budget's
+// and rejects others.
+func (s *Server) totalBadge(kind string, q url.Values, now time.Time) (label, message, color string, status int) {
+	label = "AI spend"
+	if kind == "tokens" {
+		label = "AI tokens"
+	}
+	filter := ParseUsageFilter(q)
+	period := q.Get("period")
+
+	var limit float64
+	thresholds := defaultBadgeThresholds
+	if name := q.Get("budget"); name != "" {
+		if kind != "cost" {
+			return label, "budget needs cost", chart.BadgeGrey, http.StatusBadRequest
+		}
+		b, ok := s.findBudget(name)
+		if !ok {
+			return label, "unknown budget", chart.BadgeGrey, http.StatusNotFound
+		}
+		bp := b.Period
+		if bp == "" {
+			bp = "month"
+		}
+		switch {
+		case filter.Start != "" || filter.End != "":
+			return label, "budget with start/end", chart.BadgeGrey, http.StatusBadRequest
+		case period != "" && period != bp:
+			return label, "budget is per " + bp, chart.BadgeGrey, http.StatusBadRequest
+		case b.Agent != "" && filter.Agent != "" && filter.Agent != b.Agent,
+			b.Model != "" && filter.Model != "" && filter.Model != b.Model:
+			return label, "filter differs from budget", chart.BadgeGrey, http.StatusBadRequest
+		}
+		period = bp
+		if b.Agent != "" {
+			filter.Agent = b.Agent
+		}
+		if b.Model != "" {
+			filter.Model = b.Model
+		}
+		limit, thresholds = b.LimitUSD, b.Thresholds
+	} else if v := q.Get("limit"); v != "" {
+		f, err := strconv.ParseFloat(v, 64)
+		if err != nil || f <= 0 {
+			return label, "invalid limit", chart.BadgeGrey, http.StatusBadRequest
+		}
+		limit = f
+	}
+
+	if filter.Start == "" && filter.End == "" {
+		var suffix string
+		var ok bool
+		filter.Start, filter.End, suffix, ok = badgePeriod(period, now)
+		if !ok {
+			return label, "invalid period", chart.BadgeGrey, http.StatusBadRequest
+		}
+		if suffix != "" {
+			label += " " + suffix
+		}
+	} else if period != "" {
+		return label, "period with start/end", chart.BadgeGrey, http.StatusBadRequest
+	}
+
+	stats, err := s.CollectStats(filter)
+	if err != nil {
+		return label, "error", chart.BadgeGrey, http.StatusInternalServerError
+	}
+	value := float64(stats.Summary.TotalTokens)
+	message = chart.FormatTokens(value)
+	if kind == "cost" {
+		value = stats.Summary.TotalCost
+		message = chart.FormatCost(value)
+	}
+	color = chart.BadgeBlue
+	if limit > 0 {
+		color = thresholdColor(value/limit, thresholds)
+	}
+	return label, message, color, http.StatusOK
+}
+
+// budgetBadge shows a configured budget's spend in its current period.
+// name= may be left out when only one budget is configured.
+func (s *Server) budgetBadge(q url.Values, now time.Time) (label, message, color string, status int) {
+	label = "budget"
+	name := q.Get("name")
+	if budgets := s.options().Budgets; name == "" && len(budgets) == 1 {
+		name = budgets[0].Name
+	}
+	if name == "" {
+		return label, "name required", chart.BadgeGrey, http.StatusBadRequest
+	}
+	b, ok := s.findBudget(name)
+	if !ok {
+		return label, "unknown budget", chart.BadgeGrey, http.StatusNotFound
+	}
+	label = b.Name
+	if _, err := s.store.Sync(s.agentsDir); err != nil {
+		return label, "error", chart.BadgeGrey, http.StatusInternalServerError
+	}
+	st, err := s.store.BudgetStatus(b, now)
+	if err != nil {
+		return label, "error", chart.BadgeGrey, http.StatusInternalServerError
+	}
+	message = chart.FormatCost(st.SpentUSD) + " / " + chart.FormatCost(st.LimitUSD)
+	return label, message, thresholdColor(st.Ratio, b.Thresholds), http.StatusOK
+}
+
+func (s *Server) findBudget(name string) (store.Budget, bool) {
+	for _, b := range s.options().Budgets {
+		if b.Name == name {
+			return b, true
+		}
+	}
+	return store.Budget{}, false
+}
+
+// badgePeriod turns period= into an inclusive date range and a label
+// suffix. The default is the current month.
+func badgePeriod(period string, now time.Time) (start, end, suffix string, ok bool) {
+	now = now.Local()
+	today := now.Format("2006-01-02")
+	switch period {
+	case "", "month":
+		first := time.Date(now.Year(), now.Month(), 1, 0, 0, 0, 0, now.Location())
+		return first.Format("2006-01-02"), first.AddDate(0, 1, -1).Format("2006-01-02"), "this month", true
+	case "day":
+		return today, today, "today", true
+	case "7d":
+		return now.AddDate(0, 0, -6).Format("2006-01-02"), today, "last 7 days", true
+	case "30d":
+		return now.AddDate(0, 0, -29).Format("2006-01-02"), today, "last 30 days", true
+	case "all":
+		return "", "", "", true
+	}
+	return "", "", "", false
+}
+
+// thresholdColor is green below the first threshold, red from the last (or
+// once the limit is reached), and yellow then orange in between.
+func thresholdColor(ratio float64, thresholds []float64) string {
+	crossed := 0
+	for _, th := range thresholds {
+		if ratio >= th {
+			crossed++
+		}
+	}
+	switch {
+	case ratio >= 1 || (len(thresholds) > 0 && crossed == len(thresholds)):
+		return chart.BadgeRed
+	case crossed == 0:
+		return chart.BadgeGreen
+	case crossed == 1:
+		return chart.BadgeYellow
+	default:
+		return chart.BadgeOrange
+	}
+}
+
+// writeBadge sends the badge. Error badges are not cached, so a fixed
+// config shows up on the next load.
+func writeBadge(w http.ResponseWriter, status, maxAge int, label, message, color string) {
+	var buf bytes.Buffer
+	chart.Badge(&buf, label, message, color)
+	w.Header().Set("Content-Type", "image/svg+xml")
+	if maxAge == 0 || status != http.StatusOK {
+		w.Header().Set("Cache-Control", "no-cache")
+	} else {
+		w.Header().Set("Cache-Control", "public, max-age="+strconv.Itoa(maxAge))
+	}
+	w.WriteHeader(status)
+	w.Write(buf.Bytes())
+}
@@ -0,0 +1,60 @@
+package server
+
+import (
+	"bytes"
+	"net/http"
+	"path"
+	"strconv"
+	"strings"
+
+	"github.com/yeremiel/claw-usage-chart/chart"
+)
+
+// chartHandler serves /api/chart/<kind>.<svg|png> for the same filters as
+// /api/stats, plus width, height, metric and theme.
+func (s *Server) chartHandler(w http.ResponseWriter, r *http.Request) {
+	name := path.Base(r.URL.Path)
+	kind, format, _ := strings.Cut(name, ".")
+	if _, ok := chart.Kinds[kind]; !ok || (format != "svg" && format != "png") {
+		http.NotFound(w, r)
+		return
+	}
+	q := r.URL.Query()
+	opts := chart.Options{Metric: q.Get("metric"), Theme: q.Get("theme")}
+	for _, p := range []struct {
+		name string
+		dst  *int
+	}{{"width", &opts.Width}, {"height", &opts.Height}} {
+		if v := q.Get(p.name); v != "" {
+			n, err := strconv.Atoi(v)
+			if err != nil {
+				writeJSON(w, http.StatusBadRequest, map[string]string{"error": "invalid " + p.name})
+				return
+			}
+			*p.dst = n
+		}
+	}
+	if err := opts.Validate(); err != nil {
+		writeJSON(w, http.StatusBadRequest, map[string]string{"error": err.Error()})
+		return
+	}
+
+	stats, err := s.CollectStats(ParseUsageFilter(q))
+	if err != nil {
+		writeJSON(w, http.StatusInternalServerError, map[string]string{"error": err.Error()})
+		return
+	}
+	var buf bytes.Buffer
+	if err := chart.Render(&buf, kind, format, stats, opts); err != nil {
+		writeJSON(w, http.StatusInternalServerError, map[string]string{"error": err.Error()})
+		return
+	}
+	if format == "svg" {
+		w.Header().Set("Content-Type", "image/svg+xml")
+	} else {
+		w.Header().Set("Content-Type", "image/png")
+	}
+	// Short enough for wiki and chat caches to pick up new usage.
+	w.Header().Set("Cache-Control", "public, max-age=60")
+	w.Write(buf.Bytes())
+}
@@ -0,0 +1,113 @@
+package server
+
+import (
+	"compress/gzip"
+	"crypto/subtle"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+
+	"github.com/yeremiel/claw-usage-chart/push"
+	"github.com/yeremiel/claw-usage-chart/store"
+)
+
+// maxPushBody caps a decompressed push request.
+const maxPushBody = 64 << 20
+
+// CollectorConfig lists who may push records to this server.
+type CollectorConfig struct {
+	Tokens []PushToken `json:"tokens"`
+}
+
+// PushToken authenticates one pusher; its records are attributed to User
+// and may only be labelled with one of Hosts.
+type PushToken struct {
+	User  string   `json:"user"`
+	Token string   `json:"token"`
+	Hosts []string `json:"hosts"`
+}
+
+// allowsHost reports whether the token may push records for host.
+func (t PushToken) allowsHost(host string) bool {
+	for _, h := range t.Hosts {
+		if h == host {
+			return true
+		}
+	}
+	return false
+}
+
+// pushToken returns the configured token matching the request's bearer
+// token.
+func (s *Server) pushToken(r *http.Request) (PushToken, bool) {
+	token, ok := strings.CutPrefix(r.Header.Get("Authorization"), "Bearer ")
+	if !ok || token == "" {
+		return PushToken{}, false
+	}
+	for _, t := range s.options().PushTokens {
+		if subtle.ConstantTimeCompare([]byte(token), []byte(t.Token)) == 1 {
+			return t, true
+		}
+	}
+	return PushToken{}, false
+}
+
+// pushHandler is the collector end of push.Pusher. It only exists when
+// tokens are configured.
+func (s *Server) pushHandler(w http.ResponseWriter, r *http.Request) {
+	if len(s.options().PushTokens) == 0 {
+		http.NotFound(w, r)
+		return
+	}
+	if r.Method != http.MethodPost {
+		w.Header().Set("Allow", http.MethodPost)
+		writeJSON(w, http.StatusMethodNotAllowed, map[string]string{"error": "POST only"})
+		return
+	}
+	tok, ok := s.pushToken(r)
+	if !ok {
+		writeJSON(w, http.StatusUnauthorized, map[string]string{"error": "invalid token"})
+		return
+	}
+
+	var body io.Reader = r.Body
+	switch r.Header.Get("Content-Encoding") {
+	case "":
+	case "gzip":
+		zr, err := gzip.NewReader(r.Body)
+		if err != nil {
+			writeJSON(w, http.StatusBadRequest, map[string]string{"error": "bad gzip body"})
+			return
+		}
+		defer zr.Close()
+		body = zr
+	default:
+		writeJSON(w, http.StatusUnsupportedMediaType, map[string]string{"error": "unsupported content encoding"})
+		return
+	}
+
+	var batch push.Batch
+	if err := json.NewDecoder(io.LimitReader(body, maxPushBody)).Decode(&batch); err != nil {
+		writeJSON(w, http.StatusBadRequest, map[string]string{"error": "invalid body: " + err.Error()})
+		return
+	}
+	if !tok.allowsHost(batch.Host) {
+		writeJSON(w, http.StatusForbidden, map[string]string{"error": fmt.Sprintf("token may not push host %q", batch.Host)})
+		return
+	}
+	// 400 means the batch itself is bad and resending it cannot help; a
+	// failing cache is the collector's fault and the pusher retries.
+	res, err := s.store.Ingest(batch.Host, tok.User, batch.Records)
+	if errors.Is(err, store.ErrInvalidRecord) {
+		writeJSON(w, http.StatusBadRequest, map[string]string{"error": err.Error()})
+		return
+	}
+	if err != nil {
+		writeInternalError(w, r, "ingest failed", err)
+		return
+	}
+	writeJSON(w, http.StatusOK, res)
+}
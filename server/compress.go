@@ -0,0 +1,203 @@
+package server
+
+import (
+	"compress/gzip"
+	"io"
+	"mime"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/andybalholm/brotli"
+)
+
+// compressMinSize is the smallest body worth compressing; shorter ones
+// (health checks, 304s, small errors) go out as they are.
+const compressMinSize = 512
+
+var (
+	gzipPool = sync.Pool{New: func() any {
+		w, _ := gzip.NewWriterLevel(io.Discard, gzip.DefaultCompression)
+		return w
+	}}
+	brotliPool = sync.Pool{New: func() any {
+		return brotli.NewWriterLevel(io.Discard, 5)
+	}}
+)
+
+// compressMiddleware compresses text responses (JSON, HTML, SVG, CSV, …)
+// with brotli or gzip, whichever the client prefers, br winning a tie.
+// Images that are already compressed, such as PNG charts, are left alone.
+func compressMiddleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Add("Vary", "Accept-Encoding")
+		enc := negotiateEncoding(r.Header.Get("Accept-Encoding"))
+		if enc == "" || r.Method == http.MethodHead {
+			next.ServeHTTP(w, r)
+			return
+		}
+		cw := &compressWriter{ResponseWriter: w, encoding: enc}
+		defer cw.Close()
+		next.ServeHTTP(cw, r)
+	})
+}
+
+// negotiateEncoding picks "br", "gzip" or "" from an Accept-Encoding header.
+func negotiateEncoding(header string) string {
+	best, bestQ := "", 0.0
+	for _, part := range strings.Split(header, ",") {
+		name, params, _ := strings.Cut(strings.TrimSpace(part), ";")
+		name = strings.ToLower(strings.TrimSpace(name))
+		if name != "br" && name != "gzip" {
+			continue
+		}
+		q := 1.0
+		if v, ok := strings.CutPrefix(strings.TrimSpace(params), "q="); ok {
+			f, err := strconv.ParseFloat(v, 64)
+			if err != nil {
+				continue
+			}
+			q = f
+		}
+		if q <= 0 {
+			continue // q=0 means "not acceptable"
+		}
+		if q > bestQ || (q == bestQ && name == "br") {
+			best, bestQ = name, q
+		}
+	}
+	return best
+}
+
+// compressible reports whether a Content-Type is worth compressing.
+func compressible(contentType string) bool {
+	mt, _, err := mime.ParseMediaType(contentType)
+	if err != nil {
+		return false
+	}
+	return strings.HasPrefix(mt, "text/") || mt == "application/json" ||
+		mt == "application/javascript" || mt == "image/svg+xml"
+}
+
+// compressWriter holds back the first compressMinSize bytes to decide
+// whether to compress, then streams through the encoder.
+type compressWriter struct {
+	http.ResponseWriter
+	encoding string
+
+	status  int
+	buf     []byte
+	decided bool
+	enc     io.WriteCloser // nil when the body goes out uncompressed
+}
+
+func (c *compressWriter) WriteHeader(code int) {
+	if c.status != 0 {
+		return
+	}
+	c.status = code
+	// Bodyless responses don't need to wait for a decision.
+	if code == http.StatusNoContent || code == http.StatusNotModified || code < 200 {
+		c.decide(false)
+	}
+}
+
+func (c *compressWriter) Write(p []byte) (int, error) {
+	if c.status == 0 {
+		c.status = http.StatusOK
+	}
+	if !c.decided {
+		c.buf = append(c.buf, p...)
+		if len(c.buf) < compressMinSize {
+			return len(p), nil
+		}
+		if err := c.decide(true); err != nil {
+			return 0, err
+		}
+		return len(p), nil
+	}
+	if c.enc != nil {
+		return c.enc.Write(p)
+	}
+	return c.ResponseWriter.Write(p)
+}
+
+// decide sends the header and the held-back bytes. large reports that the
+// body reached compressMinSize.
+func (c *compressWriter) decide(large bool) error {
+	c.decided = true
+	h := c.ResponseWriter.Header()
+	if h.Get("Content-Type") == "" && len(c.buf) > 0 {
+		h.Set("Content-Type", http.DetectContentType(c.buf))
+	}
+	if large && h.Get("Content-Encoding") == "" && compressible(h.Get("Content-Type")) {
+		h.Set("Content-Encoding", c.encoding)
+		h.Del("Content-Length")
+		switch c.encoding {
+		case "br":
+			bw := brotliPool.Get().(*brotli.Writer)
+			bw.Reset(c.ResponseWriter)
+			c.enc = bw
+		default:
+			gw := gzipPool.Get().(*gzip.Writer)
+			gw.Reset(c.ResponseWriter)
+			c.enc = gw
+		}
+	}
+	if c.status == 0 {
+		c.status = http.StatusOK
+	}
+	c.ResponseWriter.WriteHeader(c.status)
+	buf := c.buf
+	c.buf = nil
+	if len(buf) == 0 {
+		return nil
+	}
+	var err error
+	if c.enc != nil {
+		_, err = c.enc.Write(buf)
+	} else {
+		_, err = c.ResponseWriter.Write(buf)
+	}
+	return err
+}
+
+// Flush sends what has been written so far, compressed or not.
+func (c *compressWriter) Flush() {
+	if !c.decided {
+		c.decide(len(c.buf) > 0)
+	}
+	if f, ok := c.enc.(interface{ Flush() error }); ok {
+		f.Flush()
+	}
+	http.NewResponseController(c.ResponseWriter).Flush()
+}
+
+// Close finishes the body and returns the encoder to its pool.
+func (c *compressWriter) Close() error {
+	if !c.decided {
+		if c.status == 0 && len(c.buf) == 0 {
+			// Nothing was written; let net/http send its default 200.
+			return nil
+		}
+		c.decide(false)
+	}
+	if c.enc == nil {
+		return nil
+	}
+	err := c.enc.Close()
+	switch w := c.enc.(type) {
+	case *brotli.Writer:
+		w.Reset(io.Discard)
+		brotliPool.Put(w)
+	case *gzip.Writer:
+		w.Reset(io.Discard)
+		gzipPool.Put(w)
+	}
+	c.enc = nil
+	return err
+}
+
+// Unwrap lets http.ResponseController reach the underlying writer.
+func (c *compressWriter) Unwrap() http.ResponseWriter { return c.ResponseWriter }
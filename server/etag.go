@@ -0,0 +1,64 @@
+package server
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"log/slog"
+	"net/http"
+	"strings"
+)
+
+// notModified makes the response to r conditional. It sets a weak ETag
+// derived from the store's watermark and the request's path and query and
+// answers 304 when If-None-Match already names it; the handler must then
+// return without writing. Call it after syncing and before the query, so a
+// poll that finds nothing new costs a sync and the watermark only.
+//
+// The ETag also includes a per-process seed, so a restarted (possibly
+// upgraded) server never confirms a body an older build produced.
+func (s *Server) notModified(w http.ResponseWriter, r *http.Request) bool {
+	wm, err := s.store.Watermark()
+	if err != nil {
+		slog.Warn("watermark failed, response not cacheable", "component", "http", "err", err)
+		return false
+	}
+	sum := sha256.Sum256([]byte(s.etagSeed + "\x00" + wm + "\x00" + r.URL.Path + "?" + r.URL.Query().Encode()))
+	return checkETag(w, r, `W/"`+hex.EncodeToString(sum[:12])+`"`)
+}
+
+// checkETag sets etag and Cache-Control: no-cache, which lets browsers keep
+// the body but makes them revalidate it on every use, and answers 304 when
+// the request's If-None-Match matches.
+func checkETag(w http.ResponseWriter, r *http.Request, etag string) bool {
+	h := w.Header()
+	h.Set("ETag", etag)
+	h.Set("Cache-Control", "no-cache")
+	if !etagMatch(r.Header.Get("If-None-Match"), etag) {
+		return false
+	}
+	w.WriteHeader(http.StatusNotModified)
+	return true
+}
+
+// contentETag is the ETag of a fixed body such as an embedded file. It is
+// weak because the compressed encodings share it.
+func contentETag(content []byte) string {
+	sum := sha256.Sum256(content)
+	return `W/"` + hex.EncodeToString(sum[:12]) + `"`
+}
+
+// etagMatch applies the weak comparison If-None-Match uses: "*" or any
+// listed tag equal to etag once W/ prefixes are ignored.
+func etagMatch(header, etag string) bool {
+	if header == "" {
+		return false
+	}
+	etag = strings.TrimPrefix(etag, "W/")
+	for _, tag := range strings.Split(header, ",") {
+		tag = strings.TrimSpace(tag)
+		if tag == "*" || strings.TrimPrefix(tag, "W/") == etag {
+			return true
+		}
+	}
+	return false
+}
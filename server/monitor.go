@@ -0,0 +1,170 @@
+package server
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"sync"
+	"time"
+
+	"github.com/yeremiel/claw-usage-chart/notify"
+	"github.com/yeremiel/claw-usage-chart/store"
+)
+
+// Monitor periodically syncs the cache in the background and reports what it
+// finds: new anomalies go to the anomaly sinks, and budget crossings and sync
+// failures are emitted through the notifier (if any).
+type Monitor struct {
+	Store       store.Store
+	AgentsDir   string
+	Interval    time.Duration
+	Sensitivity float64
+	Budgets     []store.Budget
+	Notifier    *notify.Notifier // optional
+	Sinks       []AnomalySink
+
+	mu   sync.Mutex        // guards Budgets once Run has started
+	seen map[string]string // anomaly key -> date, for anomalies still in the detection window
+}
+
+// SetBudgets replaces the budgets checked from the next round on.
+func (m *Monitor) SetBudgets(budgets []store.Budget) {
+	m.mu.Lock()
+	m.Budgets = budgets
+	m.mu.Unlock()
+}
+
+// Run checks once immediately and then every Interval until ctx is done.
+func (m *Monitor) Run(ctx context.Context) {
+	m.seen = map[string]string{}
+	ticker := time.NewTicker(m.Interval)
+	defer ticker.Stop()
+	for {
+		m.check(time.Now())
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+func (m *Monitor) check(now time.Time) {
+	if _, err := m.Store.Sync(m.AgentsDir); err != nil {
+		slog.Error("sync failed", "component", "monitor", "agents_dir", m.AgentsDir, "err", err)
+		// One event per hour at most while sync keeps failing.
+		m.emit(notify.Event{
+			ID:      "sync:" + now.UTC().Format("2006-01-02T15"),
+			Kind:    notify.EventSyncFailed,
+			Summary: fmt.Sprintf("Sync of %s failed: %v", m.AgentsDir, err),
+			Data:    map[string]string{"agents_dir": m.AgentsDir, "error": err.Error()},
+		})
+		return
+	}
+
+	since := now.AddDate(0, 0, -1).Format("2006-01-02")
+	var found []store.Anomaly
+	for _, gran := range []string{"day", "hour"} {
+		for _, metric := range []string{"cost", "tokens"} {
+			got, err := m.Store.Anomalies(store.AnomalyOptions{
+				Granularity: gran,
+				Metric:      metric,
+				Sensitivity: m.Sensitivity,
+				Start:       since,
+			})
+			if err != nil {
+				slog.Error("anomaly detection failed", "component", "monitor", "granularity", gran, "metric", metric, "err", err)
+				continue
+			}
+			found = append(found, got...)
+		}
+	}
+	if fresh := m.markSeen(found, since); len(fresh) > 0 {
+		for _, s := range m.Sinks {
+			s.NotifyAnomalies(fresh)
+		}
+	}
+
+	m.mu.Lock()
+	budgets := m.Budgets
+	m.mu.Unlock()
+	events, err := checkBudgets(m.Store, budgets, now)
+	if err != nil {
+		slog.Error("budget check failed", "component", "monitor", "err", err)
+	}
+	for _, ev := range events {
+		m.emit(ev)
+	}
+}
+
+// markSeen returns the anomalies not reported before and remembers them.
+// Anomalies dated before since have left the detection window and cannot
+// come back, so they are forgotten.
+func (m *Monitor) markSeen(found []store.Anomaly, since string) []store.Anomaly {
+	for key, date := range m.seen {
+		if date < since {
+			delete(m.seen, key)
+		}
+	}
+	var fresh []store.Anomaly
+	for _, a := range found {
+		if _, ok := m.seen[a.Key()]; !ok {
+			m.seen[a.Key()] = a.Date
+			fresh = append(fresh, a)
+		}
+	}
+	return fresh
+}
+
+func (m *Monitor) emit(ev notify.Event) {
+	if m.Notifier == nil {
+		return
+	}
+	if err := m.Notifier.Emit(ev); err != nil {
+		slog.Error("emit event failed", "component", "monitor", "event", ev.ID, "err", err)
+	}
+}
+
+// checkBudgets returns one budget.crossed event per threshold reached in the
+// current period. Event IDs include the period and threshold, so the outbox
+// delivers each crossing once.
+func checkBudgets(s store.Store, budgets []store.Budget, now time.Time) ([]notify.Event, error) {
+	var events []notify.Event
+	for _, b := range budgets {
+		st, err := s.BudgetStatus(b, now)
+		if err != nil {
+			return events, err
+		}
+		for _, th := range b.Thresholds {
+			if st.Ratio < th {
+				continue
+			}
+			crossed := st
+			crossed.Threshold = th
+			events = append(events, notify.Event{
+				ID:   fmt.Sprintf("budget:%s:%s:%g", b.Name, st.PeriodKey, th),
+				Kind: notify.EventBudgetCrossed,
+				Summary: fmt.Sprintf("Budget %q reached %.0f%% for %s: $%.2f of $%.2f",
+					b.Name, th*100, st.PeriodKey, st.SpentUSD, b.LimitUSD),
+				Data: crossed,
+			})
+		}
+	}
+	return events, nil
+}
+
+// AnomalySink receives anomalies found by the background monitor.
+// Each anomaly is delivered at most once per process.
+type AnomalySink interface {
+	NotifyAnomalies(anomalies []store.Anomaly)
+}
+
+// LogAnomalySink writes anomalies to the server log.
+type LogAnomalySink struct{}
+
+func (LogAnomalySink) NotifyAnomalies(anomalies []store.Anomaly) {
+	for _, a := range anomalies {
+		slog.Warn("anomaly", "component", "monitor", "bucket", a.Bucket, "agent", a.Agent, "model", a.Model,
+			"metric", a.Metric, "value", a.Value, "baseline", a.Baseline, "score", a.Score)
+	}
+}
@@ -0,0 +1,70 @@
+package server
+
+import (
+	"errors"
+	"net/http"
+	"strconv"
+
+	"github.com/yeremiel/claw-usage-chart/store"
+)
+
+// queryHandler runs one read-only SELECT given as q (query string or POST
+// form) and answers with JSON, or CSV when format=csv.
+func (s *Server) queryHandler(w http.ResponseWriter, r *http.Request) {
+	o := s.options()
+	if !o.QueryAPI {
+		http.NotFound(w, r)
+		return
+	}
+	if r.Method != http.MethodGet && r.Method != http.MethodPost {
+		w.Header().Set("Allow", "GET, POST")
+		writeJSON(w, http.StatusMethodNotAllowed, map[string]string{"error": "method not allowed"})
+		return
+	}
+	q := r.FormValue("q")
+	if q == "" {
+		writeJSON(w, http.StatusBadRequest, map[string]string{"error": "q is required"})
+		return
+	}
+	format := r.FormValue("format")
+	if format != "" && format != "json" && format != "csv" {
+		writeJSON(w, http.StatusBadRequest, map[string]string{"error": "format must be json or csv"})
+		return
+	}
+	opts := store.QueryOptions{Allow: o.QueryAllow}
+	if v := r.FormValue("limit"); v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil || n <= 0 || n > store.DefaultQueryRows {
+			writeJSON(w, http.StatusBadRequest, map[string]string{"error": "invalid limit"})
+			return
+		}
+		opts.MaxRows = n
+	}
+
+	if _, err := s.store.Sync(s.agentsDir); err != nil {
+		writeJSON(w, http.StatusInternalServerError, map[string]string{"error": "sync: " + err.Error()})
+		return
+	}
+	res, err := s.store.Query(r.Context(), q, opts)
+	if errors.Is(err, store.ErrQueryTimeout) {
+		writeJSON(w, http.StatusGatewayTimeout, map[string]string{"error": err.Error()})
+		return
+	}
+	if errors.Is(err, store.ErrNoQueryRole) {
+		writeJSON(w, http.StatusServiceUnavailable, map[string]string{"error": err.Error()})
+		return
+	}
+	if err != nil {
+		writeJSON(w, http.StatusBadRequest, map[string]string{"error": err.Error()})
+		return
+	}
+	if format == "csv" {
+		w.Header().Set("Content-Type", "text/csv; charset=utf-8")
+		if res.Truncated {
+			w.Header().Set("X-Truncated", "true")
+		}
+		res.WriteCSV(w)
+		return
+	}
+	writeJSON(w, http.StatusOK, res)
+}
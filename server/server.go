@@ -0,0 +1,356 @@
+// Package server serves the embedded dashboard and the JSON API over a
+// store.Store.
+package server
+
+import (
+	"embed"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/yeremiel/claw-usage-chart/api"
+	"github.com/yeremiel/claw-usage-chart/store"
+)
+
+//go:embed index.html favicon.svg
+var staticFiles embed.FS
+
+// ChartJSVersion is the Chart.js release index.html loads from ChartJSURL.
+const ChartJSVersion = "4.4.7"
+
+// ChartJSURL is the Chart.js build index.html loads.
+const ChartJSURL = "https://cdn.jsdelivr.net/npm/chart.js@" + ChartJSVersion + "/dist/chart.umd.min.js"
+
+// Asset returns an embedded dashboard file: "index.html" or "favicon.svg".
+func Asset(name string) ([]byte, error) { return staticFiles.ReadFile(name) }
+
+// Options configure a Server.
+type Options struct {
+	// AnomalySensitivity is the default threshold for /api/anomalies.
+	AnomalySensitivity float64
+	// PushTokens enables the /api/push collector endpoint for these pushers.
+	PushTokens []PushToken
+	// RawRecords lets /api/records return the original session lines
+	// (raw=1). They hold prompt and response text, so it is off by default.
+	RawRecords bool
+	// QueryAPI enables /api/query, which runs read-only SQL over the
+	// tables in QueryAllow (store.DefaultQueryTables when empty).
+	QueryAPI   bool
+	QueryAllow []string
+	// Budgets are the configured budgets /badge/budget and /badge/cost
+	// colour against.
+	Budgets []store.Budget
+}
+
+// Server serves the dashboard for one agents directory. Every API request
+// syncs new session lines before answering.
+type Server struct {
+	store     store.Store
+	agentsDir string
+	etagSeed  string // see notModified
+
+	mu   sync.RWMutex
+	opts Options
+}
+
+// New creates a server over st.
+func New(st store.Store, agentsDir string, opts Options) *Server {
+	return &Server{
+		store:     st,
+		agentsDir: agentsDir,
+		etagSeed:  strconv.FormatInt(time.Now().UnixNano(), 36),
+		opts:      opts,
+	}
+}
+
+// SetOptions replaces the options for subsequent requests, e.g. after the
+// config file was reloaded. Routes that opts disable answer 404.
+func (s *Server) SetOptions(opts Options) {
+	s.mu.Lock()
+	s.opts = opts
+	s.mu.Unlock()
+}
+
+func (s *Server) options() Options {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return s.opts
+}
+
+// Handler returns every route wrapped in compression and request logging.
+func (s *Server) Handler() http.Handler {
+	mux := http.NewServeMux()
+
+	mux.HandleFunc("/", func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/" {
+			http.NotFound(w, r)
+			return
+		}
+		content, err := staticFiles.ReadFile("index.html")
+		if err != nil {
+			http.Error(w, "index.html not found", http.StatusInternalServerError)
+			return
+		}
+		if checkETag(w, r, contentETag(content)) {
+			return
+		}
+		w.Header().Set("Content-Type", "text/html; charset=utf-8")
+		w.Write(content)
+	})
+
+	mux.HandleFunc("/favicon.svg", func(w http.ResponseWriter, r *http.Request) {
+		content, err := staticFiles.ReadFile("favicon.svg")
+		if err != nil {
+			http.NotFound(w, r)
+			return
+		}
+		if checkETag(w, r, contentETag(content)) {
+			return
+		}
+		w.Header().Set("Content-Type", "image/svg+xml")
+		w.Write(content)
+	})
+
+	// Every API route answers under APIPrefix and, unchanged, under /api.
+	// push.Path is one of the /api aliases, so older pushers keep working.
+	for p, h := range s.apiRoutes() {
+		mux.HandleFunc(APIPrefix+p, h)
+		mux.HandleFunc("/api"+p, h)
+	}
+	mux.HandleFunc("/badge/", s.badgeHandler)
+
+	mux.HandleFunc("/health", func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		w.Write([]byte(`{"ok":true}`))
+	})
+
+	return loggingMiddleware(compressMiddleware(mux))
+}
+
+// CollectStats syncs and then aggregates, as /api/stats does.
+func (s *Server) CollectStats(filter store.UsageFilter) (store.StatsResponse, error) {
+	res, err := s.store.Sync(s.agentsDir)
+	if err != nil {
+		return store.StatsResponse{}, fmt.Errorf("sync: %w", err)
+	}
+	stats, err := s.store.Stats(filter)
+	if err != nil {
+		return store.StatsResponse{}, err
+	}
+	stats.Source = s.agentsDir
+	stats.Sync = res
+	return stats, nil
+}
+
+func (s *Server) statsHandler(w http.ResponseWriter, r *http.Request) {
+	filter := ParseUsageFilter(r.URL.Query())
+	res, err := s.store.Sync(s.agentsDir)
+	if err != nil {
+		writeJSON(w, http.StatusInternalServerError, map[string]string{"error": "sync: " + err.Error()})
+		return
+	}
+	if s.notModified(w, r) {
+		return
+	}
+	stats, err := s.store.Stats(filter)
+	if err != nil {
+		writeJSON(w, http.StatusInternalServerError, map[string]string{"error": err.Error()})
+		return
+	}
+	stats.Source = s.agentsDir
+	stats.Sync = res
+	writeJSON(w, http.StatusOK, stats)
+}
+
+func (s *Server) recordsHandler(w http.ResponseWriter, r *http.Request) {
+	q := r.URL.Query()
+	rq := store.RecordsQuery{
+		Filter: ParseUsageFilter(q),
+		Sort:   q.Get("sort"),
+		Order:  q.Get("order"),
+		Cursor: q.Get("cursor"),
+		Raw:    q.Get("raw") == "1" || q.Get("raw") == "true",
+	}
+	if rq.Raw && !s.options().RawRecords {
+		writeJSON(w, http.StatusForbidden, map[string]string{"error": "raw lines are disabled (start the server with --raw-records)"})
+		return
+	}
+	if v := q.Get("limit"); v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil || n <= 0 {
+			writeJSON(w, http.StatusBadRequest, map[string]string{"error": "invalid limit"})
+			return
+		}
+		rq.Limit = n
+	}
+
+	// Only the first page syncs, so paging does not shift under the cursor.
+	if rq.Cursor == "" {
+		if _, err := s.store.Sync(s.agentsDir); err != nil {
+			writeJSON(w, http.StatusInternalServerError, map[string]string{"error": "sync: " + err.Error()})
+			return
+		}
+	}
+	if s.notModified(w, r) {
+		return
+	}
+	page, err := s.store.Records(rq)
+	if errors.Is(err, store.ErrInvalidQuery) {
+		writeJSON(w, http.StatusBadRequest, map[string]string{"error": err.Error()})
+		return
+	}
+	if err != nil {
+		writeJSON(w, http.StatusInternalServerError, map[string]string{"error": err.Error()})
+		return
+	}
+	writeJSON(w, http.StatusOK, page)
+}
+
+func (s *Server) aggregateHandler(w http.ResponseWriter, r *http.Request) {
+	q := r.URL.Query()
+	aq := store.AggregateQuery{
+		Filter:  ParseUsageFilter(q),
+		GroupBy: splitList(q.Get("group_by")),
+		Metrics: splitList(q.Get("metrics")),
+	}
+	for name, dst := range map[string]*int{"top": &aq.Top, "limit": &aq.Limit} {
+		if v := q.Get(name); v != "" {
+			n, err := strconv.Atoi(v)
+			if err != nil || n < 0 {
+				writeJSON(w, http.StatusBadRequest, map[string]string{"error": "invalid " + name})
+				return
+			}
+			*dst = n
+		}
+	}
+
+	if _, err := s.store.Sync(s.agentsDir); err != nil {
+		writeJSON(w, http.StatusInternalServerError, map[string]string{"error": "sync: " + err.Error()})
+		return
+	}
+	if s.notModified(w, r) {
+		return
+	}
+	resp, err := s.store.Aggregate(aq)
+	if errors.Is(err, store.ErrInvalidQuery) {
+		writeJSON(w, http.StatusBadRequest, map[string]string{"error": err.Error()})
+		return
+	}
+	if err != nil {
+		writeInternalError(w, r, "aggregate failed", err)
+		return
+	}
+	writeJSON(w, http.StatusOK, resp)
+}
+
+// splitList splits a comma-separated query value, dropping empty items.
+func splitList(v string) []string {
+	var out []string
+	for _, s := range strings.Split(v, ",") {
+		if s = strings.TrimSpace(s); s != "" {
+			out = append(out, s)
+		}
+	}
+	return out
+}
+
+// ParseUsageFilter reads the common filter parameters shared by the API.
+func ParseUsageFilter(q url.Values) store.UsageFilter { return api.ParseUsageFilter(q) }
+
+// EncodeUsageFilter is the inverse of ParseUsageFilter. Empty fields are
+// left out.
+func EncodeUsageFilter(f store.UsageFilter) url.Values { return api.EncodeUsageFilter(f) }
+
+// AnomalyResponse is the payload of /api/anomalies.
+type AnomalyResponse = api.AnomalyResponse
+
+func (s *Server) anomaliesHandler(w http.ResponseWriter, r *http.Request) {
+	q := r.URL.Query()
+	opts := store.AnomalyOptions{
+		Granularity: q.Get("granularity"),
+		Metric:      q.Get("metric"),
+		Sensitivity: s.options().AnomalySensitivity,
+		Start:       q.Get("start"),
+		End:         q.Get("end"),
+	}
+	if v := q.Get("sensitivity"); v != "" {
+		f, err := strconv.ParseFloat(v, 64)
+		if err != nil || f <= 0 {
+			writeJSON(w, http.StatusBadRequest, map[string]string{"error": "invalid sensitivity"})
+			return
+		}
+		opts.Sensitivity = f
+	}
+	if v := q.Get("window"); v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil || n <= 0 {
+			writeJSON(w, http.StatusBadRequest, map[string]string{"error": "invalid window"})
+			return
+		}
+		opts.Window = n
+	}
+	opts, err := opts.WithDefaults()
+	if err != nil {
+		writeJSON(w, http.StatusBadRequest, map[string]string{"error": err.Error()})
+		return
+	}
+
+	if _, err := s.store.Sync(s.agentsDir); err != nil {
+		writeJSON(w, http.StatusInternalServerError, map[string]string{"error": "sync: " + err.Error()})
+		return
+	}
+	if s.notModified(w, r) {
+		return
+	}
+	anomalies, err := s.store.Anomalies(opts)
+	if err != nil {
+		writeJSON(w, http.StatusInternalServerError, map[string]string{"error": err.Error()})
+		return
+	}
+	if anomalies == nil {
+		anomalies = []store.Anomaly{}
+	}
+	writeJSON(w, http.StatusOK, AnomalyResponse{
+		GeneratedAt: time.Now().UTC().Format(time.RFC3339),
+		Granularity: opts.Granularity,
+		Metric:      opts.Metric,
+		Sensitivity: opts.Sensitivity,
+		Window:      opts.Window,
+		Anomalies:   anomalies,
+	})
+}
+
+// writeInternalError logs err and answers with a generic message, keeping
+// driver and file system details out of the response.
+func writeInternalError(w http.ResponseWriter, r *http.Request, msg string, err error) {
+	slog.ErrorContext(r.Context(), msg, "component", "http", "path", r.URL.Path, "err", err)
+	writeJSON(w, http.StatusInternalServerError, map[string]string{"error": msg})
+}
+
+// writeJSON marshals v and writes it with the given status code. Responses
+// are not stored by caches unless the handler set its own Cache-Control
+// (see notModified); errors never are.
+func writeJSON(w http.ResponseWriter, status int, v interface{}) {
+	payload, err := json.Marshal(v)
+	if err != nil {
+		payload, _ = json.Marshal(map[string]string{"error": err.Error()})
+		status = http.StatusInternalServerError
+	}
+	h := w.Header()
+	h.Set("Content-Type", "application/json; charset=utf-8")
+	if status >= 400 {
+		h.Del("ETag")
+		h.Set("Cache-Control", "no-store")
+	} else if h.Get("Cache-Control") == "" {
+		h.Set("Cache-Control", "no-store")
+	}
+	w.WriteHeader(status)
+	w.Write(payload)
+}
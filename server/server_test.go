@@ -0,0 +1,560 @@
+package server
+
+import (
+	"compress/gzip"
+	"encoding/json"
+	"errors"
+	"image/png"
+	"io"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/andybalholm/brotli"
+	"github.com/yeremiel/claw-usage-chart/chart"
+	"github.com/yeremiel/claw-usage-chart/push"
+	"github.com/yeremiel/claw-usage-chart/store"
+)
+
+func TestStatsEndpointSyncsAndReportsSource(t *testing.T) {
+	tmp := t.TempDir()
+	agentsDir := filepath.Join(tmp, "agents")
+	sessionDir := filepath.Join(agentsDir, "alpha", "sessions")
+	if err := os.MkdirAll(sessionDir, 0o755); err != nil {
+		t.Fatalf("mkdir: %v", err)
+	}
+	line := `{"timestamp":"2026-02-17T10:00:00Z","model":"m1","usage":{"input_tokens":42}}` + "\n"
+	if err := os.WriteFile(filepath.Join(sessionDir, "s.jsonl"), []byte(line), 0o644); err != nil {
+		t.Fatalf("write: %v", err)
+	}
+
+	st, err := store.Open(filepath.Join(tmp, "usage_cache.db"), store.Options{})
+	if err != nil {
+		t.Fatalf("open: %v", err)
+	}
+	defer st.Close()
+	srv := httptest.NewServer(New(st, agentsDir, Options{}).Handler())
+	defer srv.Close()
+
+	resp, err := http.Get(srv.URL + "/api/stats?agent=alpha")
+	if err != nil {
+		t.Fatalf("get: %v", err)
+	}
+	defer resp.Body.Close()
+	var stats store.StatsResponse
+	if err := json.NewDecoder(resp.Body).Decode(&stats); err != nil {
+		t.Fatalf("decode: %v", err)
+	}
+	if resp.StatusCode != http.StatusOK || stats.Source != agentsDir || stats.Sync.NewRecords != 1 || stats.Summary.TotalTokens != 42 {
+		t.Fatalf("unexpected response %d: %+v", resp.StatusCode, stats)
+	}
+
+	resp, err = http.Get(srv.URL + "/api/aggregate?group_by=nope")
+	if err != nil {
+		t.Fatalf("get: %v", err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusBadRequest {
+		t.Fatalf("expected 400 for unknown dimension, got %d", resp.StatusCode)
+	}
+
+	// Cache failures are the server's, and their details stay in the log.
+	failing := httptest.NewServer(New(failingAggregate{st}, agentsDir, Options{}).Handler())
+	defer failing.Close()
+	resp, err = http.Get(failing.URL + "/api/aggregate?group_by=agent")
+	if err != nil {
+		t.Fatalf("get: %v", err)
+	}
+	defer resp.Body.Close()
+	var e map[string]string
+	if err := json.NewDecoder(resp.Body).Decode(&e); err != nil {
+		t.Fatalf("decode: %v", err)
+	}
+	if resp.StatusCode != http.StatusInternalServerError || e["error"] != "aggregate failed" {
+		t.Fatalf("closed store = %d %v, want 500 with a generic message", resp.StatusCode, e)
+	}
+}
+
+// failingAggregate is a store whose aggregate queries fail like a broken
+// database connection.
+type failingAggregate struct{ store.Store }
+
+func (failingAggregate) Aggregate(store.AggregateQuery) (store.AggregateResponse, error) {
+	return store.AggregateResponse{}, errors.New("pq: connection to 10.0.0.5 refused")
+}
+
+func TestQueryEndpointIsOptIn(t *testing.T) {
+	tmp := t.TempDir()
+	agentsDir := filepath.Join(tmp, "agents")
+	sessionDir := filepath.Join(agentsDir, "alpha", "sessions")
+	if err := os.MkdirAll(sessionDir, 0o755); err != nil {
+		t.Fatalf("mkdir: %v", err)
+	}
+	line := `{"timestamp":"2026-02-17T10:00:00Z","model":"m1","usage":{"input_tokens":42}}` + "\n"
+	if err := os.WriteFile(filepath.Join(sessionDir, "s.jsonl"), []byte(line), 0o644); err != nil {
+		t.Fatalf("write: %v", err)
+	}
+	st, err := store.Open(filepath.Join(tmp, "usage_cache.db"), store.Options{})
+	if err != nil {
+		t.Fatalf("open: %v", err)
+	}
+	defer st.Close()
+
+	off := httptest.NewServer(New(st, agentsDir, Options{}).Handler())
+	defer off.Close()
+	resp, err := http.Get(off.URL + "/api/query?q=SELECT+1")
+	if err != nil {
+		t.Fatalf("get: %v", err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusNotFound {
+		t.Fatalf("query API without opt-in: status %d, want 404", resp.StatusCode)
+	}
+
+	on := httptest.NewServer(New(st, agentsDir, Options{QueryAPI: true}).Handler())
+	defer on.Close()
+	resp, err = http.Get(on.URL + "/api/query?q=" + url.QueryEscape("SELECT model, SUM(tokens) AS tokens FROM v_usage GROUP BY model"))
+	if err != nil {
+		t.Fatalf("get: %v", err)
+	}
+	var res store.QueryResult
+	err = json.NewDecoder(resp.Body).Decode(&res)
+	resp.Body.Close()
+	if err != nil || resp.StatusCode != http.StatusOK || len(res.Rows) != 1 || res.Rows[0][1] != float64(42) {
+		t.Fatalf("query: status %d, %+v, %v", resp.StatusCode, res, err)
+	}
+
+	resp, err = http.Get(on.URL + "/api/query?q=" + url.QueryEscape("SELECT * FROM usage_records"))
+	if err != nil {
+		t.Fatalf("get: %v", err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusBadRequest {
+		t.Fatalf("base table query: status %d, want 400", resp.StatusCode)
+	}
+}
+
+func TestRecordsRawLinesAreOptIn(t *testing.T) {
+	tmp := t.TempDir()
+	agentsDir := filepath.Join(tmp, "agents")
+	sessionDir := filepath.Join(agentsDir, "alpha", "sessions")
+	if err := os.MkdirAll(sessionDir, 0o755); err != nil {
+		t.Fatalf("mkdir: %v", err)
+	}
+	line := `{"timestamp":"2026-02-17T10:00:00Z","model":"m1","usage":{"input_tokens":42}}` + "\n"
+	if err := os.WriteFile(filepath.Join(sessionDir, "s.jsonl"), []byte(line), 0o644); err != nil {
+		t.Fatalf("write: %v", err)
+	}
+	st, err := store.Open(filepath.Join(tmp, "usage_cache.db"), store.Options{})
+	if err != nil {
+		t.Fatalf("open: %v", err)
+	}
+	defer st.Close()
+	app := New(st, agentsDir, Options{})
+	srv := httptest.NewServer(app.Handler())
+	defer srv.Close()
+
+	status := func(path string) int {
+		t.Helper()
+		resp, err := http.Get(srv.URL + path)
+		if err != nil {
+			t.Fatalf("get %s: %v", path, err)
+		}
+		resp.Body.Close()
+		return resp.StatusCode
+	}
+	if got := status("/api/records"); got != http.StatusOK {
+		t.Fatalf("records: %d", got)
+	}
+	if got := status("/api/records?raw=1"); got != http.StatusForbidden {
+		t.Fatalf("raw lines without opt-in: %d, want 403", got)
+	}
+	if got := status("/api/records?sort=nope"); got != http.StatusBadRequest {
+		t.Fatalf("unknown sort: %d, want 400", got)
+	}
+
+	app.SetOptions(Options{RawRecords: true})
+	resp, err := http.Get(srv.URL + "/api/records?raw=1")
+	if err != nil {
+		t.Fatalf("get: %v", err)
+	}
+	var page store.RecordsPage
+	err = json.NewDecoder(resp.Body).Decode(&page)
+	resp.Body.Close()
+	if err != nil || resp.StatusCode != http.StatusOK || len(page.Records) != 1 || page.Records[0].Raw+"\n" != line {
+		t.Fatalf("raw lines with opt-in: %d %+v %v", resp.StatusCode, page, err)
+	}
+}
+
+func TestChartEndpoint(t *testing.T) {
+	tmp := t.TempDir()
+	agentsDir := filepath.Join(tmp, "agents")
+	sessionDir := filepath.Join(agentsDir, "alpha", "sessions")
+	if err := os.MkdirAll(sessionDir, 0o755); err != nil {
+		t.Fatalf("mkdir: %v", err)
+	}
+	line := `{"timestamp":"2026-02-17T10:00:00Z","model":"m1","usage":{"input_tokens":42}}` + "\n"
+	if err := os.WriteFile(filepath.Join(sessionDir, "s.jsonl"), []byte(line), 0o644); err != nil {
+		t.Fatalf("write: %v", err)
+	}
+	st, err := store.Open(filepath.Join(tmp, "usage_cache.db"), store.Options{})
+	if err != nil {
+		t.Fatalf("open: %v", err)
+	}
+	defer st.Close()
+	srv := httptest.NewServer(New(st, agentsDir, Options{}).Handler())
+	defer srv.Close()
+
+	resp, err := http.Get(srv.URL + "/api/chart/heatmap.png?agent=alpha&width=300&height=150")
+	if err != nil {
+		t.Fatalf("get: %v", err)
+	}
+	img, err := png.Decode(resp.Body)
+	resp.Body.Close()
+	if err != nil || resp.Header.Get("Content-Type") != "image/png" || img.Bounds().Dx() != 300 || img.Bounds().Dy() != 150 {
+		t.Fatalf("heatmap.png: status %d, %s, %v", resp.StatusCode, resp.Header.Get("Content-Type"), err)
+	}
+
+	for path, want := range map[string]int{
+		"/api/chart/daily.svg":            http.StatusOK,
+		"/api/chart/daily.svg?theme=blue": http.StatusBadRequest,
+		"/api/chart/daily.gif":            http.StatusNotFound,
+		"/api/chart/pie.svg":              http.StatusNotFound,
+	} {
+		resp, err := http.Get(srv.URL + path)
+		if err != nil {
+			t.Fatalf("get: %v", err)
+		}
+		resp.Body.Close()
+		if resp.StatusCode != want {
+			t.Errorf("%s: status %d, want %d", path, resp.StatusCode, want)
+		}
+	}
+}
+
+func TestBadgeEndpoints(t *testing.T) {
+	tmp := t.TempDir()
+	agentsDir := filepath.Join(tmp, "agents")
+	sessionDir := filepath.Join(agentsDir, "alpha", "sessions")
+	if err := os.MkdirAll(sessionDir, 0o755); err != nil {
+		t.Fatalf("mkdir: %v", err)
+	}
+	line := `{"timestamp":"2026-02-17T10:00:00Z","model":"m1","usage":{"input_tokens":4200}}` + "\n"
+	if err := os.WriteFile(filepath.Join(sessionDir, "s.jsonl"), []byte(line), 0o644); err != nil {
+		t.Fatalf("write: %v", err)
+	}
+	st, err := store.Open(filepath.Join(tmp, "usage_cache.db"), store.Options{})
+	if err != nil {
+		t.Fatalf("open: %v", err)
+	}
+	defer st.Close()
+	budgets := []store.Budget{{Name: "team", Period: "month", LimitUSD: 100, Thresholds: []float64{0.5, 0.8, 1.0}}}
+	srv := httptest.NewServer(New(st, agentsDir, Options{Budgets: budgets}).Handler())
+	defer srv.Close()
+
+	for _, tc := range []struct {
+		path, title, color string
+		status             int
+	}{
+		{"/badge/tokens?period=all", "AI tokens: 4.2K", chart.BadgeBlue, http.StatusOK},
+		{"/badge/tokens?start=2026-02-17&end=2026-02-17&limit=5000&label=tokens", "tokens: 4.2K", chart.BadgeOrange, http.StatusOK},
+		{"/badge/cost?budget=team", "AI spend this month: $0.00", chart.BadgeGreen, http.StatusOK},
+		{"/badge/cost?budget=team&period=month", "AI spend this month: $0.00", chart.BadgeGreen, http.StatusOK},
+		{"/badge/cost?budget=team&period=7d", "AI spend: budget is per month", chart.BadgeGrey, http.StatusBadRequest},
+		{"/badge/cost?budget=team&period=all", "AI spend: budget is per month", chart.BadgeGrey, http.StatusBadRequest},
+		{"/badge/cost?budget=team&start=2026-02-01", "AI spend: budget with start/end", chart.BadgeGrey, http.StatusBadRequest},
+		{"/badge/budget", "team: $0.00 / $100.00", chart.BadgeGreen, http.StatusOK},
+		{"/badge/budget?name=nope", "budget: unknown budget", chart.BadgeGrey, http.StatusNotFound},
+		{"/badge/cost?period=year", "AI spend: invalid period", chart.BadgeGrey, http.StatusBadRequest},
+	} {
+		resp, err := http.Get(srv.URL + tc.path)
+		if err != nil {
+			t.Fatalf("get: %v", err)
+		}
+		body, _ := io.ReadAll(resp.Body)
+		resp.Body.Close()
+		svg := string(body)
+		if resp.StatusCode != tc.status || !strings.Contains(svg, "<title>"+tc.title+"</title>") || !strings.Contains(svg, `fill="`+tc.color+`"`) {
+			t.Errorf("%s: status %d, badge %s", tc.path, resp.StatusCode, svg)
+		}
+		wantCache := "public, max-age=300"
+		if tc.status != http.StatusOK {
+			wantCache = "no-cache"
+		}
+		if got := resp.Header.Get("Cache-Control"); got != wantCache {
+			t.Errorf("%s: Cache-Control %q, want %q", tc.path, got, wantCache)
+		}
+	}
+}
+
+func TestThresholdColor(t *testing.T) {
+	th := []float64{0.5, 0.8, 1.0}
+	for ratio, want := range map[float64]string{
+		0: chart.BadgeGreen, 0.49: chart.BadgeGreen, 0.5: chart.BadgeYellow,
+		0.8: chart.BadgeOrange, 1: chart.BadgeRed, 3: chart.BadgeRed,
+	} {
+		if got := thresholdColor(ratio, th); got != want {
+			t.Errorf("thresholdColor(%v) = %s, want %s", ratio, got, want)
+		}
+	}
+	if got := thresholdColor(0.9, []float64{0.9}); got != chart.BadgeRed {
+		t.Errorf("crossing the only threshold: %s, want red", got)
+	}
+}
+
+func TestSetOptionsAppliesToRunningServer(t *testing.T) {
+	tmp := t.TempDir()
+	st, err := store.Open(filepath.Join(tmp, "usage_cache.db"), store.Options{})
+	if err != nil {
+		t.Fatalf("open: %v", err)
+	}
+	defer st.Close()
+	app := New(st, filepath.Join(tmp, "agents"), Options{})
+	srv := httptest.NewServer(app.Handler())
+	defer srv.Close()
+
+	status := func(path string) int {
+		resp, err := http.Get(srv.URL + path)
+		if err != nil {
+			t.Fatalf("get %s: %v", path, err)
+		}
+		resp.Body.Close()
+		return resp.StatusCode
+	}
+	if got := status("/api/query?q=SELECT+1"); got != http.StatusNotFound {
+		t.Fatalf("query before SetOptions: status %d, want 404", got)
+	}
+	if got := status("/badge/budget?name=team"); got != http.StatusNotFound {
+		t.Fatalf("unknown budget: status %d, want 404", got)
+	}
+
+	app.SetOptions(Options{
+		QueryAPI: true,
+		Budgets:  []store.Budget{{Name: "team", Period: "month", LimitUSD: 10}},
+	})
+	if got := status("/api/query?q=SELECT+1"); got != http.StatusOK {
+		t.Fatalf("query after SetOptions: status %d, want 200", got)
+	}
+	if got := status("/badge/budget?name=team"); got != http.StatusOK {
+		t.Fatalf("budget after SetOptions: status %d, want 200", got)
+	}
+}
+
+func TestLoggingMiddleware(t *testing.T) {
+	var buf strings.Builder
+	prev := slog.Default()
+	slog.SetDefault(slog.New(slog.NewJSONHandler(&buf, &slog.HandlerOptions{Level: slog.LevelDebug})))
+	defer slog.SetDefault(prev)
+
+	h := loggingMiddleware(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusTeapot)
+		io.WriteString(w, "short and stout")
+	}))
+	req := httptest.NewRequest("GET", "/api/stats?agent=alpha", nil)
+	req.Header.Set(RequestIDHeader, "abc-123")
+	rec := httptest.NewRecorder()
+	h.ServeHTTP(rec, req)
+	if got := rec.Header().Get(RequestIDHeader); got != "abc-123" {
+		t.Fatalf("request ID %q, want the incoming one", got)
+	}
+
+	var ev struct {
+		Msg       string  `json:"msg"`
+		RequestID string  `json:"request_id"`
+		Path      string  `json:"path"`
+		Status    int     `json:"status"`
+		Bytes     int     `json:"bytes"`
+		Duration  float64 `json:"duration_ms"`
+	}
+	if err := json.Unmarshal([]byte(buf.String()), &ev); err != nil {
+		t.Fatalf("decode log line %q: %v", buf.String(), err)
+	}
+	if ev.Msg != "request" || ev.RequestID != "abc-123" || ev.Path != "/api/stats" || ev.Status != http.StatusTeapot || ev.Bytes != 15 {
+		t.Fatalf("unexpected log event: %+v", ev)
+	}
+
+	// Unsafe incoming IDs are replaced rather than logged.
+	req = httptest.NewRequest("GET", "/", nil)
+	req.Header.Set(RequestIDHeader, "x\ny")
+	rec = httptest.NewRecorder()
+	h.ServeHTTP(rec, req)
+	if got := rec.Header().Get(RequestIDHeader); got == "" || strings.Contains(got, "\n") {
+		t.Fatalf("generated request ID %q", got)
+	}
+}
+
+func TestCompressionAndConditionalRequests(t *testing.T) {
+	tmp := t.TempDir()
+	agentsDir := filepath.Join(tmp, "agents")
+	sessionDir := filepath.Join(agentsDir, "alpha", "sessions")
+	if err := os.MkdirAll(sessionDir, 0o755); err != nil {
+		t.Fatalf("mkdir: %v", err)
+	}
+	session := filepath.Join(sessionDir, "s.jsonl")
+	line := `{"timestamp":"2026-02-17T10:00:00Z","model":"m1","usage":{"input_tokens":42}}` + "\n"
+	if err := os.WriteFile(session, []byte(line), 0o644); err != nil {
+		t.Fatalf("write: %v", err)
+	}
+	st, err := store.Open(filepath.Join(tmp, "usage_cache.db"), store.Options{})
+	if err != nil {
+		t.Fatalf("open: %v", err)
+	}
+	defer st.Close()
+	srv := httptest.NewServer(New(st, agentsDir, Options{}).Handler())
+	defer srv.Close()
+
+	get := func(path string, header map[string]string) (*http.Response, []byte) {
+		t.Helper()
+		req, _ := http.NewRequest("GET", srv.URL+path, nil)
+		for k, v := range header {
+			req.Header.Set(k, v)
+		}
+		resp, err := http.DefaultTransport.RoundTrip(req)
+		if err != nil {
+			t.Fatalf("get %s: %v", path, err)
+		}
+		defer resp.Body.Close()
+		var body io.Reader = resp.Body
+		switch resp.Header.Get("Content-Encoding") {
+		case "gzip":
+			if body, err = gzip.NewReader(resp.Body); err != nil {
+				t.Fatalf("gzip: %v", err)
+			}
+		case "br":
+			body = brotli.NewReader(resp.Body)
+		}
+		b, err := io.ReadAll(body)
+		if err != nil {
+			t.Fatalf("read %s: %v", path, err)
+		}
+		return resp, b
+	}
+
+	for _, enc := range []string{"gzip", "br"} {
+		resp, body := get("/api/stats", map[string]string{"Accept-Encoding": enc})
+		var stats store.StatsResponse
+		if err := json.Unmarshal(body, &stats); err != nil || stats.Summary.TotalTokens != 42 {
+			t.Fatalf("%s stats: %v, %+v", enc, err, stats.Summary)
+		}
+		if got := resp.Header.Get("Content-Encoding"); got != enc {
+			t.Fatalf("Content-Encoding %q, want %q", got, enc)
+		}
+		if !strings.Contains(resp.Header.Get("Vary"), "Accept-Encoding") {
+			t.Fatalf("missing Vary: %v", resp.Header)
+		}
+	}
+
+	resp, _ := get("/api/stats?start=2026-02-01", nil)
+	etag := resp.Header.Get("ETag")
+	if etag == "" || resp.Header.Get("Cache-Control") != "no-cache" {
+		t.Fatalf("stats headers: %v", resp.Header)
+	}
+	resp, body := get("/api/stats?start=2026-02-01", map[string]string{"If-None-Match": etag})
+	if resp.StatusCode != http.StatusNotModified || len(body) != 0 {
+		t.Fatalf("unchanged stats: status %d, %d bytes", resp.StatusCode, len(body))
+	}
+	if other, _ := get("/api/stats?start=2026-02-02", nil); other.Header.Get("ETag") == etag {
+		t.Fatal("different query shares the ETag")
+	}
+
+	f, err := os.OpenFile(session, os.O_APPEND|os.O_WRONLY, 0)
+	if err != nil {
+		t.Fatalf("open session: %v", err)
+	}
+	f.WriteString(`{"timestamp":"2026-02-18T10:00:00Z","model":"m1","usage":{"input_tokens":8}}` + "\n")
+	f.Close()
+	resp, body = get("/api/stats?start=2026-02-01", map[string]string{"If-None-Match": etag})
+	if resp.StatusCode != http.StatusOK || resp.Header.Get("ETag") == etag || len(body) == 0 {
+		t.Fatalf("after new records: status %d, ETag %q", resp.StatusCode, resp.Header.Get("ETag"))
+	}
+
+	resp, _ = get("/", map[string]string{"Accept-Encoding": "gzip"})
+	if resp.Header.Get("Content-Encoding") != "gzip" || resp.Header.Get("ETag") == "" {
+		t.Fatalf("index headers: %v", resp.Header)
+	}
+	if resp, _ = get("/", map[string]string{"If-None-Match": resp.Header.Get("ETag")}); resp.StatusCode != http.StatusNotModified {
+		t.Fatalf("unchanged index: status %d", resp.StatusCode)
+	}
+	if resp, _ = get("/health", map[string]string{"Accept-Encoding": "gzip"}); resp.Header.Get("Content-Encoding") != "" {
+		t.Fatal("tiny /health body was compressed")
+	}
+}
+
+func TestNegotiateEncoding(t *testing.T) {
+	for header, want := range map[string]string{
+		"":                     "",
+		"identity":             "",
+		"gzip, deflate":        "gzip",
+		"gzip, deflate, br":    "br",
+		"br;q=0.5, gzip":       "gzip",
+		"gzip;q=0, br;q=0":     "",
+		"GZIP;q=0.8, br;q=0.8": "br",
+		"br;q=abc, gzip;q=0.1": "gzip",
+	} {
+		if got := negotiateEncoding(header); got != want {
+			t.Errorf("negotiateEncoding(%q) = %q, want %q", header, got, want)
+		}
+	}
+}
+
+func TestMonitorForgetsAnomaliesOutsideTheWindow(t *testing.T) {
+	m := &Monitor{seen: map[string]string{}}
+	old := store.Anomaly{Granularity: "day", Bucket: "2026-02-15", Date: "2026-02-15", Metric: "cost"}
+	cur := store.Anomaly{Granularity: "hour", Bucket: "2026-02-16 13:00", Date: "2026-02-16", Metric: "cost"}
+
+	if fresh := m.markSeen([]store.Anomaly{old, cur}, "2026-02-15"); len(fresh) != 2 {
+		t.Fatalf("first round = %v, want both", fresh)
+	}
+	if fresh := m.markSeen([]store.Anomaly{cur}, "2026-02-15"); len(fresh) != 0 {
+		t.Fatalf("second round = %v, want none", fresh)
+	}
+	m.markSeen(nil, "2026-02-16")
+	if len(m.seen) != 1 || m.seen[cur.Key()] == "" {
+		t.Fatalf("seen = %v, want only the anomaly still in the window", m.seen)
+	}
+}
+
+func TestPushAnswers400OnlyForBadRecords(t *testing.T) {
+	st, err := store.Open(filepath.Join(t.TempDir(), "usage_cache.db"), store.Options{})
+	if err != nil {
+		t.Fatalf("open: %v", err)
+	}
+	defer st.Close()
+	srv := httptest.NewServer(New(st, t.TempDir(), Options{
+		PushTokens: []PushToken{{User: "alice", Token: "alice-token-alice", Hosts: []string{"laptop"}}},
+	}).Handler())
+	defer srv.Close()
+
+	post := func(rec store.PortableRecord) (int, string) {
+		t.Helper()
+		body, _ := json.Marshal(push.Batch{Host: "laptop", Records: []store.PortableRecord{rec}})
+		req, _ := http.NewRequest(http.MethodPost, srv.URL+push.Path, strings.NewReader(string(body)))
+		req.Header.Set("Authorization", "Bearer alice-token-alice")
+		resp, err := http.DefaultClient.Do(req)
+		if err != nil {
+			t.Fatalf("post: %v", err)
+		}
+		defer resp.Body.Close()
+		var e map[string]string
+		json.NewDecoder(resp.Body).Decode(&e)
+		return resp.StatusCode, e["error"]
+	}
+
+	good := store.PortableRecord{Agent: "alpha", Model: "m1", Date: "2026-02-17", SourceFile: "s.jsonl", Tokens: 5}
+	if code, msg := post(store.PortableRecord{Agent: "alpha"}); code != http.StatusBadRequest || !strings.Contains(msg, "model is required") {
+		t.Fatalf("invalid record = %d %q, want 400", code, msg)
+	}
+	if code, msg := post(good); code != http.StatusOK {
+		t.Fatalf("valid record = %d %q, want 200", code, msg)
+	}
+	// A failing cache is not the pusher's fault: it must keep the batch.
+	st.Close()
+	if code, msg := post(good); code != http.StatusInternalServerError || msg != "ingest failed" {
+		t.Fatalf("closed store = %d %q, want 500 with a generic message", code, msg)
+	}
+}
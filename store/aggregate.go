@@ -0,0 +1,216 @@
+package store
+
+import (
+	"database/sql"
+	"fmt"
+	"strings"
+
+	"github.com/yeremiel/claw-usage-chart/api"
+)
+
+// aggDimension is a group-by column. join is added to the FROM clause for
+// dimensions stored outside usage_records; a record then counts toward each
+// of its joined values.
+type aggDimension struct {
+	expr string
+	join string
+}
+
+// aggregateDimensions is the allow-list of group_by names. Values are
+// always strings so "other" and "unknown" buckets fit every dimension.
+var aggregateDimensions = map[string]aggDimension{
+	"agent":       {expr: "agent_name"},
+	"model":       {expr: "model"},
+	"provider":    {expr: "provider"},
+	"role":        {expr: "role"},
+	"stop_reason": {expr: "stop_reason"},
+	"project":     {expr: "project"},
+	"host":        {expr: "CASE WHEN host = '' THEN 'local' ELSE host END"},
+	"user":        {expr: "CASE WHEN user_name = '' THEN 'unknown' ELSE user_name END"},
+	"day":         {expr: "date_key"},
+	"month":       {expr: "CASE WHEN date_key = 'unknown' THEN 'unknown' ELSE substr(date_key, 1, 7) END"},
+	"hour":        {expr: "CASE WHEN hour IS NULL THEN 'unknown' WHEN hour < 10 THEN '0' || CAST(hour AS TEXT) ELSE CAST(hour AS TEXT) END"},
+	"dow":         {expr: "CASE WHEN dow IS NULL THEN 'unknown' ELSE CAST(dow AS TEXT) END"},
+	"tool":        {expr: "ut.tool_name", join: "JOIN usage_tools ut ON ut.record_id = usage_records.id"},
+	"tag":         {expr: "rt.tag", join: "JOIN record_tags rt ON rt.record_id = usage_records.id"},
+}
+
+// aggregateMetrics is the allow-list of metric names.
+var aggregateMetrics = map[string]string{
+	"tokens":         "COALESCE(SUM(usage_records.tokens), 0)",
+	"cost":           "COALESCE(SUM(usage_records.cost), 0.0)",
+	"records":        "COUNT(*)",
+	"tool_calls":     "COALESCE(SUM(usage_records.tool_calls), 0)",
+	"avg_latency_ms": "AVG(usage_records.latency_ms)",
+}
+
+const (
+	maxAggregateDimensions = 4
+	defaultAggregateLimit  = 1000
+	maxAggregateLimit      = 10000
+	otherBucket            = "other"
+)
+
+// AggregateQuery describes one /api/aggregate request.
+type AggregateQuery = api.AggregateQuery
+
+// AggregateResponse is the payload of /api/aggregate.
+type AggregateResponse = api.AggregateResponse
+
+// aggregate groups usage records by any combination of the allow-listed
+// dimensions. Only names from the allow-lists are spliced into SQL; filter
+// values are bound as parameters.
+func aggregate(db *conn, q AggregateQuery) (AggregateResponse, error) {
+	if len(q.GroupBy) == 0 {
+		return AggregateResponse{}, invalidQuery("group_by is required")
+	}
+	if len(q.GroupBy) > maxAggregateDimensions {
+		return AggregateResponse{}, invalidQuery("at most %d group_by dimensions", maxAggregateDimensions)
+	}
+	if len(q.Metrics) == 0 {
+		q.Metrics = []string{"tokens", "cost", "records"}
+	}
+	if q.Top < 0 {
+		return AggregateResponse{}, invalidQuery("top must not be negative")
+	}
+	if q.Limit <= 0 {
+		q.Limit = defaultAggregateLimit
+	}
+	if q.Limit > maxAggregateLimit {
+		q.Limit = maxAggregateLimit
+	}
+
+	seen := map[string]bool{}
+	var dimExprs []string
+	var joins []string
+	for _, name := range q.GroupBy {
+		d, ok := aggregateDimensions[name]
+		if !ok {
+			return AggregateResponse{}, invalidQuery("unknown dimension %q", name)
+		}
+		if seen[name] {
+			return AggregateResponse{}, invalidQuery("dimension %q given twice", name)
+		}
+		seen[name] = true
+		dimExprs = append(dimExprs, d.expr)
+		if d.join != "" {
+			joins = append(joins, d.join)
+		}
+	}
+	var metricExprs []string
+	for _, name := range q.Metrics {
+		m, ok := aggregateMetrics[name]
+		if !ok {
+			return AggregateResponse{}, invalidQuery("unknown metric %q", name)
+		}
+		if seen[name] {
+			return AggregateResponse{}, invalidQuery("metric %q given twice", name)
+		}
+		seen[name] = true
+		metricExprs = append(metricExprs, m)
+	}
+
+	where, params := filterWhere(q.Filter)
+	from := "usage_records " + strings.Join(joins, " ")
+
+	// Top-N: rank the first dimension on the first metric, then fold every
+	// value outside the top into "other" directly in SQL so that averages
+	// are recomputed over the merged rows.
+	if q.Top > 0 {
+		top, err := topDimensionValues(db, dimExprs[0], metricExprs[0], from, where, params, q.Top)
+		if err != nil {
+			return AggregateResponse{}, err
+		}
+		if len(top) > 0 {
+			marks := strings.TrimSuffix(strings.Repeat("?, ", len(top)), ", ")
+			dimExprs[0] = "CASE WHEN " + dimExprs[0] + " IN (" + marks + ") THEN " + dimExprs[0] +
+				" ELSE '" + otherBucket + "' END"
+			params = append(append([]interface{}{}, top...), params...)
+		}
+	}
+
+	// Group and order by position so the bucketing CASE is written once.
+	var groupBy []string
+	for i := range dimExprs {
+		groupBy = append(groupBy, fmt.Sprintf("%d", i+1))
+	}
+	query := `
+		SELECT ` + strings.Join(dimExprs, ", ") + `, ` + strings.Join(metricExprs, ", ") + `
+		FROM ` + from + `
+		WHERE ` + where + `
+		GROUP BY ` + strings.Join(groupBy, ", ") + `
+		ORDER BY ` + fmt.Sprintf("%d", len(dimExprs)+1) + ` DESC, ` + strings.Join(groupBy, ", ") + `
+		LIMIT ?`
+	rows, err := db.Query(query, append(params, q.Limit+1)...)
+	if err != nil {
+		return AggregateResponse{}, fmt.Errorf("aggregate: %w", err)
+	}
+	defer rows.Close()
+
+	resp := AggregateResponse{GroupBy: q.GroupBy, Metrics: q.Metrics, Top: q.Top, Rows: []map[string]interface{}{}}
+	for rows.Next() {
+		dims := make([]sql.NullString, len(q.GroupBy))
+		metrics := make([]sql.NullFloat64, len(q.Metrics))
+		dest := make([]interface{}, 0, len(dims)+len(metrics))
+		for i := range dims {
+			dest = append(dest, &dims[i])
+		}
+		for i := range metrics {
+			dest = append(dest, &metrics[i])
+		}
+		if err := rows.Scan(dest...); err != nil {
+			return AggregateResponse{}, err
+		}
+		if len(resp.Rows) == q.Limit {
+			resp.Truncated = true
+			break
+		}
+
+		row := make(map[string]interface{}, len(dest))
+		for i, name := range q.GroupBy {
+			row[name] = dims[i].String
+		}
+		for i, name := range q.Metrics {
+			row[name] = metricValue(name, metrics[i])
+		}
+		resp.Rows = append(resp.Rows, row)
+	}
+	return resp, rows.Err()
+}
+
+// topDimensionValues returns the n values of expr ranked highest by metric.
+func topDimensionValues(db *conn, expr, metric, from, where string, params []interface{}, n int) ([]interface{}, error) {
+	rows, err := db.Query(`
+		SELECT `+expr+`
+		FROM `+from+`
+		WHERE `+where+`
+		GROUP BY `+expr+`
+		ORDER BY `+metric+` DESC, 1
+		LIMIT ?`, append(append([]interface{}{}, params...), n)...)
+	if err != nil {
+		return nil, fmt.Errorf("aggregate top: %w", err)
+	}
+	defer rows.Close()
+	var top []interface{}
+	for rows.Next() {
+		var v string
+		if err := rows.Scan(&v); err != nil {
+			return nil, err
+		}
+		top = append(top, v)
+	}
+	return top, rows.Err()
+}
+
+func metricValue(name string, v sql.NullFloat64) interface{} {
+	switch {
+	case !v.Valid:
+		return nil
+	case name == "cost":
+		return roundFloat(v.Float64, 6)
+	case name == "avg_latency_ms":
+		return roundFloat(v.Float64, 1)
+	default:
+		return int64(v.Float64)
+	}
+}
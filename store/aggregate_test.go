@@ -0,0 +1,94 @@
+package store
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestAggregateGroupsWithTopNAndOtherBucket(t *testing.T) {
+	tmp := t.TempDir()
+	agentsDir := filepath.Join(tmp, "agents")
+	// Tokens per agent: alpha 60, beta 40, gamma 20, delta 10.
+	for agent, tokens := range map[string][]int{
+		"alpha": {10, 20, 30},
+		"beta":  {15, 25},
+		"gamma": {20},
+		"delta": {10},
+	} {
+		dir := filepath.Join(agentsDir, agent, "sessions")
+		if err := os.MkdirAll(dir, 0o755); err != nil {
+			t.Fatalf("mkdir: %v", err)
+		}
+		var b strings.Builder
+		for i, n := range tokens {
+			model := "m1"
+			if i%2 == 1 {
+				model = "m2"
+			}
+			fmt.Fprintf(&b, `{"timestamp":"2026-02-%02dT10:00:00Z","requestId":"%s-%d","model":"%s","costUsd":0.1,"usage":{"input_tokens":%d}}`+"\n",
+				i+1, agent, i, model, n)
+		}
+		if err := os.WriteFile(filepath.Join(dir, "s.jsonl"), []byte(b.String()), 0o644); err != nil {
+			t.Fatalf("write: %v", err)
+		}
+	}
+
+	st, err := Open(filepath.Join(tmp, "usage_cache.db"), Options{})
+	if err != nil {
+		t.Fatalf("open: %v", err)
+	}
+	defer st.Close()
+	if _, err := st.Sync(agentsDir); err != nil {
+		t.Fatalf("sync: %v", err)
+	}
+
+	resp, err := st.Aggregate(AggregateQuery{GroupBy: []string{"agent"}, Metrics: []string{"tokens", "records"}, Top: 2})
+	if err != nil {
+		t.Fatalf("aggregate: %v", err)
+	}
+	got := map[string]int64{}
+	for _, row := range resp.Rows {
+		got[row["agent"].(string)] = row["tokens"].(int64)
+	}
+	if len(got) != 3 || got["alpha"] != 60 || got["beta"] != 40 || got[otherBucket] != 30 {
+		t.Fatalf("unexpected top-2 buckets: %v", got)
+	}
+	if resp.Rows[0]["agent"] != "alpha" {
+		t.Fatalf("rows not ranked by first metric: %v", resp.Rows)
+	}
+
+	resp, err = st.Aggregate(AggregateQuery{
+		GroupBy: []string{"model", "day"},
+		Metrics: []string{"cost"},
+		Filter:  UsageFilter{Agent: "alpha"},
+	})
+	if err != nil {
+		t.Fatalf("aggregate: %v", err)
+	}
+	if len(resp.Rows) != 3 || resp.Rows[0]["model"] == nil || resp.Rows[0]["day"] == nil {
+		t.Fatalf("unexpected model/day rows: %v", resp.Rows)
+	}
+
+	resp, err = st.Aggregate(AggregateQuery{GroupBy: []string{"agent"}, Limit: 2})
+	if err != nil {
+		t.Fatalf("aggregate: %v", err)
+	}
+	if len(resp.Rows) != 2 || !resp.Truncated {
+		t.Fatalf("expected truncated page of 2, got %d rows (truncated=%v)", len(resp.Rows), resp.Truncated)
+	}
+
+	for _, q := range []AggregateQuery{
+		{},
+		{GroupBy: []string{"agent; DROP TABLE usage_records"}},
+		{GroupBy: []string{"agent", "agent"}},
+		{GroupBy: []string{"agent"}, Metrics: []string{"sum(tokens)"}},
+	} {
+		if _, err := st.Aggregate(q); !errors.Is(err, ErrInvalidQuery) {
+			t.Fatalf("error for %+v = %v, want ErrInvalidQuery", q, err)
+		}
+	}
+}
@@ -0,0 +1,235 @@
+package store
+
+import (
+	"fmt"
+	"math"
+	"sort"
+	"time"
+
+	"github.com/yeremiel/claw-usage-chart/api"
+)
+
+// ─── anomaly detection ───────────────────────────────────────────────────────
+//
+// Spend is rolled up per (agent, model) into daily or hourly buckets and each
+// bucket is compared against a robust baseline built from the buckets before
+// it: the median and the median absolute deviation (MAD). Daily buckets use a
+// rolling window of the preceding days; hourly buckets use a seasonal window
+// made of the same hour on the preceding days, so a busy 10:00 is compared to
+// other 10:00s rather than to the quiet night.
+
+// Anomaly is a rollup bucket whose value sits far above its baseline.
+type Anomaly = api.Anomaly
+
+// AnomalyOptions controls a detection run.
+type AnomalyOptions = api.AnomalyOptions
+
+// spreadFloor keeps a flat history (MAD = 0) from turning tiny wiggles into
+// huge scores. Values are in the unit of the metric.
+func spreadFloor(granularity, metric string) float64 {
+	switch {
+	case metric == "cost" && granularity == "hour":
+		return 0.05
+	case metric == "cost":
+		return 0.25
+	case granularity == "hour":
+		return 5000
+	default:
+		return 20000
+	}
+}
+
+type seriesKey struct {
+	agent string
+	model string
+}
+
+// detectAnomalies scans the rollups in the cache and returns the buckets that
+// exceed their baseline, newest first.
+func detectAnomalies(db *conn, opts AnomalyOptions) ([]Anomaly, error) {
+	opts, err := opts.WithDefaults()
+	if err != nil {
+		return nil, err
+	}
+
+	valueExpr := "COALESCE(SUM(cost),0.0)"
+	if opts.Metric == "tokens" {
+		valueExpr = "COALESCE(SUM(tokens),0)"
+	}
+
+	var query string
+	if opts.Granularity == "hour" {
+		query = `
+			SELECT agent_name, model, date_key, hour, ` + valueExpr + `
+			FROM usage_records
+			WHERE date_key != 'unknown' AND hour IS NOT NULL
+			GROUP BY agent_name, model, date_key, hour`
+	} else {
+		query = `
+			SELECT agent_name, model, date_key, 0, ` + valueExpr + `
+			FROM usage_records
+			WHERE date_key != 'unknown'
+			GROUP BY agent_name, model, date_key`
+	}
+
+	rows, err := db.Query(query)
+	if err != nil {
+		return nil, fmt.Errorf("anomaly rollup: %w", err)
+	}
+	defer rows.Close()
+
+	// Bucket index: days since epoch, times 24 for hourly rollups.
+	series := map[seriesKey]map[int]float64{}
+	first := map[seriesKey]int{}
+	last := math.MinInt
+	for rows.Next() {
+		var k seriesKey
+		var dateKey string
+		var hour int
+		var v float64
+		if err := rows.Scan(&k.agent, &k.model, &dateKey, &hour, &v); err != nil {
+			return nil, err
+		}
+		day, err := time.Parse("2006-01-02", dateKey)
+		if err != nil {
+			continue
+		}
+		idx := int(day.Unix() / 86400)
+		if opts.Granularity == "hour" {
+			idx = idx*24 + hour
+		}
+		if series[k] == nil {
+			series[k] = map[int]float64{}
+			first[k] = idx
+		}
+		series[k][idx] += v
+		if idx < first[k] {
+			first[k] = idx
+		}
+		if idx > last {
+			last = idx
+		}
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	period := 1
+	if opts.Granularity == "hour" {
+		period = 24
+	}
+	floor := spreadFloor(opts.Granularity, opts.Metric)
+
+	var out []Anomaly
+	for k, points := range series {
+		// Dense series from the first observed bucket; idle buckets are zero.
+		values := make([]float64, last-first[k]+1)
+		for idx, v := range points {
+			values[idx-first[k]] = v
+		}
+
+		for i, s := range scoreSeries(values, period, opts.Window, floor) {
+			if s.score < opts.Sensitivity {
+				continue
+			}
+			idx := first[k] + i
+			a := anomalyForBucket(opts, idx, k)
+			if (opts.Start != "" && a.Date < opts.Start) || (opts.End != "" && a.Date > opts.End) {
+				continue
+			}
+			a.Value = roundFloat(values[i], 6)
+			a.Baseline = roundFloat(s.median, 6)
+			a.Spread = roundFloat(s.spread, 6)
+			a.Score = roundFloat(s.score, 2)
+			out = append(out, a)
+		}
+	}
+
+	sort.Slice(out, func(i, j int) bool {
+		if out[i].Bucket != out[j].Bucket {
+			return out[i].Bucket > out[j].Bucket
+		}
+		return out[i].Score > out[j].Score
+	})
+	return out, nil
+}
+
+func anomalyForBucket(opts AnomalyOptions, idx int, k seriesKey) Anomaly {
+	a := Anomaly{
+		Granularity: opts.Granularity,
+		Agent:       k.agent,
+		Model:       k.model,
+		Metric:      opts.Metric,
+	}
+	day := idx
+	if opts.Granularity == "hour" {
+		day = idx / 24
+		h := idx % 24
+		a.Hour = &h
+	}
+	a.Date = time.Unix(int64(day)*86400, 0).UTC().Format("2006-01-02")
+	a.Bucket = a.Date
+	if a.Hour != nil {
+		a.Bucket = fmt.Sprintf("%s %02d:00", a.Date, *a.Hour)
+	}
+	return a
+}
+
+type bucketScore struct {
+	median float64
+	spread float64
+	score  float64
+}
+
+// scoreSeries scores every point of values against the `window` points that
+// precede it at the given period (1 = rolling, 24 = same hour of day).
+// Points without enough history, or not above the median, score zero.
+func scoreSeries(values []float64, period, window int, floor float64) []bucketScore {
+	minPoints := window / 2
+	if minPoints < 3 {
+		minPoints = 3
+	}
+
+	scores := make([]bucketScore, len(values))
+	baseline := make([]float64, 0, window)
+	for i, x := range values {
+		baseline = baseline[:0]
+		for j := 1; j <= window; j++ {
+			p := i - j*period
+			if p < 0 {
+				break
+			}
+			baseline = append(baseline, values[p])
+		}
+		if len(baseline) < minPoints {
+			continue
+		}
+
+		med := median(baseline)
+		devs := make([]float64, len(baseline))
+		for j, b := range baseline {
+			devs[j] = math.Abs(b - med)
+		}
+		// 1.4826 scales the MAD to a standard deviation for normal data.
+		spread := math.Max(1.4826*median(devs), math.Max(0.1*med, floor))
+
+		scores[i] = bucketScore{median: med, spread: spread}
+		if x > med {
+			scores[i].score = (x - med) / spread
+		}
+	}
+	return scores
+}
+
+func median(xs []float64) float64 {
+	if len(xs) == 0 {
+		return 0
+	}
+	s := append([]float64(nil), xs...)
+	sort.Float64s(s)
+	n := len(s)
+	if n%2 == 1 {
+		return s[n/2]
+	}
+	return (s[n/2-1] + s[n/2]) / 2
+}
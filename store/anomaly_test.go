@@ -0,0 +1,104 @@
+package store
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+// writeSpikeFixture writes three weeks of steady daytime usage for two agents
+// and injects one overnight runaway burst for "alpha" on spikeDay.
+func writeSpikeFixture(t *testing.T, agentsDir string, base time.Time, spikeDay int) {
+	t.Helper()
+
+	for _, agent := range []string{"alpha", "beta"} {
+		dir := filepath.Join(agentsDir, agent, "sessions")
+		if err := os.MkdirAll(dir, 0o755); err != nil {
+			t.Fatalf("mkdir %s: %v", dir, err)
+		}
+
+		var b strings.Builder
+		for day := 0; day < 21; day++ {
+			for i, hour := range []int{9, 10, 11, 14} {
+				ts := base.AddDate(0, 0, day).Add(time.Duration(hour) * time.Hour)
+				cost := 0.5 + float64((day+i)%3)*0.05
+				fmt.Fprintf(&b, `{"timestamp":"%s","model":"m1","costUsd":%.2f,"usage":{"input_tokens":%d}}`+"\n",
+					ts.Format(time.RFC3339), cost, 10000+((day+i)%3)*500)
+			}
+			if agent == "alpha" && day == spikeDay {
+				for i := 0; i < 30; i++ {
+					ts := base.AddDate(0, 0, day).Add(3*time.Hour + time.Duration(i)*time.Minute)
+					fmt.Fprintf(&b, `{"timestamp":"%s","model":"m1","costUsd":1.0,"usage":{"input_tokens":40000}}`+"\n",
+						ts.Format(time.RFC3339))
+				}
+			}
+		}
+		if err := os.WriteFile(filepath.Join(dir, "s.jsonl"), []byte(b.String()), 0o644); err != nil {
+			t.Fatalf("write fixture: %v", err)
+		}
+	}
+}
+
+func TestDetectAnomaliesFindsInjectedSpikes(t *testing.T) {
+	tmp := t.TempDir()
+	agentsDir := filepath.Join(tmp, "agents")
+	base := time.Date(2026, 2, 1, 0, 0, 0, 0, time.Local)
+	writeSpikeFixture(t, agentsDir, base, 18)
+
+	st, err := Open(filepath.Join(tmp, "usage_cache.db"), Options{})
+	if err != nil {
+		t.Fatalf("open: %v", err)
+	}
+	defer st.Close()
+	if _, err := st.Sync(agentsDir); err != nil {
+		t.Fatalf("sync: %v", err)
+	}
+
+	spikeDate := base.AddDate(0, 0, 18).Format("2006-01-02")
+
+	for _, tc := range []struct {
+		granularity string
+		metric      string
+		bucket      string
+	}{
+		{"day", "cost", spikeDate},
+		{"day", "tokens", spikeDate},
+		{"hour", "cost", spikeDate + " 03:00"},
+		{"hour", "tokens", spikeDate + " 03:00"},
+	} {
+		got, err := st.Anomalies(AnomalyOptions{Granularity: tc.granularity, Metric: tc.metric})
+		if err != nil {
+			t.Fatalf("%s/%s: %v", tc.granularity, tc.metric, err)
+		}
+		if len(got) != 1 {
+			t.Fatalf("%s/%s: expected 1 anomaly, got %d: %+v", tc.granularity, tc.metric, len(got), got)
+		}
+		if got[0].Agent != "alpha" || got[0].Bucket != tc.bucket {
+			t.Fatalf("%s/%s: unexpected anomaly %+v", tc.granularity, tc.metric, got[0])
+		}
+		if got[0].Value <= got[0].Baseline {
+			t.Fatalf("%s/%s: value %v not above baseline %v", tc.granularity, tc.metric, got[0].Value, got[0].Baseline)
+		}
+	}
+
+	// A very high sensitivity threshold suppresses the spike.
+	got, err := st.Anomalies(AnomalyOptions{Sensitivity: 1e6})
+	if err != nil {
+		t.Fatalf("detect: %v", err)
+	}
+	if len(got) != 0 {
+		t.Fatalf("expected no anomalies at high threshold, got %+v", got)
+	}
+
+	// Start/End only narrow the reported buckets.
+	got, err = st.Anomalies(AnomalyOptions{Start: base.AddDate(0, 0, 19).Format("2006-01-02")})
+	if err != nil {
+		t.Fatalf("detect: %v", err)
+	}
+	if len(got) != 0 {
+		t.Fatalf("expected spike to be outside range, got %+v", got)
+	}
+}
@@ -0,0 +1,174 @@
+package store
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+)
+
+// backupPrefix and backupSuffix frame the file names BackupRotate writes
+// and prunes; other files in the directory are never touched.
+const (
+	backupPrefix = "usage_cache-"
+	backupSuffix = ".db"
+)
+
+// Backup writes a consistent copy of the database to path with VACUUM
+// INTO, while the store stays in use. Only SQLite caches can be backed up
+// this way; use pg_dump for PostgreSQL.
+func (s *SQLStore) Backup(path string) error {
+	if s.db.dialect != sqliteDialect {
+		return errors.New("backup: only SQLite caches are supported; use pg_dump for PostgreSQL")
+	}
+	if _, err := os.Stat(path); err == nil {
+		return fmt.Errorf("backup: %s already exists", path)
+	}
+	if _, err := s.db.Exec("VACUUM INTO ?", path); err != nil {
+		return fmt.Errorf("backup: %w", err)
+	}
+	return nil
+}
+
+// BackupRotate writes a timestamped backup into dir and then deletes all
+// but the newest keep backups there (keep <= 0 keeps everything).
+func (s *SQLStore) BackupRotate(dir string, keep int, now time.Time) (string, error) {
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		return "", err
+	}
+	path := filepath.Join(dir, backupPrefix+now.UTC().Format("20060102T150405Z")+backupSuffix)
+	if err := s.Backup(path); err != nil {
+		return "", err
+	}
+	if keep <= 0 {
+		return path, nil
+	}
+	backups, err := ListBackups(dir)
+	if err != nil {
+		return path, err
+	}
+	for len(backups) > keep {
+		if err := os.Remove(backups[0]); err != nil {
+			return path, err
+		}
+		backups = backups[1:]
+	}
+	return path, nil
+}
+
+// ListBackups returns the backups BackupRotate wrote in dir, oldest first.
+func ListBackups(dir string) ([]string, error) {
+	entries, err := os.ReadDir(dir)
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	var backups []string
+	for _, e := range entries {
+		name := e.Name()
+		if e.Type().IsRegular() && strings.HasPrefix(name, backupPrefix) && strings.HasSuffix(name, backupSuffix) {
+			backups = append(backups, filepath.Join(dir, name))
+		}
+	}
+	sort.Strings(backups) // the timestamp sorts chronologically
+	return backups, nil
+}
+
+// VerifyBackup opens path read-only and checks that SQLite reports no
+// corruption and that it holds a usage cache.
+func VerifyBackup(path string) error {
+	if _, err := os.Stat(path); err != nil {
+		return err
+	}
+	db, err := sql.Open("sqlite", "file:"+path+"?mode=ro")
+	if err != nil {
+		return err
+	}
+	defer db.Close()
+
+	rows, err := db.Query("PRAGMA integrity_check")
+	if err != nil {
+		return fmt.Errorf("%s: %w", path, err)
+	}
+	var problems []string
+	for rows.Next() {
+		var line string
+		if err := rows.Scan(&line); err != nil {
+			rows.Close()
+			return err
+		}
+		if line != "ok" {
+			problems = append(problems, line)
+		}
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return fmt.Errorf("%s: %w", path, err)
+	}
+	if len(problems) > 0 {
+		return fmt.Errorf("%s: integrity check failed: %s", path, strings.Join(problems, "; "))
+	}
+
+	var records int
+	if err := db.QueryRow("SELECT COUNT(*) FROM usage_records").Scan(&records); err != nil {
+		return fmt.Errorf("%s: not a usage cache: %w", path, err)
+	}
+	return nil
+}
+
+// RestoreSQLite replaces the cache at dbPath with a verified copy of
+// backup. The current cache is kept as dbPath+".pre-restore". No process
+// may have dbPath open.
+func RestoreSQLite(backup, dbPath string) error {
+	if err := VerifyBackup(backup); err != nil {
+		return err
+	}
+
+	tmp := dbPath + ".restore-tmp"
+	if err := copyFile(backup, tmp); err != nil {
+		os.Remove(tmp)
+		return err
+	}
+	if _, err := os.Stat(dbPath); err == nil {
+		if err := os.Rename(dbPath, dbPath+".pre-restore"); err != nil {
+			os.Remove(tmp)
+			return err
+		}
+	}
+	// The old WAL belongs to the old file and must not be replayed onto
+	// the restored one.
+	for _, suffix := range []string{"-wal", "-shm"} {
+		if err := os.Remove(dbPath + suffix); err != nil && !os.IsNotExist(err) {
+			return err
+		}
+	}
+	return os.Rename(tmp, dbPath)
+}
+
+func copyFile(src, dst string) error {
+	in, err := os.Open(src)
+	if err != nil {
+		return err
+	}
+	defer in.Close()
+	out, err := os.OpenFile(dst, os.O_CREATE|os.O_WRONLY|os.O_TRUNC, 0o644)
+	if err != nil {
+		return err
+	}
+	if _, err := io.Copy(out, in); err != nil {
+		out.Close()
+		return err
+	}
+	if err := out.Sync(); err != nil {
+		out.Close()
+		return err
+	}
+	return out.Close()
+}
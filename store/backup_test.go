@@ -0,0 +1,145 @@
+package store
+
+import (
+	"database/sql"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestBackupRotateAndRestore(t *testing.T) {
+	tmp := t.TempDir()
+	agentsDir := filepath.Join(tmp, "agents")
+	sessionDir := filepath.Join(agentsDir, "alpha", "sessions")
+	if err := os.MkdirAll(sessionDir, 0o755); err != nil {
+		t.Fatalf("mkdir: %v", err)
+	}
+	writeSessionTokens(t, filepath.Join(sessionDir, "s.jsonl"), []int{10, 20})
+
+	dbPath := filepath.Join(tmp, "usage_cache.db")
+	st, err := Open(dbPath, Options{})
+	if err != nil {
+		t.Fatalf("open: %v", err)
+	}
+	if _, err := st.Sync(agentsDir); err != nil {
+		t.Fatalf("sync: %v", err)
+	}
+
+	backupDir := filepath.Join(tmp, "backups")
+	base := time.Date(2026, 2, 17, 12, 0, 0, 0, time.UTC)
+	var first string
+	for i := 0; i < 3; i++ {
+		path, err := st.BackupRotate(backupDir, 2, base.Add(time.Duration(i)*time.Hour))
+		if err != nil {
+			t.Fatalf("backup %d: %v", i, err)
+		}
+		if i == 0 {
+			first = path
+		}
+	}
+	backups, err := ListBackups(backupDir)
+	if err != nil || len(backups) != 2 {
+		t.Fatalf("backups = %v, %v; want the newest 2", backups, err)
+	}
+	if _, err := os.Stat(first); !os.IsNotExist(err) {
+		t.Fatalf("oldest backup was not pruned: %v", err)
+	}
+	if err := VerifyBackup(backups[1]); err != nil {
+		t.Fatalf("verify: %v", err)
+	}
+
+	// History that is gone from the session files comes back with the backup.
+	writeSessionTokens(t, filepath.Join(sessionDir, "s.jsonl"), []int{5})
+	if _, err := st.Sync(agentsDir); err != nil {
+		t.Fatalf("sync after truncate: %v", err)
+	}
+	st.Close()
+
+	if err := RestoreSQLite(backups[1], dbPath); err != nil {
+		t.Fatalf("restore: %v", err)
+	}
+	if _, err := os.Stat(dbPath + ".pre-restore"); err != nil {
+		t.Fatalf("previous cache not kept: %v", err)
+	}
+	st, err = Open(dbPath, Options{})
+	if err != nil {
+		t.Fatalf("reopen: %v", err)
+	}
+	defer st.Close()
+	assertUsageTotals(t, st.DB(), 2, 30)
+
+	junk := filepath.Join(tmp, "junk.db")
+	if err := os.WriteFile(junk, []byte("not a database"), 0o644); err != nil {
+		t.Fatalf("write junk: %v", err)
+	}
+	if err := RestoreSQLite(junk, dbPath); err == nil {
+		t.Fatal("restore accepted a file that is not a database")
+	}
+}
+
+// TestRestoreOlderBackupKeepsPushedRecords restores a backup written
+// before a column existed. Opening it adds the column and keeps the pushed
+// and imported records, which no local session file could bring back.
+func TestRestoreOlderBackupKeepsPushedRecords(t *testing.T) {
+	tmp := t.TempDir()
+	backup := filepath.Join(tmp, "usage_cache-old.db")
+	old, err := Open(backup, Options{})
+	if err != nil {
+		t.Fatalf("open backup: %v", err)
+	}
+	rec := func(tokens int, offset int64) PortableRecord {
+		return PortableRecord{
+			Agent: "alpha", Model: "m1", Date: "2026-02-17", Tokens: tokens,
+			Provider: "p", Role: "assistant", StopReason: "stop", Project: "x",
+			SourceFile: "/home/ann/s.jsonl", SourceOffset: offset,
+		}
+	}
+	if _, err := old.Ingest("laptop", "ann", []PortableRecord{rec(10, 0), rec(20, 100)}); err != nil {
+		t.Fatalf("ingest: %v", err)
+	}
+	if _, err := old.Import("desktop", "bob", []PortableRecord{rec(5, 0)}); err != nil {
+		t.Fatalf("import: %v", err)
+	}
+	old.Close()
+
+	// Take the cache back to a release without latency_ms or versioning.
+	db, err := sql.Open("sqlite", backup)
+	if err != nil {
+		t.Fatalf("open raw: %v", err)
+	}
+	for _, stmt := range []string{
+		"DROP VIEW v_usage", "DROP VIEW v_usage_tools", "DROP VIEW v_usage_tags",
+		"ALTER TABLE usage_records DROP COLUMN latency_ms",
+		"DELETE FROM cache_meta WHERE key = 'schema_version'",
+	} {
+		if _, err := db.Exec(stmt); err != nil {
+			t.Fatalf("%s: %v", stmt, err)
+		}
+	}
+	db.Close()
+
+	dbPath := filepath.Join(tmp, "usage_cache.db")
+	st, err := Open(dbPath, Options{})
+	if err != nil {
+		t.Fatalf("open: %v", err)
+	}
+	st.Close()
+	if err := RestoreSQLite(backup, dbPath); err != nil {
+		t.Fatalf("restore: %v", err)
+	}
+	st, err = Open(dbPath, Options{})
+	if err != nil {
+		t.Fatalf("open restored: %v", err)
+	}
+	defer st.Close()
+	for host, want := range map[string]int{"": 35, "laptop": 30, "desktop": 5} {
+		stats, err := st.Stats(UsageFilter{Host: host})
+		if err != nil {
+			t.Fatalf("stats %q: %v", host, err)
+		}
+		if stats.Summary.TotalTokens != want {
+			t.Errorf("host %q: %d tokens, want %d", host, stats.Summary.TotalTokens, want)
+		}
+	}
+}
@@ -0,0 +1,61 @@
+package store
+
+import (
+	"fmt"
+	"time"
+)
+
+// Budget is a spend limit for a calendar period, optionally narrowed to one
+// agent and/or model.
+type Budget struct {
+	Name       string    `json:"name"`
+	Period     string    `json:"period"` // "day" or "month"
+	LimitUSD   float64   `json:"limit_usd"`
+	Agent      string    `json:"agent,omitempty"`
+	Model      string    `json:"model,omitempty"`
+	Thresholds []float64 `json:"thresholds,omitempty"` // fractions of the limit; default 0.5, 0.8, 1.0
+}
+
+// BudgetStatus is the spend counted against a budget in its current period.
+type BudgetStatus struct {
+	Name      string  `json:"name"`
+	Period    string  `json:"period"`
+	PeriodKey string  `json:"period_key"` // "YYYY-MM-DD" or "YYYY-MM"
+	Agent     string  `json:"agent,omitempty"`
+	Model     string  `json:"model,omitempty"`
+	LimitUSD  float64 `json:"limit_usd"`
+	SpentUSD  float64 `json:"spent_usd"`
+	Ratio     float64 `json:"ratio"`
+	Threshold float64 `json:"threshold,omitempty"`
+}
+
+// budgetStatus sums the cost matching b for the period containing now.
+func budgetStatus(db *conn, b Budget, now time.Time) (BudgetStatus, error) {
+	now = now.Local()
+	st := BudgetStatus{
+		Name:     b.Name,
+		Period:   b.Period,
+		Agent:    b.Agent,
+		Model:    b.Model,
+		LimitUSD: b.LimitUSD,
+	}
+
+	var start, end string
+	if b.Period == "day" {
+		st.PeriodKey = now.Format("2006-01-02")
+		start, end = st.PeriodKey, st.PeriodKey
+	} else {
+		st.PeriodKey = now.Format("2006-01")
+		start, end = st.PeriodKey+"-01", st.PeriodKey+"-31"
+	}
+
+	where, params := filterWhere(UsageFilter{Start: start, End: end, Agent: b.Agent, Model: b.Model})
+	if err := db.QueryRow(
+		"SELECT COALESCE(SUM(cost),0.0) FROM usage_records WHERE "+where, params...,
+	).Scan(&st.SpentUSD); err != nil {
+		return st, fmt.Errorf("budget %q: %w", b.Name, err)
+	}
+	st.SpentUSD = roundFloat(st.SpentUSD, 6)
+	st.Ratio = roundFloat(st.SpentUSD/b.LimitUSD, 4)
+	return st, nil
+}
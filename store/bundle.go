@@ -0,0 +1,110 @@
+package store
+
+import (
+	"bufio"
+	"compress/gzip"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"time"
+)
+
+// Bundles are gzip-compressed JSON lines: a BundleHeader, then one
+// PortableRecord per line.
+const (
+	BundleFormat  = "claw-usage-bundle"
+	BundleVersion = 1
+)
+
+// BundleHeader is the first line of a bundle. Readers reject versions
+// newer than the one they know.
+type BundleHeader struct {
+	Format     string `json:"format"`
+	Version    int    `json:"version"`
+	ExportedAt string `json:"exported_at"`
+	// Host is the exporting machine's label; importers default to it for
+	// records that were synced there.
+	Host string `json:"host,omitempty"`
+}
+
+// WriteBundle writes every record of s to w and returns how many it wrote.
+func WriteBundle(w io.Writer, s Store, host string) (int, error) {
+	zw := gzip.NewWriter(w)
+	enc := json.NewEncoder(zw)
+	hdr := BundleHeader{
+		Format:     BundleFormat,
+		Version:    BundleVersion,
+		ExportedAt: time.Now().UTC().Format(time.RFC3339),
+		Host:       host,
+	}
+	if err := enc.Encode(hdr); err != nil {
+		return 0, err
+	}
+
+	n := 0
+	var after int64
+	for {
+		recs, err := s.Export(after, 5000)
+		if err != nil {
+			return n, err
+		}
+		if len(recs) == 0 {
+			break
+		}
+		for _, r := range recs {
+			if err := enc.Encode(r); err != nil {
+				return n, err
+			}
+		}
+		n += len(recs)
+		after = recs[len(recs)-1].ID
+	}
+	return n, zw.Close()
+}
+
+// BundleReader reads the records of a bundle written by WriteBundle.
+type BundleReader struct {
+	Header BundleHeader
+
+	zr  *gzip.Reader
+	dec *json.Decoder
+}
+
+// NewBundleReader reads and checks the header.
+func NewBundleReader(r io.Reader) (*BundleReader, error) {
+	zr, err := gzip.NewReader(bufio.NewReader(r))
+	if err != nil {
+		return nil, fmt.Errorf("not a bundle: %w", err)
+	}
+	b := &BundleReader{zr: zr, dec: json.NewDecoder(zr)}
+	if err := b.dec.Decode(&b.Header); err != nil {
+		return nil, fmt.Errorf("bundle header: %w", err)
+	}
+	if b.Header.Format != BundleFormat {
+		return nil, fmt.Errorf("not a bundle (format %q)", b.Header.Format)
+	}
+	if b.Header.Version < 1 || b.Header.Version > BundleVersion {
+		return nil, fmt.Errorf("unsupported bundle version %d (this build reads up to %d)", b.Header.Version, BundleVersion)
+	}
+	return b, nil
+}
+
+// Next returns up to n more records, or none at the end of the bundle.
+func (b *BundleReader) Next(n int) ([]PortableRecord, error) {
+	var recs []PortableRecord
+	for len(recs) < n {
+		var r PortableRecord
+		err := b.dec.Decode(&r)
+		if errors.Is(err, io.EOF) {
+			break
+		}
+		if err != nil {
+			return nil, fmt.Errorf("bundle record: %w", err)
+		}
+		recs = append(recs, r)
+	}
+	return recs, nil
+}
+
+func (b *BundleReader) Close() error { return b.zr.Close() }
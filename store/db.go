@@ -0,0 +1,873 @@
+package store
+
+import (
+	"bufio"
+	"database/sql"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/yeremiel/claw-usage-chart/api"
+	"github.com/yeremiel/claw-usage-chart/parser"
+	_ "modernc.org/sqlite"
+)
+
+const schema = `
+CREATE TABLE IF NOT EXISTS file_state (
+    host        TEXT    NOT NULL DEFAULT '',
+    file_path   TEXT    NOT NULL,
+    agent_name  TEXT    NOT NULL,
+    last_offset INTEGER NOT NULL DEFAULT 0,
+    duplicates  INTEGER NOT NULL DEFAULT 0,
+    cwd         TEXT    NOT NULL DEFAULT '',
+    git_branch  TEXT    NOT NULL DEFAULT '',
+    git_repo    TEXT    NOT NULL DEFAULT '',
+    PRIMARY KEY (host, file_path)
+);
+
+CREATE TABLE IF NOT EXISTS usage_records (
+    id          INTEGER PRIMARY KEY AUTOINCREMENT,
+    agent_name  TEXT    NOT NULL,
+    model       TEXT    NOT NULL,
+    date_key    TEXT    NOT NULL,
+    tokens      INTEGER NOT NULL,
+    cost        REAL    NOT NULL DEFAULT 0.0,
+    hour        INTEGER,
+    dow         INTEGER,
+    ts          INTEGER NOT NULL DEFAULT 0,
+    provider    TEXT    NOT NULL DEFAULT 'unknown',
+    role        TEXT    NOT NULL DEFAULT 'unknown',
+    stop_reason TEXT    NOT NULL DEFAULT 'unknown',
+    tool_calls  INTEGER NOT NULL DEFAULT 0,
+    latency_ms  INTEGER,
+    dedupe_key  TEXT    NOT NULL DEFAULT '',
+    project     TEXT    NOT NULL DEFAULT 'unknown',
+    host        TEXT    NOT NULL DEFAULT '',
+    user_name   TEXT    NOT NULL DEFAULT '',
+    source_file TEXT    NOT NULL,
+    source_offset INTEGER NOT NULL
+);
+
+CREATE TABLE IF NOT EXISTS usage_tools (
+    record_id   INTEGER NOT NULL,
+    tool_name   TEXT    NOT NULL,
+    calls       INTEGER NOT NULL DEFAULT 1,
+    PRIMARY KEY (record_id, tool_name)
+);
+
+CREATE TABLE IF NOT EXISTS record_tags (
+    record_id   INTEGER NOT NULL,
+    tag         TEXT    NOT NULL,
+    PRIMARY KEY (record_id, tag)
+);
+
+CREATE INDEX IF NOT EXISTS idx_rec_agent ON usage_records(agent_name);
+CREATE INDEX IF NOT EXISTS idx_rec_model ON usage_records(model);
+CREATE INDEX IF NOT EXISTS idx_rec_date  ON usage_records(date_key);
+CREATE INDEX IF NOT EXISTS idx_rec_ts    ON usage_records(ts);
+CREATE INDEX IF NOT EXISTS idx_rec_provider ON usage_records(provider);
+CREATE INDEX IF NOT EXISTS idx_rec_dedupe ON usage_records(dedupe_key);
+CREATE INDEX IF NOT EXISTS idx_rec_project ON usage_records(project);
+CREATE INDEX IF NOT EXISTS idx_rec_host ON usage_records(host);
+CREATE INDEX IF NOT EXISTS idx_rec_user ON usage_records(user_name);
+CREATE INDEX IF NOT EXISTS idx_tools_name ON usage_tools(tool_name);
+CREATE INDEX IF NOT EXISTS idx_tags_tag ON record_tags(tag);
+CREATE INDEX IF NOT EXISTS idx_rec_source_file ON usage_records(source_file);
+CREATE UNIQUE INDEX IF NOT EXISTS idx_rec_source_line ON usage_records(host, source_file, source_offset);
+
+CREATE TABLE IF NOT EXISTS cache_meta (
+    key   TEXT PRIMARY KEY,
+    value TEXT NOT NULL
+);
+
+CREATE TABLE IF NOT EXISTS notify_outbox (
+    id            INTEGER PRIMARY KEY AUTOINCREMENT,
+    target        TEXT    NOT NULL,
+    event_key     TEXT    NOT NULL,
+    kind          TEXT    NOT NULL,
+    payload       TEXT    NOT NULL,
+    created_at    INTEGER NOT NULL,
+    attempts      INTEGER NOT NULL DEFAULT 0,
+    next_attempt  INTEGER NOT NULL,
+    delivered_at  INTEGER,
+    failed_at     INTEGER,
+    last_error    TEXT,
+    claimed_by    TEXT    NOT NULL DEFAULT '',
+    claimed_until INTEGER NOT NULL DEFAULT 0
+);
+
+CREATE UNIQUE INDEX IF NOT EXISTS idx_outbox_event ON notify_outbox(target, event_key);
+CREATE INDEX IF NOT EXISTS idx_outbox_pending ON notify_outbox(next_attempt) WHERE delivered_at IS NULL AND failed_at IS NULL;
+`
+
+// views are the documented, stable shape for user SQL (see Query). They
+// are recreated on every open, so their definitions follow the tables
+// while their columns stay put.
+var views = []struct{ name, query string }{
+	{"v_usage", `
+		SELECT id, CASE WHEN host = '' THEN 'local' ELSE host END AS host, user_name,
+		       agent_name AS agent, model, provider, project, role, stop_reason,
+		       date_key AS day, hour, dow, ts, tokens, cost, tool_calls, latency_ms,
+		       source_file, source_offset
+		FROM usage_records`},
+	{"v_usage_tools", `SELECT record_id, tool_name AS tool, calls FROM usage_tools`},
+	{"v_usage_tags", `SELECT record_id, tag FROM record_tags`},
+}
+
+// openDB connects to dsn with the given dialect and brings the schema up
+// to date.
+func openDB(d *dialect, dsn string) (*conn, error) {
+	sqlDB, err := sql.Open(d.driver, d.dsn(dsn))
+	if err != nil {
+		return nil, err
+	}
+	db := &conn{DB: sqlDB, dialect: d}
+	for _, stmt := range d.init {
+		if _, err := db.Exec(stmt); err != nil {
+			db.Close()
+			return nil, err
+		}
+	}
+	if err := ensureSchema(db); err != nil {
+		db.Close()
+		return nil, err
+	}
+	return db, nil
+}
+
+// createViews (re)creates the views in one transaction, so processes
+// opening the database at the same time do not trip over each other.
+func createViews(db *conn) error {
+	tx, err := db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+	for _, v := range views {
+		if _, err := tx.Exec("DROP VIEW IF EXISTS " + v.name); err != nil {
+			return err
+		}
+		if _, err := tx.Exec("CREATE VIEW " + v.name + " AS " + v.query); err != nil {
+			return fmt.Errorf("view %s: %w", v.name, err)
+		}
+	}
+	return tx.Commit()
+}
+
+// querier is what conn and txn have in common for reading.
+type querier interface {
+	Query(query string, args ...interface{}) (*sql.Rows, error)
+}
+
+// tableColumns returns the column names of table; none if it is missing.
+func tableColumns(q querier, d *dialect, table string) (map[string]bool, error) {
+	rows, err := q.Query(d.columns, table)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	cols := map[string]bool{}
+	for rows.Next() {
+		var name string
+		if err := rows.Scan(&name); err != nil {
+			return nil, err
+		}
+		cols[name] = true
+	}
+	return cols, rows.Err()
+}
+
+// SyncResult holds statistics from a sync run.
+type SyncResult = api.SyncResult
+
+// Dedupe policies decide when a parsed line is dropped because an equivalent
+// record is already stored, e.g. when a forked or resumed session file
+// repeats earlier assistant messages.
+const (
+	DedupeOff     = "off"     // count every line
+	DedupeID      = "id"      // drop lines whose message/request ID was already seen
+	DedupeContent = "content" // as "id", and lines without an ID match by content hash
+)
+
+// syncFiles parses only new bytes from JSONL files and persists them to
+// SQLite. Callers must not run it concurrently on the same database.
+func syncFiles(db *conn, opts Options, host, agentsDir string) (SyncResult, error) {
+	files, err := parser.IterSessionFiles(agentsDir)
+	if err != nil {
+		return SyncResult{}, err
+	}
+
+	var result SyncResult
+
+	tx, err := db.Begin()
+	if err != nil {
+		return SyncResult{}, err
+	}
+	defer tx.Rollback()
+
+	if lock := db.dialect.syncLock; lock != "" {
+		if _, err := tx.Exec(lock); err != nil {
+			return SyncResult{}, fmt.Errorf("sync lock: %w", err)
+		}
+	}
+
+	if err := remapProjects(tx, host, opts.Projects); err != nil {
+		return SyncResult{}, fmt.Errorf("remap projects: %w", err)
+	}
+	if err := retagRecords(tx, host, opts.Tags, opts.Projects); err != nil {
+		return SyncResult{}, fmt.Errorf("retag records: %w", err)
+	}
+
+	insertRec, err := tx.Prepare(`
+		INSERT INTO usage_records (agent_name, model, date_key, tokens, cost, hour, dow, ts,
+		                           provider, role, stop_reason, tool_calls, latency_ms, dedupe_key,
+		                           project, host, source_file, source_offset)
+		VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)
+		RETURNING id`)
+	if err != nil {
+		return SyncResult{}, err
+	}
+	defer insertRec.Close()
+
+	insertTool, err := tx.Prepare(`
+		INSERT INTO usage_tools (record_id, tool_name, calls) VALUES (?, ?, ?)`)
+	if err != nil {
+		return SyncResult{}, err
+	}
+	defer insertTool.Close()
+
+	insertTag, err := tx.Prepare("INSERT INTO record_tags (record_id, tag) VALUES (?, ?)")
+	if err != nil {
+		return SyncResult{}, err
+	}
+	defer insertTag.Close()
+
+	findDup, err := tx.Prepare(findDupQuery)
+	if err != nil {
+		return SyncResult{}, err
+	}
+	defer findDup.Close()
+	stmts := syncStmts{
+		insertRec:  insertRec,
+		insertTool: insertTool,
+		insertTag:  insertTag,
+		findDup:    findDup,
+		host:       host,
+		dedupe:     opts.Dedupe,
+		projects:   opts.Projects,
+		tags:       opts.Tags,
+	}
+
+	for _, sf := range files {
+		if _, err := tx.Exec("SAVEPOINT file_sync"); err != nil {
+			return SyncResult{}, fmt.Errorf("savepoint: %w", err)
+		}
+
+		synced, newRecords, dups, err := syncOneFile(tx, stmts, sf)
+		if err != nil {
+			if rbErr := rollbackFileSyncSavepoint(tx); rbErr != nil {
+				return SyncResult{}, fmt.Errorf("rollback savepoint: %w (original: %v)", rbErr, err)
+			}
+			result.SkippedFiles++
+			continue
+		}
+
+		if _, err := tx.Exec("RELEASE SAVEPOINT file_sync"); err != nil {
+			return SyncResult{}, fmt.Errorf("release savepoint: %w", err)
+		}
+
+		if synced {
+			result.SyncedFiles++
+			result.NewRecords += newRecords
+			result.Duplicates += dups
+		} else {
+			result.SkippedFiles++
+		}
+	}
+
+	if err := tx.Commit(); err != nil {
+		return SyncResult{}, err
+	}
+	return result, nil
+}
+
+func rollbackFileSyncSavepoint(tx *txn) error {
+	if _, err := tx.Exec("ROLLBACK TO SAVEPOINT file_sync"); err != nil {
+		return err
+	}
+	_, err := tx.Exec("RELEASE SAVEPOINT file_sync")
+	return err
+}
+
+// syncStmts are the prepared statements shared by every file in a sync run.
+type syncStmts struct {
+	insertRec  *sql.Stmt
+	insertTool *sql.Stmt
+	insertTag  *sql.Stmt
+	findDup    *sql.Stmt
+	host       string // stamped on every record; "" for a private cache
+	dedupe     string
+	projects   []ProjectRule
+	tags       []TagRule
+}
+
+// findDupQuery looks for a stored record with a dedupe key, from the same
+// host or the same (named) user. Keys are computed by whoever sends the
+// record, so a pusher must not be able to suppress another's records.
+const findDupQuery = `
+	SELECT 1 FROM usage_records
+	WHERE dedupe_key = ? AND (host = ? OR (user_name <> '' AND user_name = ?))
+	LIMIT 1`
+
+// isDuplicate reports whether a record with the same dedupe key is already
+// stored for host or user and the policy says such a record should be
+// dropped.
+func (s syncStmts) isDuplicate(key, host, user string) (bool, error) {
+	switch {
+	case s.dedupe == DedupeOff || key == "":
+		return false, nil
+	case s.dedupe == DedupeID && !strings.HasPrefix(key, "id:"):
+		return false, nil
+	}
+	var one int
+	err := s.findDup.QueryRow(key, host, user).Scan(&one)
+	if err == sql.ErrNoRows {
+		return false, nil
+	}
+	return err == nil, err
+}
+
+// syncOneFile applies an incremental update for a single session file.
+// Returns synced=false when there is simply nothing new to process, and the
+// number of records added and of duplicate lines dropped.
+func syncOneFile(tx *txn, stmts syncStmts, sf parser.SessionFile) (bool, int, int, error) {
+	// Get last offset and the session context seen so far
+	var lastOffset int64
+	var hasRow bool
+	var meta parser.SessionMeta
+	if err := claimUnhostedFile(tx, stmts.host, sf.Path); err != nil {
+		return false, 0, 0, err
+	}
+	err := tx.QueryRow(
+		"SELECT last_offset, cwd, git_branch, git_repo FROM file_state WHERE host = ? AND file_path = ?",
+		stmts.host, sf.Path,
+	).Scan(&lastOffset, &meta.Cwd, &meta.GitBranch, &meta.GitRepo)
+	if err == nil {
+		hasRow = true
+	} else if err != sql.ErrNoRows {
+		return false, 0, 0, err
+	}
+
+	// Check current size
+	fi, err := os.Stat(sf.Path)
+	if err != nil {
+		return false, 0, 0, err
+	}
+
+	// File was truncated or rotated: reset offset and re-read from the beginning.
+	if hasRow && fi.Size() < lastOffset {
+		for _, table := range []string{"usage_tools", "record_tags"} {
+			if _, err := tx.Exec(
+				"DELETE FROM "+table+" WHERE record_id IN (SELECT id FROM usage_records WHERE host = ? AND source_file = ?)",
+				stmts.host, sf.Path,
+			); err != nil {
+				return false, 0, 0, err
+			}
+		}
+		if _, err := tx.Exec(
+			"DELETE FROM usage_records WHERE host = ? AND source_file = ?",
+			stmts.host, sf.Path,
+		); err != nil {
+			return false, 0, 0, err
+		}
+		lastOffset = 0
+		meta = parser.SessionMeta{}
+		if _, err := tx.Exec(
+			"UPDATE file_state SET last_offset = 0, duplicates = 0 WHERE host = ? AND file_path = ?",
+			stmts.host, sf.Path,
+		); err != nil {
+			return false, 0, 0, err
+		}
+	}
+
+	if fi.Size() <= lastOffset {
+		return false, 0, 0, nil
+	}
+
+	// Read only new bytes
+	f, err := os.Open(sf.Path)
+	if err != nil {
+		return false, 0, 0, err
+	}
+	defer f.Close()
+
+	if lastOffset > 0 {
+		if _, err := f.Seek(lastOffset, io.SeekStart); err != nil {
+			return false, 0, 0, err
+		}
+	}
+
+	var batchCount, dupCount int
+	newOffset := lastOffset
+	project := resolveProject(stmts.projects, sf.AgentName, meta)
+
+	scanner := bufio.NewScanner(f)
+	scanner.Buffer(make([]byte, 2*1024*1024), 2*1024*1024)
+	for scanner.Scan() {
+		raw := scanner.Bytes()
+		lineOffset := newOffset
+		newOffset += int64(len(raw)) + 1 // +1 for newline
+
+		if m := parser.ParseSessionMeta(raw); m != nil {
+			meta = meta.Merge(*m)
+			project = resolveProject(stmts.projects, sf.AgentName, meta)
+		}
+
+		rec := parser.ParseLine(sf.AgentName, raw)
+		if rec == nil {
+			continue
+		}
+
+		dup, err := stmts.isDuplicate(rec.DedupeKey, stmts.host, "")
+		if err != nil {
+			return false, 0, 0, err
+		}
+		if dup {
+			dupCount++
+			continue
+		}
+
+		var hour, dow, latency interface{}
+		if rec.Hour != nil {
+			hour = *rec.Hour
+		}
+		if rec.DOW != nil {
+			dow = *rec.DOW
+		}
+		if rec.LatencyMs != nil {
+			latency = *rec.LatencyMs
+		}
+
+		var id int64
+		if err := stmts.insertRec.QueryRow(
+			rec.AgentName, rec.Model, rec.DateKey,
+			rec.Tokens, rec.Cost, hour, dow, rec.Timestamp,
+			rec.Provider, rec.Role, rec.StopReason, rec.ToolCalls, latency, rec.DedupeKey,
+			project, stmts.host, sf.Path, lineOffset,
+		).Scan(&id); err != nil {
+			return false, 0, 0, err
+		}
+		if err := insertToolCalls(stmts.insertTool, id, rec.ToolNames); err != nil {
+			return false, 0, 0, err
+		}
+		if len(stmts.tags) > 0 {
+			tags := matchTags(stmts.tags, taggable{
+				agent: rec.AgentName, model: rec.Model, project: project, date: rec.DateKey, source: sf.Path,
+			})
+			if err := insertTags(stmts.insertTag, id, tags); err != nil {
+				return false, 0, 0, err
+			}
+		}
+		batchCount++
+	}
+
+	if err := scanner.Err(); err != nil {
+		return false, 0, 0, err
+	}
+
+	// Update or insert file_state
+	if hasRow {
+		if _, err := tx.Exec(
+			`UPDATE file_state SET last_offset = ?, duplicates = duplicates + ?,
+			        cwd = ?, git_branch = ?, git_repo = ? WHERE host = ? AND file_path = ?`,
+			newOffset, dupCount, meta.Cwd, meta.GitBranch, meta.GitRepo, stmts.host, sf.Path,
+		); err != nil {
+			return false, 0, 0, err
+		}
+	} else {
+		if _, err := tx.Exec(
+			`INSERT INTO file_state (host, file_path, agent_name, last_offset, duplicates, cwd, git_branch, git_repo)
+			 VALUES (?, ?, ?, ?, ?, ?, ?, ?)`,
+			stmts.host, sf.Path, sf.AgentName, newOffset, dupCount, meta.Cwd, meta.GitBranch, meta.GitRepo,
+		); err != nil {
+			return false, 0, 0, err
+		}
+	}
+
+	return true, batchCount, dupCount, nil
+}
+
+// claimUnhostedFile gives host the records and read position of a file
+// synced before records were stamped with their host, so a shared database
+// upgraded in place does not read the file again. The first host to sync
+// the path claims it.
+func claimUnhostedFile(tx *txn, host, path string) error {
+	if host == "" {
+		return nil
+	}
+	res, err := tx.Exec(`
+		UPDATE file_state SET host = ?
+		WHERE host = '' AND file_path = ?
+		  AND NOT EXISTS (SELECT 1 FROM file_state WHERE host = ? AND file_path = ?)`,
+		host, path, host, path)
+	if err != nil {
+		return err
+	}
+	if n, err := res.RowsAffected(); err != nil || n == 0 {
+		return err
+	}
+	_, err = tx.Exec("UPDATE usage_records SET host = ? WHERE host = '' AND source_file = ?", host, path)
+	return err
+}
+
+// insertToolCalls stores one usage_tools row per distinct tool name.
+func insertToolCalls(stmt *sql.Stmt, recordID int64, names []string) error {
+	counts := map[string]int{}
+	var order []string
+	for _, n := range names {
+		if counts[n] == 0 {
+			order = append(order, n)
+		}
+		counts[n]++
+	}
+	for _, n := range order {
+		if _, err := stmt.Exec(recordID, n, counts[n]); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// ─── aggregation types ────────────────────────────────────────────────────────
+
+// The aggregation types are the wire types of the API; see package api.
+type (
+	AgentTotal    = api.AgentTotal
+	ModelTotal    = api.ModelTotal
+	ProjectTotal  = api.ProjectTotal
+	ProviderTotal = api.ProviderTotal
+	ToolTotal     = api.ToolTotal
+	TagTotal      = api.TagTotal
+	DailyTokens   = api.DailyTokens
+	HeatmapCell   = api.HeatmapCell
+	Summary       = api.Summary
+	StatsResponse = api.StatsResponse
+)
+
+// UsageFilter narrows queries over usage_records. Zero fields match everything.
+type UsageFilter = api.UsageFilter
+
+// filterWhere returns a SQL condition (never empty) and its parameters.
+// If a date range is provided, unknown dates are excluded so presets like
+// "today/7d/30d" align with user expectations in the UI.
+func filterWhere(f UsageFilter) (string, []interface{}) {
+	parts := []string{}
+	var params []interface{}
+
+	rangeParts := []string{}
+	if f.Start != "" {
+		rangeParts = append(rangeParts, "date_key >= ?")
+		params = append(params, f.Start)
+	}
+	if f.End != "" {
+		rangeParts = append(rangeParts, "date_key <= ?")
+		params = append(params, f.End)
+	}
+	if len(rangeParts) > 0 {
+		parts = append(parts, "date_key != 'unknown' AND ("+strings.Join(rangeParts, " AND ")+")")
+	}
+	if f.Agent != "" {
+		parts = append(parts, "agent_name = ?")
+		params = append(params, f.Agent)
+	}
+	if f.Model != "" {
+		parts = append(parts, "model = ?")
+		params = append(params, f.Model)
+	}
+	if f.Provider != "" {
+		parts = append(parts, "provider = ?")
+		params = append(params, f.Provider)
+	}
+	if f.Role != "" {
+		parts = append(parts, "role = ?")
+		params = append(params, f.Role)
+	}
+	if f.StopReason != "" {
+		parts = append(parts, "stop_reason = ?")
+		params = append(params, f.StopReason)
+	}
+	if f.Project != "" {
+		parts = append(parts, "project = ?")
+		params = append(params, f.Project)
+	}
+	if f.Host != "" {
+		parts = append(parts, "host = ?")
+		params = append(params, hostColumnValue(f.Host))
+	}
+	if f.User != "" {
+		parts = append(parts, "user_name = ?")
+		params = append(params, userColumnValue(f.User))
+	}
+	if f.Tool != "" {
+		parts = append(parts, "EXISTS (SELECT 1 FROM usage_tools ut WHERE ut.record_id = usage_records.id AND ut.tool_name = ?)")
+		params = append(params, f.Tool)
+	}
+	if f.Tag != "" {
+		parts = append(parts, "EXISTS (SELECT 1 FROM record_tags rt WHERE rt.record_id = usage_records.id AND rt.tag = ?)")
+		params = append(params, f.Tag)
+	}
+
+	if len(parts) == 0 {
+		return "1=1", nil // no filter
+	}
+	return strings.Join(parts, " AND "), params
+}
+
+// collectStats aggregates data from the SQLite cache.
+func collectStats(db *conn, filter UsageFilter) (StatsResponse, error) {
+	dateWhere, dateParams := filterWhere(filter)
+
+	// ── totals ────────────────────────────────────────────────────────────────
+	var totalRecords, totalTokens int
+	var totalCost float64
+	if err := db.QueryRow(
+		`SELECT COUNT(*), COALESCE(SUM(tokens),0), COALESCE(SUM(cost),0.0)
+		 FROM usage_records WHERE `+dateWhere, dateParams...,
+	).Scan(&totalRecords, &totalTokens, &totalCost); err != nil {
+		return StatsResponse{}, fmt.Errorf("totals: %w", err)
+	}
+
+	// ── session file count ────────────────────────────────────────────────────
+	var sessionFiles, duplicates int
+	if err := db.QueryRow(
+		"SELECT COUNT(*), COALESCE(SUM(duplicates),0) FROM file_state",
+	).Scan(&sessionFiles, &duplicates); err != nil {
+		return StatsResponse{}, fmt.Errorf("file count: %w", err)
+	}
+
+	// ── per-agent ─────────────────────────────────────────────────────────────
+	rows, err := db.Query(`
+		SELECT agent_name, COALESCE(SUM(tokens),0), COUNT(*), COALESCE(SUM(cost),0.0)
+		FROM usage_records
+		WHERE `+dateWhere+`
+		GROUP BY agent_name
+		ORDER BY SUM(tokens) DESC`, dateParams...)
+	if err != nil {
+		return StatsResponse{}, fmt.Errorf("agent totals: %w", err)
+	}
+	var agentTotals []AgentTotal
+	for rows.Next() {
+		var a AgentTotal
+		if err := rows.Scan(&a.Agent, &a.Tokens, &a.Records, &a.Cost); err == nil {
+			a.Cost = roundFloat(a.Cost, 6)
+			agentTotals = append(agentTotals, a)
+		}
+	}
+	rows.Close()
+
+	// ── per-model ─────────────────────────────────────────────────────────────
+	rows, err = db.Query(`
+		SELECT model, COALESCE(SUM(tokens),0), COUNT(*), COALESCE(SUM(cost),0.0)
+		FROM usage_records
+		WHERE `+dateWhere+`
+		GROUP BY model
+		ORDER BY SUM(tokens) DESC`, dateParams...)
+	if err != nil {
+		return StatsResponse{}, fmt.Errorf("model totals: %w", err)
+	}
+	var modelTotals []ModelTotal
+	for rows.Next() {
+		var m ModelTotal
+		if err := rows.Scan(&m.Model, &m.Tokens, &m.Records, &m.Cost); err == nil {
+			m.Cost = roundFloat(m.Cost, 6)
+			modelTotals = append(modelTotals, m)
+		}
+	}
+	rows.Close()
+
+	// ── per-project ───────────────────────────────────────────────────────────
+	rows, err = db.Query(`
+		SELECT project, COALESCE(SUM(tokens),0), COUNT(*), COALESCE(SUM(cost),0.0)
+		FROM usage_records
+		WHERE `+dateWhere+`
+		GROUP BY project
+		ORDER BY SUM(cost) DESC, SUM(tokens) DESC`, dateParams...)
+	if err != nil {
+		return StatsResponse{}, fmt.Errorf("project totals: %w", err)
+	}
+	var projectTotals []ProjectTotal
+	for rows.Next() {
+		var p ProjectTotal
+		if err := rows.Scan(&p.Project, &p.Tokens, &p.Records, &p.Cost); err == nil {
+			p.Cost = roundFloat(p.Cost, 6)
+			projectTotals = append(projectTotals, p)
+		}
+	}
+	rows.Close()
+
+	// ── per-provider ──────────────────────────────────────────────────────────
+	rows, err = db.Query(`
+		SELECT provider, COALESCE(SUM(tokens),0), COUNT(*), COALESCE(SUM(cost),0.0)
+		FROM usage_records
+		WHERE `+dateWhere+`
+		GROUP BY provider
+		ORDER BY SUM(tokens) DESC`, dateParams...)
+	if err != nil {
+		return StatsResponse{}, fmt.Errorf("provider totals: %w", err)
+	}
+	var providerTotals []ProviderTotal
+	for rows.Next() {
+		var p ProviderTotal
+		if err := rows.Scan(&p.Provider, &p.Tokens, &p.Records, &p.Cost); err == nil {
+			p.Cost = roundFloat(p.Cost, 6)
+			providerTotals = append(providerTotals, p)
+		}
+	}
+	rows.Close()
+
+	// ── per-tool (most expensive turns first) ─────────────────────────────────
+	rows, err = db.Query(`
+		SELECT t.tool_name, COALESCE(SUM(t.calls),0), COUNT(*),
+		       COALESCE(SUM(usage_records.tokens),0), COALESCE(SUM(usage_records.cost),0.0)
+		FROM usage_tools t
+		JOIN usage_records ON usage_records.id = t.record_id
+		WHERE `+dateWhere+`
+		GROUP BY t.tool_name
+		ORDER BY SUM(usage_records.cost) DESC, SUM(usage_records.tokens) DESC`, dateParams...)
+	if err != nil {
+		return StatsResponse{}, fmt.Errorf("tool totals: %w", err)
+	}
+	var toolTotals []ToolTotal
+	for rows.Next() {
+		var t ToolTotal
+		if err := rows.Scan(&t.Tool, &t.Calls, &t.Turns, &t.Tokens, &t.Cost); err == nil {
+			t.Cost = roundFloat(t.Cost, 6)
+			toolTotals = append(toolTotals, t)
+		}
+	}
+	rows.Close()
+
+	// ── per-tag ───────────────────────────────────────────────────────────────
+	rows, err = db.Query(`
+		SELECT t.tag, COALESCE(SUM(usage_records.tokens),0), COUNT(*), COALESCE(SUM(usage_records.cost),0.0)
+		FROM record_tags t
+		JOIN usage_records ON usage_records.id = t.record_id
+		WHERE `+dateWhere+`
+		GROUP BY t.tag
+		ORDER BY SUM(usage_records.cost) DESC, SUM(usage_records.tokens) DESC`, dateParams...)
+	if err != nil {
+		return StatsResponse{}, fmt.Errorf("tag totals: %w", err)
+	}
+	var tagTotals []TagTotal
+	for rows.Next() {
+		var t TagTotal
+		if err := rows.Scan(&t.Tag, &t.Tokens, &t.Records, &t.Cost); err == nil {
+			t.Cost = roundFloat(t.Cost, 6)
+			tagTotals = append(tagTotals, t)
+		}
+	}
+	rows.Close()
+
+	// ── daily series ──────────────────────────────────────────────────────────
+	rows, err = db.Query(`
+		SELECT date_key, COALESCE(SUM(tokens),0), COUNT(*), COALESCE(SUM(cost),0.0)
+		FROM usage_records
+		WHERE `+dateWhere+`
+		GROUP BY date_key
+		ORDER BY
+		    CASE WHEN date_key = 'unknown' THEN 1 ELSE 0 END,
+		    date_key`, dateParams...)
+	if err != nil {
+		return StatsResponse{}, fmt.Errorf("daily: %w", err)
+	}
+	var daily []DailyTokens
+	for rows.Next() {
+		var d DailyTokens
+		if err := rows.Scan(&d.Date, &d.Tokens, &d.Records, &d.Cost); err == nil {
+			d.Cost = roundFloat(d.Cost, 6)
+			daily = append(daily, d)
+		}
+	}
+	rows.Close()
+
+	// ── heatmap ───────────────────────────────────────────────────────────────
+	heatWhere := "hour IS NOT NULL AND dow IS NOT NULL AND (" + dateWhere + ")"
+	rows, err = db.Query(`
+		SELECT dow, hour, COALESCE(SUM(tokens),0), COALESCE(SUM(cost),0.0)
+		FROM usage_records
+		WHERE `+heatWhere+`
+		GROUP BY dow, hour
+		ORDER BY dow, hour`, dateParams...)
+	if err != nil {
+		return StatsResponse{}, fmt.Errorf("heatmap: %w", err)
+	}
+	var heatmap []HeatmapCell
+	for rows.Next() {
+		var h HeatmapCell
+		if err := rows.Scan(&h.DOW, &h.Hour, &h.Tokens, &h.Cost); err == nil {
+			h.Cost = roundFloat(h.Cost, 6)
+			heatmap = append(heatmap, h)
+		}
+	}
+	rows.Close()
+
+	// Ensure slices are never nil (JSON [] not null)
+	if agentTotals == nil {
+		agentTotals = []AgentTotal{}
+	}
+	if modelTotals == nil {
+		modelTotals = []ModelTotal{}
+	}
+	if projectTotals == nil {
+		projectTotals = []ProjectTotal{}
+	}
+	if providerTotals == nil {
+		providerTotals = []ProviderTotal{}
+	}
+	if toolTotals == nil {
+		toolTotals = []ToolTotal{}
+	}
+	if tagTotals == nil {
+		tagTotals = []TagTotal{}
+	}
+	if daily == nil {
+		daily = []DailyTokens{}
+	}
+	if heatmap == nil {
+		heatmap = []HeatmapCell{}
+	}
+
+	return StatsResponse{
+		GeneratedAt: time.Now().UTC().Format(time.RFC3339),
+		Cached:      true,
+		Summary: Summary{
+			TotalTokens:  totalTokens,
+			TotalCost:    roundFloat(totalCost, 6),
+			UsageRecords: totalRecords,
+			SessionFiles: sessionFiles,
+			Duplicates:   duplicates,
+			AgentCount:   len(agentTotals),
+			ModelCount:   len(modelTotals),
+			DayCount:     len(daily),
+		},
+		AgentTotals:    agentTotals,
+		ModelTotals:    modelTotals,
+		ProjectTotals:  projectTotals,
+		ProviderTotals: providerTotals,
+		ToolTotals:     toolTotals,
+		TagTotals:      tagTotals,
+		DailyTokens:    daily,
+		Heatmap:        heatmap,
+	}, nil
+}
+
+func roundFloat(f float64, precision int) float64 {
+	// Simple rounding to N decimal places
+	p := 1.0
+	for i := 0; i < precision; i++ {
+		p *= 10
+	}
+	return float64(int(f*p+0.5)) / p
+}
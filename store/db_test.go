@@ -0,0 +1,294 @@
+package store
+
+import (
+	"database/sql"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestSyncTruncateReplacesFileRecordsWithoutDuplication(t *testing.T) {
+	tmp := t.TempDir()
+	agentsDir := filepath.Join(tmp, "agents")
+	sessionDir := filepath.Join(agentsDir, "alpha", "sessions")
+	if err := os.MkdirAll(sessionDir, 0o755); err != nil {
+		t.Fatalf("mkdir session dir: %v", err)
+	}
+
+	fileA := filepath.Join(sessionDir, "a.jsonl")
+	fileB := filepath.Join(sessionDir, "b.jsonl")
+	writeSessionTokens(t, fileA, []int{10, 20})
+	writeSessionTokens(t, fileB, []int{5})
+
+	dbPath := filepath.Join(tmp, "usage_cache.db")
+	st, err := Open(dbPath, Options{})
+	if err != nil {
+		t.Fatalf("open: %v", err)
+	}
+	defer st.Close()
+
+	if _, err := st.Sync(agentsDir); err != nil {
+		t.Fatalf("first sync: %v", err)
+	}
+	assertUsageTotals(t, st.DB(), 3, 35)
+
+	// Truncate/rewrite fileA with different content.
+	writeSessionTokens(t, fileA, []int{7})
+	if _, err := st.Sync(agentsDir); err != nil {
+		t.Fatalf("sync after truncate: %v", err)
+	}
+	assertUsageTotals(t, st.DB(), 2, 12)
+
+	var fileARows int
+	if err := st.DB().QueryRow("SELECT COUNT(*) FROM usage_records WHERE source_file = ?", fileA).Scan(&fileARows); err != nil {
+		t.Fatalf("count rows for fileA: %v", err)
+	}
+	if fileARows != 1 {
+		t.Fatalf("expected 1 row for truncated file, got %d", fileARows)
+	}
+
+	if _, err := st.Sync(agentsDir); err != nil {
+		t.Fatalf("third sync: %v", err)
+	}
+	assertUsageTotals(t, st.DB(), 2, 12)
+}
+
+func TestStatsWithToolFilter(t *testing.T) {
+	tmp := t.TempDir()
+	sessionDir := filepath.Join(tmp, "agents", "alpha", "sessions")
+	if err := os.MkdirAll(sessionDir, 0o755); err != nil {
+		t.Fatalf("mkdir session dir: %v", err)
+	}
+	lines := `{"type":"assistant","timestamp":"2026-02-17T10:00:00Z","message":{"role":"assistant","model":"m",` +
+		`"stop_reason":"tool_use","content":[{"type":"tool_use","name":"Bash"}],"usage":{"input_tokens":7}}}` + "\n" +
+		`{"timestamp":"2026-02-17T10:01:00Z","model":"m","usage":{"input_tokens":5}}` + "\n"
+	if err := os.WriteFile(filepath.Join(sessionDir, "s.jsonl"), []byte(lines), 0o644); err != nil {
+		t.Fatalf("write session: %v", err)
+	}
+
+	st, err := Open(filepath.Join(tmp, "usage_cache.db"), Options{})
+	if err != nil {
+		t.Fatalf("open: %v", err)
+	}
+	defer st.Close()
+
+	if _, err := st.Sync(filepath.Join(tmp, "agents")); err != nil {
+		t.Fatalf("sync: %v", err)
+	}
+	stats, err := st.Stats(UsageFilter{Tool: "Bash"})
+	if err != nil {
+		t.Fatalf("stats with tool filter: %v", err)
+	}
+	if stats.Summary.TotalTokens != 7 || len(stats.ToolTotals) != 1 || stats.ToolTotals[0].Tool != "Bash" {
+		t.Fatalf("unexpected stats: summary %+v, tools %+v", stats.Summary, stats.ToolTotals)
+	}
+}
+
+func assertUsageTotals(t *testing.T, db *sql.DB, wantCount, wantTokens int) {
+	t.Helper()
+
+	var gotCount, gotTokens int
+	if err := db.QueryRow(
+		"SELECT COUNT(*), COALESCE(SUM(tokens), 0) FROM usage_records",
+	).Scan(&gotCount, &gotTokens); err != nil {
+		t.Fatalf("query totals: %v", err)
+	}
+	if gotCount != wantCount || gotTokens != wantTokens {
+		t.Fatalf("totals mismatch: got count=%d tokens=%d, want count=%d tokens=%d",
+			gotCount, gotTokens, wantCount, wantTokens)
+	}
+}
+
+// TestSyncKeepsHostsApart syncs one path as two hosts of a shared database:
+// each keeps its own read position, and a truncation seen by one host
+// leaves the other host's records alone.
+func TestSyncKeepsHostsApart(t *testing.T) {
+	tmp := t.TempDir()
+	agentsDir := filepath.Join(tmp, "agents")
+	sessionDir := filepath.Join(agentsDir, "alpha", "sessions")
+	if err := os.MkdirAll(sessionDir, 0o755); err != nil {
+		t.Fatalf("mkdir session dir: %v", err)
+	}
+	file := filepath.Join(sessionDir, "s.jsonl")
+
+	st, err := Open(filepath.Join(tmp, "usage_cache.db"), Options{})
+	if err != nil {
+		t.Fatalf("open: %v", err)
+	}
+	defer st.Close()
+	sync := func(host string) {
+		t.Helper()
+		if _, err := syncFiles(st.db, st.opts, host, agentsDir); err != nil {
+			t.Fatalf("sync as %q: %v", host, err)
+		}
+	}
+
+	// Records synced before hosts were stamped are claimed, not re-read.
+	writeSessionTokens(t, file, []int{10, 20})
+	sync("")
+	sync("a")
+	assertUsageTotals(t, st.DB(), 2, 30)
+
+	writeSessionTokens(t, file, []int{5, 6})
+	sync("b")
+	assertUsageTotals(t, st.DB(), 4, 41)
+
+	writeSessionTokens(t, file, []int{3})
+	sync("b")
+	assertUsageTotals(t, st.DB(), 3, 33)
+
+	var aRows, positions int
+	if err := st.DB().QueryRow("SELECT COUNT(*) FROM usage_records WHERE host = 'a'").Scan(&aRows); err != nil {
+		t.Fatalf("count: %v", err)
+	}
+	if err := st.DB().QueryRow("SELECT COUNT(*) FROM file_state WHERE file_path = ?", file).Scan(&positions); err != nil {
+		t.Fatalf("count: %v", err)
+	}
+	if aRows != 2 || positions != 2 {
+		t.Fatalf("host a rows = %d, file positions = %d; want 2 and 2", aRows, positions)
+	}
+}
+
+func writeSessionTokens(t *testing.T, path string, tokens []int) {
+	t.Helper()
+
+	var b strings.Builder
+	for i, tok := range tokens {
+		ts := time.Date(2026, 2, 17, 0, i, 0, 0, time.UTC).Format(time.RFC3339)
+		fmt.Fprintf(&b, `{"timestamp":"%s","model":"test-model","usage":{"input_tokens":%d,"output_tokens":0}}`+"\n", ts, tok)
+	}
+	if err := os.WriteFile(path, []byte(b.String()), 0o644); err != nil {
+		t.Fatalf("write %s: %v", path, err)
+	}
+}
+
+func TestSyncDedupesForkedSessionLines(t *testing.T) {
+	tmp := t.TempDir()
+	agentsDir := filepath.Join(tmp, "agents")
+	sessionDir := filepath.Join(agentsDir, "alpha", "sessions")
+	if err := os.MkdirAll(sessionDir, 0o755); err != nil {
+		t.Fatalf("mkdir session dir: %v", err)
+	}
+
+	// The fork repeats both lines of the original and adds one of its own.
+	original := `{"timestamp":"2026-02-17T00:00:00Z","message":{"id":"msg_1","usage":{"input_tokens":10}}}` + "\n" +
+		`{"timestamp":"2026-02-17T00:01:00Z","usage":{"input_tokens":20}}` + "\n"
+	fork := original + `{"timestamp":"2026-02-17T00:02:00Z","message":{"id":"msg_3","usage":{"input_tokens":5}}}` + "\n"
+	if err := os.WriteFile(filepath.Join(sessionDir, "a.jsonl"), []byte(original), 0o644); err != nil {
+		t.Fatalf("write original: %v", err)
+	}
+	if err := os.WriteFile(filepath.Join(sessionDir, "b.jsonl"), []byte(fork), 0o644); err != nil {
+		t.Fatalf("write fork: %v", err)
+	}
+
+	for _, tc := range []struct {
+		policy     string
+		wantCount  int
+		wantTokens int
+		wantDups   int
+	}{
+		{DedupeContent, 3, 35, 2},
+		{DedupeID, 4, 55, 1}, // the ID-less line is counted twice
+		{DedupeOff, 5, 65, 0},
+	} {
+		t.Run(tc.policy, func(t *testing.T) {
+			st, err := Open(filepath.Join(t.TempDir(), "usage_cache.db"), Options{Dedupe: tc.policy})
+			if err != nil {
+				t.Fatalf("open: %v", err)
+			}
+			defer st.Close()
+
+			res, err := st.Sync(agentsDir)
+			if err != nil {
+				t.Fatalf("sync: %v", err)
+			}
+			if res.Duplicates != tc.wantDups {
+				t.Fatalf("sync reported %d duplicates, want %d", res.Duplicates, tc.wantDups)
+			}
+			assertUsageTotals(t, st.DB(), tc.wantCount, tc.wantTokens)
+
+			stats, err := st.Stats(UsageFilter{})
+			if err != nil {
+				t.Fatalf("stats: %v", err)
+			}
+			if stats.Summary.Duplicates != tc.wantDups {
+				t.Fatalf("summary reports %d duplicates, want %d", stats.Summary.Duplicates, tc.wantDups)
+			}
+		})
+	}
+}
+
+func TestSyncKeepsIdenticalLinesFromDifferentAgents(t *testing.T) {
+	tmp := t.TempDir()
+	agentsDir := filepath.Join(tmp, "agents")
+	line := `{"timestamp":"2026-02-17T00:00:00Z","model":"m1","usage":{"input_tokens":10}}` + "\n"
+	for _, agent := range []string{"alpha", "beta"} {
+		dir := filepath.Join(agentsDir, agent, "sessions")
+		if err := os.MkdirAll(dir, 0o755); err != nil {
+			t.Fatalf("mkdir: %v", err)
+		}
+		if err := os.WriteFile(filepath.Join(dir, "s.jsonl"), []byte(line), 0o644); err != nil {
+			t.Fatalf("write: %v", err)
+		}
+	}
+
+	st, err := Open(filepath.Join(tmp, "usage_cache.db"), Options{Dedupe: DedupeContent})
+	if err != nil {
+		t.Fatalf("open: %v", err)
+	}
+	defer st.Close()
+	res, err := st.Sync(agentsDir)
+	if err != nil {
+		t.Fatalf("sync: %v", err)
+	}
+	if res.Duplicates != 0 {
+		t.Fatalf("sync dropped %d lines as duplicates across agents", res.Duplicates)
+	}
+	assertUsageTotals(t, st.DB(), 2, 20)
+}
+
+func TestWatermarkMovesWithContent(t *testing.T) {
+	tmp := t.TempDir()
+	agentsDir := filepath.Join(tmp, "agents")
+	sessionDir := filepath.Join(agentsDir, "alpha", "sessions")
+	if err := os.MkdirAll(sessionDir, 0o755); err != nil {
+		t.Fatalf("mkdir session dir: %v", err)
+	}
+	file := filepath.Join(sessionDir, "a.jsonl")
+	writeSessionTokens(t, file, []int{10})
+
+	st, err := Open(filepath.Join(tmp, "usage_cache.db"), Options{})
+	if err != nil {
+		t.Fatalf("open: %v", err)
+	}
+	defer st.Close()
+
+	watermark := func() string {
+		t.Helper()
+		if _, err := st.Sync(agentsDir); err != nil {
+			t.Fatalf("sync: %v", err)
+		}
+		w, err := st.Watermark()
+		if err != nil {
+			t.Fatalf("watermark: %v", err)
+		}
+		return w
+	}
+
+	first := watermark()
+	if again := watermark(); again != first {
+		t.Fatalf("watermark moved without changes: %q → %q", first, again)
+	}
+	writeSessionTokens(t, file, []int{10, 20})
+	appended := watermark()
+	if appended == first {
+		t.Fatalf("watermark unchanged after new records: %q", appended)
+	}
+	st.SetOptions(Options{Tags: []TagRule{{Tags: []string{"team:a"}, Agent: "alpha"}}})
+	if retagged := watermark(); retagged == appended {
+		t.Fatalf("watermark unchanged after tag rules changed: %q", retagged)
+	}
+}
@@ -0,0 +1,162 @@
+package store
+
+import (
+	"database/sql"
+	"strconv"
+	"strings"
+
+	_ "github.com/lib/pq"
+)
+
+// dialect captures what differs between the SQL backends. Queries are
+// written once with "?" placeholders and portable SQL (ON CONFLICT,
+// RETURNING, CASE, substr, ||); the dialect only rebinds placeholders and
+// supplies backend-specific DDL and housekeeping statements.
+type dialect struct {
+	name   string
+	driver string
+
+	// dollarParams rebinds "?" to "$1", "$2", ...
+	dollarParams bool
+
+	// dsn adapts the caller's path or URL, e.g. to set per-connection options.
+	dsn func(string) string
+	// init runs once after connecting.
+	init []string
+	// schemaTypes rewrites the column types of the shared schema.
+	schemaTypes *strings.Replacer
+	// columns lists a table's column names; its one parameter is the table.
+	columns string
+	// tables lists the tables and views.
+	tables string
+	// readOnlyDSN opens a second, read-only handle for user queries; nil
+	// when they need a separately configured role (Options.QueryDSN).
+	readOnlyDSN func(string) string
+	// readableTables lists the tables and views the connected role may
+	// SELECT; empty when the read-only handle is not a separate role.
+	readableTables string
+	// syncLock serialises Sync across processes sharing the database;
+	// empty when the database is private to one process.
+	syncLock string
+	// skipLocked ends the subquery that picks outbox rows to claim, so
+	// processes claiming at the same time pass over each other's rows.
+	skipLocked string
+	// stampHost stamps synced records and file positions with the local
+	// host name, so machines syncing the same paths into one database
+	// keep apart.
+	stampHost bool
+}
+
+var sqliteDialect = &dialect{
+	name:   "sqlite",
+	driver: "sqlite",
+	// Every pooled connection waits for other processes (an import, a
+	// restore) instead of failing with SQLITE_BUSY.
+	dsn: func(path string) string { return path + "?_pragma=busy_timeout(5000)" },
+	init: []string{
+		"PRAGMA journal_mode=WAL",
+		"PRAGMA synchronous=NORMAL",
+	},
+	schemaTypes: strings.NewReplacer(),
+	columns:     "SELECT name FROM pragma_table_info(?)",
+	tables:      "SELECT name FROM sqlite_master WHERE type IN ('table', 'view')",
+	readOnlyDSN: func(path string) string {
+		return "file:" + path + "?mode=ro&_pragma=query_only(1)&_pragma=busy_timeout(5000)"
+	},
+}
+
+var postgresDialect = &dialect{
+	name:         "postgres",
+	driver:       "postgres",
+	dollarParams: true,
+	dsn:          func(url string) string { return url },
+	schemaTypes: strings.NewReplacer(
+		"INTEGER PRIMARY KEY AUTOINCREMENT", "BIGSERIAL PRIMARY KEY",
+		"INTEGER", "BIGINT",
+		"REAL", "DOUBLE PRECISION",
+	),
+	columns: "SELECT column_name FROM information_schema.columns WHERE table_schema = current_schema() AND table_name = ?",
+	tables:  "SELECT table_name FROM information_schema.tables WHERE table_schema = current_schema()",
+	readableTables: `
+		SELECT table_name FROM information_schema.tables
+		WHERE table_schema = current_schema()
+		  AND has_table_privilege(quote_ident(table_schema) || '.' || quote_ident(table_name), 'SELECT')`,
+	syncLock:   "SELECT pg_advisory_xact_lock(7429031)", // arbitrary app-wide key
+	skipLocked: "FOR UPDATE SKIP LOCKED",
+	stampHost:  true,
+}
+
+// rebind rewrites "?" placeholders outside string literals for the dialect.
+func (d *dialect) rebind(query string) string {
+	if !d.dollarParams || !strings.Contains(query, "?") {
+		return query
+	}
+	var b strings.Builder
+	b.Grow(len(query) + 16)
+	n := 0
+	inQuote := false
+	for i := 0; i < len(query); i++ {
+		c := query[i]
+		switch {
+		case c == '\'':
+			inQuote = !inQuote
+		case c == '?' && !inQuote:
+			n++
+			b.WriteByte('$')
+			b.WriteString(strconv.Itoa(n))
+			continue
+		}
+		b.WriteByte(c)
+	}
+	return b.String()
+}
+
+// conn is a *sql.DB whose query methods rebind placeholders for its dialect.
+// Code in this package goes through conn and txn only, so every query
+// is written once.
+type conn struct {
+	*sql.DB
+	dialect *dialect
+}
+
+func (c *conn) Exec(query string, args ...interface{}) (sql.Result, error) {
+	return c.DB.Exec(c.dialect.rebind(query), args...)
+}
+
+func (c *conn) Query(query string, args ...interface{}) (*sql.Rows, error) {
+	return c.DB.Query(c.dialect.rebind(query), args...)
+}
+
+func (c *conn) QueryRow(query string, args ...interface{}) *sql.Row {
+	return c.DB.QueryRow(c.dialect.rebind(query), args...)
+}
+
+func (c *conn) Begin() (*txn, error) {
+	tx, err := c.DB.Begin()
+	if err != nil {
+		return nil, err
+	}
+	return &txn{Tx: tx, dialect: c.dialect}, nil
+}
+
+// txn is the transaction counterpart of conn.
+type txn struct {
+	*sql.Tx
+	dialect *dialect
+}
+
+func (t *txn) Exec(query string, args ...interface{}) (sql.Result, error) {
+	return t.Tx.Exec(t.dialect.rebind(query), args...)
+}
+
+func (t *txn) Query(query string, args ...interface{}) (*sql.Rows, error) {
+	return t.Tx.Query(t.dialect.rebind(query), args...)
+}
+
+func (t *txn) QueryRow(query string, args ...interface{}) *sql.Row {
+	return t.Tx.QueryRow(t.dialect.rebind(query), args...)
+}
+
+func (t *txn) Prepare(query string) (*sql.Stmt, error) {
+	return t.Tx.Prepare(t.dialect.rebind(query))
+}
@@ -0,0 +1,101 @@
+package store
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"os"
+)
+
+// portableDefaults stand in for usage_records columns that an older cache
+// does not have yet.
+var portableDefaults = map[string]string{
+	"host":        "''",
+	"user_name":   "''",
+	"hour":        "NULL",
+	"dow":         "NULL",
+	"ts":          "0",
+	"cost":        "0.0",
+	"provider":    "'unknown'",
+	"role":        "'unknown'",
+	"stop_reason": "'unknown'",
+	"tool_calls":  "0",
+	"latency_ms":  "NULL",
+	"dedupe_key":  "''",
+	"project":     "'unknown'",
+}
+
+// ForeignCache reads the records of another instance's SQLite cache file
+// without modifying it, whatever version of the schema it has, as long as
+// its records carry their source position.
+type ForeignCache struct {
+	db        *conn
+	cols      []string
+	toolCalls string
+	after     int64
+}
+
+// OpenForeignCache opens path read-only.
+func OpenForeignCache(path string) (*ForeignCache, error) {
+	if _, err := os.Stat(path); err != nil {
+		return nil, err
+	}
+	sqlDB, err := sql.Open("sqlite", "file:"+path+"?mode=ro&_pragma=busy_timeout(5000)")
+	if err != nil {
+		return nil, err
+	}
+	db := &conn{DB: sqlDB, dialect: sqliteDialect}
+	f, err := newForeignCache(db)
+	if err != nil {
+		db.Close()
+		return nil, fmt.Errorf("%s: %w", path, err)
+	}
+	return f, nil
+}
+
+func newForeignCache(db *conn) (*ForeignCache, error) {
+	have, err := tableColumns(db, db.dialect, "usage_records")
+	if err != nil {
+		return nil, err
+	}
+	if len(have) == 0 {
+		return nil, errors.New("not a usage cache (no usage_records table)")
+	}
+	f := &ForeignCache{db: db}
+	for _, c := range portableColumns {
+		switch {
+		case have[c]:
+			f.cols = append(f.cols, c)
+		case portableDefaults[c] != "":
+			f.cols = append(f.cols, portableDefaults[c])
+		default:
+			return nil, fmt.Errorf("cache predates the %s column; open it once with a current version first", c)
+		}
+	}
+
+	tools, err := tableColumns(db, db.dialect, "usage_tools")
+	if err != nil {
+		return nil, err
+	}
+	switch {
+	case tools["calls"]:
+		f.toolCalls = "calls"
+	case tools["tool_name"]:
+		f.toolCalls = "1"
+	}
+	return f, nil
+}
+
+// Next returns up to n more records, or none when all have been read.
+func (f *ForeignCache) Next(n int) ([]PortableRecord, error) {
+	recs, err := selectPortable(f.db, f.cols, f.toolCalls, f.after, n)
+	if err != nil {
+		return nil, err
+	}
+	if len(recs) > 0 {
+		f.after = recs[len(recs)-1].ID
+	}
+	return recs, nil
+}
+
+func (f *ForeignCache) Close() error { return f.db.Close() }
@@ -0,0 +1,340 @@
+package store
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"math"
+	"sort"
+	"strings"
+
+	"github.com/yeremiel/claw-usage-chart/api"
+)
+
+// LocalHost names the records synced on this machine in filters and
+// group-by results; they are stored with an empty host.
+const LocalHost = "local"
+
+func hostColumnValue(host string) string {
+	if host == LocalHost {
+		return ""
+	}
+	return host
+}
+
+func userColumnValue(user string) string {
+	if user == "unknown" {
+		return ""
+	}
+	return user
+}
+
+// ValidHost reports whether host can label pushed records: non-empty, at
+// most 128 bytes, without slashes or control characters, and not LocalHost.
+func ValidHost(host string) bool {
+	if host == "" || host == LocalHost || len(host) > 128 {
+		return false
+	}
+	for _, c := range host {
+		if c < 0x20 || c == 0x7f || c == '/' || c == '\\' {
+			return false
+		}
+	}
+	return true
+}
+
+// ErrInvalidRecord wraps errors caused by the records or host passed to
+// Ingest or Import, as opposed to failures writing the cache.
+var ErrInvalidRecord = errors.New("invalid record")
+
+type invalidRecordError string
+
+func (e invalidRecordError) Error() string        { return string(e) }
+func (e invalidRecordError) Is(target error) bool { return target == ErrInvalidRecord }
+
+func invalidRecord(format string, args ...interface{}) error {
+	return invalidRecordError(fmt.Sprintf(format, args...))
+}
+
+// PortableRecord is a usage record detached from its database, as shipped
+// from a push agent to a collector.
+type PortableRecord = api.PortableRecord
+
+func validateRecord(r PortableRecord) error {
+	switch {
+	case r.Agent == "":
+		return errors.New("agent is required")
+	case r.Model == "":
+		return errors.New("model is required")
+	case r.Date == "":
+		return errors.New("date is required")
+	case r.SourceFile == "":
+		return errors.New("source_file is required")
+	case r.SourceOffset < 0:
+		return errors.New("negative source_offset")
+	}
+	return nil
+}
+
+// IngestResult counts the outcome of one Ingest or Import call.
+type IngestResult = api.IngestResult
+
+// Conflict describes one incoming record that disagrees with the stored
+// record at the same source position.
+type Conflict = api.Conflict
+
+// portableColumns are the usage_records expressions a PortableRecord is
+// scanned from, in field order.
+var portableColumns = []string{
+	"id", "host", "user_name", "agent_name", "model", "date_key", "hour", "dow", "ts", "tokens", "cost",
+	"provider", "role", "stop_reason", "tool_calls", "latency_ms", "dedupe_key", "project",
+	"source_file", "source_offset",
+}
+
+// exportRecords returns up to limit records with an id above afterID, in
+// id order, for shipping elsewhere.
+func exportRecords(db *conn, afterID int64, limit int) ([]PortableRecord, error) {
+	return selectPortable(db, portableColumns, "calls", afterID, limit)
+}
+
+// selectPortable reads records through the given column expressions.
+// toolCalls is the usage_tools expression for the call count, or empty
+// when the database has no tool table.
+func selectPortable(db *conn, cols []string, toolCalls string, afterID int64, limit int) ([]PortableRecord, error) {
+	rows, err := db.Query(`
+		SELECT `+strings.Join(cols, ", ")+`
+		FROM usage_records WHERE id > ? ORDER BY id LIMIT ?`, afterID, limit)
+	if err != nil {
+		return nil, fmt.Errorf("export: %w", err)
+	}
+	defer rows.Close()
+
+	var recs []PortableRecord
+	byID := map[int64]*PortableRecord{}
+	for rows.Next() {
+		var r PortableRecord
+		var hour, dow, latency sql.NullInt64
+		if err := rows.Scan(&r.ID, &r.Host, &r.User, &r.Agent, &r.Model, &r.Date, &hour, &dow,
+			&r.Timestamp, &r.Tokens, &r.Cost, &r.Provider, &r.Role, &r.StopReason, &r.ToolCalls,
+			&latency, &r.DedupeKey, &r.Project, &r.SourceFile, &r.SourceOffset); err != nil {
+			return nil, err
+		}
+		r.Hour, r.DOW, r.LatencyMs = intPtr(hour), intPtr(dow), intPtr(latency)
+		recs = append(recs, r)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	if len(recs) == 0 || toolCalls == "" {
+		return recs, nil
+	}
+	for i := range recs {
+		byID[recs[i].ID] = &recs[i]
+	}
+
+	toolRows, err := db.Query(`
+		SELECT record_id, tool_name, `+toolCalls+` FROM usage_tools
+		WHERE record_id > ? AND record_id <= ?`, afterID, recs[len(recs)-1].ID)
+	if err != nil {
+		return nil, fmt.Errorf("export tools: %w", err)
+	}
+	defer toolRows.Close()
+	for toolRows.Next() {
+		var id int64
+		var name string
+		var calls int
+		if err := toolRows.Scan(&id, &name, &calls); err != nil {
+			return nil, err
+		}
+		if r := byID[id]; r != nil {
+			if r.Tools == nil {
+				r.Tools = map[string]int{}
+			}
+			r.Tools[name] = calls
+		}
+	}
+	return recs, toolRows.Err()
+}
+
+// ingestRecords stores records received from host on behalf of user,
+// whatever host and user the records name themselves.
+func ingestRecords(db *conn, opts Options, host, user string, recs []PortableRecord) (IngestResult, error) {
+	if !ValidHost(host) {
+		return IngestResult{}, invalidRecord("invalid host %q", host)
+	}
+	labeled := make([]PortableRecord, len(recs))
+	for i, r := range recs {
+		r.Host, r.User = host, user
+		labeled[i] = r
+	}
+	return insertPortable(db, opts, labeled)
+}
+
+// importRecords stores records read from another cache or a bundle.
+// Records synced on the source machine have no host and get host; records
+// the source had itself received keep theirs. user likewise fills in
+// missing users only.
+func importRecords(db *conn, opts Options, host, user string, recs []PortableRecord) (IngestResult, error) {
+	labeled := make([]PortableRecord, len(recs))
+	for i, r := range recs {
+		if r.Host == "" {
+			r.Host = host
+		}
+		if r.User == "" {
+			r.User = user
+		}
+		if !ValidHost(r.Host) {
+			return IngestResult{}, invalidRecord("record %d: invalid host %q", i, r.Host)
+		}
+		labeled[i] = r
+	}
+	return insertPortable(db, opts, labeled)
+}
+
+// insertPortable stores records under their own host and user. A record
+// already stored under the same host, source file and offset is a
+// duplicate or a conflict, and so is one whose dedupe key the policy says
+// was seen before from the same host or user. Tags come from the local rules; the project is kept as
+// sent.
+func insertPortable(db *conn, opts Options, recs []PortableRecord) (IngestResult, error) {
+	for i, r := range recs {
+		if err := validateRecord(r); err != nil {
+			return IngestResult{}, invalidRecord("record %d: %v", i, err)
+		}
+	}
+
+	tx, err := db.Begin()
+	if err != nil {
+		return IngestResult{}, err
+	}
+	defer tx.Rollback()
+
+	if lock := db.dialect.syncLock; lock != "" {
+		if _, err := tx.Exec(lock); err != nil {
+			return IngestResult{}, fmt.Errorf("sync lock: %w", err)
+		}
+	}
+
+	insertRec, err := tx.Prepare(`
+		INSERT INTO usage_records (agent_name, model, date_key, tokens, cost, hour, dow, ts,
+		                           provider, role, stop_reason, tool_calls, latency_ms, dedupe_key,
+		                           project, host, user_name, source_file, source_offset)
+		VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)
+		ON CONFLICT (host, source_file, source_offset) DO NOTHING
+		RETURNING id`)
+	if err != nil {
+		return IngestResult{}, err
+	}
+	defer insertRec.Close()
+
+	insertTool, err := tx.Prepare("INSERT INTO usage_tools (record_id, tool_name, calls) VALUES (?, ?, ?)")
+	if err != nil {
+		return IngestResult{}, err
+	}
+	defer insertTool.Close()
+
+	insertTag, err := tx.Prepare("INSERT INTO record_tags (record_id, tag) VALUES (?, ?)")
+	if err != nil {
+		return IngestResult{}, err
+	}
+	defer insertTag.Close()
+
+	findDup, err := tx.Prepare(findDupQuery)
+	if err != nil {
+		return IngestResult{}, err
+	}
+	defer findDup.Close()
+	stmts := syncStmts{findDup: findDup, dedupe: opts.Dedupe}
+
+	findStored, err := tx.Prepare(`
+		SELECT model, tokens, cost FROM usage_records
+		WHERE host = ? AND source_file = ? AND source_offset = ?`)
+	if err != nil {
+		return IngestResult{}, err
+	}
+	defer findStored.Close()
+
+	var res IngestResult
+	for _, r := range recs {
+		dup, err := stmts.isDuplicate(r.DedupeKey, r.Host, r.User)
+		if err != nil {
+			return IngestResult{}, err
+		}
+		if dup {
+			res.Duplicates++
+			continue
+		}
+
+		var id int64
+		err = insertRec.QueryRow(
+			r.Agent, r.Model, r.Date, r.Tokens, r.Cost, nullable(r.Hour), nullable(r.DOW), r.Timestamp,
+			r.Provider, r.Role, r.StopReason, r.ToolCalls, nullable(r.LatencyMs), r.DedupeKey,
+			r.Project, r.Host, r.User, r.SourceFile, r.SourceOffset,
+		).Scan(&id)
+		if err == sql.ErrNoRows {
+			// Same host, file and offset: compare with the stored record.
+			var model string
+			var tokens int
+			var cost float64
+			if err := findStored.QueryRow(r.Host, r.SourceFile, r.SourceOffset).Scan(&model, &tokens, &cost); err != nil {
+				return IngestResult{}, err
+			}
+			if model == r.Model && tokens == r.Tokens && math.Abs(cost-r.Cost) < 1e-9 {
+				res.Duplicates++
+				continue
+			}
+			res.Add(IngestResult{Conflicts: 1, ConflictSamples: []Conflict{{
+				Host:         r.Host,
+				SourceFile:   r.SourceFile,
+				SourceOffset: r.SourceOffset,
+				Stored:       fmt.Sprintf("%s %d %g", model, tokens, cost),
+				Incoming:     fmt.Sprintf("%s %d %g", r.Model, r.Tokens, r.Cost),
+			}}})
+			continue
+		}
+		if err != nil {
+			return IngestResult{}, err
+		}
+
+		names := make([]string, 0, len(r.Tools))
+		for name := range r.Tools {
+			names = append(names, name)
+		}
+		sort.Strings(names)
+		for _, name := range names {
+			if _, err := insertTool.Exec(id, name, r.Tools[name]); err != nil {
+				return IngestResult{}, err
+			}
+		}
+		if len(opts.Tags) > 0 {
+			tags := matchTags(opts.Tags, taggable{
+				agent: r.Agent, model: r.Model, project: r.Project, date: r.Date, source: r.SourceFile,
+			})
+			if err := insertTags(insertTag, id, tags); err != nil {
+				return IngestResult{}, err
+			}
+		}
+		res.Accepted++
+	}
+
+	if err := tx.Commit(); err != nil {
+		return IngestResult{}, err
+	}
+	return res, nil
+}
+
+func intPtr(n sql.NullInt64) *int {
+	if !n.Valid {
+		return nil
+	}
+	i := int(n.Int64)
+	return &i
+}
+
+// nullable turns an optional int into a query argument.
+func nullable(p *int) interface{} {
+	if p == nil {
+		return nil
+	}
+	return *p
+}
@@ -0,0 +1,143 @@
+package store
+
+import (
+	"bytes"
+	"database/sql"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// importAll copies every record of src into dst.
+func importAll(t *testing.T, dst *SQLStore, src interface {
+	Next(int) ([]PortableRecord, error)
+}, host string) IngestResult {
+	t.Helper()
+	var total IngestResult
+	for {
+		recs, err := src.Next(2)
+		if err != nil {
+			t.Fatalf("read: %v", err)
+		}
+		if len(recs) == 0 {
+			return total
+		}
+		res, err := dst.Import(host, "", recs)
+		if err != nil {
+			t.Fatalf("import: %v", err)
+		}
+		total.Add(res)
+	}
+}
+
+func TestImportForeignCacheAndBundle(t *testing.T) {
+	tmp := t.TempDir()
+	agentsDir := filepath.Join(tmp, "agents")
+	sessionDir := filepath.Join(agentsDir, "alpha", "sessions")
+	if err := os.MkdirAll(sessionDir, 0o755); err != nil {
+		t.Fatalf("mkdir: %v", err)
+	}
+	writeSessionTokens(t, filepath.Join(sessionDir, "s.jsonl"), []int{10, 20, 30})
+
+	laptopPath := filepath.Join(tmp, "laptop.db")
+	laptop, err := Open(laptopPath, Options{})
+	if err != nil {
+		t.Fatalf("open laptop: %v", err)
+	}
+	if _, err := laptop.Sync(agentsDir); err != nil {
+		t.Fatalf("sync: %v", err)
+	}
+
+	central, err := Open(filepath.Join(tmp, "central.db"), Options{Dedupe: DedupeOff})
+	if err != nil {
+		t.Fatalf("open central: %v", err)
+	}
+	defer central.Close()
+
+	fc, err := OpenForeignCache(laptopPath)
+	if err != nil {
+		t.Fatalf("open foreign: %v", err)
+	}
+	if res := importAll(t, central, fc, "laptop"); res.Accepted != 3 {
+		t.Fatalf("first import = %+v, want 3 accepted", res)
+	}
+	fc.Close()
+
+	// Re-importing is a no-op; a record that changed since is a conflict.
+	if _, err := central.DB().Exec("UPDATE usage_records SET tokens = 99 WHERE tokens = 20"); err != nil {
+		t.Fatalf("update: %v", err)
+	}
+	fc, err = OpenForeignCache(laptopPath)
+	if err != nil {
+		t.Fatalf("reopen foreign: %v", err)
+	}
+	res := importAll(t, central, fc, "laptop")
+	fc.Close()
+	if res.Accepted != 0 || res.Duplicates != 2 || res.Conflicts != 1 || len(res.ConflictSamples) != 1 {
+		t.Fatalf("re-import = %+v, want 2 duplicates and 1 conflict", res)
+	}
+	if c := res.ConflictSamples[0]; c.Host != "laptop" || !strings.Contains(c.Stored, " 99 ") {
+		t.Fatalf("conflict = %+v", c)
+	}
+
+	// A bundle round-trips the records under the exporter's host label.
+	var buf bytes.Buffer
+	if n, err := WriteBundle(&buf, laptop, "laptop"); err != nil || n != 3 {
+		t.Fatalf("write bundle = %d, %v", n, err)
+	}
+	laptop.Close()
+	br, err := NewBundleReader(&buf)
+	if err != nil {
+		t.Fatalf("read bundle: %v", err)
+	}
+	if br.Header.Version != BundleVersion || br.Header.Host != "laptop" {
+		t.Fatalf("header = %+v", br.Header)
+	}
+	other, err := Open(filepath.Join(tmp, "other.db"), Options{})
+	if err != nil {
+		t.Fatalf("open other: %v", err)
+	}
+	defer other.Close()
+	if res := importAll(t, other, br, br.Header.Host); res.Accepted != 3 {
+		t.Fatalf("bundle import = %+v", res)
+	}
+	stats, err := other.Stats(UsageFilter{Host: "laptop"})
+	if err != nil || stats.Summary.TotalTokens != 60 {
+		t.Fatalf("imported stats = %+v, %v", stats.Summary, err)
+	}
+}
+
+func TestForeignCacheWithOldSchema(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "old.db")
+	db, err := sql.Open("sqlite", path)
+	if err != nil {
+		t.Fatalf("open: %v", err)
+	}
+	if _, err := db.Exec(`
+		CREATE TABLE usage_records (
+		    id INTEGER PRIMARY KEY AUTOINCREMENT, agent_name TEXT, model TEXT, date_key TEXT,
+		    tokens INTEGER, cost REAL, source_file TEXT, source_offset INTEGER);
+		INSERT INTO usage_records (agent_name, model, date_key, tokens, cost, source_file, source_offset)
+		VALUES ('alpha', 'm1', '2026-02-17', 42, 0.5, '/old/s.jsonl', 0)`); err != nil {
+		t.Fatalf("create: %v", err)
+	}
+	db.Close()
+
+	fc, err := OpenForeignCache(path)
+	if err != nil {
+		t.Fatalf("open foreign: %v", err)
+	}
+	defer fc.Close()
+	recs, err := fc.Next(10)
+	if err != nil || len(recs) != 1 {
+		t.Fatalf("next = %v, %v", recs, err)
+	}
+	if r := recs[0]; r.Tokens != 42 || r.Provider != "unknown" || r.Project != "unknown" || r.Hour != nil {
+		t.Fatalf("record = %+v", r)
+	}
+
+	if _, err := OpenForeignCache(filepath.Join(t.TempDir(), "missing.db")); err == nil {
+		t.Fatal("expected an error for a missing file")
+	}
+}
@@ -0,0 +1,211 @@
+package store
+
+import (
+	"fmt"
+	"time"
+)
+
+// LiveOptions set the trailing windows Live summarises, all ending at now.
+// Records without a timestamp never count.
+type LiveOptions struct {
+	Filter UsageFilter
+
+	RateWindow   time.Duration // tokens/min and $/hour; default 5m
+	TableWindow  time.Duration // per-agent and per-model totals; default 1h
+	SparkWindow  time.Duration // default 6h
+	SparkBuckets int           // default 36
+	ActiveWindow time.Duration // a session with a record this recent is active; default 10m
+	MaxSessions  int           // default 20
+}
+
+// LiveStats is a snapshot of recent usage for a live monitor.
+type LiveStats struct {
+	At           int64          `json:"at"`
+	TokensPerMin float64        `json:"tokens_per_min"`
+	CostPerHour  float64        `json:"cost_per_hour"`
+	Agents       []LiveRow      `json:"agents"`
+	Models       []LiveRow      `json:"models"`
+	Spark        []LiveBucket   `json:"spark"` // oldest first
+	Sessions     []LiveSession  `json:"sessions"`
+	Windows      LiveWindowInfo `json:"windows"`
+}
+
+// LiveWindowInfo echoes the windows used, in seconds.
+type LiveWindowInfo struct {
+	Rate   int64 `json:"rate"`
+	Table  int64 `json:"table"`
+	Spark  int64 `json:"spark"`
+	Active int64 `json:"active"`
+}
+
+// LiveRow is one agent's or model's usage within the table window.
+type LiveRow struct {
+	Name    string  `json:"name"`
+	Records int     `json:"records"`
+	Tokens  int64   `json:"tokens"`
+	Cost    float64 `json:"cost"`
+}
+
+// LiveBucket is one sparkline bucket starting at Start (Unix seconds).
+type LiveBucket struct {
+	Start  int64   `json:"start"`
+	Tokens int64   `json:"tokens"`
+	Cost   float64 `json:"cost"`
+}
+
+// LiveSession is a session file that produced records within the active
+// window, most recent first.
+type LiveSession struct {
+	Host       string  `json:"host"`
+	Agent      string  `json:"agent"`
+	Model      string  `json:"model"` // model of the latest record
+	Project    string  `json:"project"`
+	SourceFile string  `json:"source_file"`
+	Records    int     `json:"records"`
+	Tokens     int64   `json:"tokens"`
+	Cost       float64 `json:"cost"`
+	LastTS     int64   `json:"last_ts"`
+}
+
+func (o *LiveOptions) defaults() {
+	if o.RateWindow <= 0 {
+		o.RateWindow = 5 * time.Minute
+	}
+	if o.TableWindow <= 0 {
+		o.TableWindow = time.Hour
+	}
+	if o.SparkWindow <= 0 {
+		o.SparkWindow = 6 * time.Hour
+	}
+	if o.SparkBuckets <= 0 {
+		o.SparkBuckets = 36
+	}
+	if o.ActiveWindow <= 0 {
+		o.ActiveWindow = 10 * time.Minute
+	}
+	if o.MaxSessions <= 0 {
+		o.MaxSessions = 20
+	}
+}
+
+// liveStats reads the windows of opts ending at now.
+func liveStats(db *conn, now time.Time, opts LiveOptions) (LiveStats, error) {
+	opts.defaults()
+	end := now.Unix()
+	where, params := filterWhere(opts.Filter)
+	since := func(d time.Duration) (string, []interface{}) {
+		return where + " AND ts > ? AND ts <= ?", append(append([]interface{}{}, params...), end-int64(d/time.Second), end)
+	}
+	out := LiveStats{
+		At: end,
+		Windows: LiveWindowInfo{
+			Rate:   int64(opts.RateWindow / time.Second),
+			Table:  int64(opts.TableWindow / time.Second),
+			Spark:  int64(opts.SparkWindow / time.Second),
+			Active: int64(opts.ActiveWindow / time.Second),
+		},
+	}
+
+	// ── rates ─────────────────────────────────────────────────────────────────
+	w, p := since(opts.RateWindow)
+	var tokens int64
+	var cost float64
+	if err := db.QueryRow(
+		"SELECT COALESCE(SUM(tokens),0), COALESCE(SUM(cost),0.0) FROM usage_records WHERE "+w, p...,
+	).Scan(&tokens, &cost); err != nil {
+		return out, fmt.Errorf("live rate: %w", err)
+	}
+	out.TokensPerMin = roundFloat(float64(tokens)/opts.RateWindow.Minutes(), 2)
+	out.CostPerHour = roundFloat(cost/opts.RateWindow.Hours(), 6)
+
+	// ── per agent / per model ─────────────────────────────────────────────────
+	w, p = since(opts.TableWindow)
+	for _, t := range []struct {
+		col  string
+		rows *[]LiveRow
+	}{{"agent_name", &out.Agents}, {"model", &out.Models}} {
+		rows, err := db.Query(
+			"SELECT "+t.col+", COUNT(*), SUM(tokens), COALESCE(SUM(cost),0.0) FROM usage_records WHERE "+w+
+				" GROUP BY "+t.col+" ORDER BY SUM(cost) DESC, SUM(tokens) DESC, "+t.col, p...)
+		if err != nil {
+			return out, fmt.Errorf("live %s: %w", t.col, err)
+		}
+		*t.rows = []LiveRow{}
+		for rows.Next() {
+			var r LiveRow
+			if err := rows.Scan(&r.Name, &r.Records, &r.Tokens, &r.Cost); err != nil {
+				rows.Close()
+				return out, err
+			}
+			r.Cost = roundFloat(r.Cost, 6)
+			*t.rows = append(*t.rows, r)
+		}
+		rows.Close()
+		if err := rows.Err(); err != nil {
+			return out, err
+		}
+	}
+
+	// ── sparkline ─────────────────────────────────────────────────────────────
+	width := int64(opts.SparkWindow/time.Second) / int64(opts.SparkBuckets)
+	if width < 1 {
+		width = 1
+	}
+	start := end - width*int64(opts.SparkBuckets)
+	out.Spark = make([]LiveBucket, opts.SparkBuckets)
+	for i := range out.Spark {
+		out.Spark[i].Start = start + int64(i)*width
+	}
+	rows, err := db.Query(
+		"SELECT (ts - ? - 1) / ?, SUM(tokens), COALESCE(SUM(cost),0.0) FROM usage_records WHERE "+where+
+			" AND ts > ? AND ts <= ? GROUP BY 1",
+		append(append([]interface{}{start, width}, params...), start, end)...)
+	if err != nil {
+		return out, fmt.Errorf("live spark: %w", err)
+	}
+	for rows.Next() {
+		var i int64
+		var b LiveBucket
+		if err := rows.Scan(&i, &b.Tokens, &b.Cost); err != nil {
+			rows.Close()
+			return out, err
+		}
+		if i >= 0 && i < int64(len(out.Spark)) {
+			out.Spark[i].Tokens = b.Tokens
+			out.Spark[i].Cost = roundFloat(b.Cost, 6)
+		}
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return out, err
+	}
+
+	// ── active sessions ───────────────────────────────────────────────────────
+	w, p = since(opts.ActiveWindow)
+	rows, err = db.Query(`
+		SELECT host, agent_name, source_file, MAX(project), COUNT(*), SUM(tokens), COALESCE(SUM(cost),0.0), MAX(ts),
+		       (SELECT l.model FROM usage_records l
+		        WHERE l.host = usage_records.host AND l.source_file = usage_records.source_file
+		        ORDER BY l.ts DESC, l.id DESC LIMIT 1)
+		FROM usage_records WHERE `+w+`
+		GROUP BY host, agent_name, source_file
+		ORDER BY MAX(ts) DESC, source_file
+		LIMIT ?`, append(p, opts.MaxSessions)...)
+	if err != nil {
+		return out, fmt.Errorf("live sessions: %w", err)
+	}
+	defer rows.Close()
+	out.Sessions = []LiveSession{}
+	for rows.Next() {
+		var s LiveSession
+		if err := rows.Scan(&s.Host, &s.Agent, &s.SourceFile, &s.Project, &s.Records, &s.Tokens, &s.Cost, &s.LastTS, &s.Model); err != nil {
+			return out, err
+		}
+		if s.Host == "" {
+			s.Host = LocalHost
+		}
+		s.Cost = roundFloat(s.Cost, 6)
+		out.Sessions = append(out.Sessions, s)
+	}
+	return out, rows.Err()
+}
@@ -0,0 +1,64 @@
+package store
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestLiveWindows(t *testing.T) {
+	tmp := t.TempDir()
+	agentsDir := filepath.Join(tmp, "agents")
+	sessionDir := filepath.Join(agentsDir, "alpha", "sessions")
+	if err := os.MkdirAll(sessionDir, 0o755); err != nil {
+		t.Fatalf("mkdir: %v", err)
+	}
+	// Lines at 00:00, 00:01 and 00:02.
+	writeSessionTokens(t, filepath.Join(sessionDir, "s.jsonl"), []int{10, 20, 30})
+
+	st, err := Open(filepath.Join(tmp, "usage_cache.db"), Options{})
+	if err != nil {
+		t.Fatalf("open: %v", err)
+	}
+	defer st.Close()
+	if _, err := st.Sync(agentsDir); err != nil {
+		t.Fatalf("sync: %v", err)
+	}
+
+	now := time.Date(2026, 2, 17, 0, 2, 30, 0, time.UTC)
+	live, err := st.Live(now, LiveOptions{
+		RateWindow:   2 * time.Minute,
+		SparkWindow:  3 * time.Minute,
+		SparkBuckets: 3,
+	})
+	if err != nil {
+		t.Fatalf("live: %v", err)
+	}
+	if live.TokensPerMin != 25 {
+		t.Errorf("tokens/min = %v, want 25 (the last two lines over 2m)", live.TokensPerMin)
+	}
+	if len(live.Agents) != 1 || live.Agents[0].Name != "alpha" || live.Agents[0].Tokens != 60 {
+		t.Errorf("agents = %+v", live.Agents)
+	}
+	var spark []int64
+	for _, b := range live.Spark {
+		spark = append(spark, b.Tokens)
+	}
+	if len(spark) != 3 || spark[0] != 10 || spark[1] != 20 || spark[2] != 30 {
+		t.Errorf("spark = %v, want [10 20 30]", spark)
+	}
+	if len(live.Sessions) != 1 || live.Sessions[0].Host != LocalHost || live.Sessions[0].Model != "test-model" ||
+		live.Sessions[0].Records != 3 || live.Sessions[0].LastTS != now.Add(-30*time.Second).Unix() {
+		t.Errorf("sessions = %+v", live.Sessions)
+	}
+
+	// An hour later nothing is live, but the sparkline keeps its shape.
+	live, err = st.Live(now.Add(time.Hour), LiveOptions{})
+	if err != nil {
+		t.Fatalf("live later: %v", err)
+	}
+	if live.TokensPerMin != 0 || len(live.Sessions) != 0 || len(live.Spark) != 36 {
+		t.Errorf("an hour later: %+v", live)
+	}
+}
@@ -0,0 +1,193 @@
+package store
+
+import (
+	"database/sql"
+	"fmt"
+	"strconv"
+)
+
+// schemaVersion is the version of schema. A database records the version
+// it was last migrated to in cache_meta; opening it runs the migrations
+// between that version and this one. Migrations only add: a cache can
+// hold pushed, imported or restored records that no session file on this
+// machine could bring back, so nothing is ever dropped to upgrade it.
+const schemaVersion = 3
+
+const schemaVersionKey = "schema_version"
+
+// migrations[i] upgrades a database from version i to version i+1. They
+// run inside one transaction, before schema creates what is still missing.
+var migrations = []func(tx *txn) error{
+	migrateUnversioned,
+	migrateFileStateHost,
+	migrateOutboxClaims,
+}
+
+// addedColumns are the columns added to the cache tables since the first
+// release, with their definitions in the shared schema's types. Records
+// stored before a column existed keep its default.
+var addedColumns = []struct{ table, column, def string }{
+	{"usage_records", "hour", "INTEGER"},
+	{"usage_records", "dow", "INTEGER"},
+	{"usage_records", "ts", "INTEGER NOT NULL DEFAULT 0"},
+	{"usage_records", "provider", "TEXT NOT NULL DEFAULT 'unknown'"},
+	{"usage_records", "role", "TEXT NOT NULL DEFAULT 'unknown'"},
+	{"usage_records", "stop_reason", "TEXT NOT NULL DEFAULT 'unknown'"},
+	{"usage_records", "tool_calls", "INTEGER NOT NULL DEFAULT 0"},
+	{"usage_records", "latency_ms", "INTEGER"},
+	{"usage_records", "dedupe_key", "TEXT NOT NULL DEFAULT ''"},
+	{"usage_records", "project", "TEXT NOT NULL DEFAULT 'unknown'"},
+	{"usage_records", "host", "TEXT NOT NULL DEFAULT ''"},
+	{"usage_records", "user_name", "TEXT NOT NULL DEFAULT ''"},
+	{"file_state", "duplicates", "INTEGER NOT NULL DEFAULT 0"},
+	{"file_state", "cwd", "TEXT NOT NULL DEFAULT ''"},
+	{"file_state", "git_branch", "TEXT NOT NULL DEFAULT ''"},
+	{"file_state", "git_repo", "TEXT NOT NULL DEFAULT ''"},
+}
+
+// migrateUnversioned brings a cache written before schema versions existed
+// up to version 1, whichever release created it.
+func migrateUnversioned(tx *txn) error {
+	for _, c := range addedColumns {
+		cols, err := tableColumns(tx, tx.dialect, c.table)
+		if err != nil {
+			return err
+		}
+		if cols[c.column] {
+			continue
+		}
+		stmt := fmt.Sprintf("ALTER TABLE %s ADD COLUMN %s %s", c.table, c.column, c.def)
+		if _, err := tx.Exec(tx.dialect.schemaTypes.Replace(stmt)); err != nil {
+			return fmt.Errorf("add %s.%s: %w", c.table, c.column, err)
+		}
+		// Source lines were unique per file before records carried a
+		// host; schema recreates the index with it.
+		if c.column == "host" {
+			if _, err := tx.Exec("DROP INDEX IF EXISTS idx_rec_source_line"); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// migrateFileStateHost keys file_state by (host, file_path). The primary
+// key cannot be altered in place, so the rows are copied into a new table.
+// Existing positions belong to host "", like the records synced with them.
+func migrateFileStateHost(tx *txn) error {
+	stmts := []string{`
+		CREATE TABLE file_state_v2 (
+		    host        TEXT    NOT NULL DEFAULT '',
+		    file_path   TEXT    NOT NULL,
+		    agent_name  TEXT    NOT NULL,
+		    last_offset INTEGER NOT NULL DEFAULT 0,
+		    duplicates  INTEGER NOT NULL DEFAULT 0,
+		    cwd         TEXT    NOT NULL DEFAULT '',
+		    git_branch  TEXT    NOT NULL DEFAULT '',
+		    git_repo    TEXT    NOT NULL DEFAULT '',
+		    PRIMARY KEY (host, file_path)
+		)`, `
+		INSERT INTO file_state_v2 (host, file_path, agent_name, last_offset, duplicates, cwd, git_branch, git_repo)
+		SELECT '', file_path, agent_name, last_offset, duplicates, cwd, git_branch, git_repo FROM file_state`,
+		"DROP TABLE file_state",
+		"ALTER TABLE file_state_v2 RENAME TO file_state",
+	}
+	for _, stmt := range stmts {
+		if _, err := tx.Exec(tx.dialect.schemaTypes.Replace(stmt)); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// migrateOutboxClaims adds the lease columns Outbox.Claim sets. A cache
+// from before the outbox gets the table, with them, from schema.
+func migrateOutboxClaims(tx *txn) error {
+	cols, err := tableColumns(tx, tx.dialect, "notify_outbox")
+	if err != nil || len(cols) == 0 {
+		return err
+	}
+	for _, c := range []struct{ column, def string }{
+		{"claimed_by", "TEXT NOT NULL DEFAULT ''"},
+		{"claimed_until", "INTEGER NOT NULL DEFAULT 0"},
+	} {
+		if cols[c.column] {
+			continue
+		}
+		stmt := "ALTER TABLE notify_outbox ADD COLUMN " + c.column + " " + c.def
+		if _, err := tx.Exec(tx.dialect.schemaTypes.Replace(stmt)); err != nil {
+			return fmt.Errorf("add notify_outbox.%s: %w", c.column, err)
+		}
+	}
+	return nil
+}
+
+// ensureSchema migrates an existing database to schemaVersion and creates
+// the tables, indices and views that are missing. Processes opening a
+// shared database at the same time take turns through the sync lock.
+func ensureSchema(db *conn) error {
+	tx, err := db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+	if lock := db.dialect.syncLock; lock != "" {
+		if _, err := tx.Exec(lock); err != nil {
+			return err
+		}
+	}
+
+	cols, err := tableColumns(tx, tx.dialect, "usage_records")
+	if err != nil {
+		return err
+	}
+	if len(cols) > 0 {
+		version, err := storedSchemaVersion(tx)
+		if err != nil {
+			return err
+		}
+		if version > schemaVersion {
+			return fmt.Errorf("database schema version %d is newer than this build supports (%d); upgrade claw-usage-chart", version, schemaVersion)
+		}
+		for v := version; v < schemaVersion; v++ {
+			if err := migrations[v](tx); err != nil {
+				return fmt.Errorf("migrate schema to version %d: %w", v+1, err)
+			}
+		}
+	}
+	if _, err := tx.Exec(db.dialect.schemaTypes.Replace(schema)); err != nil {
+		return err
+	}
+	if _, err := tx.Exec(`
+		INSERT INTO cache_meta (key, value) VALUES (?, ?)
+		ON CONFLICT(key) DO UPDATE SET value = excluded.value`,
+		schemaVersionKey, strconv.Itoa(schemaVersion)); err != nil {
+		return err
+	}
+	if err := tx.Commit(); err != nil {
+		return err
+	}
+	return createViews(db)
+}
+
+// storedSchemaVersion returns the version recorded in cache_meta, or 0 for
+// a cache from before versioning (which may not even have cache_meta).
+func storedSchemaVersion(tx *txn) (int, error) {
+	cols, err := tableColumns(tx, tx.dialect, "cache_meta")
+	if err != nil || len(cols) == 0 {
+		return 0, err
+	}
+	var v string
+	err = tx.QueryRow("SELECT value FROM cache_meta WHERE key = ?", schemaVersionKey).Scan(&v)
+	if err != nil {
+		if err == sql.ErrNoRows {
+			return 0, nil
+		}
+		return 0, err
+	}
+	n, err := strconv.Atoi(v)
+	if err != nil {
+		return 0, fmt.Errorf("bad %s %q", schemaVersionKey, v)
+	}
+	return n, nil
+}
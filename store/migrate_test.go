@@ -0,0 +1,102 @@
+package store
+
+import (
+	"database/sql"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"testing"
+)
+
+// legacySchema is the cache layout of the first release.
+const legacySchema = `
+CREATE TABLE file_state (
+    file_path   TEXT PRIMARY KEY,
+    agent_name  TEXT    NOT NULL,
+    last_offset INTEGER NOT NULL DEFAULT 0
+);
+CREATE TABLE usage_records (
+    id          INTEGER PRIMARY KEY AUTOINCREMENT,
+    agent_name  TEXT    NOT NULL,
+    model       TEXT    NOT NULL,
+    date_key    TEXT    NOT NULL,
+    tokens      INTEGER NOT NULL,
+    cost        REAL    NOT NULL DEFAULT 0.0,
+    hour        INTEGER,
+    dow         INTEGER,
+    source_file TEXT    NOT NULL,
+    source_offset INTEGER NOT NULL
+);
+CREATE UNIQUE INDEX idx_rec_source_line ON usage_records(source_file, source_offset);
+INSERT INTO usage_records (agent_name, model, date_key, tokens, cost, hour, dow, source_file, source_offset)
+VALUES ('old', 'm0', '2026-01-05', 1000, 0.5, 9, 1, '/gone/s.jsonl', 0);
+INSERT INTO file_state (file_path, agent_name, last_offset) VALUES ('/gone/s.jsonl', 'old', 80);
+`
+
+func TestOpenMigratesLegacyCacheInPlace(t *testing.T) {
+	tmp := t.TempDir()
+	dbPath := filepath.Join(tmp, "usage_cache.db")
+	legacy, err := sql.Open("sqlite", dbPath)
+	if err != nil {
+		t.Fatalf("open legacy: %v", err)
+	}
+	if _, err := legacy.Exec(legacySchema); err != nil {
+		t.Fatalf("legacy schema: %v", err)
+	}
+	legacy.Close()
+
+	agentsDir := filepath.Join(tmp, "agents")
+	sessionDir := filepath.Join(agentsDir, "alpha", "sessions")
+	if err := os.MkdirAll(sessionDir, 0o755); err != nil {
+		t.Fatalf("mkdir: %v", err)
+	}
+	line := `{"timestamp":"2026-02-17T10:00:00Z","model":"m1","usage":{"input_tokens":42}}` + "\n"
+	if err := os.WriteFile(filepath.Join(sessionDir, "s.jsonl"), []byte(line), 0o644); err != nil {
+		t.Fatalf("write: %v", err)
+	}
+
+	st, err := Open(dbPath, Options{})
+	if err != nil {
+		t.Fatalf("open: %v", err)
+	}
+	if v, err := st.Meta(schemaVersionKey); err != nil || v != strconv.Itoa(schemaVersion) {
+		t.Fatalf("schema version = %q, %v", v, err)
+	}
+	var offset int64
+	if err := st.DB().QueryRow(
+		"SELECT last_offset FROM file_state WHERE host = '' AND file_path = '/gone/s.jsonl'",
+	).Scan(&offset); err != nil || offset != 80 {
+		t.Fatalf("legacy file position = %d, %v", offset, err)
+	}
+	if _, err := st.Sync(agentsDir); err != nil {
+		t.Fatalf("sync: %v", err)
+	}
+	stats, err := st.Stats(UsageFilter{})
+	if err != nil {
+		t.Fatalf("stats: %v", err)
+	}
+	if stats.Summary.TotalTokens != 1042 {
+		t.Fatalf("total tokens = %d, want the legacy record kept (1042)", stats.Summary.TotalTokens)
+	}
+	st.Close()
+
+	// Reopening an up-to-date cache migrates nothing and keeps everything.
+	st, err = Open(dbPath, Options{})
+	if err != nil {
+		t.Fatalf("reopen: %v", err)
+	}
+	stats, err = st.Stats(UsageFilter{})
+	if err != nil || stats.Summary.TotalTokens != 1042 {
+		t.Fatalf("after reopen: %d, %v", stats.Summary.TotalTokens, err)
+	}
+
+	// A cache from a newer build is left alone.
+	if err := st.SetMeta(schemaVersionKey, strconv.Itoa(schemaVersion+1)); err != nil {
+		t.Fatalf("set meta: %v", err)
+	}
+	st.Close()
+	if _, err := Open(dbPath, Options{}); err == nil || !strings.Contains(err.Error(), "newer") {
+		t.Fatalf("open newer cache: %v, want an error", err)
+	}
+}
@@ -0,0 +1,143 @@
+package store
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+)
+
+// OutboxItem is one pending delivery of an event to a target.
+type OutboxItem struct {
+	ID       int64
+	Target   string
+	Kind     string
+	Payload  []byte
+	Attempts int
+}
+
+// Outbox is a durable delivery queue keyed by (target, event key), so the
+// same event is queued at most once per target, even across restarts.
+// Several processes may drain one outbox: each item is leased to the
+// owner that claimed it until it is settled or the lease runs out.
+type Outbox interface {
+	// Enqueue adds an item due now and reports whether it was new.
+	Enqueue(target, eventKey, kind string, payload []byte, now time.Time) (bool, error)
+	// Claim leases the undelivered, unfailed items for targets whose next
+	// attempt is due to owner until now+lease, and returns them. Items
+	// leased to another owner are skipped until their lease expires.
+	Claim(owner string, targets []string, now time.Time, lease time.Duration) ([]OutboxItem, error)
+	// MarkDelivered, Retry and MarkFailed settle a claimed item and end
+	// its lease.
+	MarkDelivered(id int64, now time.Time) error
+	// Retry records a failed attempt and schedules the next one.
+	Retry(id int64, attempts int, next time.Time, lastErr string) error
+	// MarkFailed gives up on an item.
+	MarkFailed(id int64, attempts int, now time.Time, lastErr string) error
+	// RenameTarget moves the items of target from to target to, dropping
+	// those whose event is already queued for to.
+	RenameTarget(from, to string) error
+}
+
+// sqlOutbox implements Outbox on the notify_outbox table.
+type sqlOutbox struct {
+	db *conn
+}
+
+func (o sqlOutbox) Enqueue(target, eventKey, kind string, payload []byte, now time.Time) (bool, error) {
+	res, err := o.db.Exec(`
+		INSERT INTO notify_outbox (target, event_key, kind, payload, created_at, next_attempt)
+		VALUES (?, ?, ?, ?, ?, ?)
+		ON CONFLICT (target, event_key) DO NOTHING`,
+		target, eventKey, kind, string(payload), now.Unix(), now.Unix())
+	if err != nil {
+		return false, fmt.Errorf("outbox insert: %w", err)
+	}
+	c, _ := res.RowsAffected()
+	return c > 0, nil
+}
+
+func (o sqlOutbox) Claim(owner string, targets []string, now time.Time, lease time.Duration) ([]OutboxItem, error) {
+	if len(targets) == 0 {
+		return nil, nil
+	}
+	params := []interface{}{owner, now.Add(lease).Unix(), now.Unix(), now.Unix()}
+	marks := make([]string, len(targets))
+	for i, t := range targets {
+		marks[i] = "?"
+		params = append(params, t)
+	}
+	params = append(params, now.Unix())
+	// The outer condition repeats the lease check for PostgreSQL, which
+	// re-evaluates it on a row another claim updated meanwhile.
+	rows, err := o.db.Query(`
+		UPDATE notify_outbox SET claimed_by = ?, claimed_until = ?
+		WHERE id IN (
+			SELECT id FROM notify_outbox
+			WHERE delivered_at IS NULL AND failed_at IS NULL AND next_attempt <= ?
+			  AND claimed_until <= ? AND target IN (`+strings.Join(marks, ", ")+`)
+			`+o.db.dialect.skipLocked+`
+		) AND claimed_until <= ?
+		RETURNING id, target, kind, payload, attempts`, params...)
+	if err != nil {
+		return nil, fmt.Errorf("outbox claim: %w", err)
+	}
+	defer rows.Close()
+	var due []OutboxItem
+	for rows.Next() {
+		var it OutboxItem
+		var payload string
+		if err := rows.Scan(&it.ID, &it.Target, &it.Kind, &payload, &it.Attempts); err != nil {
+			return nil, err
+		}
+		it.Payload = []byte(payload)
+		due = append(due, it)
+	}
+	sort.Slice(due, func(i, j int) bool { return due[i].ID < due[j].ID })
+	return due, rows.Err()
+}
+
+func (o sqlOutbox) MarkDelivered(id int64, now time.Time) error {
+	_, err := o.db.Exec(
+		"UPDATE notify_outbox SET delivered_at = ?, attempts = attempts + 1, last_error = NULL, claimed_by = '', claimed_until = 0 WHERE id = ?",
+		now.Unix(), id,
+	)
+	return err
+}
+
+func (o sqlOutbox) Retry(id int64, attempts int, next time.Time, lastErr string) error {
+	_, err := o.db.Exec(
+		"UPDATE notify_outbox SET attempts = ?, next_attempt = ?, last_error = ?, claimed_by = '', claimed_until = 0 WHERE id = ?",
+		attempts, next.Unix(), lastErr, id,
+	)
+	return err
+}
+
+func (o sqlOutbox) MarkFailed(id int64, attempts int, now time.Time, lastErr string) error {
+	_, err := o.db.Exec(
+		"UPDATE notify_outbox SET attempts = ?, failed_at = ?, last_error = ?, claimed_by = '', claimed_until = 0 WHERE id = ?",
+		attempts, now.Unix(), lastErr, id,
+	)
+	return err
+}
+
+func (o sqlOutbox) RenameTarget(from, to string) error {
+	if from == to {
+		return nil
+	}
+	tx, err := o.db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+	if _, err := tx.Exec(`
+		UPDATE notify_outbox SET target = ?
+		WHERE target = ? AND event_key NOT IN (SELECT event_key FROM notify_outbox WHERE target = ?)`,
+		to, from, to); err != nil {
+		return fmt.Errorf("outbox rename: %w", err)
+	}
+	if _, err := tx.Exec("DELETE FROM notify_outbox WHERE target = ?", from); err != nil {
+		return fmt.Errorf("outbox rename: %w", err)
+	}
+	return tx.Commit()
+}
@@ -0,0 +1,204 @@
+package store
+
+import (
+	"crypto/sha256"
+	"database/sql"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+
+	"github.com/yeremiel/claw-usage-chart/parser"
+)
+
+// ProjectRule maps session context to a project name. All non-empty
+// conditions must match; the first matching rule wins.
+type ProjectRule struct {
+	Path    string `json:"path,omitempty"`   // working directory prefix or glob ("~/work/api", "/srv/*")
+	Repo    string `json:"repo,omitempty"`   // git repo glob
+	Branch  string `json:"branch,omitempty"` // git branch glob
+	Agent   string `json:"agent,omitempty"`  // agent name glob
+	Project string `json:"project"`
+}
+
+// Validate reports a missing project name or a malformed pattern.
+func (r ProjectRule) Validate() error {
+	if r.Project == "" {
+		return errors.New("project is required")
+	}
+	for _, g := range []string{r.Path, r.Repo, r.Branch, r.Agent} {
+		if !validGlob(g) {
+			return fmt.Errorf("bad pattern %q", g)
+		}
+	}
+	return nil
+}
+
+func (r ProjectRule) matches(agent string, meta parser.SessionMeta) bool {
+	if r.Agent != "" && !globMatch(r.Agent, agent) {
+		return false
+	}
+	if r.Path != "" && !pathMatch(r.Path, meta.Cwd) {
+		return false
+	}
+	if r.Repo != "" && !globMatch(r.Repo, meta.GitRepo) && !globMatch(r.Repo, repoName(meta.GitRepo)) {
+		return false
+	}
+	if r.Branch != "" && !globMatch(r.Branch, meta.GitBranch) {
+		return false
+	}
+	return true
+}
+
+// resolveProject applies the rules, then falls back to the git repo name and
+// finally to the last element of the working directory.
+func resolveProject(rules []ProjectRule, agent string, meta parser.SessionMeta) string {
+	for _, r := range rules {
+		if r.matches(agent, meta) {
+			return r.Project
+		}
+	}
+	if name := repoName(meta.GitRepo); name != "" {
+		return name
+	}
+	if meta.Cwd != "" {
+		if base := filepath.Base(filepath.Clean(meta.Cwd)); base != "/" && base != "." {
+			return base
+		}
+	}
+	return "unknown"
+}
+
+// repoName turns "git@host:org/name.git" or "https://host/org/name" into "name".
+func repoName(repo string) string {
+	repo = strings.TrimSuffix(strings.TrimRight(repo, "/"), ".git")
+	if i := strings.LastIndexAny(repo, "/:"); i >= 0 {
+		repo = repo[i+1:]
+	}
+	return repo
+}
+
+func globMatch(pattern, s string) bool {
+	if s == "" {
+		return false
+	}
+	ok, err := path.Match(pattern, s)
+	return err == nil && ok
+}
+
+// validGlob reports whether pattern is a well-formed glob.
+func validGlob(pattern string) bool {
+	_, err := path.Match(pattern, "")
+	return err == nil
+}
+
+// pathMatch matches dir against a directory prefix, or against a glob
+// applied to dir and each of its parents.
+func pathMatch(pattern, dir string) bool {
+	if dir == "" {
+		return false
+	}
+	pattern = expandHome(pattern)
+	dir = filepath.Clean(dir)
+	if !strings.ContainsAny(pattern, "*?[") {
+		pattern = filepath.Clean(pattern)
+		return dir == pattern || strings.HasPrefix(dir, pattern+string(filepath.Separator))
+	}
+	for d := dir; ; d = filepath.Dir(d) {
+		if ok, err := filepath.Match(pattern, d); err == nil && ok {
+			return true
+		}
+		if parent := filepath.Dir(d); parent == d {
+			return false
+		}
+	}
+}
+
+func expandHome(p string) string {
+	if p == "~" || strings.HasPrefix(p, "~/") {
+		if home, err := os.UserHomeDir(); err == nil {
+			return filepath.Join(home, p[1:])
+		}
+	}
+	return p
+}
+
+// rulesFingerprint is a stable hash of a rule set, stored in cache_meta to
+// notice when the rules change between runs.
+func rulesFingerprint(rules interface{}) string {
+	b, _ := json.Marshal(rules)
+	sum := sha256.Sum256(b)
+	return hex.EncodeToString(sum[:8])
+}
+
+// hostMetaKey is the cache_meta key under which host keeps its own copy of
+// a value, such as the fingerprint of its rules. SQLite caches, whose
+// synced records carry no host, use key itself.
+func hostMetaKey(key, host string) string {
+	if host == "" {
+		return key
+	}
+	return key + ":" + host
+}
+
+func getCacheMeta(tx *txn, key string) (string, error) {
+	var v string
+	err := tx.QueryRow("SELECT value FROM cache_meta WHERE key = ?", key).Scan(&v)
+	if err == sql.ErrNoRows {
+		return "", nil
+	}
+	return v, err
+}
+
+func setCacheMeta(tx *txn, key, value string) error {
+	_, err := tx.Exec(`
+		INSERT INTO cache_meta (key, value) VALUES (?, ?)
+		ON CONFLICT (key) DO UPDATE SET value = excluded.value`, key, value)
+	return err
+}
+
+// remapProjects recomputes the project of every record synced on host when
+// the project rules differ from the ones the records were written with.
+// Records of other hosts sharing the database follow those hosts' rules,
+// and pushed records keep the project their sender resolved.
+func remapProjects(tx *txn, host string, rules []ProjectRule) error {
+	key := hostMetaKey("project_rules", host)
+	fp := rulesFingerprint(rules)
+	prev, err := getCacheMeta(tx, key)
+	if err != nil || prev == fp {
+		return err
+	}
+
+	type fileMeta struct {
+		path, agent string
+		meta        parser.SessionMeta
+	}
+	rows, err := tx.Query("SELECT file_path, agent_name, cwd, git_branch, git_repo FROM file_state WHERE host = ?", host)
+	if err != nil {
+		return err
+	}
+	var files []fileMeta
+	for rows.Next() {
+		var f fileMeta
+		if err := rows.Scan(&f.path, &f.agent, &f.meta.Cwd, &f.meta.GitBranch, &f.meta.GitRepo); err != nil {
+			rows.Close()
+			return err
+		}
+		files = append(files, f)
+	}
+	rows.Close()
+
+	for _, f := range files {
+		if _, err := tx.Exec(
+			"UPDATE usage_records SET project = ? WHERE host = ? AND source_file = ?",
+			resolveProject(rules, f.agent, f.meta), host, f.path,
+		); err != nil {
+			return err
+		}
+	}
+	return setCacheMeta(tx, key, fp)
+}
@@ -0,0 +1,121 @@
+package store
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestSyncAttributesProjectsAndRemapsOnRuleChange(t *testing.T) {
+	tmp := t.TempDir()
+	agentsDir := filepath.Join(tmp, "agents")
+	sessionDir := filepath.Join(agentsDir, "alpha", "sessions")
+	if err := os.MkdirAll(sessionDir, 0o755); err != nil {
+		t.Fatalf("mkdir session dir: %v", err)
+	}
+
+	api := `{"type":"session","id":"s1","cwd":"/work/acme/api"}` + "\n" +
+		`{"timestamp":"2026-02-17T00:00:00Z","message":{"id":"m1","usage":{"input_tokens":10}}}` + "\n"
+	web := `{"type":"user","cwd":"/home/dev/site","gitBranch":"main","message":{"role":"user"}}` + "\n" +
+		`{"timestamp":"2026-02-17T00:01:00Z","message":{"id":"m2","usage":{"input_tokens":20}}}` + "\n"
+	if err := os.WriteFile(filepath.Join(sessionDir, "api.jsonl"), []byte(api), 0o644); err != nil {
+		t.Fatalf("write: %v", err)
+	}
+	if err := os.WriteFile(filepath.Join(sessionDir, "web.jsonl"), []byte(web), 0o644); err != nil {
+		t.Fatalf("write: %v", err)
+	}
+
+	st, err := Open(filepath.Join(tmp, "usage_cache.db"), Options{})
+	if err != nil {
+		t.Fatalf("open: %v", err)
+	}
+	defer st.Close()
+
+	projectTokens := func() map[string]int {
+		t.Helper()
+		if _, err := st.Sync(agentsDir); err != nil {
+			t.Fatalf("sync: %v", err)
+		}
+		stats, err := st.Stats(UsageFilter{})
+		if err != nil {
+			t.Fatalf("stats: %v", err)
+		}
+		got := map[string]int{}
+		for _, p := range stats.ProjectTotals {
+			got[p.Project] = p.Tokens
+		}
+		return got
+	}
+
+	st.SetOptions(Options{
+		Dedupe:   DedupeContent,
+		Projects: []ProjectRule{{Path: "/work/acme/*", Project: "acme"}},
+	})
+	got := projectTokens()
+	if got["acme"] != 10 || got["site"] != 20 {
+		t.Fatalf("unexpected projects with first rules: %v", got)
+	}
+
+	// Changing the rules re-attributes history without re-reading files.
+	st.SetOptions(Options{
+		Dedupe:   DedupeContent,
+		Projects: []ProjectRule{{Branch: "main", Project: "mainline"}},
+	})
+	got = projectTokens()
+	if got["api"] != 10 || got["mainline"] != 20 || len(got) != 2 {
+		t.Fatalf("unexpected projects after rule change: %v", got)
+	}
+}
+
+// TestRemapLeavesOtherHostsAlone checks that hosts sharing a database with
+// different project rules each remap only their own records.
+func TestRemapLeavesOtherHostsAlone(t *testing.T) {
+	tmp := t.TempDir()
+	path := filepath.Join(tmp, "usage_cache.db")
+	session := `{"type":"session","id":"s1","cwd":"/work/acme/api"}` + "\n" +
+		`{"timestamp":"2026-02-17T00:00:00Z","message":{"id":"m1","usage":{"input_tokens":10}}}` + "\n"
+	open := func(host, project string) (*SQLStore, string) {
+		t.Helper()
+		agentsDir := filepath.Join(tmp, host, "agents")
+		dir := filepath.Join(agentsDir, "alpha", "sessions")
+		if err := os.MkdirAll(dir, 0o755); err != nil {
+			t.Fatalf("mkdir: %v", err)
+		}
+		if err := os.WriteFile(filepath.Join(dir, "s.jsonl"), []byte(session), 0o644); err != nil {
+			t.Fatalf("write: %v", err)
+		}
+		st, err := Open(path, Options{Projects: []ProjectRule{{Path: "/work/acme", Project: project}}})
+		if err != nil {
+			t.Fatalf("open: %v", err)
+		}
+		t.Cleanup(func() { st.Close() })
+		st.host = host
+		return st, agentsDir
+	}
+	a, dirA := open("host-a", "acme-a")
+	b, dirB := open("host-b", "acme-b")
+
+	for i := 0; i < 2; i++ {
+		if _, err := a.Sync(dirA); err != nil {
+			t.Fatalf("sync a: %v", err)
+		}
+		if _, err := b.Sync(dirB); err != nil {
+			t.Fatalf("sync b: %v", err)
+		}
+	}
+	stats, err := a.Stats(UsageFilter{})
+	if err != nil {
+		t.Fatalf("stats: %v", err)
+	}
+	got := map[string]int{}
+	for _, p := range stats.ProjectTotals {
+		got[p.Project] = p.Tokens
+	}
+	if len(got) != 2 || got["acme-a"] != 10 || got["acme-b"] != 10 {
+		t.Fatalf("projects = %v, want each host's records attributed by its own rules", got)
+	}
+
+	if err := (ProjectRule{Path: "/srv/[", Project: "x"}).Validate(); err == nil {
+		t.Fatal("malformed path glob was accepted")
+	}
+}
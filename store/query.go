@@ -0,0 +1,305 @@
+package store
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+	"unicode"
+
+	"github.com/yeremiel/claw-usage-chart/api"
+)
+
+// DefaultQueryTables are the tables and views user SQL may read unless
+// configured otherwise: the documented views, whose columns stay stable
+// across schema changes.
+var DefaultQueryTables = []string{"v_usage", "v_usage_tools", "v_usage_tags"}
+
+const (
+	DefaultQueryRows    = 1000
+	DefaultQueryTimeout = 10 * time.Second
+)
+
+// QueryOptions bound a user query.
+type QueryOptions struct {
+	Allow   []string      // readable tables and views; empty means DefaultQueryTables
+	MaxRows int           // rows returned before Truncated is set; default DefaultQueryRows
+	Timeout time.Duration // default DefaultQueryTimeout
+}
+
+// QueryResult holds the rows of a user query.
+type QueryResult = api.QueryResult
+
+// ErrQueryTimeout is returned when a query runs past its timeout.
+var ErrQueryTimeout = errors.New("query timed out")
+
+// ErrNoQueryRole is returned by Query on PostgreSQL without
+// Options.QueryDSN.
+var ErrNoQueryRole = errors.New("user queries on PostgreSQL need a read-only role (query DSN)")
+
+// runQuery runs one SELECT statement on a read-only connection: SQLite's
+// query_only handle, or PostgreSQL's query role. Besides the allowed
+// tables, it refuses any identifier naming another table or view of the
+// database, the engine's own catalog or a function reading tables by name.
+func runQuery(ctx context.Context, rw, ro *conn, query string, opts QueryOptions) (QueryResult, error) {
+	if len(opts.Allow) == 0 {
+		opts.Allow = DefaultQueryTables
+	}
+	if opts.MaxRows <= 0 {
+		opts.MaxRows = DefaultQueryRows
+	}
+	if opts.Timeout <= 0 {
+		opts.Timeout = DefaultQueryTimeout
+	}
+
+	tables, err := schemaTables(rw)
+	if err != nil {
+		return QueryResult{}, err
+	}
+	allowed := map[string]bool{}
+	for _, t := range opts.Allow {
+		allowed[strings.ToLower(t)] = true
+	}
+	if err := checkQuery(query, tables, allowed); err != nil {
+		return QueryResult{}, err
+	}
+	if err := checkQueryRole(ro, allowed); err != nil {
+		return QueryResult{}, err
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, opts.Timeout)
+	defer cancel()
+	tx, err := ro.BeginTx(ctx, &sql.TxOptions{ReadOnly: ro.dialect == postgresDialect})
+	if err != nil {
+		return QueryResult{}, err
+	}
+	defer tx.Rollback()
+
+	rows, err := tx.QueryContext(ctx, query)
+	if err != nil {
+		return QueryResult{}, queryError(ctx, err)
+	}
+	defer rows.Close()
+
+	cols, err := rows.Columns()
+	if err != nil {
+		return QueryResult{}, err
+	}
+	res := QueryResult{Columns: cols, Rows: [][]interface{}{}}
+	for rows.Next() {
+		if len(res.Rows) == opts.MaxRows {
+			res.Truncated = true
+			break
+		}
+		vals := make([]interface{}, len(cols))
+		dest := make([]interface{}, len(cols))
+		for i := range vals {
+			dest[i] = &vals[i]
+		}
+		if err := rows.Scan(dest...); err != nil {
+			return QueryResult{}, queryError(ctx, err)
+		}
+		for i, v := range vals {
+			switch x := v.(type) {
+			case []byte:
+				vals[i] = string(x)
+			case time.Time:
+				vals[i] = x.UTC().Format(time.RFC3339)
+			}
+		}
+		res.Rows = append(res.Rows, vals)
+	}
+	if err := rows.Err(); err != nil {
+		return QueryResult{}, queryError(ctx, err)
+	}
+	return res, nil
+}
+
+func queryError(ctx context.Context, err error) error {
+	if ctx.Err() == context.DeadlineExceeded {
+		return ErrQueryTimeout
+	}
+	return err
+}
+
+// schemaTables lists the tables and views of the database, lower-cased.
+func schemaTables(db *conn) (map[string]bool, error) {
+	return schemaTablesWith(db, db.dialect.tables)
+}
+
+// schemaTablesWith lists the table names query returns, lower-cased.
+func schemaTablesWith(db *conn, query string) (map[string]bool, error) {
+	rows, err := db.Query(query)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	tables := map[string]bool{}
+	for rows.Next() {
+		var name string
+		if err := rows.Scan(&name); err != nil {
+			return nil, err
+		}
+		tables[strings.ToLower(name)] = true
+	}
+	return tables, rows.Err()
+}
+
+// checkQueryRole refuses to run user SQL as a role that may read a table
+// or view outside allowed: the role is what enforces the allow list.
+func checkQueryRole(ro *conn, allowed map[string]bool) error {
+	if ro.dialect.readableTables == "" {
+		return nil
+	}
+	readable, err := schemaTablesWith(ro, ro.dialect.readableTables)
+	if err != nil {
+		return err
+	}
+	for _, t := range sortedKeys(readable) {
+		if !allowed[t] {
+			return fmt.Errorf("the query role can read %s, which is not allowed; grant it SELECT on the allowed views only", t)
+		}
+	}
+	return nil
+}
+
+// catalogPrefixes name the engines' own tables and table-valued functions.
+var catalogPrefixes = []string{"sqlite_", "pragma_", "pg_", "information_schema"}
+
+// readerFunctions read a table or run SQL named by a string argument, which
+// checkQuery cannot see into. On PostgreSQL the query role is what really
+// keeps them to the allowed views; rejecting them gives a clear error.
+var readerFunctions = map[string]bool{
+	"query_to_xml": true, "query_to_xml_and_xmlschema": true, "query_to_xmlschema": true,
+	"table_to_xml": true, "table_to_xml_and_xmlschema": true, "table_to_xmlschema": true,
+	"cursor_to_xml": true, "cursor_to_xmlschema": true,
+	"schema_to_xml": true, "schema_to_xml_and_xmlschema": true, "schema_to_xmlschema": true,
+	"database_to_xml": true, "database_to_xml_and_xmlschema": true, "database_to_xmlschema": true,
+	"lo_get": true, "lo_export": true, "current_setting": true, "set_config": true,
+	"load_extension": true, "readfile": true, "writefile": true, "fts3_tokenizer": true,
+}
+
+// checkQuery accepts a single SELECT (or WITH ... SELECT) statement whose
+// identifiers name no table outside allowed.
+func checkQuery(query string, tables, allowed map[string]bool) error {
+	toks, err := sqlTokens(query)
+	if err != nil {
+		return err
+	}
+	if len(toks) > 0 && toks[len(toks)-1] == ";" {
+		toks = toks[:len(toks)-1]
+	}
+	if len(toks) == 0 {
+		return errors.New("empty query")
+	}
+	if first := strings.ToLower(toks[0]); first != "select" && first != "with" {
+		return errors.New("only SELECT statements are allowed")
+	}
+	for _, t := range toks {
+		if t == ";" {
+			return errors.New("only one statement is allowed")
+		}
+		name := strings.ToLower(t)
+		for _, p := range catalogPrefixes {
+			if strings.HasPrefix(name, p) {
+				return fmt.Errorf("%s is not readable", t)
+			}
+		}
+		if readerFunctions[name] || strings.HasPrefix(name, "dblink") {
+			return fmt.Errorf("function %s is not allowed", t)
+		}
+		if tables[name] && !allowed[name] {
+			return fmt.Errorf("table %s is not readable (allowed: %s)", t, strings.Join(sortedKeys(allowed), ", "))
+		}
+	}
+	return nil
+}
+
+// sqlTokens splits query into identifiers (unquoted), ";" and the first
+// word; comments, numbers and other punctuation are dropped. A string
+// literal that reads as a name is kept as one too: SQLite accepts
+// FROM 'cache_meta' for a table, so it is checked like an identifier.
+func sqlTokens(query string) ([]string, error) {
+	var toks []string
+	for i := 0; i < len(query); {
+		c := query[i]
+		switch {
+		case c == '-' && strings.HasPrefix(query[i:], "--"):
+			for i < len(query) && query[i] != '\n' {
+				i++
+			}
+		case c == '/' && strings.HasPrefix(query[i:], "/*"):
+			end := strings.Index(query[i+2:], "*/")
+			if end < 0 {
+				return nil, errors.New("unterminated comment")
+			}
+			i += end + 4
+		case c == '\'':
+			j := i + 1
+			for ; j < len(query); j++ {
+				if query[j] == '\'' {
+					if j+1 < len(query) && query[j+1] == '\'' {
+						j++
+						continue
+					}
+					break
+				}
+			}
+			if j >= len(query) {
+				return nil, errors.New("unterminated string")
+			}
+			if lit := query[i+1 : j]; isSQLName(lit) {
+				toks = append(toks, lit)
+			}
+			i = j + 1
+		case c == '"' || c == '`' || c == '[':
+			closer := map[byte]byte{'"': '"', '`': '`', '[': ']'}[c]
+			end := strings.IndexByte(query[i+1:], closer)
+			if end < 0 {
+				return nil, errors.New("unterminated identifier")
+			}
+			toks = append(toks, query[i+1:i+1+end])
+			i += end + 2
+		case c == ';':
+			toks = append(toks, ";")
+			i++
+		case c == '_' || unicode.IsLetter(rune(c)) || c >= 0x80:
+			j := i
+			for j < len(query) && (query[j] == '_' || query[j] == '$' || query[j] >= 0x80 ||
+				unicode.IsLetter(rune(query[j])) || unicode.IsDigit(rune(query[j]))) {
+				j++
+			}
+			toks = append(toks, query[i:j])
+			i = j
+		default:
+			i++
+		}
+	}
+	return toks, nil
+}
+
+// isSQLName reports whether s could name a table without quoting.
+func isSQLName(s string) bool {
+	if s == "" || unicode.IsDigit(rune(s[0])) {
+		return false
+	}
+	for i := 0; i < len(s); i++ {
+		c := s[i]
+		if c != '_' && c != '$' && c < 0x80 && !unicode.IsLetter(rune(c)) && !unicode.IsDigit(rune(c)) {
+			return false
+		}
+	}
+	return true
+}
+
+func sortedKeys(m map[string]bool) []string {
+	keys := make([]string, 0, len(m))
+	for k := range m {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	return keys
+}
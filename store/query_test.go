@@ -0,0 +1,164 @@
+package store
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestQueryReadsViewsOnly(t *testing.T) {
+	tmp := t.TempDir()
+	sessionDir := filepath.Join(tmp, "agents", "alpha", "sessions")
+	if err := os.MkdirAll(sessionDir, 0o755); err != nil {
+		t.Fatalf("mkdir: %v", err)
+	}
+	writeSessionTokens(t, filepath.Join(sessionDir, "s.jsonl"), []int{10, 20, 30})
+
+	st, err := Open(filepath.Join(tmp, "usage_cache.db"), Options{})
+	if err != nil {
+		t.Fatalf("open: %v", err)
+	}
+	defer st.Close()
+	if _, err := st.Sync(filepath.Join(tmp, "agents")); err != nil {
+		t.Fatalf("sync: %v", err)
+	}
+	ctx := context.Background()
+
+	res, err := st.Query(ctx, "SELECT agent, host, SUM(tokens) AS tokens FROM v_usage GROUP BY agent, host;", QueryOptions{})
+	if err != nil {
+		t.Fatalf("query: %v", err)
+	}
+	if len(res.Rows) != 1 || res.Rows[0][0] != "alpha" || res.Rows[0][1] != LocalHost || res.Rows[0][2] != int64(60) {
+		t.Fatalf("rows = %v", res.Rows)
+	}
+	var buf bytes.Buffer
+	if err := res.WriteCSV(&buf); err != nil {
+		t.Fatalf("csv: %v", err)
+	}
+	if got, want := buf.String(), "agent,host,tokens\nalpha,local,60\n"; got != want {
+		t.Fatalf("csv = %q, want %q", got, want)
+	}
+
+	res, err = st.Query(ctx, "SELECT tokens FROM v_usage ORDER BY tokens", QueryOptions{MaxRows: 2})
+	if err != nil {
+		t.Fatalf("limited query: %v", err)
+	}
+	if len(res.Rows) != 2 || !res.Truncated {
+		t.Fatalf("rows = %v, truncated = %v; want 2 rows, truncated", res.Rows, res.Truncated)
+	}
+
+	for _, q := range []string{
+		"SELECT * FROM usage_records",
+		`SELECT * FROM "usage_records"`,
+		"SELECT name FROM sqlite_master",
+		"SELECT * FROM pragma_table_info('v_usage')",
+		"SELECT 1; DELETE FROM usage_records",
+		"DELETE FROM v_usage",
+		"WITH x AS (SELECT * FROM file_state) SELECT * FROM x",
+		"SELECT query_to_xml('select * from cache_meta', true, false, '')",
+		"SELECT * FROM table_to_xml('notify_outbox', true, false, '')",
+		"SELECT Query_To_Xml('select 1', true, false, '')",
+		"SELECT * FROM 'cache_meta'",
+		"SELECT key, value FROM main.'cache_meta'",
+		"SELECT name FROM 'sqlite_master'",
+		"SELECT * FROM v_usage JOIN 'file_state' ON 1 = 1",
+		"",
+	} {
+		if _, err := st.Query(ctx, q, QueryOptions{}); err == nil {
+			t.Errorf("query %q was accepted", q)
+		}
+	}
+
+	// Literals that are not table names still pass.
+	if _, err := st.Query(ctx, "SELECT COUNT(*) FROM v_usage WHERE agent = 'alpha' OR project LIKE 'pg_%'", QueryOptions{}); err != nil {
+		t.Fatalf("query with literals: %v", err)
+	}
+
+	// An allow list replaces the default views.
+	if _, err := st.Query(ctx, "SELECT COUNT(*) FROM usage_records", QueryOptions{Allow: []string{"usage_records"}}); err != nil {
+		t.Fatalf("allowed table: %v", err)
+	}
+	if _, err := st.Query(ctx, "SELECT * FROM v_usage", QueryOptions{Allow: []string{"usage_records"}}); err == nil ||
+		!strings.Contains(err.Error(), "not readable") {
+		t.Fatalf("view outside the allow list: %v", err)
+	}
+}
+
+// TestPostgresQueryRole checks that on PostgreSQL the allow list is kept by
+// the query role itself, not only by checkQuery.
+func TestPostgresQueryRole(t *testing.T) {
+	base := os.Getenv("OCL_TEST_POSTGRES_DSN")
+	if base == "" {
+		t.Skip("OCL_TEST_POSTGRES_DSN not set")
+	}
+	pg := openBackends(t, Options{})["postgres"]
+	ctx := context.Background()
+
+	if _, err := pg.Query(ctx, "SELECT COUNT(*) FROM v_usage", QueryOptions{}); !errors.Is(err, ErrNoQueryRole) {
+		t.Fatalf("query without a role: %v, want ErrNoQueryRole", err)
+	}
+
+	var schema string
+	if err := pg.DB().QueryRow("SELECT current_schema()").Scan(&schema); err != nil {
+		t.Fatalf("schema: %v", err)
+	}
+	role := schema + "_query"
+	for _, stmt := range []string{
+		"CREATE ROLE " + role + " LOGIN PASSWORD 'query-role-test'",
+		"GRANT USAGE ON SCHEMA " + schema + " TO " + role,
+		"GRANT SELECT ON v_usage, v_usage_tools, v_usage_tags TO " + role,
+	} {
+		if _, err := pg.DB().Exec(stmt); err != nil {
+			t.Fatalf("%s: %v", stmt, err)
+		}
+	}
+	t.Cleanup(func() {
+		pg.DB().Exec("REVOKE ALL ON ALL TABLES IN SCHEMA " + schema + " FROM " + role)
+		pg.DB().Exec("REVOKE ALL ON SCHEMA " + schema + " FROM " + role)
+		pg.DB().Exec("DROP ROLE " + role)
+	})
+
+	u, err := url.Parse(base)
+	if err != nil {
+		t.Fatalf("parse dsn: %v", err)
+	}
+	u.User = url.UserPassword(role, "query-role-test")
+	q := u.Query()
+	q.Set("search_path", schema)
+	u.RawQuery = q.Encode()
+
+	st, err := OpenPostgres(pg.dsn, Options{QueryDSN: u.String()})
+	if err != nil {
+		t.Fatalf("open: %v", err)
+	}
+	defer st.Close()
+	if _, err := st.Query(ctx, "SELECT COUNT(*) FROM v_usage", QueryOptions{}); err != nil {
+		t.Fatalf("query as role: %v", err)
+	}
+	// Past checkQuery, the database still refuses.
+	for _, q := range []string{
+		"SELECT query_to_xml('select * from cache_meta', true, false, '')",
+		"SELECT table_to_xml('notify_outbox', true, false, '')",
+		"SELECT * FROM usage_records",
+	} {
+		if _, err := st.ro.Exec(q); err == nil || !strings.Contains(err.Error(), "permission denied") {
+			t.Errorf("%s as query role: %v, want permission denied", q, err)
+		}
+	}
+
+	// A role that reads more than the allow list is refused.
+	own, err := OpenPostgres(pg.dsn, Options{QueryDSN: pg.dsn})
+	if err != nil {
+		t.Fatalf("open: %v", err)
+	}
+	defer own.Close()
+	if _, err := own.Query(ctx, "SELECT COUNT(*) FROM v_usage", QueryOptions{}); err == nil ||
+		!strings.Contains(err.Error(), "query role can read") {
+		t.Fatalf("query as the owner: %v, want refused", err)
+	}
+}
@@ -0,0 +1,333 @@
+package store
+
+import (
+	"bufio"
+	"database/sql"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/yeremiel/claw-usage-chart/api"
+)
+
+const (
+	defaultRecordsLimit = 100
+	maxRecordsLimit     = 1000
+	maxRawLineBytes     = 2 * 1024 * 1024
+)
+
+// recordSortColumns maps the public sort names to usage_records columns.
+var recordSortColumns = map[string]string{
+	"time":   "ts",
+	"tokens": "tokens",
+	"cost":   "cost",
+	"id":     "id",
+}
+
+// RecordRow is one usage_records row as returned by /api/records.
+type RecordRow = api.RecordRow
+
+// RecordsQuery selects one page of records.
+type RecordsQuery = api.RecordsQuery
+
+// RecordsPage is the payload of /api/records.
+type RecordsPage = api.RecordsPage
+
+// recordCursor is the keyset position after the last row of a page: the
+// sort column value plus the row id as a tie-breaker.
+type recordCursor struct {
+	Sort  string `json:"s"`
+	Order string `json:"o"`
+	Value string `json:"v"`
+	ID    int64  `json:"id"`
+}
+
+func (c recordCursor) encode() string {
+	b, _ := json.Marshal(c)
+	return base64.RawURLEncoding.EncodeToString(b)
+}
+
+// ErrInvalidQuery wraps errors caused by the request itself (an unknown
+// sort or dimension, a bad cursor), as opposed to failures reading the
+// cache.
+var ErrInvalidQuery = errors.New("invalid query")
+
+type invalidQueryError string
+
+func (e invalidQueryError) Error() string        { return string(e) }
+func (e invalidQueryError) Is(target error) bool { return target == ErrInvalidQuery }
+
+func invalidQuery(format string, args ...interface{}) error {
+	return invalidQueryError(fmt.Sprintf(format, args...))
+}
+
+func decodeRecordCursor(s string) (recordCursor, error) {
+	var c recordCursor
+	b, err := base64.RawURLEncoding.DecodeString(s)
+	if err != nil {
+		return c, invalidQuery("invalid cursor")
+	}
+	if err := json.Unmarshal(b, &c); err != nil {
+		return c, invalidQuery("invalid cursor")
+	}
+	return c, nil
+}
+
+// listRecords returns one page of individual usage records using keyset
+// pagination, so deep pages cost the same as the first one.
+func listRecords(db *conn, host string, q RecordsQuery) (RecordsPage, error) {
+	if q.Sort == "" {
+		q.Sort = "time"
+	}
+	col, ok := recordSortColumns[q.Sort]
+	if !ok {
+		return RecordsPage{}, invalidQuery("unknown sort %q", q.Sort)
+	}
+	if q.Order == "" {
+		q.Order = "desc"
+	}
+	if q.Order != "asc" && q.Order != "desc" {
+		return RecordsPage{}, invalidQuery("unknown order %q", q.Order)
+	}
+	if q.Limit <= 0 {
+		q.Limit = defaultRecordsLimit
+	}
+	if q.Limit > maxRecordsLimit {
+		q.Limit = maxRecordsLimit
+	}
+
+	where, params := filterWhere(q.Filter)
+
+	if q.Cursor != "" {
+		c, err := decodeRecordCursor(q.Cursor)
+		if err != nil {
+			return RecordsPage{}, err
+		}
+		if c.Sort != q.Sort || c.Order != q.Order {
+			return RecordsPage{}, invalidQuery("cursor does not match sort/order")
+		}
+		var v interface{}
+		if q.Sort == "cost" {
+			v, err = strconv.ParseFloat(c.Value, 64)
+		} else {
+			v, err = strconv.ParseInt(c.Value, 10, 64)
+		}
+		if err != nil {
+			return RecordsPage{}, invalidQuery("invalid cursor")
+		}
+		cmp := "<"
+		if q.Order == "asc" {
+			cmp = ">"
+		}
+		where += fmt.Sprintf(" AND (%s %s ? OR (%s = ? AND id %s ?))", col, cmp, col, cmp)
+		params = append(params, v, v, c.ID)
+	}
+
+	dir := "DESC"
+	if q.Order == "asc" {
+		dir = "ASC"
+	}
+	rows, err := db.Query(`
+		SELECT id, agent_name, model, date_key, hour, dow, ts, tokens, cost,
+		       provider, role, stop_reason, tool_calls, latency_ms, project, host, user_name,
+		       source_file, source_offset
+		FROM usage_records
+		WHERE `+where+`
+		ORDER BY `+col+` `+dir+`, id `+dir+`
+		LIMIT ?`, append(params, q.Limit+1)...)
+	if err != nil {
+		return RecordsPage{}, fmt.Errorf("records: %w", err)
+	}
+	defer rows.Close()
+
+	page := RecordsPage{Sort: q.Sort, Order: q.Order, Records: []RecordRow{}}
+	var sortValues []string
+	for rows.Next() {
+		var r RecordRow
+		var hour, dow, latency sql.NullInt64
+		var ts int64
+		if err := rows.Scan(&r.ID, &r.Agent, &r.Model, &r.Date, &hour, &dow, &ts,
+			&r.Tokens, &r.Cost, &r.Provider, &r.Role, &r.StopReason, &r.ToolCalls, &latency,
+			&r.Project, &r.Host, &r.User, &r.SourceFile, &r.SourceOffset); err != nil {
+			return RecordsPage{}, err
+		}
+		if latency.Valid {
+			l := int(latency.Int64)
+			r.LatencyMs = &l
+		}
+		r.Tools = []string{}
+		r.Tags = []string{}
+		if hour.Valid {
+			h := int(hour.Int64)
+			r.Hour = &h
+		}
+		if dow.Valid {
+			d := int(dow.Int64)
+			r.DOW = &d
+		}
+		if ts > 0 {
+			r.Timestamp = time.Unix(ts, 0).UTC().Format(time.RFC3339)
+		}
+
+		switch q.Sort {
+		case "time":
+			sortValues = append(sortValues, strconv.FormatInt(ts, 10))
+		case "tokens":
+			sortValues = append(sortValues, strconv.Itoa(r.Tokens))
+		case "cost":
+			sortValues = append(sortValues, strconv.FormatFloat(r.Cost, 'g', -1, 64))
+		default:
+			sortValues = append(sortValues, strconv.FormatInt(r.ID, 10))
+		}
+		r.Cost = roundFloat(r.Cost, 6)
+		page.Records = append(page.Records, r)
+	}
+	if err := rows.Err(); err != nil {
+		return RecordsPage{}, err
+	}
+
+	if len(page.Records) > q.Limit {
+		page.Records = page.Records[:q.Limit]
+		last := page.Records[q.Limit-1]
+		page.NextCursor = recordCursor{
+			Sort:  q.Sort,
+			Order: q.Order,
+			Value: sortValues[q.Limit-1],
+			ID:    last.ID,
+		}.encode()
+	}
+
+	if err := attachTools(db, page.Records); err != nil {
+		return RecordsPage{}, err
+	}
+	if err := attachTags(db, page.Records); err != nil {
+		return RecordsPage{}, err
+	}
+
+	if q.Raw {
+		for i := range page.Records {
+			r := &page.Records[i]
+			// Only this host's session files can be read: those synced
+			// here, stamped with host on PostgreSQL and unstamped on SQLite.
+			if r.Host != host {
+				r.RawError = "line is stored on host " + r.Host
+				continue
+			}
+			line, err := readSourceLine(r.SourceFile, r.SourceOffset)
+			if err != nil {
+				r.RawError = err.Error()
+			} else {
+				r.Raw = line
+			}
+		}
+	}
+	return page, nil
+}
+
+// attachTools fills RecordRow.Tools for the records that called tools.
+func attachTools(db *conn, records []RecordRow) error {
+	byID := map[int64]*RecordRow{}
+	var ids []interface{}
+	var marks []string
+	for i := range records {
+		if records[i].ToolCalls > 0 {
+			byID[records[i].ID] = &records[i]
+			ids = append(ids, records[i].ID)
+			marks = append(marks, "?")
+		}
+	}
+	if len(ids) == 0 {
+		return nil
+	}
+	rows, err := db.Query(`
+		SELECT record_id, tool_name FROM usage_tools
+		WHERE record_id IN (`+strings.Join(marks, ",")+`)
+		ORDER BY record_id, tool_name`, ids...)
+	if err != nil {
+		return fmt.Errorf("record tools: %w", err)
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var id int64
+		var name string
+		if err := rows.Scan(&id, &name); err != nil {
+			return err
+		}
+		byID[id].Tools = append(byID[id].Tools, name)
+	}
+	return rows.Err()
+}
+
+// attachTags fills RecordRow.Tags.
+func attachTags(db *conn, records []RecordRow) error {
+	if len(records) == 0 {
+		return nil
+	}
+	byID := map[int64]*RecordRow{}
+	ids := make([]interface{}, len(records))
+	marks := make([]string, len(records))
+	for i := range records {
+		byID[records[i].ID] = &records[i]
+		ids[i] = records[i].ID
+		marks[i] = "?"
+	}
+	rows, err := db.Query(`
+		SELECT record_id, tag FROM record_tags
+		WHERE record_id IN (`+strings.Join(marks, ",")+`)
+		ORDER BY record_id, tag`, ids...)
+	if err != nil {
+		return fmt.Errorf("record tags: %w", err)
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var id int64
+		var tag string
+		if err := rows.Scan(&id, &tag); err != nil {
+			return err
+		}
+		byID[id].Tags = append(byID[id].Tags, tag)
+	}
+	return rows.Err()
+}
+
+// readSourceLine returns the JSONL line that starts at offset in path.
+func readSourceLine(path string, offset int64) (string, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return "", err
+	}
+	defer f.Close()
+
+	if _, err := f.Seek(offset, io.SeekStart); err != nil {
+		return "", err
+	}
+	r := bufio.NewReaderSize(f, 64*1024)
+	var line []byte
+	for {
+		chunk, err := r.ReadSlice('\n')
+		line = append(line, chunk...)
+		if len(line) > maxRawLineBytes {
+			return "", errors.New("line too long")
+		}
+		if err == bufio.ErrBufferFull {
+			continue
+		}
+		if err != nil && err != io.EOF {
+			return "", err
+		}
+		break
+	}
+	if len(line) == 0 {
+		return "", errors.New("no line at offset (file rewritten?)")
+	}
+	if line[len(line)-1] == '\n' {
+		line = line[:len(line)-1]
+	}
+	return string(line), nil
+}
@@ -0,0 +1,125 @@
+package store
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/yeremiel/claw-usage-chart/parser"
+)
+
+func TestListRecordsKeysetPaginationAndRawLines(t *testing.T) {
+	tmp := t.TempDir()
+	agentsDir := filepath.Join(tmp, "agents")
+	sessionDir := filepath.Join(agentsDir, "alpha", "sessions")
+	if err := os.MkdirAll(sessionDir, 0o755); err != nil {
+		t.Fatalf("mkdir session dir: %v", err)
+	}
+	file := filepath.Join(sessionDir, "a.jsonl")
+	// Duplicate token counts exercise the id tie-breaker.
+	writeSessionTokens(t, file, []int{10, 20, 20, 20, 30, 40, 50})
+
+	st, err := Open(filepath.Join(tmp, "usage_cache.db"), Options{})
+	if err != nil {
+		t.Fatalf("open: %v", err)
+	}
+	defer st.Close()
+	if _, err := st.Sync(agentsDir); err != nil {
+		t.Fatalf("sync: %v", err)
+	}
+
+	for _, order := range []string{"asc", "desc"} {
+		seen := map[int64]bool{}
+		var tokens []int
+		cursor := ""
+		for pages := 0; ; pages++ {
+			if pages > 10 {
+				t.Fatalf("%s: pagination did not terminate", order)
+			}
+			page, err := st.Records(RecordsQuery{Sort: "tokens", Order: order, Limit: 3, Cursor: cursor, Raw: true})
+			if err != nil {
+				t.Fatalf("%s: list: %v", order, err)
+			}
+			for _, r := range page.Records {
+				if seen[r.ID] {
+					t.Fatalf("%s: record %d returned twice", order, r.ID)
+				}
+				seen[r.ID] = true
+				tokens = append(tokens, r.Tokens)
+				if !strings.HasPrefix(r.Raw, "{") || r.RawError != "" {
+					t.Fatalf("%s: bad raw line for %d: %q (%s)", order, r.ID, r.Raw, r.RawError)
+				}
+				if rec := parser.ParseLine("alpha", []byte(r.Raw)); rec == nil || rec.Tokens != r.Tokens {
+					t.Fatalf("%s: raw line does not match record %d", order, r.ID)
+				}
+			}
+			if page.NextCursor == "" {
+				break
+			}
+			cursor = page.NextCursor
+		}
+		if len(seen) != 7 {
+			t.Fatalf("%s: expected 7 records, got %d", order, len(seen))
+		}
+		for i := 1; i < len(tokens); i++ {
+			if (order == "asc" && tokens[i] < tokens[i-1]) || (order == "desc" && tokens[i] > tokens[i-1]) {
+				t.Fatalf("%s: out of order: %v", order, tokens)
+			}
+		}
+	}
+
+	for _, q := range []RecordsQuery{{Sort: "cost", Cursor: "garbage"}, {Sort: "nope"}, {Order: "sideways"}} {
+		if _, err := st.Records(q); !errors.Is(err, ErrInvalidQuery) {
+			t.Fatalf("%+v: expected ErrInvalidQuery, got %v", q, err)
+		}
+	}
+}
+
+// TestRecordsRawLinesOnStampedHost checks that a store stamping its host on
+// synced records (PostgreSQL) still reads their raw lines, and only theirs.
+func TestRecordsRawLinesOnStampedHost(t *testing.T) {
+	agentsDir := filepath.Join(t.TempDir(), "agents")
+	sessionDir := filepath.Join(agentsDir, "alpha", "sessions")
+	if err := os.MkdirAll(sessionDir, 0o755); err != nil {
+		t.Fatalf("mkdir session dir: %v", err)
+	}
+	writeSessionTokens(t, filepath.Join(sessionDir, "a.jsonl"), []int{10, 20})
+
+	for name, st := range openBackends(t, Options{}) {
+		if name == "sqlite" {
+			st.host = "this-host" // as if the dialect stamped it
+		}
+		if _, err := st.Sync(agentsDir); err != nil {
+			t.Fatalf("%s: sync: %v", name, err)
+		}
+		if _, err := st.Ingest("other-host", "bob", []PortableRecord{{
+			Agent: "alpha", Model: "m1", Date: "2026-02-17", Tokens: 5,
+			SourceFile: filepath.Join(sessionDir, "a.jsonl"), SourceOffset: 0,
+		}}); err != nil {
+			t.Fatalf("%s: ingest: %v", name, err)
+		}
+		page, err := st.Records(RecordsQuery{Raw: true})
+		if err != nil {
+			t.Fatalf("%s: records: %v", name, err)
+		}
+		if len(page.Records) != 3 {
+			t.Fatalf("%s: got %d records, want 3", name, len(page.Records))
+		}
+		for _, r := range page.Records {
+			switch r.Host {
+			case st.host:
+				if !strings.HasPrefix(r.Raw, "{") || r.RawError != "" {
+					t.Errorf("%s: no raw line for synced record %d: %q (%s)", name, r.ID, r.Raw, r.RawError)
+				}
+			case "other-host":
+				if r.Raw != "" || !strings.Contains(r.RawError, "other-host") {
+					t.Errorf("%s: pushed record %d: raw %q, error %q", name, r.ID, r.Raw, r.RawError)
+				}
+			default:
+				t.Errorf("%s: record %d has host %q", name, r.ID, r.Host)
+			}
+		}
+	}
+}
@@ -0,0 +1,168 @@
+package store
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"sort"
+	"time"
+)
+
+// TagRule assigns labels to records. All non-empty conditions must match;
+// every matching rule contributes its tags.
+type TagRule struct {
+	Tags    []string `json:"tags"`
+	Agent   string   `json:"agent,omitempty"`   // agent name glob
+	Model   string   `json:"model,omitempty"`   // model glob
+	Project string   `json:"project,omitempty"` // project glob
+	Path    string   `json:"path,omitempty"`    // session file path prefix or glob
+	From    string   `json:"from,omitempty"`    // inclusive "YYYY-MM-DD"
+	To      string   `json:"to,omitempty"`      // inclusive "YYYY-MM-DD"
+}
+
+// Validate reports missing tags, malformed patterns and bad dates.
+func (r TagRule) Validate() error {
+	if len(r.Tags) == 0 {
+		return errors.New("tags is required")
+	}
+	for _, t := range r.Tags {
+		if t == "" {
+			return errors.New("empty tag")
+		}
+	}
+	for _, g := range []string{r.Agent, r.Model, r.Project, r.Path} {
+		if !validGlob(g) {
+			return fmt.Errorf("bad pattern %q", g)
+		}
+	}
+	for _, d := range []string{r.From, r.To} {
+		if d == "" {
+			continue
+		}
+		if _, err := time.Parse("2006-01-02", d); err != nil {
+			return fmt.Errorf("bad date %q (want YYYY-MM-DD)", d)
+		}
+	}
+	return nil
+}
+
+// taggable is the subset of a record the rules look at.
+type taggable struct {
+	agent, model, project, date, source string
+}
+
+func (r TagRule) matches(rec taggable) bool {
+	if r.Agent != "" && !globMatch(r.Agent, rec.agent) {
+		return false
+	}
+	if r.Model != "" && !globMatch(r.Model, rec.model) {
+		return false
+	}
+	if r.Project != "" && !globMatch(r.Project, rec.project) {
+		return false
+	}
+	if r.Path != "" && !pathMatch(r.Path, rec.source) {
+		return false
+	}
+	if r.From != "" || r.To != "" {
+		if rec.date == "unknown" {
+			return false
+		}
+		if (r.From != "" && rec.date < r.From) || (r.To != "" && rec.date > r.To) {
+			return false
+		}
+	}
+	return true
+}
+
+// matchTags returns the sorted, distinct tags the rules assign to rec.
+func matchTags(rules []TagRule, rec taggable) []string {
+	set := map[string]bool{}
+	for _, r := range rules {
+		if r.matches(rec) {
+			for _, t := range r.Tags {
+				set[t] = true
+			}
+		}
+	}
+	tags := make([]string, 0, len(set))
+	for t := range set {
+		tags = append(tags, t)
+	}
+	sort.Strings(tags)
+	return tags
+}
+
+func insertTags(stmt *sql.Stmt, recordID int64, tags []string) error {
+	for _, t := range tags {
+		if _, err := stmt.Exec(recordID, t); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// retagRecords rebuilds record_tags for the records synced on host when
+// the tag rules differ from the ones their tags were computed with. Project
+// rules are part of the fingerprint because tags may match on project.
+// Records of other hosts sharing the database follow those hosts' rules,
+// and pushed records keep the tags they were ingested with.
+func retagRecords(tx *txn, host string, rules []TagRule, projects []ProjectRule) error {
+	key := hostMetaKey("tag_rules", host)
+	fp := rulesFingerprint([]interface{}{rules, projects})
+	prev, err := getCacheMeta(tx, key)
+	if err != nil || prev == fp {
+		return err
+	}
+
+	if _, err := tx.Exec(
+		"DELETE FROM record_tags WHERE record_id IN (SELECT id FROM usage_records WHERE host = ?)", host,
+	); err != nil {
+		return err
+	}
+	if len(rules) > 0 {
+		insertTag, err := tx.Prepare("INSERT INTO record_tags (record_id, tag) VALUES (?, ?)")
+		if err != nil {
+			return err
+		}
+		defer insertTag.Close()
+
+		// Walk the records in id batches so no cursor stays open while
+		// inserting.
+		const batch = 5000
+		var lastID int64
+		for {
+			rows, err := tx.Query(`
+				SELECT id, agent_name, model, project, date_key, source_file
+				FROM usage_records WHERE host = ? AND id > ? ORDER BY id LIMIT ?`, host, lastID, batch)
+			if err != nil {
+				return err
+			}
+			type row struct {
+				id  int64
+				rec taggable
+			}
+			var page []row
+			for rows.Next() {
+				var r row
+				if err := rows.Scan(&r.id, &r.rec.agent, &r.rec.model, &r.rec.project, &r.rec.date, &r.rec.source); err != nil {
+					rows.Close()
+					return err
+				}
+				page = append(page, r)
+			}
+			rows.Close()
+
+			for _, r := range page {
+				if err := insertTags(insertTag, r.id, matchTags(rules, r.rec)); err != nil {
+					return fmt.Errorf("tag record %d: %w", r.id, err)
+				}
+			}
+			if len(page) < batch {
+				break
+			}
+			lastID = page[len(page)-1].id
+		}
+	}
+	return setCacheMeta(tx, key, fp)
+}
@@ -0,0 +1,153 @@
+package store
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestSyncTagsRecordsAndRetagsOnRuleChange(t *testing.T) {
+	tmp := t.TempDir()
+	agentsDir := filepath.Join(tmp, "agents")
+	for _, agent := range []string{"alpha", "beta"} {
+		dir := filepath.Join(agentsDir, agent, "sessions")
+		if err := os.MkdirAll(dir, 0o755); err != nil {
+			t.Fatalf("mkdir: %v", err)
+		}
+		lines := `{"timestamp":"2026-02-10T00:00:00Z","requestId":"` + agent + `-1","model":"claude-opus","usage":{"input_tokens":10}}` + "\n" +
+			`{"timestamp":"2026-03-10T00:00:00Z","requestId":"` + agent + `-2","model":"gpt-5","usage":{"input_tokens":20}}` + "\n"
+		if err := os.WriteFile(filepath.Join(dir, "s.jsonl"), []byte(lines), 0o644); err != nil {
+			t.Fatalf("write: %v", err)
+		}
+	}
+
+	st, err := Open(filepath.Join(tmp, "usage_cache.db"), Options{})
+	if err != nil {
+		t.Fatalf("open: %v", err)
+	}
+	defer st.Close()
+
+	tagTokens := func(filter UsageFilter) map[string]int {
+		t.Helper()
+		if _, err := st.Sync(agentsDir); err != nil {
+			t.Fatalf("sync: %v", err)
+		}
+		stats, err := st.Stats(filter)
+		if err != nil {
+			t.Fatalf("stats: %v", err)
+		}
+		got := map[string]int{}
+		for _, tt := range stats.TagTotals {
+			got[tt.Tag] = tt.Tokens
+		}
+		return got
+	}
+
+	st.SetOptions(Options{
+		Dedupe: DedupeContent,
+		Tags: []TagRule{
+			{Tags: []string{"team:research"}, Agent: "alpha"},
+			{Tags: []string{"vendor:anthropic"}, Model: "claude-*"},
+			{Tags: []string{"q1"}, From: "2026-01-01", To: "2026-02-28"},
+			{Tags: []string{"team:infra"}, Path: filepath.Join(agentsDir, "beta")},
+		},
+	})
+	got := tagTokens(UsageFilter{})
+	want := map[string]int{"team:research": 30, "team:infra": 30, "vendor:anthropic": 20, "q1": 20}
+	for tag, tokens := range want {
+		if got[tag] != tokens {
+			t.Fatalf("tag %s: got %d tokens, want %d (all: %v)", tag, got[tag], tokens, got)
+		}
+	}
+
+	stats, err := st.Stats(UsageFilter{Tag: "team:infra"})
+	if err != nil {
+		t.Fatalf("stats: %v", err)
+	}
+	if stats.Summary.TotalTokens != 30 || stats.Summary.UsageRecords != 2 {
+		t.Fatalf("tag filter: unexpected summary %+v", stats.Summary)
+	}
+
+	page, err := st.Records(RecordsQuery{Filter: UsageFilter{Agent: "alpha", Model: "claude-opus"}})
+	if err != nil {
+		t.Fatalf("records: %v", err)
+	}
+	if len(page.Records) != 1 || len(page.Records[0].Tags) != 3 {
+		t.Fatalf("unexpected record tags: %+v", page.Records)
+	}
+
+	// Changing the rules re-tags history without re-reading files.
+	st.SetOptions(Options{
+		Dedupe: DedupeContent,
+		Tags:   []TagRule{{Tags: []string{"cc:42"}, Model: "gpt-*"}},
+	})
+	got = tagTokens(UsageFilter{})
+	if len(got) != 1 || got["cc:42"] != 40 {
+		t.Fatalf("unexpected tags after rule change: %v", got)
+	}
+}
+
+// TestRetagLeavesOtherHostsAlone checks that hosts sharing a database with
+// different tag rules each retag only their own records, once.
+func TestRetagLeavesOtherHostsAlone(t *testing.T) {
+	tmp := t.TempDir()
+	path := filepath.Join(tmp, "usage_cache.db")
+	open := func(host, agent, tag string) (*SQLStore, string) {
+		t.Helper()
+		agentsDir := filepath.Join(tmp, host, "agents")
+		dir := filepath.Join(agentsDir, agent, "sessions")
+		if err := os.MkdirAll(dir, 0o755); err != nil {
+			t.Fatalf("mkdir: %v", err)
+		}
+		writeSessionTokens(t, filepath.Join(dir, "s.jsonl"), []int{10, 20})
+		st, err := Open(path, Options{Tags: []TagRule{{Tags: []string{tag}, Agent: "*"}}})
+		if err != nil {
+			t.Fatalf("open: %v", err)
+		}
+		t.Cleanup(func() { st.Close() })
+		st.host = host
+		return st, agentsDir
+	}
+	a, dirA := open("host-a", "alpha", "team:a")
+	b, dirB := open("host-b", "beta", "team:b")
+
+	syncBoth := func() {
+		t.Helper()
+		if _, err := a.Sync(dirA); err != nil {
+			t.Fatalf("sync a: %v", err)
+		}
+		if _, err := b.Sync(dirB); err != nil {
+			t.Fatalf("sync b: %v", err)
+		}
+	}
+	syncBoth()
+	mark, err := a.Watermark()
+	if err != nil {
+		t.Fatalf("watermark: %v", err)
+	}
+	syncBoth()
+	if again, err := a.Watermark(); err != nil || again != mark {
+		t.Fatalf("watermark moved on an idle sync: %s -> %s (%v)", mark, again, err)
+	}
+
+	stats, err := a.Stats(UsageFilter{})
+	if err != nil {
+		t.Fatalf("stats: %v", err)
+	}
+	got := map[string]int{}
+	for _, tt := range stats.TagTotals {
+		got[tt.Tag] = tt.Tokens
+	}
+	if len(got) != 2 || got["team:a"] != 30 || got["team:b"] != 30 {
+		t.Fatalf("tags = %v, want each host's records tagged by its own rules", got)
+	}
+}
+
+func TestTagRuleValidatesPathGlob(t *testing.T) {
+	if err := (TagRule{Tags: []string{"x"}, Path: "/srv/["}).Validate(); err == nil {
+		t.Fatal("malformed path glob was accepted")
+	}
+	if err := (TagRule{Tags: []string{"x"}, Path: "~/work/*"}).Validate(); err != nil {
+		t.Fatalf("path glob: %v", err)
+	}
+}